@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	accessLogApache = "apache"
+	accessLogJSON   = "json"
+)
+
+// accessLogger writes one line per request, apart from the application log
+// busy routes like redirects can be sampled so the log stays a manageable size
+type accessLogger struct {
+	format string
+	// sampling maps a route, e.g. /shortie/:id, to the fraction of its requests that are logged, unlisted routes are all logged
+	sampling map[string]float64
+
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	ClientIP  string    `json:"clientIP"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Route     string    `json:"route"`
+	Protocol  string    `json:"protocol"`
+	Status    int       `json:"status"`
+	Bytes     int       `json:"bytes"`
+	Latency   float64   `json:"latencyMs"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+}
+
+func newAccessLogger(format string, sampling map[string]float64, writer io.Writer) (*accessLogger, error) {
+	if format != accessLogApache && format != accessLogJSON {
+		return nil, fmt.Errorf("unknown access log format %q", format)
+	}
+	return &accessLogger{format: format, sampling: sampling, writer: writer}, nil
+}
+
+// parseSampling reads comma separated route=rate pairs, e.g. /shortie/:id=0.1,/health=0
+func parseSampling(config string) (map[string]float64, error) {
+	sampling := map[string]float64{}
+	for _, pair := range strings.Split(config, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		route, rateString, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("access log sampling %q is not route=rate", pair)
+		}
+		rate, err := strconv.ParseFloat(rateString, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("access log sampling rate for %s must be between 0 and 1", route)
+		}
+		sampling[route] = rate
+	}
+	return sampling, nil
+}
+
+func (logger *accessLogger) Middleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := routeOf(c)
+	rate, found := logger.sampling[route]
+	if found && rand.Float64() >= rate {
+		return
+	}
+
+	logger.write(accessLogEntry{
+		Time:      start,
+		ClientIP:  c.ClientIP(),
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.RequestURI(),
+		Route:     route,
+		Protocol:  c.Request.Proto,
+		Status:    c.Writer.Status(),
+		Bytes:     max(c.Writer.Size(), 0),
+		Latency:   float64(time.Since(start).Microseconds()) / 1000,
+		Referer:   c.Request.Referer(),
+		UserAgent: c.Request.UserAgent(),
+	})
+}
+
+func (logger *accessLogger) write(entry accessLogEntry) {
+	var line []byte
+	switch logger.format {
+	case accessLogJSON:
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+		line = append(encoded, '\n')
+	default:
+		// the apache combined log format
+		line = fmt.Appendf(nil, "%s - - [%s] \"%s %s %s\" %d %d %q %q\n",
+			entry.ClientIP, entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			entry.Method, entry.Path, entry.Protocol, entry.Status, entry.Bytes,
+			valueOrDash(entry.Referer), valueOrDash(entry.UserAgent))
+	}
+
+	logger.lock.Lock()
+	defer logger.lock.Unlock()
+	_, _ = logger.writer.Write(line)
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// rotatingFile is an append-only log file, of the access or click log, that is rotated to path.1, path.2, ... once it grows past maxSize
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	rotating := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	return rotating, rotating.open()
+}
+
+func (rotating *rotatingFile) open() error {
+	file, err := os.OpenFile(rotating.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open the log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to open the log file: %w", err)
+	}
+	rotating.file = file
+	rotating.size = info.Size()
+	return nil
+}
+
+func (rotating *rotatingFile) Write(data []byte) (int, error) {
+	rotating.lock.Lock()
+	defer rotating.lock.Unlock()
+
+	if rotating.maxSize > 0 && rotating.size > 0 && rotating.size+int64(len(data)) > rotating.maxSize {
+		err := rotating.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	written, err := rotating.file.Write(data)
+	rotating.size += int64(written)
+	return written, err
+}
+
+func (rotating *rotatingFile) rotate() error {
+	err := rotating.file.Close()
+	if err != nil {
+		return err
+	}
+	// the oldest backup falls off the end
+	for i := rotating.maxBackups - 1; i > 0; i-- {
+		_ = os.Rename(rotating.path+"."+strconv.Itoa(i), rotating.path+"."+strconv.Itoa(i+1))
+	}
+	if rotating.maxBackups > 0 {
+		err = os.Rename(rotating.path, rotating.path+".1")
+	} else {
+		err = os.Remove(rotating.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rotate the log file: %w", err)
+	}
+	return rotating.open()
+}
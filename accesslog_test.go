@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func accessLogRouter(t *testing.T, format string, sampling map[string]float64) (*gin.Engine, *bytes.Buffer) {
+	buffer := &bytes.Buffer{}
+	logger, err := newAccessLogger(format, sampling, buffer)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(logger.Middleware)
+	router.GET("/shortie/:id", func(c *gin.Context) { c.String(http.StatusTemporaryRedirect, "") })
+	router.GET("/health", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	return router, buffer
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	router, buffer := accessLogRouter(t, accessLogApache, nil)
+	request := httptest.NewRequest(http.MethodGet, "/health?verbose=1", nil)
+	request.Header.Set("User-Agent", "curl/8.0")
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	assert.Regexp(t, `^192\.0\.2\.1 - - \[.+\] "GET /health\?verbose=1 HTTP/1\.1" 200 2 "-" "curl/8\.0"\n$`, buffer.String())
+
+	router, buffer = accessLogRouter(t, accessLogJSON, nil)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/shortie/111", nil))
+	var entry accessLogEntry
+	require.NoError(t, json.Unmarshal(buffer.Bytes(), &entry))
+	assert.Equal(t, "/shortie/111", entry.Path)
+	assert.Equal(t, "/shortie/:id", entry.Route)
+	assert.Equal(t, http.StatusTemporaryRedirect, entry.Status)
+
+	_, err := newAccessLogger("common", nil, buffer)
+	assert.Error(t, err)
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	sampling, err := parseSampling("/shortie/:id=0, /health=1")
+	require.NoError(t, err)
+	router, buffer := accessLogRouter(t, accessLogApache, sampling)
+
+	for i := 0; i < 10; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/shortie/111", nil))
+	}
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, 1, strings.Count(buffer.String(), "\n"))
+
+	_, err = parseSampling("/health=2")
+	assert.Error(t, err)
+	_, err = parseSampling("/health")
+	assert.Error(t, err)
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	file, err := openRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = file.Write([]byte(line))
+		require.NoError(t, err)
+	}
+
+	for suffix, expected := range map[string]string{"": "fourth\n", ".1": "third\n", ".2": "second\n"} {
+		contents, err := os.ReadFile(path + suffix)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(contents))
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err), "only maxBackups backups are kept")
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireAdmin only lets requests through with the admin bearer token, or an api key or jwt with the admin role
+func (api shortieAPI) RequireAdmin(c *gin.Context) {
+	if api.dev {
+		c.Next()
+		return
+	}
+	if api.adminToken == "" && !api.rbacEnabled() {
+		respondError(c, http.StatusForbidden, codeAdminDisabled, "admin api is disabled")
+		return
+	}
+	caller := api.authenticate(c)
+	if caller == nil {
+		respondError(c, http.StatusUnauthorized, codeUnauthorized, "invalid admin token")
+		return
+	}
+	if caller.role < roleAdmin {
+		respondError(c, http.StatusForbidden, codeForbidden, "the admin api requires the admin role")
+		return
+	}
+	c.Set(principalKey, caller)
+	c.Next()
+}
+
+// GetConfig shows the configuration the service is running with, secrets are redacted
+func (api shortieAPI) GetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, api.config)
+}
+
+func (api shortieAPI) GetCacheStats(c *gin.Context) {
+	if api.cache == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "caching is not enabled")
+		return
+	}
+	c.JSON(http.StatusOK, api.cache.Statistics())
+}
+
+// FlushCache evicts a single link when an id query parameter is given, otherwise the whole cache
+func (api shortieAPI) FlushCache(c *gin.Context) {
+	if api.cache == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "caching is not enabled")
+		return
+	}
+	shortID := c.Query("id")
+	if shortID != "" {
+		api.cache.Flush(shortID)
+	} else {
+		api.cache.Flush()
+	}
+	c.Status(http.StatusOK)
+}
+
+type leaderboardEntry struct {
+	ShortID string `json:"shortID"`
+	AllTime int64  `json:"allTime"`
+}
+
+// GetLeaderboard ranks every link by its all time usage, the limit query parameter defaults to 10
+func (api shortieAPI) GetLeaderboard(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, "limit must be a positive integer")
+		return
+	}
+
+	shortIDs, err := api.storage.ListShortIDs(c)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+
+	entries := make([]leaderboardEntry, 0, len(shortIDs))
+	for start := 0; start < len(shortIDs); start += maxStatsBatch {
+		batch, err := api.storage.GetStatisticsBatch(c, shortIDs[start:min(start+maxStatsBatch, len(shortIDs))])
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		for shortID, statistics := range batch {
+			// hourly usage repeats the daily counts, only the days are added up
+			entries = append(entries, leaderboardEntry{ShortID: shortID, AllTime: usageSince(statistics.Usage, time.Time{})})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].AllTime != entries[j].AllTime {
+			return entries[i].AllTime > entries[j].AllTime
+		}
+		return entries[i].ShortID < entries[j].ShortID
+	})
+	c.JSON(http.StatusOK, entries[:min(limit, len(entries))])
+}
+
+// linkDetails is the admin view of a link, including the internal fields never shown to visitors
+type linkDetails struct {
+	ShortID        string            `json:"shortID"`
+	URL            string            `json:"url"`
+	Expiration     int64             `json:"expiration,omitempty"`
+	Paused         bool              `json:"paused"`
+	Quarantined    bool              `json:"quarantined"`
+	Locked         bool              `json:"locked"`
+	ContentHash    string            `json:"contentHash,omitempty"`
+	Passthrough    bool              `json:"passthrough,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	ReferrerPolicy string            `json:"referrerPolicy,omitempty"`
+	Campaign       string            `json:"campaign,omitempty"`
+	Notes          string            `json:"notes"`
+	Annotations    map[string]string `json:"annotations"`
+	Owner          string            `json:"owner,omitempty"`
+	Anonymous      bool              `json:"anonymous,omitempty"`
+	Created        int64             `json:"created,omitempty"`
+	Indexable      bool              `json:"indexable,omitempty"`
+	Title          string            `json:"title,omitempty"`
+}
+
+// GetLink shows a link with its notes and annotations
+func (api shortieAPI) GetLink(c *gin.Context) {
+	object, err := api.storage.GetURL(c, c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if object == nil {
+		respondNotFound(c)
+		return
+	}
+	c.JSON(http.StatusOK, newLinkDetails(object))
+}
+
+func newLinkDetails(object *URLObject) linkDetails {
+	details := linkDetails{
+		ShortID:        object.ShortID,
+		URL:            object.URL,
+		Expiration:     object.Expiration,
+		Paused:         object.Paused,
+		Quarantined:    object.Quarantined,
+		Locked:         object.Locked,
+		ContentHash:    object.ContentHash,
+		Passthrough:    object.Passthrough,
+		Headers:        object.Headers,
+		ReferrerPolicy: object.ReferrerPolicy,
+		Campaign:       object.Campaign,
+		Notes:          object.Notes,
+		Annotations:    object.Annotations,
+		Owner:          object.Owner,
+		Anonymous:      object.Anonymous,
+		Created:        object.Created,
+		Indexable:      object.Indexable,
+		Title:          object.Title,
+	}
+	if details.Annotations == nil {
+		details.Annotations = map[string]string{}
+	}
+	return details
+}
+
+// maxListLimit bounds a page of GET /admin/links, each link on it is read separately
+const maxListLimit = 1000
+
+type linkPage struct {
+	Links []linkDetails `json:"links"`
+	// NextCursor fetches the next page, it is left out after the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// ListLinks pages through every link, the cursor query parameter continues from the nextCursor of the previous page
+func (api shortieAPI) ListLinks(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > maxListLimit {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, "limit must be between 1 and "+strconv.Itoa(maxListLimit))
+		return
+	}
+	after := ""
+	if cursor := c.Query("cursor"); cursor != "" {
+		after, err = api.cursors.Decode(cursor)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, codeInvalidParameter, err.Error())
+			return
+		}
+	}
+
+	shortIDs, next, err := api.storage.ListPage(c, after, limit)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	page := linkPage{Links: []linkDetails{}}
+	for _, shortID := range shortIDs {
+		object, err := api.storage.GetURL(c, shortID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		// links deleted since the page was listed are left out
+		if object != nil {
+			page.Links = append(page.Links, newLinkDetails(object))
+		}
+	}
+	if next != "" {
+		page.NextCursor = api.cursors.Encode(next)
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+type quarantinedLink struct {
+	ShortID string `json:"shortID"`
+	URL     string `json:"url"`
+	Reason  string `json:"reason"`
+}
+
+// GetQuarantine lists the links waiting for review, which requires reading every link
+func (api shortieAPI) GetQuarantine(c *gin.Context) {
+	shortIDs, err := api.storage.ListShortIDs(c)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	sort.Strings(shortIDs)
+
+	links := []quarantinedLink{}
+	for _, shortID := range shortIDs {
+		object, err := api.storage.GetURL(c, shortID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		if object != nil && object.Quarantined {
+			links = append(links, quarantinedLink{ShortID: shortID, URL: object.URL, Reason: object.QuarantineReason})
+		}
+	}
+	c.JSON(http.StatusOK, links)
+}
+
+// ApproveQuarantined lets a quarantined link redirect
+func (api shortieAPI) ApproveQuarantined(c *gin.Context) {
+	shortID := c.Param("id")
+	// links quarantined by the content check are approved with the content their destination has now
+	if api.contents != nil {
+		object, err := api.storage.GetURL(c, shortID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		if object != nil && object.ContentHash != "" {
+			hash, err := api.contents.Hash(c, object.URL)
+			if err != nil {
+				respondError(c, http.StatusBadGateway, codeURLInvalid, "the destination's content can't be verified: "+err.Error())
+				return
+			}
+			err = api.storage.SetContentHash(c, shortID, hash)
+			if err != nil && !errors.Is(err, errNotFound) {
+				respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+				return
+			}
+		}
+	}
+	err := api.storage.SetQuarantined(c, shortID, false)
+	if errors.Is(err, errNotFound) {
+		respondNotFound(c)
+		return
+	}
+	if errors.Is(err, errLocked) {
+		respondLocked(c)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// RejectQuarantined deletes a quarantined link, links that were approved are left alone
+func (api shortieAPI) RejectQuarantined(c *gin.Context) {
+	object, err := api.storage.GetURL(c, c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if object == nil || !object.Quarantined {
+		respondNotFound(c)
+		return
+	}
+	err = api.storage.DeleteURL(c, object.ShortID)
+	if errors.Is(err, errLocked) {
+		respondLocked(c)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// LockLink keeps a link from being changed or deleted, e.g. one printed on products that can't be reprinted
+func (api shortieAPI) LockLink(c *gin.Context) {
+	api.setLocked(c, true)
+}
+
+func (api shortieAPI) UnlockLink(c *gin.Context) {
+	api.setLocked(c, false)
+}
+
+func (api shortieAPI) setLocked(c *gin.Context, locked bool) {
+	err := api.storage.SetLocked(c, c.Param("id"), locked)
+	if errors.Is(err, errNotFound) {
+		respondNotFound(c)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// GetScanners lists the ips currently denied for probing nonexistent links
+func (api shortieAPI) GetScanners(c *gin.Context) {
+	if api.scanners == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "scanner detection is not enabled")
+		return
+	}
+	scanners := api.scanners.List(time.Now())
+	sort.Slice(scanners, func(i, j int) bool { return scanners[i].IP < scanners[j].IP })
+	c.JSON(http.StatusOK, scanners)
+}
+
+func (api shortieAPI) RemoveScanner(c *gin.Context) {
+	if api.scanners == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "scanner detection is not enabled")
+		return
+	}
+	if !api.scanners.Remove(c.Param("ip")) {
+		respondNotFound(c)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (api shortieAPI) GetMigrationStatus(c *gin.Context) {
+	if api.migration == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "no migration is configured")
+		return
+	}
+	c.JSON(http.StatusOK, api.migration.Status())
+}
+
+// StartMigrationCopy copies every link to the migration target in the background, followed by a verification pass
+func (api shortieAPI) StartMigrationCopy(c *gin.Context) {
+	api.startMigrationPass(c, api.migration.Copy)
+}
+
+// StartMigrationVerify compares every link between the backends in the background
+func (api shortieAPI) StartMigrationVerify(c *gin.Context) {
+	api.startMigrationPass(c, api.migration.Verify)
+}
+
+func (api shortieAPI) startMigrationPass(c *gin.Context, pass func(ctx context.Context) error) {
+	if api.migration == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "no migration is configured")
+		return
+	}
+	if api.migration.Running() {
+		respondError(c, http.StatusConflict, codeMigrationRunning, errMigrationRunning.Error())
+		return
+	}
+	// the pass outlives the request, progress is reported by GET /admin/migration
+	ctx := context.WithoutCancel(c.Request.Context())
+	go func() {
+		err := pass(ctx)
+		if err != nil {
+			log.Println("error: migration pass failed: " + err.Error())
+		}
+	}()
+	c.Status(http.StatusAccepted)
+}
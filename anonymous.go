@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// anonymousKey marks requests creating a link without a token in public mode
+const anonymousKey = "anonymous"
+
+// anonymousLimiter is the public mode of a shortener: clients without a token can create links, which expire within
+// ttl, and each client can create at most limit of them within window. Counts are kept per replica.
+type anonymousLimiter struct {
+	ttl    time.Duration
+	limit  int
+	window time.Duration
+
+	lock      sync.Mutex
+	created   map[string]*missCount
+	lastPrune time.Time
+}
+
+func newAnonymousLimiter(ttl time.Duration, limit int, window time.Duration) *anonymousLimiter {
+	return &anonymousLimiter{ttl: ttl, limit: limit, window: window, created: map[string]*missCount{}}
+}
+
+// initAnonymousLimiter reads the public mode, nil if anonymous clients can't create links. Roles must be enforced,
+// otherwise every client could extend, pause or delete the links anonymous clients create.
+func initAnonymousLimiter(env Environment, rbac bool) (*anonymousLimiter, error) {
+	if env.AnonymousTTL == "" {
+		return nil, nil
+	}
+	if !rbac {
+		return nil, errors.New("SHORTIE_ANONYMOUS_TTL requires SHORTIE_API_KEYS, SHORTIE_JWT_SECRET or SHORTIE_ACCESS_TOKENS")
+	}
+	ttl, err := time.ParseDuration(env.AnonymousTTL)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("invalid SHORTIE_ANONYMOUS_TTL %q", env.AnonymousTTL)
+	}
+	limit, err := strconv.Atoi(env.AnonymousRateLimit)
+	if err != nil || limit <= 0 {
+		return nil, errors.New("SHORTIE_ANONYMOUS_RATE_LIMIT must be a positive integer")
+	}
+	window, err := time.ParseDuration(env.AnonymousRateWindow)
+	if err != nil || window <= 0 {
+		return nil, fmt.Errorf("invalid SHORTIE_ANONYMOUS_RATE_WINDOW %q", env.AnonymousRateWindow)
+	}
+	return newAnonymousLimiter(ttl, limit, window), nil
+}
+
+// Allow counts a link created by ip, reporting whether it is within the limit or how long until it is
+func (limiter *anonymousLimiter) Allow(ip string, now time.Time) (bool, time.Duration) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	limiter.prune(now)
+	created, found := limiter.created[ip]
+	if !found || now.Sub(created.windowStart) >= limiter.window {
+		created = &missCount{windowStart: now}
+		limiter.created[ip] = created
+	}
+	if created.count >= limiter.limit {
+		return false, created.windowStart.Add(limiter.window).Sub(now)
+	}
+	created.count++
+	return true, 0
+}
+
+// prune drops the counts of past windows at most once per window, the caller must hold the lock
+func (limiter *anonymousLimiter) prune(now time.Time) {
+	if now.Sub(limiter.lastPrune) < limiter.window {
+		return
+	}
+	limiter.lastPrune = now
+	for ip, created := range limiter.created {
+		if now.Sub(created.windowStart) >= limiter.window {
+			delete(limiter.created, ip)
+		}
+	}
+}
+
+// RequireCreator lets clients without a token create links in public mode, within their rate limit.
+// Requests with a token are checked like any other, so an invalid token isn't treated as anonymous.
+func (api shortieAPI) RequireCreator(c *gin.Context) {
+	if api.anonymous == nil || (api.rbacEnabled() && c.GetHeader("Authorization") != "") {
+		api.RequireRole(roleEditor)(c)
+		return
+	}
+	allowed, retryAfter := api.anonymous.Allow(c.ClientIP(), time.Now())
+	if !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		respondError(c, http.StatusTooManyRequests, codeRateLimited, "too many links created without a token, try again later or authenticate")
+		return
+	}
+	c.Set(anonymousKey, true)
+	c.Next()
+}
+
+// isAnonymous reports whether the link is created without a token in public mode
+func isAnonymous(c *gin.Context) bool {
+	return c.GetBool(anonymousKey)
+}
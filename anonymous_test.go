@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnonymousLimiter(t *testing.T) {
+	limiter := newAnonymousLimiter(time.Hour, 2, time.Minute)
+	now := time.Now()
+
+	allowed, _ := limiter.Allow("192.0.2.10", now)
+	assert.True(t, allowed)
+	allowed, _ = limiter.Allow("192.0.2.10", now.Add(time.Second))
+	assert.True(t, allowed)
+	allowed, retryAfter := limiter.Allow("192.0.2.10", now.Add(10*time.Second))
+	assert.False(t, allowed)
+	assert.Equal(t, 50*time.Second, retryAfter)
+	allowed, _ = limiter.Allow("198.51.100.10", now)
+	assert.True(t, allowed, "clients are limited apart")
+	allowed, _ = limiter.Allow("192.0.2.10", now.Add(time.Minute))
+	assert.True(t, allowed, "a new window starts over")
+
+	env := Environment{AnonymousTTL: "24h", AnonymousRateLimit: "10", AnonymousRateWindow: "1h"}
+	_, err := initAnonymousLimiter(env, false)
+	assert.Error(t, err, "public mode requires roles")
+	limiter, err = initAnonymousLimiter(env, true)
+	require.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, limiter.ttl)
+}
+
+func TestAnonymousLinks(t *testing.T) {
+	ctx := context.Background()
+	keys, err := parseAPIKeys("alice=editor:alice-key,bob=viewer:bob-key")
+	require.NoError(t, err)
+	api := shortieAPI{storage: newContractLocalStorage(t), apiKeys: keys, anonymous: newAnonymousLimiter(time.Hour, 1, time.Hour)}
+
+	// anonymous links expire within the ttl even when asked for longer
+	w := serveAs(api, "", http.MethodPost, "/shortie", `{"url": "https://example.com/a", "expiration": 4102444800}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"expiration"`)
+	w = serveAs(api, "", http.MethodPost, "/shortie", `{"url": "https://example.com/b"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), codeRateLimited)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// callers with a token aren't limited, and still need their role
+	w = serveAs(api, "alice-key", http.MethodPost, "/shortie", `{"url": "https://example.com/c"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotContains(t, w.Body.String(), `"expiration"`)
+	assert.Equal(t, http.StatusForbidden, serveAs(api, "bob-key", http.MethodPost, "/shortie", `{"url": "https://example.com/d"}`).Code)
+	assert.Equal(t, http.StatusUnauthorized, serveAs(api, "wrong-key", http.MethodPost, "/shortie", `{"url": "https://example.com/d"}`).Code)
+
+	shortIDs, err := api.storage.ListShortIDs(ctx)
+	require.NoError(t, err)
+	require.Len(t, shortIDs, 2)
+	for _, shortID := range shortIDs {
+		object, err := api.storage.GetURL(ctx, shortID)
+		require.NoError(t, err)
+		if object.Owner == "" {
+			assert.LessOrEqual(t, object.Expiration, time.Now().Add(time.Hour).Unix())
+		} else {
+			assert.Zero(t, object.Expiration)
+		}
+	}
+
+	// without public mode anonymous clients can't create links
+	api.anonymous = nil
+	assert.Equal(t, http.StatusUnauthorized, serveAs(api, "", http.MethodPost, "/shortie", `{"url": "https://example.com/e"}`).Code)
+}
@@ -1,36 +1,227 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"html/template"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 )
 
 type shortieAPI struct {
 	storage urlStorage
+	// cache is the caching layer wrapping storage, nil if caching is disabled
+	cache *CachedStorage
+	// statsCache keeps the statistics of links for stats responses, nil to read them from storage every time
+	statsCache *statsCache
+	// changeFeed records the changes to links for /internal/links/changes, nil if the change feed is disabled
+	changeFeed *changeFeed
+	// meter counts the usage of owners and enforces their plans, nil if metering is disabled
+	meter *ownerMeter
+	// edgeTTL is how long edge workers may serve the links they resolve from /edge/resolve, 0 if the edge api is disabled
+	edgeTTL time.Duration
+	// migration is the dual-writing layer used while moving to another backend, nil if no migration is configured
+	migration *MigratingStorage
+	// compression gzips or deflates json responses of at least compressMinBytes
+	compression      bool
+	compressMinBytes int
+	// maxTTL is the furthest in the future links can expire, 0 for no limit
+	maxTTL time.Duration
+	// archiveGrace is how long expired links answer with a 410 and their destination before they are purged
+	archiveGrace time.Duration
+	// rejectPastMaxTTL rejects new links without an expiration within maxTTL instead of clamping their expiration
+	rejectPastMaxTTL bool
+	// sampler thins out the detailed usage counters of links with heavy traffic, nil to count every click in them
+	sampler *usageSampler
+	// trustedProxies are the CIDRs or IPs of the proxies whose forwarding headers are used to find the client IP
+	trustedProxies []string
+	// ids generates the shortIDs of new links, derived from the url with hashIDs if nil
+	ids idGenerator
+	// duplicatePolicy is what creating a link to a url that is already shortened does unless a request picks otherwise,
+	// reuse for ids derived from the url and new for other ids if empty
+	duplicatePolicy string
+	// checksumAlphabet is set when generated ids end in a check character from this alphabet,
+	// missing links with a wrong check character get suggestions instead of a plain 404
+	checksumAlphabet string
+	// maxBodyBytes limits POST bodies, maxURLLength limits every url in a link, neither is limited if 0
+	maxBodyBytes int64
+	maxURLLength int
+	// strictJSON rejects request bodies with unknown fields
+	strictJSON bool
+	// canonicalizer rewrites the urls of new links before their shortID is derived, nil to keep urls as given
+	canonicalizer *urlCanonicalizer
+	// captcha verifies a solved challenge before links are created, nil if no captcha is required
+	captcha *CaptchaVerifier
+	// anonymous lets clients without a token create short-lived links at a lower rate, nil if they can't
+	anonymous *anonymousLimiter
+	// spam flags new links that look like spam for quarantine, nil if no spam heuristics are enabled
+	spam *spamChecker
+	// rewrites redirect the paths matching them before links are looked up, tried in order
+	rewrites []*rewriteRule
+	// contents hashes the destinations of links created with verifyContent, nil if content checks are disabled
+	contents *contentWatch
+	// titles fetches the titles of links created without one, nil if link titles are disabled
+	titles *linkTitles
+	// thumbnails captures and caches screenshots of destinations, nil if no screenshot provider is configured
+	thumbnails *thumbnails
+	// scanners denylists clients probing for links, nil if scanner detection is disabled
+	scanners *scannerDetector
+	// fraud flags links with suspect clicks, nil if fraud detection is disabled
+	fraud *fraudDetector
+	// accessLog replaces gin's request logging when set
+	accessLog *accessLogger
+	// metrics receives request and redirect metrics, nil if no exporter is configured
+	metrics metricsSink
+	// clicks logs every redirect for batch analytics, nil if the click log is disabled
+	clicks *clickLogger
+	// clickDedup counts redirects retried with the same X-Request-ID once, nil if dedup is disabled
+	clickDedup *clickDeduper
+	// consistentReadHeader honors redirects asking for a strongly consistent lookup with X-Shortie-Consistent-Read
+	consistentReadHeader bool
+	// config is the redacted configuration served by /admin/config for debugging deployments
+	config map[string]string
+	// cursors signs the positions list pages continue from
+	cursors *cursorCodec
+	// adminToken is the bearer token required by the /admin routes, which are disabled if empty
+	adminToken string
+	// apiKeys and jwtSecret authenticate callers with a role, which is enforced on the json api once either is set
+	apiKeys   []apiKey
+	jwtSecret []byte
+	// stepUp verifies the TOTP codes destructive admin operations require on top of the admin credentials, nil if not required
+	stepUp *totpVerifier
+	// tokens keeps the scoped access tokens minted with POST /auth/tokens, nil if access tokens aren't enabled
+	tokens tokenStore
+	// teamTokens maps each team with an alias namespace to the bearer token that manages its aliases
+	teamTokens map[string]string
+	// corsOrigins are the origins, like a browser extension's, allowed to call the api from a browser
+	corsOrigins map[string]bool
+	// slackSigningSecret verifies the slash commands sent to /integrations/slack, which is disabled if empty
+	slackSigningSecret string
+	// statsShareSecret signs the urls of shared stats pages, sharing is disabled if empty
+	statsShareSecret string
+	// email shortens the urls of emails delivered by SES through SNS to /integrations/email, nil if the gateway is disabled
+	email *emailGateway
+	// directory serves the browsable page of team aliases at /directory to viewers and above
+	directory bool
+	// replica serves redirects and pages only, without the json api, the admin api or integrations
+	replica bool
+	// dev opens the /admin routes to everyone for local development
+	dev bool
+	// concurrency limits the in-flight requests of the routes it has a limiter for, keyed by route pattern
+	concurrency map[string]*concurrencyLimiter
+	// readOnly rejects changes to links while set, it can be switched at runtime from the admin api
+	readOnly *atomic.Bool
+	// maintenance serves maintenancePage (or defaultMaintenancePage) with a 503 on every route but health checks and the admin api,
+	// maintenanceRetryAfter is sent as the Retry-After header if set
+	maintenance           *atomic.Bool
+	maintenancePage       []byte
+	maintenanceRetryAfter time.Duration
+
+	// pausedPage is served in place of a redirect when a link is paused, a plain 503 is used if empty
+	pausedPage []byte
+	// scheduledPage is rendered for links that haven't reached their activeFrom time yet, a plain 404 is used if nil
+	scheduledPage *template.Template
+	// robotsTxt replaces defaultRobotsTxt if not empty
+	robotsTxt []byte
+	// sitemap serves /sitemap.xml of the indexable links and allows them in the default robots.txt, a 404 if nil
+	sitemap *SitemapStorage
+	// operator identifies who runs this deployment on redirects and the default /about and /abuse pages
+	operator operatorInfo
+	// aboutPage and abusePage replace the default pages if set
+	aboutPage []byte
+	abusePage []byte
 }
 
+// defaultRobotsTxt keeps crawlers from following short urls into search indexes
+const defaultRobotsTxt = `User-agent: *
+Disallow: /shortie/
+`
+
 type urlStorage interface {
-	SaveURL(ctx context.Context, shortID string, url string, expiration int64) error
-	GetURL(ctx context.Context, shortID string) (string, error)
+	SaveURL(ctx context.Context, object URLObject) error
+	GetURL(ctx context.Context, shortID string) (*URLObject, error)
+	// IncrementUsage counts clicks for today, 1 for a redirect, and weight is what they add to the detailed counters:
+	// this hour if hourly usage is enabled and the redirect rule that picked its destination if not empty.
+	// It is the clicks unless the link's clicks are being sampled, then 0 for clicks left out of the sample.
+	IncrementUsage(ctx context.Context, shortID string, rule string, clicks int64, weight int64) error
+	// RollupUsage drops the hourly usage buckets that started before the given time, their clicks stay counted in the daily usage
+	RollupUsage(ctx context.Context, shortID string, before time.Time) error
+	SetPaused(ctx context.Context, shortID string, paused bool) error
+	SetQuarantined(ctx context.Context, shortID string, quarantined bool) error
+	// SetContentHash replaces the content hash a link's destination is checked against
+	SetContentHash(ctx context.Context, shortID string, hash string) error
+	// SetTitle replaces the title of a link, returning errNotFound for missing links
+	SetTitle(ctx context.Context, shortID string, title string) error
+	// SetLocked locks or unlocks a link, the other changes and deletes return errLocked for locked links
+	SetLocked(ctx context.Context, shortID string, locked bool) error
+	// SetExpiration moves a link's expiration, returning errNotFound for missing links
+	SetExpiration(ctx context.Context, shortID string, expiration int64) error
 	DeleteURL(ctx context.Context, shortID string) error
-	GetStatistics(ctx context.Context, shortID string) (map[string]int64, error)
+	// ConsumeURL atomically deletes a link, reporting false if another caller already consumed or deleted it
+	ConsumeURL(ctx context.Context, shortID string) (bool, error)
+	GetStatistics(ctx context.Context, shortID string) (Statistics, error)
+	// GetStatisticsBatch reads the statistics of many links at once, missing links are left out of the result
+	GetStatisticsBatch(ctx context.Context, shortIDs []string) (map[string]Statistics, error)
+	// FindByURL returns the links whose destination normalizes to the same url, mapped from shortID to their url
+	FindByURL(ctx context.Context, url string) (map[string]string, error)
+	// FindByCampaign returns the shortIDs of the links in a campaign
+	FindByCampaign(ctx context.Context, campaign string) ([]string, error)
+	HealthCheck(ctx context.Context) (HealthStatus, error)
+	// ListShortIDs returns every stored shortID, which requires a full scan on large backends
+	ListShortIDs(ctx context.Context) ([]string, error)
+	// ListPage returns up to limit shortIDs starting after the position a previous page returned, empty for the first page.
+	// next is the position to continue from, empty after the last page. Pages can come back short, even empty, before the last one.
+	ListPage(ctx context.Context, after string, limit int) (shortIDs []string, next string, err error)
+	// ImportURL writes a link as is, usage included, replacing any existing copy of it
+	ImportURL(ctx context.Context, object URLObject) error
 }
 
 func (api shortieAPI) GetRouter() *gin.Engine {
 	router := gin.Default() // Default gives us logging and a recover function built-in
+	if api.accessLog != nil {
+		// the access log replaces gin's request logging
+		router = gin.New()
+		router.Use(gin.Recovery(), api.accessLog.Middleware)
+	}
+	router.Use(RequestID, api.CORS, api.RecordMetrics, api.Maintenance, api.ShedLoad)
+
+	// redirects and pages are for browsers and stay unversioned
+	router.GET("/shortie/:id", api.DetectScanners, api.Rewrite, api.HandleRedirect)
+	router.GET("/t/:team/:alias", api.DetectScanners, api.HandleTeamRedirect)
+	router.NoRoute(api.MatchPassthrough, api.DetectScanners, api.Rewrite, api.HandlePassthrough)
+	router.GET("/robots.txt", api.GetRobotsTxt)
+	router.GET("/sitemap.xml", api.GetSitemap)
+	router.GET("/about", api.GetAbout)
+	router.GET("/abuse", api.GetAbuse)
+	router.GET("/favicon.ico", api.GetFavicon)
+	router.StaticFS("/static", staticFiles)
+
+	if api.replica {
+		// read replicas only redirect, links are managed on the other instances
+		router.GET("/health", api.GetHealth)
+	} else {
+		router.GET("/directory", api.RequireDirectoryAccess, api.GetDirectory)
+		// shared stats pages are signed instead of needing a token
+		router.GET("/shared/stats/:id", api.GetSharedStats)
+		// integrations are called by other services, which sign their requests instead of sending a token
+		router.POST("/integrations/slack", api.LimitBody, api.HandleSlackCommand)
+		router.POST("/integrations/email", api.LimitBody, api.HandleEmail)
 
-	router.POST("/shortie", api.CreateURL)
-	router.GET("/shortie/:id", api.HandleRedirect)
-	router.DELETE("/shortie/:id", api.DeleteURL)
-	router.GET("/shortie/:id/stats", api.GetUsageStats)
-	err := router.SetTrustedProxies(nil)
+		// the json api is served under /v1, and without a prefix for clients from before versioning
+		api.addJSONRoutes(router.Group("", NegotiateVersion(currentAPIVersion), api.Compress))
+		api.addJSONRoutes(router.Group("/v1", NegotiateVersion("1"), api.Compress))
+	}
+	// c.ClientIP() only honors X-Forwarded-For and X-Real-IP from these proxies, and is the remote address otherwise
+	err := router.SetTrustedProxies(api.trustedProxies)
 	if err != nil {
 		log.Println("error: " + err.Error())
 		panic(err)
@@ -39,52 +230,729 @@ func (api shortieAPI) GetRouter() *gin.Engine {
 	return router
 }
 
+func (api shortieAPI) addJSONRoutes(router *gin.RouterGroup) {
+	editor := router.Group("", api.RequireRole(roleEditor))
+	// creating links is open to anonymous clients in public mode
+	router.POST("/shortie", api.RequireCreator, api.RejectWhenReadOnly, api.LimitBody, RequireScope(scopeCreate), api.RequireCaptcha, api.CreateURL)
+	editor.DELETE("/shortie/:id", api.RejectWhenReadOnly, RequireScope(scopeManage), api.RequireOwner, api.RestrictCampaign, api.DeleteURL)
+	editor.PATCH("/shortie/:id/pause", api.RejectWhenReadOnly, RequireScope(scopeManage), api.RequireOwner, api.RestrictCampaign, api.PauseURL)
+	editor.PATCH("/shortie/:id/resume", api.RejectWhenReadOnly, RequireScope(scopeManage), api.RequireOwner, api.RestrictCampaign, api.ResumeURL)
+	editor.POST("/shortie/:id/extend", api.RejectWhenReadOnly, api.LimitBody, RequireScope(scopeManage), api.RequireOwner, api.RestrictCampaign, api.ExtendURL)
+	editor.POST("/shortie/:id/title", api.RejectWhenReadOnly, RequireScope(scopeManage), api.RequireOwner, api.RestrictCampaign, api.RefreshTitle)
+	editor.POST("/shortie/:id/stats/share", api.LimitBody, RequireScope(scopeStats), api.RequireOwner, api.RestrictCampaign, api.ShareStats)
+
+	viewer := router.Group("", api.RequireRole(roleViewer), RequireScope(scopeStats), api.RestrictCampaign)
+	viewer.GET("/shortie/:id/stats", ConditionalGET, api.GetUsageStats)
+	viewer.GET("/shortie/:id/thumbnail", api.GetThumbnail)
+	viewer.GET("/shortie/stats", ConditionalGET, api.GetUsageStatsBatch)
+	viewer.GET("/shortie/lookup", ConditionalGET, api.LookupURL)
+	viewer.GET("/campaigns/:name/stats", ConditionalGET, api.GetCampaignStats)
+
+	router.GET("/health", api.GetHealth)
+
+	me := router.Group("/me", api.RequireRole(roleViewer))
+	me.GET("", api.GetMe)
+	me.GET("/links", api.GetMyLinks)
+	me.GET("/usage", api.GetMyUsage)
+
+	triggers := router.Group("/triggers", api.RequireTriggers, api.RequireRole(roleViewer), RequireScope(scopeStats))
+	triggers.GET("/links", api.GetLinkTriggers)
+	triggers.GET("/clicks", api.GetClickTriggers)
+
+	edge := router.Group("/edge", api.RequireEdge, api.RequireRole(roleViewer), RequireScope(scopeEdge), api.RestrictCampaign)
+	edge.GET("/resolve", api.ResolveForEdge)
+	edge.POST("/clicks", api.LimitBody, api.ReportEdgeClicks)
+
+	internal := router.Group("/internal", api.RequireChangeFeed, api.RequireRole(roleViewer), RequireScope(scopeEdge), api.RestrictCampaign)
+	internal.GET("/links/changes", api.GetLinkChanges)
+
+	teams := router.Group("/teams/:team", api.RequireTeam)
+	teams.GET("/aliases", api.ListTeamAliases)
+	teams.POST("/aliases", api.RejectWhenReadOnly, api.LimitBody, api.CreateTeamAlias)
+	teams.DELETE("/aliases/:alias", api.RejectWhenReadOnly, api.DeleteTeamAlias)
+
+	auth := router.Group("/auth", api.RequireAdmin)
+	auth.POST("/tokens", api.RequireStepUp, api.LimitBody, api.CreateToken)
+	auth.GET("/tokens", api.ListTokens)
+	auth.DELETE("/tokens/:id", api.RevokeToken)
+
+	admin := router.Group("/admin", api.RequireAdmin)
+	admin.GET("/config", api.GetConfig)
+	admin.GET("/cache/stats", api.GetCacheStats)
+	admin.POST("/cache/flush", api.RequireStepUp, api.FlushCache)
+	admin.GET("/leaderboard", ConditionalGET, api.GetLeaderboard)
+	admin.GET("/links", api.ListLinks)
+	admin.GET("/links/:id", api.GetLink)
+	admin.GET("/rewrites", api.GetRewrites)
+	admin.POST("/links/:id/lock", api.RejectWhenReadOnly, api.LockLink)
+	admin.POST("/links/:id/unlock", api.RejectWhenReadOnly, api.RequireStepUp, api.UnlockLink)
+	admin.GET("/quarantine", ConditionalGET, api.GetQuarantine)
+	admin.GET("/id-conflicts", ConditionalGET, api.GetIDConflicts)
+	admin.POST("/quarantine/:id/approve", api.RejectWhenReadOnly, api.ApproveQuarantined)
+	admin.POST("/quarantine/:id/reject", api.RejectWhenReadOnly, api.RequireStepUp, api.RejectQuarantined)
+	admin.GET("/scanners", ConditionalGET, api.GetScanners)
+	admin.DELETE("/scanners/:ip", api.RemoveScanner)
+	admin.GET("/fraud", ConditionalGET, api.GetFraud)
+	admin.DELETE("/fraud/:id", api.DismissFraud)
+	admin.GET("/migration", api.GetMigrationStatus)
+	admin.POST("/migration/copy", api.RequireStepUp, api.StartMigrationCopy)
+	admin.POST("/migration/verify", api.StartMigrationVerify)
+	admin.GET("/read-only", api.GetReadOnly)
+	admin.PUT("/read-only", api.RequireStepUp, api.LimitBody, api.SetReadOnly)
+	admin.GET("/maintenance", api.GetMaintenance)
+	admin.PUT("/maintenance", api.RequireStepUp, api.LimitBody, api.SetMaintenance)
+}
+
 func (api shortieAPI) CreateURL(c *gin.Context) {
 	var body = struct {
-		URL        string `json:"url"`        // TODO: Add validation to this URL
-		Expiration int64  `json:"expiration"` // TODO: Add validation to this expiration timestamp
+		URL            string            `json:"url"`        // TODO: Add validation to this URL
+		Expiration     int64             `json:"expiration"` // TODO: Add validation to this expiration timestamp
+		ActiveFrom     int64             `json:"activeFrom"`
+		BurnAfterRead  bool              `json:"burnAfterRead"`
+		Signed         bool              `json:"signed"`
+		IPRules        IPRules           `json:"ipRules"`
+		Schedule       Schedule          `json:"schedule"`
+		ReferrerRules  []ReferrerRule    `json:"referrerRules"`
+		LanguageRules  map[string]string `json:"languageRules"`
+		AppLink        AppLink           `json:"appLink"`
+		NoIndex        bool              `json:"noIndex"`
+		Headers        map[string]string `json:"headers"`
+		ReferrerPolicy string            `json:"referrerPolicy"`
+		Indexable      bool              `json:"indexable"`
+		Title          string            `json:"title"`
+		Passthrough    bool              `json:"passthrough"`
+		Campaign       string            `json:"campaign"`
+		FailIfExists   bool              `json:"failIfExists"`
+		Dedupe         bool              `json:"dedupe"`
+		Duplicates     string            `json:"duplicates"`
+		Notes          string            `json:"notes"`
+		Annotations    map[string]string `json:"annotations"`
+		VerifyContent  bool              `json:"verifyContent"`
+		DryRun         bool              `json:"dryRun"`
 	}{}
-	err := c.BindJSON(&body)
+	if !api.bindJSON(c, &body) {
+		return
+	}
+	urls := []string{body.URL, body.AppLink.URI}
+	for _, rule := range body.Schedule.Rules {
+		urls = append(urls, rule.URL)
+	}
+	for _, rule := range body.ReferrerRules {
+		urls = append(urls, rule.URL)
+	}
+	for _, url := range body.LanguageRules {
+		urls = append(urls, url)
+	}
+	err := api.validateURLLengths(urls...)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeURLInvalid, err.Error())
+		return
+	}
+	// the app link is checked with the other app link fields, every other url is a destination
+	for _, destination := range append(urls[:1:1], urls[2:]...) {
+		if !isWebURL(destination) {
+			respondError(c, http.StatusBadRequest, codeURLInvalid, fmt.Sprintf("%q is not an http or https url", destination))
+			return
+		}
+	}
+	if len(body.Campaign) > maxCampaignLength {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("campaign names can be at most %d characters", maxCampaignLength))
+		return
+	}
+	// tokens limited to a campaign create their links in it
+	if caller := callerOf(c); caller != nil && caller.campaign != "" {
+		if body.Campaign != "" && body.Campaign != caller.campaign {
+			respondError(c, http.StatusForbidden, codeForbidden, "the token is limited to the links of campaign "+caller.campaign)
+			return
+		}
+		body.Campaign = caller.campaign
+	}
+	err = validateNotes(body.Notes, body.Annotations)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	err = validateTitle(body.Title)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	err = validateHeaders(body.Headers)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	err = validateReferrerPolicy(body.ReferrerPolicy, body.Headers)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	// links without a requested expiration can be handed back whatever theirs is
+	requestedExpiration := body.Expiration
+	clamped := false
+	if api.maxTTL > 0 {
+		latest := time.Now().Add(api.maxTTL).Unix()
+		if body.Expiration == 0 || body.Expiration > latest {
+			if api.rejectPastMaxTTL {
+				respondError(c, http.StatusBadRequest, codeExpirationInvalid, fmt.Sprintf("expiration is required and can be at most %s away", api.maxTTL))
+				return
+			}
+			body.Expiration = latest
+			clamped = true
+		}
+	}
+	// links created without a token always expire within the anonymous ttl
+	if isAnonymous(c) {
+		latest := time.Now().Add(api.anonymous.ttl).Unix()
+		if body.Expiration == 0 || body.Expiration > latest {
+			body.Expiration = latest
+			clamped = true
+		}
+	}
+	if body.ActiveFrom != 0 && body.Expiration != 0 && body.ActiveFrom >= body.Expiration {
+		respondError(c, http.StatusBadRequest, codeExpirationInvalid, "activeFrom must be before expiration")
+		return
+	}
+	err = body.IPRules.Validate()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeRuleInvalid, err.Error())
+		return
+	}
+	err = body.Schedule.Validate()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeRuleInvalid, err.Error())
+		return
+	}
+	err = validateReferrerRules(body.ReferrerRules)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeRuleInvalid, err.Error())
+		return
+	}
+	err = validateLanguageRules(body.LanguageRules)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeRuleInvalid, err.Error())
+		return
+	}
+	err = body.AppLink.Validate()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeRuleInvalid, err.Error())
 		return
 	}
 
-	data := []byte(body.URL)
-	guid := uuid.NewSHA1(uuid.NameSpaceURL, data)
-	// TODO: handle conflicts - we can check the DB and if we have a conflict give this a couple more characters
-	shortID := strings.ReplaceAll(guid.String(), "-", "")[0:10]
+	// the link is made for the canonical form of the url, which is reported back
+	if api.canonicalizer != nil {
+		body.URL = api.canonicalizer.Canonicalize(body.URL)
+	}
 
-	err = api.storage.SaveURL(c, shortID, body.URL, body.Expiration)
+	if body.Indexable && body.NoIndex {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, "indexable can't be combined with noIndex")
+		return
+	}
+	if body.Dedupe && (body.FailIfExists || body.Signed || body.BurnAfterRead) {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, "dedupe can't be combined with failIfExists, signed or burnAfterRead")
+		return
+	}
+	policy := api.defaultDuplicatePolicy()
+	if body.Duplicates != "" {
+		err = validateDuplicatePolicy(body.Duplicates)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, codeInvalidParameter, err.Error())
+			return
+		}
+		policy = body.Duplicates
+	}
+	// dedupe is the owner policy from before policies could be picked
+	if body.Dedupe {
+		if body.Duplicates != "" && body.Duplicates != duplicateOwner {
+			respondError(c, http.StatusBadRequest, codeInvalidParameter, "dedupe can only be combined with the owner duplicate policy")
+			return
+		}
+		policy = duplicateOwner
+	}
+	owner := ""
+	if caller := callerOf(c); caller != nil {
+		owner = caller.name
+	}
+	object := URLObject{
+		URL:            body.URL,
+		Expiration:     body.Expiration,
+		ActiveFrom:     body.ActiveFrom,
+		BurnAfterRead:  body.BurnAfterRead,
+		IPRules:        body.IPRules,
+		Schedule:       body.Schedule,
+		ReferrerRules:  body.ReferrerRules,
+		LanguageRules:  body.LanguageRules,
+		AppLink:        body.AppLink,
+		NoIndex:        body.NoIndex,
+		Headers:        canonicalHeaders(body.Headers),
+		ReferrerPolicy: body.ReferrerPolicy,
+		Indexable:      body.Indexable,
+		Title:          body.Title,
+		Passthrough:    body.Passthrough,
+		Campaign:       body.Campaign,
+		Notes:          body.Notes,
+		Annotations:    body.Annotations,
+		Owner:          owner,
+		Anonymous:      isAnonymous(c),
+		Created:        time.Now().Unix(),
+	}
+	requested := object
+	requested.Expiration = requestedExpiration
+	// signed and burn after read links are never shared
+	if !body.Signed && !body.BurnAfterRead && !body.DryRun {
+		existing, err := api.reusableLink(c, policy, requested)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		if existing != nil {
+			response := map[string]any{"shortUrl": "http://localhost:8421/shortie/" + existing.ShortID, "existing": true}
+			if api.canonicalizer != nil {
+				response["canonicalUrl"] = existing.URL
+			}
+			c.JSON(http.StatusOK, response)
+			return
+		}
+	}
+
+	if body.FailIfExists {
+		matches, err := api.storage.FindByURL(c, body.URL)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		if len(matches) > 0 {
+			// report the same link every time when several already point at the url
+			existing := make([]string, 0, len(matches))
+			for shortID := range matches {
+				existing = append(existing, shortID)
+			}
+			sort.Strings(existing)
+			respondErrorDetails(c, http.StatusConflict, codeURLExists, "the url is already shortened", map[string]any{
+				"shortID":  existing[0],
+				"shortUrl": "http://localhost:8421/shortie/" + existing[0],
+			})
+			return
+		}
+	}
+
+	var signingSecret string
+	if body.Signed {
+		signingSecret, err = newSigningSecret()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+	}
+
+	// an existing link is the one hashed ids hand back, saving doesn't change it
+	shortID, existing, err := api.newLinkID(c, policy, requested, signingSecret)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	object.ShortID = shortID
+	object.SigningSecret = signingSecret
+	if body.VerifyContent && api.contents == nil {
+		respondError(c, http.StatusBadRequest, codeFeatureDisabled, "content verification is not enabled")
+		return
+	}
+	if body.DryRun {
+		api.previewURL(c, object, clamped)
+		return
+	}
+	// handing back an existing link doesn't use up the plan
+	if api.meter != nil && existing == nil {
+		allowed, err := api.meter.Allows(c, owner, true, time.Now())
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		if !allowed {
+			respondError(c, http.StatusTooManyRequests, codeQuotaExceeded, "the links of your plan are used up this month")
+			return
+		}
+	}
+	if body.VerifyContent {
+		object.ContentHash, err = api.contents.Hash(c, body.URL)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, codeURLInvalid, "the destination's content can't be verified: "+err.Error())
+			return
+		}
+	}
+	if api.spam != nil {
+		object.QuarantineReason = api.spam.Check(c, body.URL, c.ClientIP(), time.Now())
+		object.Quarantined = object.QuarantineReason != ""
+	}
+	if existing != nil {
+		// saving doesn't change a link that already exists, so report its own state instead
+		object.Quarantined = existing.Quarantined
+	}
+	err = api.storage.SaveURL(c, object)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
+	if api.meter != nil && existing == nil {
+		api.meter.Add(owner, time.Now(), 1, 0)
+	}
+	if api.titles != nil && object.Title == "" && !object.Quarantined {
+		api.titles.FetchLater(api.storage, shortID, object.URL)
+	}
+
+	response := map[string]any{"shortUrl": "http://localhost:8421/shortie/" + shortID}
+	if signingSecret != "" {
+		response["signingSecret"] = signingSecret
+	}
+	if clamped {
+		response["expiration"] = body.Expiration
+	}
+	if object.Quarantined {
+		response["status"] = "quarantined"
+	}
+	if api.canonicalizer != nil {
+		response["canonicalUrl"] = object.URL
+	}
+	c.JSON(http.StatusOK, response)
+}
 
-	c.JSON(http.StatusOK, map[string]string{"shortUrl": "http://localhost:8421/shortie/" + shortID})
+// previewURL responds with what creating the link would do without saving it
+// the spam heuristics are skipped since they count the links each client creates
+func (api shortieAPI) previewURL(c *gin.Context, object URLObject, clamped bool) {
+	existing, err := api.storage.GetURL(c, object.ShortID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	response := map[string]any{
+		"dryRun":   true,
+		"shortID":  object.ShortID,
+		"shortUrl": "http://localhost:8421/shortie/" + object.ShortID,
+		"exists":   existing != nil,
+	}
+	if clamped {
+		response["expiration"] = object.Expiration
+	}
+	if api.canonicalizer != nil {
+		response["canonicalUrl"] = object.URL
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 func (api shortieAPI) HandleRedirect(c *gin.Context) {
-	shortID := c.Param("id")
+	api.redirect(c, c.Param("id"))
+}
 
-	url, err := api.storage.GetURL(c, shortID)
+// redirect sends the client to the destination of a link, shortID is either a generated id or a team alias
+func (api shortieAPI) redirect(c *gin.Context, shortID string) {
+	if api.operator.Name != "" {
+		c.Header(operatorHeader, api.operator.String())
+	}
+	api.requestConsistentRead(c)
+
+	object, err := api.storage.GetURL(c, shortID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
-	if url == "" {
+	if object == nil {
+		// links made before checksums were enabled don't have one, so only missing links are checked
+		if api.checksumAlphabet != "" && !isTeamAlias(shortID) && !validChecksum(shortID, api.checksumAlphabet) {
+			api.respondMistyped(c, shortID)
+			return
+		}
+		c.String(http.StatusNotFound, "Not Found")
+		return
+	}
+	// only passthrough links have paths below them
+	remaining, below := c.Get(passthroughPathKey)
+	if below && !object.Passthrough {
+		c.String(http.StatusNotFound, "Not Found")
+		return
+	}
+	now := time.Now()
+	if !object.IsActive(now) {
+		if api.scheduledPage != nil && now.Unix() < object.ActiveFrom {
+			api.renderScheduledPage(c, object)
+			return
+		}
+		if object.IsArchived(now, api.archiveGrace) {
+			api.renderArchivedPage(c, object)
+			return
+		}
 		c.String(http.StatusNotFound, "Not Found")
 		return
 	}
-	c.Header("Location", url)
+	// ClientIP only trusts proxy headers from the router's trusted proxies
+	if !object.IPRules.Permits(c.ClientIP()) {
+		c.String(http.StatusForbidden, "Forbidden")
+		return
+	}
+	if object.SigningSecret != "" {
+		expires, _ := strconv.ParseInt(c.Query(expiresQueryParam), 10, 64)
+		if !verifySignature(object.SigningSecret, shortID, c.Query(signatureQueryParam), expires, now) {
+			c.String(http.StatusForbidden, "Forbidden")
+			return
+		}
+	}
+	if object.Quarantined {
+		c.String(http.StatusForbidden, "Forbidden")
+		return
+	}
+	if object.Paused {
+		if len(api.pausedPage) > 0 {
+			c.Data(http.StatusServiceUnavailable, "text/html; charset=utf-8", api.pausedPage)
+			return
+		}
+		c.String(http.StatusServiceUnavailable, "Service Unavailable")
+		return
+	}
+	if !api.allowsRedirect(c, object, now) {
+		c.String(http.StatusTooManyRequests, "Too Many Requests")
+		return
+	}
+
+	destination, rule := resolveDestination(c.Request, object, now)
+	if object.Passthrough {
+		remainingPath, _ := remaining.(string)
+		destination, err = passthroughDestination(destination, remainingPath, c.Request.URL.Query())
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+	}
+	// a retried redirect that was already counted still redirects, it just isn't counted again
+	counted := api.firstClick(c, shortID, now)
+	// suspect clicks redirect too, fraud detection only decides whether they count
+	if counted {
+		counted = api.countClick(c, shortID, now)
+	}
+
+	if object.BurnAfterRead {
+		consumed, err := api.storage.ConsumeURL(c, shortID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		if !consumed {
+			c.String(http.StatusNotFound, "Not Found")
+			return
+		}
+	} else if counted {
+		// usage is best-effort, a failure to record it shouldn't fail the redirect
+		weight := int64(1)
+		if api.sampler != nil {
+			weight = api.sampler.Weight(shortID, now)
+		}
+		err = api.storage.IncrementUsage(c, shortID, rule, 1, weight)
+		if err != nil {
+			log.Println("error: " + err.Error())
+		}
+	}
+	if api.clicks != nil && counted {
+		api.clicks.Record(c, shortID, now)
+	}
+	if api.meter != nil && counted {
+		api.meter.Add(object.Owner, now, 0, 1)
+	}
+
+	for name, value := range responseHeaders(*object) {
+		c.Header(name, value)
+	}
+	if object.NoIndex {
+		c.Header("X-Robots-Tag", "noindex")
+	}
+
+	if object.AppLink.URI != "" {
+		api.redirectToApp(c, object.AppLink, destination)
+		return
+	}
+
+	c.Header("Location", destination)
 	c.Status(http.StatusTemporaryRedirect)
 }
 
+// redirectToApp sends mobile clients into the app with the destination as the web fallback
+func (api shortieAPI) redirectToApp(c *gin.Context, appLink AppLink, fallback string) {
+	platform := detectPlatform(c.Request.UserAgent())
+	// links saved before app links and destinations were checked only get the plain redirect
+	if appLink.Validate() != nil || !isWebURL(fallback) {
+		platform = platformOther
+	}
+	switch platform {
+	case platformAndroid:
+		if appLink.AndroidPackage != "" {
+			c.Header("Location", appLink.intentURL(fallback))
+			c.Status(http.StatusTemporaryRedirect)
+			return
+		}
+		fallthrough
+	case platformIOS:
+		var page bytes.Buffer
+		err := appLinkPage.Execute(&page, map[string]any{
+			"URI":      appLink.URI,
+			"Fallback": fallback,
+			"Operator": api.operator.String(),
+		})
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+	default:
+		c.Header("Location", fallback)
+		c.Status(http.StatusTemporaryRedirect)
+	}
+}
+
+// resolveDestination applies a link's redirect rules, falling back to its URL
+// the returned rule names the rule that matched for analytics, and is empty if none are recorded
+func resolveDestination(request *http.Request, object *URLObject, now time.Time) (string, string) {
+	referrerRule, matched := matchReferrerRule(object.ReferrerRules, request.Referer())
+	if matched {
+		return referrerRule.URL, "referrer:" + strings.ToLower(referrerRule.Host)
+	}
+	language, destination, matched := matchLanguageRule(object.LanguageRules, request.Header.Get("Accept-Language"))
+	if matched {
+		return destination, "language:" + language
+	}
+	destination, matched = object.Schedule.Destination(now)
+	if matched {
+		return destination, ""
+	}
+	return object.URL, ""
+}
+
+func (api shortieAPI) renderScheduledPage(c *gin.Context, object *URLObject) {
+	var page bytes.Buffer
+	err := api.scheduledPage.Execute(&page, map[string]any{
+		"ShortID":    object.ShortID,
+		"ActiveFrom": time.Unix(object.ActiveFrom, 0).UTC(),
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	c.Data(http.StatusNotFound, "text/html; charset=utf-8", page.Bytes())
+}
+
+func (api shortieAPI) GetHealth(c *gin.Context) {
+	status, err := api.storage.HealthCheck(c)
+	if err != nil {
+		log.Println("error: " + err.Error())
+	}
+	if err != nil || !status.Healthy {
+		c.JSON(http.StatusServiceUnavailable, status)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (api shortieAPI) GetRobotsTxt(c *gin.Context) {
+	if len(api.robotsTxt) > 0 {
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", api.robotsTxt)
+		return
+	}
+	if api.sitemap != nil {
+		c.String(http.StatusOK, api.sitemapRobotsTxt(c))
+		return
+	}
+	c.String(http.StatusOK, defaultRobotsTxt)
+}
+
+func (api shortieAPI) PauseURL(c *gin.Context) {
+	api.setPaused(c, true)
+}
+
+func (api shortieAPI) ResumeURL(c *gin.Context) {
+	api.setPaused(c, false)
+}
+
+func (api shortieAPI) setPaused(c *gin.Context, paused bool) {
+	shortID := c.Param("id")
+	err := api.storage.SetPaused(c, shortID, paused)
+	if errors.Is(err, errNotFound) {
+		respondNotFound(c)
+		return
+	}
+	if errors.Is(err, errLocked) {
+		respondLocked(c)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// ExtendURL moves the expiration of a link that hasn't expired yet, within the maxTTL policy
+func (api shortieAPI) ExtendURL(c *gin.Context) {
+	var body = struct {
+		Expiration int64 `json:"expiration"`
+	}{}
+	if !api.bindJSON(c, &body) {
+		return
+	}
+
+	shortID := c.Param("id")
+	object, err := api.storage.GetURL(c, shortID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	now := time.Now()
+	if object == nil {
+		respondNotFound(c)
+		return
+	}
+	// expired links stay gone, they already stopped redirecting and may have been cleaned up
+	if object.Expiration != 0 && now.Unix() >= object.Expiration {
+		respondError(c, http.StatusNotFound, codeExpired, "the short url has already expired")
+		return
+	}
+	if body.Expiration <= now.Unix() {
+		respondError(c, http.StatusBadRequest, codeExpirationInvalid, "expiration must be in the future")
+		return
+	}
+	if object.ActiveFrom != 0 && object.ActiveFrom >= body.Expiration {
+		respondError(c, http.StatusBadRequest, codeExpirationInvalid, "activeFrom must be before expiration")
+		return
+	}
+	if api.maxTTL > 0 && body.Expiration > now.Add(api.maxTTL).Unix() {
+		respondError(c, http.StatusBadRequest, codeExpirationInvalid, fmt.Sprintf("expiration can be at most %s away", api.maxTTL))
+		return
+	}
+
+	err = api.storage.SetExpiration(c, shortID, body.Expiration)
+	if errors.Is(err, errNotFound) {
+		respondNotFound(c)
+		return
+	}
+	if errors.Is(err, errLocked) {
+		respondLocked(c)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, map[string]int64{"expiration": body.Expiration})
+}
+
 func (api shortieAPI) DeleteURL(c *gin.Context) {
 	shortID := c.Param("id")
 	err := api.storage.DeleteURL(c, shortID)
+	if errors.Is(err, errLocked) {
+		respondLocked(c)
+		return
+	}
+	if errors.Is(err, errNotOwner) {
+		respondError(c, http.StatusForbidden, codeForbidden, "only the link's owner or an admin can change it")
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	c.Status(http.StatusOK)
@@ -93,33 +961,196 @@ func (api shortieAPI) DeleteURL(c *gin.Context) {
 func (api shortieAPI) GetUsageStats(c *gin.Context) {
 	shortID := c.Param("id")
 
-	usage, err := api.storage.GetStatistics(c, shortID)
+	statistics, err := api.statistics(c, shortID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+
+	response := summarizeUsage(statistics)
+	err = addUsageOptions(c, response, statistics.Usage)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, err.Error())
 		return
 	}
+	c.JSON(http.StatusOK, response)
+}
+
+const maxCampaignLength = 128
+
+// GetCampaignStats adds up the usage of every link in a campaign
+func (api shortieAPI) GetCampaignStats(c *gin.Context) {
+	shortIDs, err := api.storage.FindByCampaign(c, c.Param("name"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if len(shortIDs) == 0 {
+		respondError(c, http.StatusNotFound, codeNotFound, "the campaign has no links")
+		return
+	}
+
+	total := Statistics{Usage: map[string]int64{}, RuleUsage: map[string]int64{}}
+	for start := 0; start < len(shortIDs); start += maxStatsBatch {
+		batch, err := api.storage.GetStatisticsBatch(c, shortIDs[start:min(start+maxStatsBatch, len(shortIDs))])
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		for _, statistics := range batch {
+			for day, usage := range statistics.Usage {
+				total.Usage[day] += usage
+			}
+			for rule, usage := range statistics.RuleUsage {
+				total.RuleUsage[rule] += usage
+			}
+		}
+	}
+
+	response := summarizeUsage(total)
+	response["links"] = len(shortIDs)
+	err = addUsageOptions(c, response, total.Usage)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// LookupURL finds the links pointing at the url query parameter, split into exact and normalized matches
+func (api shortieAPI) LookupURL(c *gin.Context) {
+	url := c.Query("url")
+	if url == "" {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, "url is required")
+		return
+	}
+
+	// links are stored with canonical urls, so they are looked up by the canonical form too
+	query := url
+	if api.canonicalizer != nil {
+		query = api.canonicalizer.Canonicalize(url)
+	}
+	matches, err := api.storage.FindByURL(c, query)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+
+	response := map[string][]string{
+		"exact":      {},
+		"normalized": {},
+	}
+	for shortID, matchedURL := range matches {
+		if matchedURL == url {
+			response["exact"] = append(response["exact"], shortID)
+		} else {
+			response["normalized"] = append(response["normalized"], shortID)
+		}
+	}
+	sort.Strings(response["exact"])
+	sort.Strings(response["normalized"])
+	c.JSON(http.StatusOK, response)
+}
+
+// maxStatsBatch bounds how many links a single batch stats request can ask for
+const maxStatsBatch = 100
+
+// GetUsageStatsBatch returns the usage statistics for the comma separated ids query parameter
+func (api shortieAPI) GetUsageStatsBatch(c *gin.Context) {
+	shortIDs := strings.Split(c.Query("ids"), ",")
+	if c.Query("ids") == "" || len(shortIDs) > maxStatsBatch {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, "ids must list between 1 and "+strconv.Itoa(maxStatsBatch)+" shortie ids")
+		return
+	}
+
+	batch, err := api.storage.GetStatisticsBatch(c, shortIDs)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+
+	response := make(map[string]map[string]any, len(batch))
+	for shortID, statistics := range batch {
+		response[shortID] = summarizeUsage(statistics)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// summarizeUsage rolls the stored daily usage up into the stats response
+func summarizeUsage(statistics Statistics) map[string]any {
+	usage := statistics.Usage
 
 	// Usage is stored as a map of UTC day timestamps rounded to the nearest day
 	// Days with no usage are not present in the map
 	// TODO: make the usage a struct with much more user-friendly names and access points
-	todayTimestamp := UTCTimestampOfTodayRounded()
-	todayUsage := usage[strconv.Itoa(int(UTCTimestampOfTodayRounded().Unix()))]
+	today := UTCTimestampOfTodayRounded()
+	monthStart := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	response := map[string]any{
+		"lastDay":    usageOverDays(usage, 1),
+		"lastWeek":   usageOverDays(usage, 7),
+		"last30Days": usageOverDays(usage, 30),
+		"lastMonth":  usageSince(usage, monthStart),
+		"allTime":    usageSince(usage, time.Time{}),
+	}
+	if len(statistics.RuleUsage) > 0 {
+		response["rules"] = statistics.RuleUsage
+	}
+	return response
+}
+
+// usageOverDays adds up the usage of the last days days, today included
+func usageOverDays(usage map[string]int64, days int) int64 {
+	return usageSince(usage, UTCTimestampOfTodayRounded().AddDate(0, 0, 1-days))
+}
 
-	weekUsage := todayUsage
-	dayTimestamp := todayTimestamp
-	for i := 0; i < 6; i++ {
-		dayTimestamp = dayTimestamp.Add(-time.Hour * 24)
-		weekUsage += usage[strconv.Itoa(int(dayTimestamp.Unix()))]
+// usageSince adds up the usage of the days starting at or after from
+func usageSince(usage map[string]int64, from time.Time) int64 {
+	total := int64(0)
+	for day, dayUsage := range usage {
+		timestamp, err := strconv.ParseInt(day, 10, 64)
+		if err != nil || timestamp < from.Unix() {
+			continue
+		}
+		total += dayUsage
 	}
+	return total
+}
+
+// maxUsageDays bounds the days query parameter of the stats endpoints
+const maxUsageDays = 3660
 
-	totalUsage := int64(0)
-	for _, dayUsage := range usage {
-		totalUsage += dayUsage
+// addUsageOptions adds the breakdowns asked for by the days and detailed query parameters to a stats response
+func addUsageOptions(c *gin.Context, response map[string]any, usage map[string]int64) error {
+	if c.Query("days") != "" {
+		days, err := strconv.Atoi(c.Query("days"))
+		if err != nil || days < 1 || days > maxUsageDays {
+			return fmt.Errorf("days must be a number between 1 and %d", maxUsageDays)
+		}
+		response["days"] = days
+		response["lastDays"] = usageOverDays(usage, days)
 	}
+	if c.Query("detailed") == "true" {
+		daily := make(map[string]int64, len(usage))
+		for day, dayUsage := range usage {
+			timestamp, err := strconv.ParseInt(day, 10, 64)
+			if err != nil {
+				continue
+			}
+			daily[time.Unix(timestamp, 0).UTC().Format(time.DateOnly)] += dayUsage
+		}
+		response["daily"] = daily
 
-	c.JSON(http.StatusOK, map[string]int64{
-		"lastDay":  todayUsage,
-		"lastWeek": weekUsage,
-		"allTime":  totalUsage,
-	})
+		hourly := map[string]int64{}
+		for key, hourUsage := range usage {
+			hour, isHour := parseHourUsageKey(key)
+			if isHour {
+				hourly[hour.Format(time.RFC3339)] += hourUsage
+			}
+		}
+		if len(hourly) > 0 {
+			response["hourly"] = hourly
+		}
+	}
+	return nil
 }
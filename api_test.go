@@ -3,16 +3,33 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// serveAs sends a request through the api's router, authenticated with the api key if one is given
+func serveAs(api shortieAPI, key string, method string, target string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	if key != "" {
+		request.Header.Set("Authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	api.GetRouter().ServeHTTP(w, request)
+	return w
+}
+
 func TestShortieAPI(t *testing.T) {
 	httpRequest := func(method, url string, body io.Reader) *http.Request {
 		request, err := http.NewRequest(method, url, body)
@@ -21,14 +38,112 @@ func TestShortieAPI(t *testing.T) {
 		}
 		return request
 	}
+	remoteRequest := func(request *http.Request, remoteAddr string) *http.Request {
+		request.RemoteAddr = remoteAddr
+		return request
+	}
+	headerRequest := func(request *http.Request, header, value string) *http.Request {
+		request.Header.Set(header, value)
+		return request
+	}
+	basicAuthRequest := func(request *http.Request, username, password string) *http.Request {
+		request.SetBasicAuth(username, password)
+		return request
+	}
+	sns := newFakeSNS(t)
+	snsRequest := func(message snsMessage) *http.Request {
+		body, err := json.Marshal(message)
+		require.NoError(t, err)
+		return headerRequest(httpRequest(http.MethodPost, "/integrations/email", bytes.NewReader(body)), "Content-Type", "text/plain; charset=UTF-8")
+	}
+	// the email cases' replies and confirmed subscriptions
+	emailReplies := &fakeEmailSender{}
+	var confirmedSubscription string
+	configureEmail := func(api *shortieAPI) {
+		emailReplies.sent = nil
+		api.email = &emailGateway{
+			address:      testEmailAddress,
+			topicARN:     testEmailTopic,
+			allowed:      []string{"@example.com"},
+			certificates: sns,
+			confirm: func(ctx context.Context, subscribeURL string) error {
+				confirmedSubscription = subscribeURL
+				return nil
+			},
+			sender: emailReplies,
+		}
+	}
+	configureSharing := func(api *shortieAPI) {
+		api.statsShareSecret = "share-secret"
+		api.apiKeys = []apiKey{{key: "alice-key", principal: principal{name: "alice", role: roleEditor}}}
+	}
+	// the triggers cases poll as a viewer or an admin, with the change log holding the creation of links
+	configureTriggers := func(created ...linkChange) func(api *shortieAPI) {
+		return func(api *shortieAPI) {
+			changes := NewLocalChangeLog()
+			for _, change := range created {
+				change.Type = changeCreated
+				change.ExpiresAt = time.Now().Add(time.Hour).Unix()
+				// the local log doesn't fail
+				_ = changes.AppendChange(context.Background(), change)
+			}
+			api.apiKeys = []apiKey{
+				{key: "zap-key", principal: principal{name: "zapier", role: roleViewer}},
+				{key: "admin-key", principal: principal{name: "root", role: roleAdmin}},
+			}
+			api.changeFeed = &changeFeed{log: changes, retention: 24 * time.Hour}
+		}
+	}
+	createdAt := func(at int64, shortID, owner string) linkChange {
+		return linkChange{Seq: changeSeq(time.Unix(at, 0)), ShortID: shortID, Owner: owner}
+	}
+	untrustedEmail := base64.StdEncoding.EncodeToString([]byte("From: mallory@evil.example.net\r\nSubject: links\r\n\r\nhttps://evil.example.net\r\n"))
+	today := UTCTimestampOfTodayRounded()
+	fortyDaysAgo := today.AddDate(0, 0, -40)
+	yesterday := today.AddDate(0, 0, -1)
+	twoDaysAgo := today.AddDate(0, 0, -2)
+	anHourAgo := time.Now().Add(-time.Hour).Unix()
+	shareExpiration := time.Now().Add(time.Hour).Unix()
+	sharedStatsPath := func(shortID string, expires int64, signature string) string {
+		return "/shared/stats/" + shortID + "?exp=" + strconv.FormatInt(expires, 10) + "&sig=" + signature
+	}
+	inAnHour := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	inADay := strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10)
+	// cursors signed with a fixed key are the same in the expected bodies
+	cursors, err := newCursorCodec("secret")
+	require.NoError(t, err)
+	// the links trigger cases share the links, one of them deleted since it was created and one created by someone else
+	setupTriggerLinks := func(t *testing.T, storage urlStorage) {
+		for _, object := range []URLObject{
+			{ShortID: "b", URL: "https://example.com/b", Created: anHourAgo, Owner: "zapier"},
+			{ShortID: "other", URL: "https://example.com/other", Created: anHourAgo + 1, Owner: "someone"},
+			{ShortID: "a", URL: "https://example.com/a", Created: anHourAgo + 3, Owner: "zapier"},
+			{ShortID: "c", URL: "https://example.com/c", Created: anHourAgo + 60, Owner: "zapier"},
+			{ShortID: "settling", URL: "https://example.com/settling", Created: time.Now().Unix(), Owner: "zapier"},
+		} {
+			require.NoError(t, storage.ImportURL(context.Background(), object))
+		}
+	}
+	configureTriggerLinks := configureTriggers(
+		createdAt(anHourAgo, "b", "zapier"),
+		createdAt(anHourAgo+1, "other", "someone"),
+		createdAt(anHourAgo+2, "deleted", "zapier"),
+		createdAt(anHourAgo+3, "a", "zapier"),
+		createdAt(anHourAgo+60, "c", "zapier"),
+		createdAt(time.Now().Unix(), "settling", "zapier"),
+	)
 
 	tests := []struct {
-		name           string
-		setup          func(t *testing.T, storage urlStorage)
-		httpRequest    *http.Request
-		expectedStatus int
-		expectedBody   string
-		expectations   func(t *testing.T, storage urlStorage)
+		name            string
+		setup           func(t *testing.T, storage urlStorage)
+		configure       func(api *shortieAPI)
+		httpRequest     *http.Request
+		expectedStatus  int
+		expectedBody    string
+		expectedHeaders map[string]string
+		// bodyExpectations checks bodies that aren't json
+		bodyExpectations func(t *testing.T, body string)
+		expectations     func(t *testing.T, storage urlStorage)
 	}{
 		{
 			name:           "create a url",
@@ -39,23 +154,83 @@ func TestShortieAPI(t *testing.T) {
 		{
 			name: "create a existing url",
 			setup: func(t *testing.T, storage urlStorage) {
-				err := storage.SaveURL(context.Background(), "4e24c46962", "https://example.com/data/hi", 0)
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "4e24c46962", URL: "https://example.com/data/hi"})
+				require.NoError(t, err)
+				err = storage.IncrementUsage(context.Background(), "4e24c46962", "", 1, 1)
 				require.NoError(t, err)
-				_, _ = storage.GetURL(context.Background(), "4e24c46962")
 			},
 			httpRequest:    httpRequest(http.MethodPost, "/shortie", bytes.NewReader([]byte(`{"url":"https://example.com/data/hi"}`))),
 			expectedStatus: http.StatusOK,
 			expectedBody:   `{"shortUrl": "http://localhost:8421/shortie/4e24c46962"}`,
 			expectations: func(t *testing.T, storage urlStorage) {
-				usage, err := storage.GetStatistics(context.Background(), "4e24c46962")
+				statistics, err := storage.GetStatistics(context.Background(), "4e24c46962")
+				require.NoError(t, err)
+				assert.True(t, len(statistics.Usage) > 0)
+			},
+		},
+		{
+			name: "create an existing url with ip rules",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "4e24c46962", URL: "https://example.com/data/hi"})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"https://example.com/data/hi","ipRules":{"allow":["10.0.0.0/8"]}}`)),
+			expectedStatus: http.StatusOK,
+			expectations: func(t *testing.T, storage urlStorage) {
+				matches, err := storage.FindByURL(context.Background(), "https://example.com/data/hi")
 				require.NoError(t, err)
-				assert.True(t, len(usage) > 0)
+				require.Len(t, matches, 2, "the restricted link gets an id of its own")
+				for shortID := range matches {
+					object, err := storage.GetURL(context.Background(), shortID)
+					require.NoError(t, err)
+					assert.Equal(t, shortID != "4e24c46962", len(object.IPRules.Allow) == 1)
+				}
+			},
+		},
+		{
+			name: "create an existing url with another expiration",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "4e24c46962", URL: "https://example.com/data/hi"})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"https://example.com/data/hi","expiration":`+inADay+`}`)),
+			expectedStatus: http.StatusOK,
+			expectations: func(t *testing.T, storage urlStorage) {
+				matches, err := storage.FindByURL(context.Background(), "https://example.com/data/hi")
+				require.NoError(t, err)
+				assert.Len(t, matches, 2)
+			},
+		},
+		{
+			name: "create a url an anonymous client shortened",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "4e24c46962", URL: "https://example.com/data/hi", Anonymous: true, Expiration: time.Now().Add(time.Hour).Unix()})
+				require.NoError(t, err)
+			},
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "editor-key", principal: principal{name: "ci", role: roleEditor}}}
+				api.anonymous = newAnonymousLimiter(time.Hour, 10, time.Hour)
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"https://example.com/data/hi"}`)), "Authorization", "Bearer editor-key"),
+			expectedStatus: http.StatusOK,
+			expectations: func(t *testing.T, storage urlStorage) {
+				matches, err := storage.FindByURL(context.Background(), "https://example.com/data/hi")
+				require.NoError(t, err)
+				require.Len(t, matches, 2)
+				for shortID := range matches {
+					object, err := storage.GetURL(context.Background(), shortID)
+					require.NoError(t, err)
+					if shortID != "4e24c46962" {
+						assert.Equal(t, "ci", object.Owner)
+						assert.Zero(t, object.Expiration)
+					}
+				}
 			},
 		},
 		{
 			name: "get /shortie/111 redirect",
 			setup: func(t *testing.T, storage urlStorage) {
-				err := storage.SaveURL(context.Background(), "111", "http://redirection.com/portal/portal", 0)
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
 				require.NoError(t, err)
 			},
 			httpRequest:    httpRequest(http.MethodGet, "/shortie/111", nil),
@@ -64,71 +239,2034 @@ func TestShortieAPI(t *testing.T) {
 		{
 			name: "get /shortie/222 not found",
 			setup: func(t *testing.T, storage urlStorage) {
-				err := storage.SaveURL(context.Background(), "111", "http://redirection.com/portal/portal", 0)
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
 				require.NoError(t, err)
 			},
 			httpRequest:    httpRequest(http.MethodGet, "/shortie/222", nil),
 			expectedStatus: http.StatusNotFound,
 		},
 		{
-			name: "delete /shortie/111",
+			name:           "create a url with activeFrom after expiration",
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", bytes.NewReader([]byte(`{"url":"https://example.com/data/hi","activeFrom":200,"expiration":100}`))),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "get /shortie/111 not yet active",
 			setup: func(t *testing.T, storage urlStorage) {
-				err := storage.SaveURL(context.Background(), "111", "http://redirection.com/portal/portal", 0)
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", ActiveFrom: time.Now().Add(time.Hour).Unix()})
 				require.NoError(t, err)
 			},
-			httpRequest:    httpRequest(http.MethodDelete, "/shortie/111", nil),
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111", nil),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "get /shortie/111 expired",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Expiration: time.Now().Add(-time.Hour).Unix()})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111", nil),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "get /shortie/111 burn after read",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", BurnAfterRead: true})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111", nil),
+			expectedStatus: http.StatusTemporaryRedirect,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "111")
+				require.NoError(t, err)
+				assert.Nil(t, object)
+			},
+		},
+		{
+			name: "get /shortie/111 signed",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", SigningSecret: "secret"})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111?exp=0&sig="+signShortID("secret", "111", 0), nil),
+			expectedStatus: http.StatusTemporaryRedirect,
+		},
+		{
+			name: "get /shortie/111 signed with a bad signature",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", SigningSecret: "secret"})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111?sig="+signShortID("wrong", "111", 0), nil),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "get /shortie/111 signed with an expired signature",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", SigningSecret: "secret"})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111?exp=100&sig="+signShortID("secret", "111", 100), nil),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "create a url with an invalid ip rule",
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", bytes.NewReader([]byte(`{"url":"https://example.com/data/hi","ipRules":{"allow":["10.0.0.0"]}}`))),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "get /shortie/111 from an allowed ip",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", IPRules: IPRules{Allow: []string{"192.0.2.0/24"}}})
+				require.NoError(t, err)
+			},
+			httpRequest:    remoteRequest(httpRequest(http.MethodGet, "/shortie/111", nil), "192.0.2.10:1234"),
+			expectedStatus: http.StatusTemporaryRedirect,
+		},
+		{
+			name: "get /shortie/111 from an ip outside the allowlist",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", IPRules: IPRules{Allow: []string{"192.0.2.0/24"}}})
+				require.NoError(t, err)
+			},
+			httpRequest:    remoteRequest(httpRequest(http.MethodGet, "/shortie/111", nil), "198.51.100.10:1234"),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "get /shortie/111 forwarded by a trusted proxy",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", IPRules: IPRules{Allow: []string{"192.0.2.0/24"}}})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.trustedProxies = []string{"10.0.0.0/8"} },
+			httpRequest:    headerRequest(remoteRequest(httpRequest(http.MethodGet, "/shortie/111", nil), "10.1.2.3:1234"), "X-Forwarded-For", "192.0.2.10"),
+			expectedStatus: http.StatusTemporaryRedirect,
+		},
+		{
+			name: "get /shortie/111 forwarded by an untrusted proxy",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", IPRules: IPRules{Allow: []string{"192.0.2.0/24"}}})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.trustedProxies = []string{"10.0.0.0/8"} },
+			httpRequest:    headerRequest(remoteRequest(httpRequest(http.MethodGet, "/shortie/111", nil), "198.51.100.10:1234"), "X-Forwarded-For", "192.0.2.10"),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "get /shortie/111 from a scanner",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			configure: func(api *shortieAPI) {
+				api.scanners = newScannerDetector(1, time.Minute, time.Hour, 0)
+				api.scanners.RecordMiss("192.0.2.10", time.Now())
+			},
+			httpRequest:    remoteRequest(httpRequest(http.MethodGet, "/shortie/111", nil), "192.0.2.10:1234"),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "get /shortie/111 from a scanner while redirects are at their limit",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			configure: func(api *shortieAPI) {
+				api.scanners = newScannerDetector(1, time.Minute, time.Hour, 0)
+				api.scanners.RecordMiss("192.0.2.10", time.Now())
+				api.concurrency, _ = parseConcurrencyLimits("/shortie/:id=1", 0, 0)
+				api.concurrency["/shortie/:id"].slots <- struct{}{}
+			},
+			httpRequest:    remoteRequest(httpRequest(http.MethodGet, "/shortie/111", nil), "192.0.2.10:1234"),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "get /shortie/111/docs while redirects are at their limit",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/", Passthrough: true})
+				require.NoError(t, err)
+			},
+			configure: func(api *shortieAPI) {
+				api.concurrency, _ = parseConcurrencyLimits("/shortie/:id=1", 0, 0)
+				api.concurrency["/shortie/:id"].slots <- struct{}{}
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111/docs", nil),
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "get /shortie/111/docs at the passthrough limit",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/", Passthrough: true})
+				require.NoError(t, err)
+			},
+			configure: func(api *shortieAPI) {
+				api.concurrency, _ = parseConcurrencyLimits("/shortie/:id=1,/shortie/:id/*path=1", 0, 0)
+				api.concurrency["/shortie/:id/*path"].slots <- struct{}{}
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111/docs", nil),
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "delete /admin/scanners/192.0.2.10",
+			configure: func(api *shortieAPI) {
+				api.adminToken = "admin"
+				api.scanners = newScannerDetector(1, time.Minute, time.Hour, 0)
+				api.scanners.RecordMiss("192.0.2.10", time.Now())
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodDelete, "/admin/scanners/192.0.2.10", nil), "Authorization", "Bearer admin"),
 			expectedStatus: http.StatusOK,
 		},
 		{
-			name: "delete /shortie/222 idempotent",
+			name: "get /admin/scanners",
+			configure: func(api *shortieAPI) {
+				api.adminToken = "admin"
+				api.scanners = newScannerDetector(1, time.Minute, time.Hour, 0)
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/admin/scanners", nil), "Authorization", "Bearer admin"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+		{
+			name: "get /shortie/111 from a denied ip",
 			setup: func(t *testing.T, storage urlStorage) {
-				err := storage.SaveURL(context.Background(), "111", "http://redirection.com/portal/portal", 0)
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", IPRules: IPRules{Deny: []string{"192.0.2.0/24"}}})
 				require.NoError(t, err)
 			},
-			httpRequest:    httpRequest(http.MethodDelete, "/shortie/222", nil),
+			httpRequest:    remoteRequest(httpRequest(http.MethodGet, "/shortie/111", nil), "192.0.2.10:1234"),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "create a url that looks like spam",
+			configure: func(api *shortieAPI) {
+				api.spam = newSpamChecker()
+				api.spam.maxEntropy = 3.8
+			},
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", bytes.NewReader([]byte(`{"url":"https://x7kq9zp2mw4bv8ht.top/"}`))),
 			expectedStatus: http.StatusOK,
+			expectedBody:   `{"shortUrl":"http://localhost:8421/shortie/27ad298763","status":"quarantined"}`,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "27ad298763")
+				require.NoError(t, err)
+				assert.True(t, object.Quarantined)
+				assert.Equal(t, "random looking domain", object.QuarantineReason)
+			},
 		},
 		{
-			name: "get usage - empty",
+			name: "get /shortie/docs/guide/intro passthrough",
 			setup: func(t *testing.T, storage urlStorage) {
-				err := storage.SaveURL(context.Background(), "111", "http://redirection.com/portal/portal", 0)
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "docs", URL: "https://docs.example.com/v2/", Passthrough: true})
 				require.NoError(t, err)
-				_, _ = storage.GetURL(context.Background(), "111")
 			},
-			httpRequest:    httpRequest(http.MethodGet, "/shortie/222/stats", nil),
+			httpRequest:     httpRequest(http.MethodGet, "/shortie/docs/guide/intro?lang=en", nil),
+			expectedStatus:  http.StatusTemporaryRedirect,
+			expectedHeaders: map[string]string{"Location": "https://docs.example.com/v2/guide/intro?lang=en"},
+		},
+		{
+			name: "get /shortie/docs passthrough without a path",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "docs", URL: "https://docs.example.com/v2/", Passthrough: true})
+				require.NoError(t, err)
+			},
+			httpRequest:     httpRequest(http.MethodGet, "/shortie/docs", nil),
+			expectedStatus:  http.StatusTemporaryRedirect,
+			expectedHeaders: map[string]string{"Location": "https://docs.example.com/v2/"},
+		},
+		{
+			name: "get /shortie/111/guide without passthrough",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111/guide", nil),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "get /shortie/111 with an operator",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			configure:       func(api *shortieAPI) { api.operator = operatorInfo{Name: "Example Co", Contact: "abuse@example.com"} },
+			httpRequest:     httpRequest(http.MethodGet, "/shortie/111", nil),
+			expectedStatus:  http.StatusTemporaryRedirect,
+			expectedHeaders: map[string]string{"X-Shortie-Owner": "Example Co <abuse@example.com>"},
+		},
+		{
+			name:           "get /abuse without an operator",
+			httpRequest:    httpRequest(http.MethodGet, "/abuse", nil),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:            "get /abuse",
+			configure:       func(api *shortieAPI) { api.operator = operatorInfo{Name: "Example Co", Contact: "abuse@example.com"} },
+			httpRequest:     httpRequest(http.MethodGet, "/abuse", nil),
+			expectedStatus:  http.StatusOK,
+			expectedHeaders: map[string]string{"Content-Type": "text/html; charset=utf-8"},
+		},
+		{
+			name:            "get /about custom page",
+			configure:       func(api *shortieAPI) { api.aboutPage = []byte("<p>about us</p>") },
+			httpRequest:     httpRequest(http.MethodGet, "/about", nil),
+			expectedStatus:  http.StatusOK,
+			expectedHeaders: map[string]string{"Content-Type": "text/html; charset=utf-8"},
+		},
+		{
+			name: "get /shortie/l0Ol5ao mistyped",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "10Ol5ao", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.checksumAlphabet = base62Alphabet },
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/l0Ol5ao", nil),
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"code":"ID_MISTYPED","message":"the shortie id is mistyped","details":{"didYouMean":["10Ol5ao"]},"requestID":"test"}`,
+		},
+		{
+			name:           "get /shortie/10Ol5ao missing with a valid checksum",
+			configure:      func(api *shortieAPI) { api.checksumAlphabet = base62Alphabet },
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/10Ol5ao", nil),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "get /shortie/111 quarantined",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Quarantined: true})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111", nil),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "post /admin/quarantine/111/approve",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Quarantined: true})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.adminToken = "admin" },
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/admin/quarantine/111/approve", nil), "Authorization", "Bearer admin"),
 			expectedStatus: http.StatusOK,
-			expectedBody:   `{"lastDay":0,"lastWeek":0,"allTime":0}`,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "111")
+				require.NoError(t, err)
+				assert.False(t, object.Quarantined)
+			},
 		},
 		{
-			name: "get usage",
+			name: "post /admin/quarantine/111/reject",
 			setup: func(t *testing.T, storage urlStorage) {
-				err := storage.SaveURL(context.Background(), "111", "http://redirection.com/portal/portal", 0)
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Quarantined: true, QuarantineReason: "repeated destination"})
+				require.NoError(t, err)
+				err = storage.SaveURL(context.Background(), URLObject{ShortID: "222", URL: "http://redirection.com/other"})
 				require.NoError(t, err)
-				_, _ = storage.GetURL(context.Background(), "111")
-				_, _ = storage.GetURL(context.Background(), "111")
-				_, _ = storage.GetURL(context.Background(), "111")
 			},
-			httpRequest:    httpRequest(http.MethodGet, "/shortie/111/stats", nil),
+			configure:      func(api *shortieAPI) { api.adminToken = "admin" },
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/admin/quarantine/111/reject", nil), "Authorization", "Bearer admin"),
 			expectedStatus: http.StatusOK,
-			expectedBody:   `{"lastDay":3,"lastWeek":3,"allTime":3}`,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "111")
+				require.NoError(t, err)
+				assert.Nil(t, object)
+			},
 		},
-	}
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			storage := &LocalStorage{
-				Objects: map[string]URLObject{},
-				lock:    sync.Mutex{},
-			}
-			if test.setup != nil {
-				test.setup(t, storage)
-			}
-
-			router := shortieAPI{storage: storage}.GetRouter()
-			w := httptest.NewRecorder()
-			router.ServeHTTP(w, test.httpRequest)
-			assert.Equal(t, test.expectedStatus, w.Code)
-			if test.expectedBody != "" {
-				assert.JSONEq(t, test.expectedBody, w.Body.String())
+		{
+			name: "get /admin/quarantine",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Quarantined: true, QuarantineReason: "repeated destination"})
+				require.NoError(t, err)
+				err = storage.SaveURL(context.Background(), URLObject{ShortID: "222", URL: "http://redirection.com/other"})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.adminToken = "admin" },
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/admin/quarantine", nil), "Authorization", "Bearer admin"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"shortID":"111","url":"http://redirection.com/portal/portal","reason":"repeated destination"}]`,
+		},
+		{
+			name:           "create a url with an id generator",
+			configure:      func(api *shortieAPI) { api.ids = fixedIDs("0000000001") },
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", bytes.NewReader([]byte(`{"url":"https://example.com/data/hi"}`))),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"shortUrl":"http://localhost:8421/shortie/0000000001"}`,
+		},
+		{
+			name:           "create a url with a body over the limit",
+			configure:      func(api *shortieAPI) { api.maxBodyBytes = 64 },
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", bytes.NewReader([]byte(`{"url":"https://example.com/`+strings.Repeat("a", 64)+`"}`))),
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedBody:   `{"code":"BODY_TOO_LARGE","message":"the body is larger than 64 bytes","requestID":"test"}`,
+		},
+		{
+			name:           "create a url that is too long",
+			configure:      func(api *shortieAPI) { api.maxURLLength = 32 },
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", bytes.NewReader([]byte(`{"url":"https://example.com/data/hi","referrerRules":[{"host":"t.co","url":"https://example.com/`+strings.Repeat("a", 32)+`"}]}`))),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"code":"URL_INVALID","message":"urls can be at most 32 characters","requestID":"test"}`,
+		},
+		{
+			name:           "create a url with an unknown field in strict mode",
+			configure:      func(api *shortieAPI) { api.strictJSON = true },
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", bytes.NewReader([]byte(`{"url":"https://example.com/data/hi","expiry":1730689222}`))),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"code":"INVALID_JSON","message":"json: unknown field \"expiry\"","requestID":"test"}`,
+		},
+		{
+			name:           "create a url with an unknown field",
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", bytes.NewReader([]byte(`{"url":"https://example.com/data/hi","expiry":1730689222}`))),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "create a url with an invalid schedule",
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", bytes.NewReader([]byte(`{"url":"https://example.com/data/hi","schedule":{"timezone":"Nowhere/Special","rules":[{"start":"09:00","end":"17:00","url":"https://example.com/chat"}]}}`))),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "get /shortie/111 on schedule",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Schedule: Schedule{
+					Rules: []ScheduleRule{{Start: "00:00", End: "00:00", URL: "http://redirection.com/always"}},
+				}})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111", nil),
+			expectedStatus: http.StatusTemporaryRedirect,
+			expectedHeaders: map[string]string{
+				"Location": "http://redirection.com/always",
+			},
+		},
+		{
+			name: "get /shortie/111 from a referrer with a rule",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", ReferrerRules: []ReferrerRule{
+					{Host: "twitter.com", URL: "http://redirection.com/portal/portal?utm_source=twitter"},
+				}})
+				require.NoError(t, err)
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/shortie/111", nil), "Referer", "https://mobile.twitter.com/some/post"),
+			expectedStatus: http.StatusTemporaryRedirect,
+			expectedHeaders: map[string]string{
+				"Location": "http://redirection.com/portal/portal?utm_source=twitter",
+			},
+			expectations: func(t *testing.T, storage urlStorage) {
+				statistics, err := storage.GetStatistics(context.Background(), "111")
+				require.NoError(t, err)
+				assert.Equal(t, map[string]int64{"referrer:twitter.com": 1}, statistics.RuleUsage)
+			},
+		},
+		{
+			name: "get /shortie/111 with a preferred language",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", LanguageRules: map[string]string{
+					"fr": "http://redirection.com/fr/portal",
+					"de": "http://redirection.com/de/portal",
+				}})
+				require.NoError(t, err)
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/shortie/111", nil), "Accept-Language", "en-US,fr-CA;q=0.8,de;q=0.5"),
+			expectedStatus: http.StatusTemporaryRedirect,
+			expectedHeaders: map[string]string{
+				"Location": "http://redirection.com/fr/portal",
+			},
+		},
+		{
+			name: "get /shortie/111 without a matching language",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", LanguageRules: map[string]string{
+					"fr": "http://redirection.com/fr/portal",
+				}})
+				require.NoError(t, err)
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/shortie/111", nil), "Accept-Language", "en-US,en;q=0.9"),
+			expectedStatus: http.StatusTemporaryRedirect,
+			expectedHeaders: map[string]string{
+				"Location": "http://redirection.com/portal/portal",
+			},
+		},
+		{
+			name: "get /shortie/111 app link from android",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", AppLink: AppLink{URI: "portal://portal/home", AndroidPackage: "com.redirection.portal"}})
+				require.NoError(t, err)
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/shortie/111", nil), "User-Agent", "Mozilla/5.0 (Linux; Android 14; Pixel 8)"),
+			expectedStatus: http.StatusTemporaryRedirect,
+			expectedHeaders: map[string]string{
+				"Location": "intent://portal/home#Intent;scheme=portal;package=com.redirection.portal;S.browser_fallback_url=http%3A%2F%2Fredirection.com%2Fportal%2Fportal;end",
+			},
+		},
+		{
+			name: "get /shortie/111 app link from ios",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", AppLink: AppLink{URI: "portal://portal/home"}})
+				require.NoError(t, err)
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/shortie/111", nil), "User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)"),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "get /shortie/111 app link from desktop",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", AppLink: AppLink{URI: "portal://portal/home"}})
+				require.NoError(t, err)
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/shortie/111", nil), "User-Agent", "Mozilla/5.0 (X11; Linux x86_64)"),
+			expectedStatus: http.StatusTemporaryRedirect,
+			expectedHeaders: map[string]string{
+				"Location": "http://redirection.com/portal/portal",
+			},
+		},
+		{
+			name:           "create a url with a javascript app link",
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"https://example.com/data/hi","appLink":{"uri":"javascript:alert(1)"}}`)),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "create a url with an app link to a data uri",
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"https://example.com/data/hi","appLink":{"uri":"DATA:text/html,<script>alert(1)</script>"}}`)),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "create a url with an app link to an injected android package",
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"https://example.com/data/hi","appLink":{"uri":"portal://home","androidPackage":"com.portal;S.browser_fallback_url=javascript:alert(1)#"}}`)),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "create a javascript url",
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"javascript:alert(1)","appLink":{"uri":"portal://home"}}`)),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "create a url with a javascript language rule",
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"https://example.com/data/hi","languageRules":{"fr":"javascript:alert(1)"}}`)),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "get /shortie/111 app link saved with a javascript fallback from ios",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "javascript:alert(1)", AppLink: AppLink{URI: "portal://portal/home"}})
+				require.NoError(t, err)
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/shortie/111", nil), "User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)"),
+			expectedStatus: http.StatusTemporaryRedirect,
+		},
+		{
+			name: "get /shortie/111 noindex",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", NoIndex: true})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111", nil),
+			expectedStatus: http.StatusTemporaryRedirect,
+			expectedHeaders: map[string]string{
+				"X-Robots-Tag": "noindex",
+			},
+		},
+		{
+			name:           "get /health",
+			httpRequest:    httpRequest(http.MethodGet, "/health", nil),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"healthy":true,"backend":"memory"}`,
+		},
+		{
+			name:           "get /robots.txt",
+			httpRequest:    httpRequest(http.MethodGet, "/robots.txt", nil),
+			expectedStatus: http.StatusOK,
+			expectedHeaders: map[string]string{
+				"Content-Type": "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:           "get /favicon.ico",
+			httpRequest:    httpRequest(http.MethodGet, "/favicon.ico", nil),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "get /static/style.css",
+			httpRequest:    httpRequest(http.MethodGet, "/static/style.css", nil),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "get /admin/cache/stats without a token",
+			configure:      func(api *shortieAPI) { api.adminToken = "admin" },
+			httpRequest:    httpRequest(http.MethodGet, "/admin/cache/stats", nil),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "get /admin/config",
+			configure: func(api *shortieAPI) {
+				api.adminToken = "admin"
+				api.config = map[string]string{"SHORTIE_ADMIN_TOKEN": redactedValue, "SHORTIE_CACHE_TTL": "30s"}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/admin/config", nil), "Authorization", "Bearer admin"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"SHORTIE_ADMIN_TOKEN":"[redacted]","SHORTIE_CACHE_TTL":"30s"}`,
+		},
+		{
+			name: "get /admin/cache/stats",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			configure: func(api *shortieAPI) {
+				api.cache = NewCachedStorage(api.storage, time.Minute)
+				api.storage = api.cache
+				api.adminToken = "admin"
+				_, _ = api.storage.GetURL(context.Background(), "111")
+				_, _ = api.storage.GetURL(context.Background(), "111")
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/admin/cache/stats", nil), "Authorization", "Bearer admin"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"entries":1,"hits":1,"misses":1,"ttl":"1m0s"}`,
+		},
+		{
+			name: "post /admin/cache/flush",
+			configure: func(api *shortieAPI) {
+				api.cache = NewCachedStorage(api.storage, time.Minute)
+				api.storage = api.cache
+				api.adminToken = "admin"
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/admin/cache/flush?id=111", nil), "Authorization", "Bearer admin"),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "get /admin/migration without a migration",
+			configure:      func(api *shortieAPI) { api.adminToken = "admin" },
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/admin/migration", nil), "Authorization", "Bearer admin"),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "get /admin/migration",
+			configure: func(api *shortieAPI) {
+				api.migration = NewMigratingStorage(api.storage, &LocalStorage{Objects: map[string]URLObject{}, lock: sync.Mutex{}})
+				api.storage = api.migration
+				api.adminToken = "admin"
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/admin/migration", nil), "Authorization", "Bearer admin"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"state":"pending","copied":0,"failed":0,"verified":0,"mismatches":0,"mismatched":[]}`,
+		},
+		{
+			name: "get /shortie/stats batch",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+				err = storage.SaveURL(context.Background(), URLObject{ShortID: "222", URL: "http://redirection.com/other"})
+				require.NoError(t, err)
+				_ = storage.IncrementUsage(context.Background(), "111", "", 1, 1)
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/stats?ids=111,222,333", nil),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"111":{"lastDay":1,"lastWeek":1,"last30Days":1,"lastMonth":1,"allTime":1},"222":{"lastDay":0,"lastWeek":0,"last30Days":0,"lastMonth":0,"allTime":0}}`,
+		},
+		{
+			name: "get /campaigns/:name/stats",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Campaign: "launch"})
+				require.NoError(t, err)
+				err = storage.SaveURL(context.Background(), URLObject{ShortID: "222", URL: "http://redirection.com/other", Campaign: "launch"})
+				require.NoError(t, err)
+				err = storage.SaveURL(context.Background(), URLObject{ShortID: "333", URL: "http://redirection.com/unrelated"})
+				require.NoError(t, err)
+				_ = storage.IncrementUsage(context.Background(), "111", "", 1, 1)
+				_ = storage.IncrementUsage(context.Background(), "222", "", 1, 1)
+				_ = storage.IncrementUsage(context.Background(), "333", "", 1, 1)
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/campaigns/launch/stats", nil),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"lastDay":2,"lastWeek":2,"last30Days":2,"lastMonth":2,"allTime":2,"links":2}`,
+		},
+		{
+			name:           "get /campaigns/:name/stats for an unknown campaign",
+			httpRequest:    httpRequest(http.MethodGet, "/campaigns/missing/stats", nil),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "post /shortie failing if the url is already shortened",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"HTTP://redirection.com/portal/portal","failIfExists":true}`)),
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"code":"URL_EXISTS","message":"the url is already shortened","details":{"shortID":"111","shortUrl":"http://localhost:8421/shortie/111"},"requestID":"test"}`,
+		},
+		{
+			name:           "post /shortie failing if the url is already shortened for a new url",
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"http://redirection.com/portal/portal","failIfExists":true}`)),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"shortUrl":"http://localhost:8421/shortie/0f9c8c6ff5"}`,
+		},
+		{
+			name:           "post /shortie clamping the expiration to the max ttl",
+			configure:      func(api *shortieAPI) { api.maxTTL = time.Hour },
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"http://redirection.com/portal/portal"}`)),
+			expectedStatus: http.StatusOK,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "0f9c8c6ff5")
+				require.NoError(t, err)
+				assert.InDelta(t, time.Now().Add(time.Hour).Unix(), object.Expiration, 5)
+			},
+		},
+		{
+			name: "post /shortie rejecting an expiration past the max ttl",
+			configure: func(api *shortieAPI) {
+				api.maxTTL = time.Hour
+				api.rejectPastMaxTTL = true
+			},
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"http://redirection.com/portal/portal","expiration":`+inADay+`}`)),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"code":"EXPIRATION_INVALID","message":"expiration is required and can be at most 1h0m0s away","requestID":"test"}`,
+		},
+		{
+			name: "post /shortie with an expiration within the max ttl",
+			configure: func(api *shortieAPI) {
+				api.maxTTL = 2 * time.Hour
+				api.rejectPastMaxTTL = true
+			},
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"http://redirection.com/portal/portal","expiration":`+inAnHour+`}`)),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"shortUrl":"http://localhost:8421/shortie/0f9c8c6ff5"}`,
+		},
+		{
+			name:           "post /shortie dry run",
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"http://redirection.com/portal/portal","dryRun":true}`)),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"dryRun":true,"shortID":"0f9c8c6ff5","shortUrl":"http://localhost:8421/shortie/0f9c8c6ff5","exists":false}`,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "0f9c8c6ff5")
+				require.NoError(t, err)
+				assert.Nil(t, object)
+			},
+		},
+		{
+			name: "post /shortie dry run for an existing link",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "0f9c8c6ff5", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"http://redirection.com/portal/portal","dryRun":true}`)),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"dryRun":true,"shortID":"0f9c8c6ff5","shortUrl":"http://localhost:8421/shortie/0f9c8c6ff5","exists":true}`,
+		},
+		{
+			name:           "post /shortie dry run with validation errors",
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"http://redirection.com","activeFrom":20,"expiration":10,"dryRun":true}`)),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"code":"EXPIRATION_INVALID","message":"activeFrom must be before expiration","requestID":"test"}`,
+		},
+		{
+			name:           "post /shortie with a campaign name that is too long",
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"http://redirection.com","campaign":"`+strings.Repeat("a", 129)+`"}`)),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"code":"INVALID_PARAMETER","message":"campaign names can be at most 128 characters","requestID":"test"}`,
+		},
+		{
+			name: "get /admin/links/:id",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{
+					ShortID:     "111",
+					URL:         "http://redirection.com/portal/portal",
+					Notes:       "requested by marketing",
+					Annotations: map[string]string{"ticket": "MKT-42"},
+				})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.adminToken = "admin" },
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/admin/links/111", nil), "Authorization", "Bearer admin"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"shortID":"111","url":"http://redirection.com/portal/portal","paused":false,"quarantined":false,"locked":false,"notes":"requested by marketing","annotations":{"ticket":"MKT-42"}}`,
+		},
+		{
+			name:           "get /admin/links/:id without a token",
+			configure:      func(api *shortieAPI) { api.adminToken = "admin" },
+			httpRequest:    httpRequest(http.MethodGet, "/admin/links/111", nil),
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"code":"UNAUTHORIZED","message":"invalid admin token","requestID":"test"}`,
+		},
+		{
+			name:           "post /shortie with an empty annotation key",
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"http://redirection.com","annotations":{"":"x"}}`)),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"code":"INVALID_PARAMETER","message":"annotation keys must be between 1 and 128 characters","requestID":"test"}`,
+		},
+		{
+			name: "post /shortie/:id/extend",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Expiration: time.Now().Add(time.Minute).Unix()})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodPost, "/shortie/111/extend", strings.NewReader(`{"expiration":`+inAnHour+`}`)),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"expiration":` + inAnHour + `}`,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "111")
+				require.NoError(t, err)
+				assert.Equal(t, inAnHour, strconv.FormatInt(object.Expiration, 10))
+			},
+		},
+		{
+			name: "post /shortie/:id/extend into the past",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodPost, "/shortie/111/extend", strings.NewReader(`{"expiration":1000}`)),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"code":"EXPIRATION_INVALID","message":"expiration must be in the future","requestID":"test"}`,
+		},
+		{
+			name: "post /shortie/:id/extend past the max ttl",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.maxTTL = 2 * time.Hour },
+			httpRequest:    httpRequest(http.MethodPost, "/shortie/111/extend", strings.NewReader(`{"expiration":`+inADay+`}`)),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"code":"EXPIRATION_INVALID","message":"expiration can be at most 2h0m0s away","requestID":"test"}`,
+		},
+		{
+			name: "post /shortie/:id/extend for an expired link",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Expiration: 1000})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodPost, "/shortie/111/extend", strings.NewReader(`{"expiration":`+inAnHour+`}`)),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "get an archived link",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Expiration: time.Now().Add(-time.Hour).Unix()})
+				require.NoError(t, err)
+			},
+			configure:       func(api *shortieAPI) { api.archiveGrace = 24 * time.Hour },
+			httpRequest:     httpRequest(http.MethodGet, "/shortie/111", nil),
+			expectedStatus:  http.StatusGone,
+			expectedHeaders: map[string]string{"Content-Type": "text/html; charset=utf-8"},
+		},
+		{
+			name: "get a signed archived link",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", SigningSecret: "secret", Expiration: time.Now().Add(-time.Hour).Unix()})
+				require.NoError(t, err)
+			},
+			configure:       func(api *shortieAPI) { api.archiveGrace = 24 * time.Hour },
+			httpRequest:     httpRequest(http.MethodGet, "/shortie/111", nil),
+			expectedStatus:  http.StatusGone,
+			expectedHeaders: map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+		},
+		{
+			name: "get a link past its archive grace period",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Expiration: time.Now().Add(-48 * time.Hour).Unix()})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.archiveGrace = 24 * time.Hour },
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111", nil),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:            "errors carry the client's request id",
+			configure:       func(api *shortieAPI) { api.adminToken = "admin" },
+			httpRequest:     headerRequest(httpRequest(http.MethodGet, "/admin/links/111", nil), "X-Request-ID", "abc-123"),
+			expectedStatus:  http.StatusUnauthorized,
+			expectedBody:    `{"code":"UNAUTHORIZED","message":"invalid admin token","requestID":"abc-123"}`,
+			expectedHeaders: map[string]string{"X-Request-ID": "abc-123"},
+		},
+		{
+			name:           "post /shortie/:id/pause for a missing link",
+			httpRequest:    httpRequest(http.MethodPatch, "/shortie/111/pause", nil),
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"code":"NOT_FOUND","message":"the shortie id is not found","requestID":"test"}`,
+		},
+		{
+			name:            "post /v1/shortie",
+			httpRequest:     httpRequest(http.MethodPost, "/v1/shortie", strings.NewReader(`{"url":"http://redirection.com/portal/portal"}`)),
+			expectedStatus:  http.StatusOK,
+			expectedBody:    `{"shortUrl":"http://localhost:8421/shortie/0f9c8c6ff5"}`,
+			expectedHeaders: map[string]string{"X-Shortie-API-Version": "1"},
+		},
+		{
+			name: "get /v1/shortie/:id/stats",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+				_ = storage.IncrementUsage(context.Background(), "111", "", 1, 1)
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/v1/shortie/111/stats", nil), "X-Shortie-API-Version", "1"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"lastDay":1,"lastWeek":1,"last30Days":1,"lastMonth":1,"allTime":1}`,
+		},
+		{
+			name:           "get /v1/shortie/:id is not a route",
+			httpRequest:    httpRequest(http.MethodGet, "/v1/shortie/111", nil),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "request an unsupported api version",
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/shortie/111/stats", nil), "X-Shortie-API-Version", "7"),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"code":"UNSUPPORTED_VERSION","message":"unsupported api version 7","details":{"supported":["1"]},"requestID":"test"}`,
+		},
+		{
+			name: "get /admin/leaderboard",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+				err = storage.SaveURL(context.Background(), URLObject{ShortID: "222", URL: "http://redirection.com/other"})
+				require.NoError(t, err)
+				_ = storage.IncrementUsage(context.Background(), "222", "", 1, 1)
+			},
+			configure:      func(api *shortieAPI) { api.adminToken = "admin" },
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/admin/leaderboard?limit=1", nil), "Authorization", "Bearer admin"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"shortID":"222","allTime":1}]`,
+		},
+		{
+			name: "get /admin/leaderboard with hourly usage",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.ImportURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Usage: map[string]int64{
+					strconv.FormatInt(today.Unix(), 10): 2,
+					hourUsageKey(today):                 2,
+				}})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.adminToken = "admin" },
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/admin/leaderboard", nil), "Authorization", "Bearer admin"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"shortID":"111","allTime":2}]`,
+		},
+		{
+			name: "get /shortie/lookup",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+				err = storage.SaveURL(context.Background(), URLObject{ShortID: "222", URL: "HTTP://Redirection.com:80/portal/portal#top"})
+				require.NoError(t, err)
+				err = storage.SaveURL(context.Background(), URLObject{ShortID: "333", URL: "http://redirection.com/other"})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/lookup?url="+url.QueryEscape("http://redirection.com/portal/portal"), nil),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"exact":["111"],"normalized":["222"]}`,
+		},
+		{
+			name: "get /shortie/111 paused",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+				err = storage.SetPaused(context.Background(), "111", true)
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111", nil),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectations: func(t *testing.T, storage urlStorage) {
+				statistics, err := storage.GetStatistics(context.Background(), "111")
+				require.NoError(t, err)
+				assert.Empty(t, statistics.Usage)
+			},
+		},
+		{
+			name: "pause /shortie/111",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodPatch, "/shortie/111/pause", nil),
+			expectedStatus: http.StatusOK,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "111")
+				require.NoError(t, err)
+				assert.True(t, object.Paused)
+			},
+		},
+		{
+			name: "resume /shortie/111",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+				err = storage.SetPaused(context.Background(), "111", true)
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodPatch, "/shortie/111/resume", nil),
+			expectedStatus: http.StatusOK,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "111")
+				require.NoError(t, err)
+				assert.False(t, object.Paused)
+			},
+		},
+		{
+			name:           "pause /shortie/222 not found",
+			httpRequest:    httpRequest(http.MethodPatch, "/shortie/222/pause", nil),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "delete /shortie/111",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodDelete, "/shortie/111", nil),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "delete /shortie/222 idempotent",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodDelete, "/shortie/222", nil),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "get usage - empty",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+				_ = storage.IncrementUsage(context.Background(), "111", "", 1, 1)
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/222/stats", nil),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"lastDay":0,"lastWeek":0,"last30Days":0,"lastMonth":0,"allTime":0}`,
+		},
+		{
+			name: "get usage",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+				_ = storage.IncrementUsage(context.Background(), "111", "", 1, 1)
+				_ = storage.IncrementUsage(context.Background(), "111", "", 1, 1)
+				_ = storage.IncrementUsage(context.Background(), "111", "", 1, 1)
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111/stats", nil),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"lastDay":3,"lastWeek":3,"last30Days":3,"lastMonth":3,"allTime":3}`,
+		},
+		{
+			name: "get usage over custom days with the daily breakdown",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.ImportURL(context.Background(), URLObject{
+					ShortID: "111",
+					URL:     "http://redirection.com/portal/portal",
+					Usage: map[string]int64{
+						strconv.FormatInt(today.Unix(), 10):        2,
+						strconv.FormatInt(fortyDaysAgo.Unix(), 10): 5,
+						hourUsageKey(today):                        2,
+					},
+				})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111/stats?days=60&detailed=true", nil),
+			expectedStatus: http.StatusOK,
+			expectedBody: `{"lastDay":2,"lastWeek":2,"last30Days":2,"lastMonth":2,"allTime":7,"days":60,"lastDays":7,"daily":{"` +
+				today.Format(time.DateOnly) + `":2,"` + fortyDaysAgo.Format(time.DateOnly) + `":5},"hourly":{"` + today.Format(time.RFC3339) + `":2}}`,
+		},
+		{
+			name:           "get usage with an invalid days parameter",
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111/stats?days=0", nil),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"code":"INVALID_PARAMETER","message":"days must be a number between 1 and 3660","requestID":"test"}`,
+		},
+		{
+			name: "create a url with tracking parameters",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "4e24c46962", URL: "https://example.com/data/hi"})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.canonicalizer = parseCanonicalizer("fbclid,utm_*", false, false) },
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", bytes.NewReader([]byte(`{"url":"https://example.com/data/hi?utm_source=mail&fbclid=abc"}`))),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"shortUrl": "http://localhost:8421/shortie/4e24c46962", "canonicalUrl": "https://example.com/data/hi"}`,
+		},
+		{
+			name:           "create a url while read-only",
+			configure:      func(api *shortieAPI) { api.readOnly = newSwitch(true) },
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", bytes.NewReader([]byte(`{"url":"https://example.com/data/hi"}`))),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{"code":"READ_ONLY","message":"links can't be changed while the service is read-only","requestID":"test"}`,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "4e24c46962")
+				require.NoError(t, err)
+				assert.Nil(t, object)
+			},
+		},
+		{
+			name: "get /shortie/111 redirect while read-only",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.readOnly = newSwitch(true) },
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111", nil),
+			expectedStatus: http.StatusTemporaryRedirect,
+		},
+		{
+			name: "delete /shortie/111 while read-only",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.readOnly = newSwitch(true) },
+			httpRequest:    httpRequest(http.MethodDelete, "/v1/shortie/111", nil),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "111")
+				require.NoError(t, err)
+				assert.NotNil(t, object)
+			},
+		},
+		{
+			name: "put /admin/read-only",
+			configure: func(api *shortieAPI) {
+				api.adminToken = "admin"
+				api.readOnly = newSwitch(false)
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodPut, "/admin/read-only", strings.NewReader(`{"readOnly":true}`)), "Authorization", "Bearer admin"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"readOnly":true}`,
+		},
+		{
+			name: "put /admin/read-only without readOnly",
+			configure: func(api *shortieAPI) {
+				api.adminToken = "admin"
+				api.readOnly = newSwitch(true)
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodPut, "/admin/read-only", strings.NewReader(`{}`)), "Authorization", "Bearer admin"),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "get /t/eng/deploy-guide redirect",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "eng/deploy-guide", URL: "https://wiki.example.com/eng/deploying"})
+				require.NoError(t, err)
+			},
+			httpRequest:     httpRequest(http.MethodGet, "/t/eng/deploy-guide", nil),
+			expectedStatus:  http.StatusTemporaryRedirect,
+			expectedHeaders: map[string]string{"Location": "https://wiki.example.com/eng/deploying"},
+		},
+		{
+			name: "get /t/growth/deploy-guide of another team",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "eng/deploy-guide", URL: "https://wiki.example.com/eng/deploying"})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.checksumAlphabet = base62Alphabet },
+			httpRequest:    httpRequest(http.MethodGet, "/t/growth/deploy-guide", nil),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "post /teams/eng/aliases",
+			configure: func(api *shortieAPI) {
+				api.teamTokens = map[string]string{"eng": "eng-token", "growth": "growth-token"}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/teams/eng/aliases", strings.NewReader(`{"alias":"deploy-guide","url":"https://wiki.example.com/eng/deploying"}`)), "Authorization", "Bearer eng-token"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"alias":"deploy-guide","url":"https://wiki.example.com/eng/deploying","shortUrl":"http://localhost:8421/t/eng/deploy-guide"}`,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "eng/deploy-guide")
+				require.NoError(t, err)
+				require.NotNil(t, object)
+				assert.Equal(t, "https://wiki.example.com/eng/deploying", object.URL)
+			},
+		},
+		{
+			name: "post /teams/growth/aliases with an alias another team uses",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "eng/deploy-guide", URL: "https://wiki.example.com/eng/deploying"})
+				require.NoError(t, err)
+			},
+			configure: func(api *shortieAPI) {
+				api.teamTokens = map[string]string{"eng": "eng-token", "growth": "growth-token"}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/teams/growth/aliases", strings.NewReader(`{"alias":"deploy-guide","url":"https://wiki.example.com/growth/launches"}`)), "Authorization", "Bearer growth-token"),
+			expectedStatus: http.StatusOK,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "eng/deploy-guide")
+				require.NoError(t, err)
+				assert.Equal(t, "https://wiki.example.com/eng/deploying", object.URL)
+			},
+		},
+		{
+			name: "post /teams/eng/aliases with a taken alias",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "eng/deploy-guide", URL: "https://wiki.example.com/eng/deploying"})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.teamTokens = map[string]string{"eng": "eng-token"} },
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/teams/eng/aliases", strings.NewReader(`{"alias":"deploy-guide","url":"https://wiki.example.com/other"}`)), "Authorization", "Bearer eng-token"),
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"code":"ALIAS_TAKEN","message":"the alias is already taken","details":{"url":"https://wiki.example.com/eng/deploying"},"requestID":"test"}`,
+		},
+		{
+			name:           "post /teams/eng/aliases with an invalid alias",
+			configure:      func(api *shortieAPI) { api.teamTokens = map[string]string{"eng": "eng-token"} },
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/teams/eng/aliases", strings.NewReader(`{"alias":"Deploy Guide","url":"https://wiki.example.com/eng/deploying"}`)), "Authorization", "Bearer eng-token"),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "post /teams/eng/aliases with another team's token",
+			configure: func(api *shortieAPI) {
+				api.teamTokens = map[string]string{"eng": "eng-token", "growth": "growth-token"}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/teams/eng/aliases", strings.NewReader(`{"alias":"deploy-guide","url":"https://wiki.example.com/eng/deploying"}`)), "Authorization", "Bearer growth-token"),
+			expectedStatus: http.StatusUnauthorized,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "eng/deploy-guide")
+				require.NoError(t, err)
+				assert.Nil(t, object)
+			},
+		},
+		{
+			name:           "post /teams/ops/aliases of an unknown team",
+			configure:      func(api *shortieAPI) { api.teamTokens = map[string]string{"eng": "eng-token"} },
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/teams/ops/aliases", strings.NewReader(`{"alias":"deploy-guide","url":"https://wiki.example.com/eng/deploying"}`)), "Authorization", "Bearer eng-token"),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "get /teams/eng/aliases with the admin token",
+			setup: func(t *testing.T, storage urlStorage) {
+				for _, object := range []URLObject{
+					{ShortID: "eng/runbook", URL: "https://wiki.example.com/eng/runbook"},
+					{ShortID: "eng/deploy-guide", URL: "https://wiki.example.com/eng/deploying"},
+					{ShortID: "growth/deploy-guide", URL: "https://wiki.example.com/growth/launches"},
+					{ShortID: "111", URL: "http://redirection.com/portal/portal"},
+				} {
+					err := storage.SaveURL(context.Background(), object)
+					require.NoError(t, err)
+				}
+			},
+			configure: func(api *shortieAPI) {
+				api.adminToken = "admin"
+				api.teamTokens = map[string]string{"eng": "eng-token", "growth": "growth-token"}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/v1/teams/eng/aliases", nil), "Authorization", "Bearer admin"),
+			expectedStatus: http.StatusOK,
+			expectedBody: `{"aliases":[
+				{"alias":"deploy-guide","url":"https://wiki.example.com/eng/deploying","shortUrl":"http://localhost:8421/t/eng/deploy-guide"},
+				{"alias":"runbook","url":"https://wiki.example.com/eng/runbook","shortUrl":"http://localhost:8421/t/eng/runbook"}
+			]}`,
+		},
+		{
+			name: "delete /teams/eng/aliases/deploy-guide",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "eng/deploy-guide", URL: "https://wiki.example.com/eng/deploying"})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.teamTokens = map[string]string{"eng": "eng-token"} },
+			httpRequest:    headerRequest(httpRequest(http.MethodDelete, "/teams/eng/aliases/deploy-guide", nil), "Authorization", "Bearer eng-token"),
+			expectedStatus: http.StatusOK,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "eng/deploy-guide")
+				require.NoError(t, err)
+				assert.Nil(t, object)
+			},
+		},
+		{
+			name: "post /shortie without an api key",
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "editor-key", principal: principal{name: "ci", role: roleEditor}}}
+			},
+			httpRequest:    httpRequest(http.MethodPost, "/shortie", bytes.NewReader([]byte(`{"url":"https://example.com/data/hi"}`))),
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"code":"UNAUTHORIZED","message":"a valid api key is required","requestID":"test"}`,
+		},
+		{
+			name: "post /shortie as a viewer",
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "viewer-key", principal: principal{name: "grafana", role: roleViewer}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/shortie", bytes.NewReader([]byte(`{"url":"https://example.com/data/hi"}`))), "Authorization", "Bearer viewer-key"),
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"code":"FORBIDDEN","message":"the api key's role doesn't allow this","requestID":"test"}`,
+		},
+		{
+			name: "post /shortie as an editor records the owner",
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "editor-key", principal: principal{name: "ci", role: roleEditor}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/shortie", bytes.NewReader([]byte(`{"url":"https://example.com/data/hi"}`))), "Authorization", "Bearer editor-key"),
+			expectedStatus: http.StatusOK,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "4e24c46962")
+				require.NoError(t, err)
+				require.NotNil(t, object)
+				assert.Equal(t, "ci", object.Owner)
+			},
+		},
+		{
+			name: "get /shortie/111/stats as a viewer",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "viewer-key", principal: principal{name: "grafana", role: roleViewer}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/shortie/111/stats", nil), "Authorization", "Bearer viewer-key"),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "get /shortie/111 redirect without an api key",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "viewer-key", principal: principal{name: "grafana", role: roleViewer}}}
+			},
+			httpRequest:    httpRequest(http.MethodGet, "/shortie/111", nil),
+			expectedStatus: http.StatusTemporaryRedirect,
+		},
+		{
+			name: "delete /shortie/111 as an editor that doesn't own it",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Owner: "alice"})
+				require.NoError(t, err)
+			},
+			configure: func(api *shortieAPI) {
+				api.jwtSecret = []byte("secret")
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodDelete, "/shortie/111", nil), "Authorization", "Bearer "+signJWT(`{"alg":"HS256"}`, `{"sub":"bob","role":"editor"}`, "secret")),
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"code":"FORBIDDEN","message":"only the link's owner or an admin can change it","requestID":"test"}`,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "111")
+				require.NoError(t, err)
+				assert.NotNil(t, object)
+			},
+		},
+		{
+			name: "delete /shortie/111 as its owner",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Owner: "alice"})
+				require.NoError(t, err)
+			},
+			configure: func(api *shortieAPI) {
+				api.jwtSecret = []byte("secret")
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodDelete, "/shortie/111", nil), "Authorization", "Bearer "+signJWT(`{"alg":"HS256"}`, `{"sub":"alice","role":"editor"}`, "secret")),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "pause /shortie/111 as an admin",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Owner: "alice"})
+				require.NoError(t, err)
+			},
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "ops-key", principal: principal{name: "ops", role: roleAdmin}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodPatch, "/shortie/111/pause", nil), "Authorization", "Bearer ops-key"),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "post /admin/links/111/lock",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.adminToken = "admin" },
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/admin/links/111/lock", nil), "Authorization", "Bearer admin"),
+			expectedStatus: http.StatusOK,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "111")
+				require.NoError(t, err)
+				assert.True(t, object.Locked)
+			},
+		},
+		{
+			name: "delete /shortie/111 locked",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Locked: true})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.adminToken = "admin" },
+			httpRequest:    headerRequest(httpRequest(http.MethodDelete, "/shortie/111", nil), "Authorization", "Bearer admin"),
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"code":"LINK_LOCKED","message":"the link is locked, an admin has to unlock it first","requestID":"test"}`,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "111")
+				require.NoError(t, err)
+				assert.NotNil(t, object)
+			},
+		},
+		{
+			name: "pause /shortie/111 locked",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Locked: true})
+				require.NoError(t, err)
+			},
+			httpRequest:    httpRequest(http.MethodPatch, "/shortie/111/pause", nil),
+			expectedStatus: http.StatusConflict,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "111")
+				require.NoError(t, err)
+				assert.False(t, object.Paused)
+			},
+		},
+		{
+			name: "post /admin/links/111/unlock",
+			setup: func(t *testing.T, storage urlStorage) {
+				err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Locked: true})
+				require.NoError(t, err)
+			},
+			configure:      func(api *shortieAPI) { api.adminToken = "admin" },
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/admin/links/111/unlock", nil), "Authorization", "Bearer admin"),
+			expectedStatus: http.StatusOK,
+			expectations: func(t *testing.T, storage urlStorage) {
+				object, err := storage.GetURL(context.Background(), "111")
+				require.NoError(t, err)
+				assert.False(t, object.Locked)
+			},
+		},
+		{
+			name: "get /admin/config as an editor",
+			configure: func(api *shortieAPI) {
+				api.adminToken = "admin"
+				api.apiKeys = []apiKey{{key: "editor-key", principal: principal{name: "ci", role: roleEditor}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/admin/config", nil), "Authorization", "Bearer editor-key"),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "get /admin/config with an admin api key",
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "ops-key", principal: principal{name: "ops", role: roleAdmin}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/admin/config", nil), "Authorization", "Bearer ops-key"),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "get /directory",
+			setup: func(t *testing.T, storage urlStorage) {
+				for _, object := range []URLObject{
+					{ShortID: "eng/deploy-guide", URL: "https://wiki.example.com/deploy"},
+					{ShortID: "eng/oncall", URL: "https://pager.example.com/schedules", Paused: true},
+					{ShortID: "growth/dashboard", URL: "https://grafana.example.com/d/growth"},
+					{ShortID: "eng/old-runbook", URL: "https://wiki.example.com/runbook", Expiration: time.Now().Add(-time.Hour).Unix()},
+					{ShortID: "eng/flagged", URL: "https://spam.example.com", Quarantined: true},
+					{ShortID: "abc123", URL: "https://example.com/generated"},
+				} {
+					require.NoError(t, storage.SaveURL(context.Background(), object))
+				}
+			},
+			configure: func(api *shortieAPI) {
+				api.directory = true
+				api.apiKeys = []apiKey{{key: "viewer-key", principal: principal{name: "grafana", role: roleViewer}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/directory", nil), "Authorization", "Bearer viewer-key"),
+			expectedStatus: http.StatusOK,
+			bodyExpectations: func(t *testing.T, body string) {
+				assert.Contains(t, body, `<a href="/t/eng/deploy-guide">eng/deploy-guide</a>`)
+				assert.Contains(t, body, `eng/oncall</a> (paused)`)
+				assert.Contains(t, body, "https://grafana.example.com/d/growth")
+				assert.NotContains(t, body, "old-runbook", "expired aliases are left out")
+				assert.NotContains(t, body, "flagged", "quarantined aliases are left out")
+				assert.NotContains(t, body, "abc123", "only aliases are listed")
+				assert.Less(t, strings.Index(body, "eng/oncall"), strings.Index(body, "growth/dashboard"))
+			},
+		},
+		{
+			name: "get /directory?q=WIKI with the admin password",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "eng/deploy-guide", URL: "https://wiki.example.com/deploy"}))
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "growth/dashboard", URL: "https://grafana.example.com/d/growth"}))
+			},
+			configure: func(api *shortieAPI) {
+				api.directory = true
+				api.adminToken = "admin"
+			},
+			httpRequest:    basicAuthRequest(httpRequest(http.MethodGet, "/directory?q=WIKI", nil), "me", "admin"),
+			expectedStatus: http.StatusOK,
+			bodyExpectations: func(t *testing.T, body string) {
+				assert.Contains(t, body, "eng/deploy-guide")
+				assert.NotContains(t, body, "growth/dashboard")
+			},
+		},
+		{
+			name: "get /directory without credentials",
+			configure: func(api *shortieAPI) {
+				api.directory = true
+				api.adminToken = "admin"
+			},
+			httpRequest:     httpRequest(http.MethodGet, "/directory", nil),
+			expectedStatus:  http.StatusUnauthorized,
+			expectedHeaders: map[string]string{"WWW-Authenticate": directoryRealm},
+		},
+		{
+			name: "get /directory with the wrong password",
+			configure: func(api *shortieAPI) {
+				api.directory = true
+				api.adminToken = "admin"
+			},
+			httpRequest:    basicAuthRequest(httpRequest(http.MethodGet, "/directory", nil), "me", "wrong"),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "get /directory when it isn't enabled",
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "viewer-key", principal: principal{name: "grafana", role: roleViewer}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/directory", nil), "Authorization", "Bearer viewer-key"),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			// the links deleted since and other people's links are skipped
+			name:           "get /triggers/links",
+			setup:          setupTriggerLinks,
+			configure:      configureTriggerLinks,
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/triggers/links?limit=2", nil), "Authorization", "Bearer zap-key"),
+			expectedStatus: http.StatusOK,
+			expectedBody: `[{"id":"a","cursor":"` + linkCursor(changeSeq(time.Unix(anHourAgo+3, 0))) + `","shortID":"a","shortUrl":"http://localhost:8421/shortie/a","url":"https://example.com/a","created":` + strconv.FormatInt(anHourAgo+3, 10) + `,"owner":"zapier"},` +
+				`{"id":"b","cursor":"` + linkCursor(changeSeq(time.Unix(anHourAgo, 0))) + `","shortID":"b","shortUrl":"http://localhost:8421/shortie/b","url":"https://example.com/b","created":` + strconv.FormatInt(anHourAgo, 10) + `,"owner":"zapier"}]`,
+		},
+		{
+			name:           "get /triggers/links since a cursor",
+			setup:          setupTriggerLinks,
+			configure:      configureTriggerLinks,
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/triggers/links?since="+linkCursor(changeSeq(time.Unix(anHourAgo+3, 0))), nil), "Authorization", "Bearer zap-key"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"id":"c","cursor":"` + linkCursor(changeSeq(time.Unix(anHourAgo+60, 0))) + `","shortID":"c","shortUrl":"http://localhost:8421/shortie/c","url":"https://example.com/c","created":` + strconv.FormatInt(anHourAgo+60, 10) + `,"owner":"zapier"}]`,
+		},
+		{
+			name:           "get /triggers/links since the newest link",
+			setup:          setupTriggerLinks,
+			configure:      configureTriggerLinks,
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/triggers/links?since="+linkCursor(changeSeq(time.Unix(anHourAgo+60, 0))), nil), "Authorization", "Bearer zap-key"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+		{
+			// admins see every link
+			name:           "get /triggers/links as an admin",
+			setup:          setupTriggerLinks,
+			configure:      configureTriggerLinks,
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/triggers/links?limit=2", nil), "Authorization", "Bearer admin-key"),
+			expectedStatus: http.StatusOK,
+			expectedBody: `[{"id":"other","cursor":"` + linkCursor(changeSeq(time.Unix(anHourAgo+1, 0))) + `","shortID":"other","shortUrl":"http://localhost:8421/shortie/other","url":"https://example.com/other","created":` + strconv.FormatInt(anHourAgo+1, 10) + `,"owner":"someone"},` +
+				`{"id":"b","cursor":"` + linkCursor(changeSeq(time.Unix(anHourAgo, 0))) + `","shortID":"b","shortUrl":"http://localhost:8421/shortie/b","url":"https://example.com/b","created":` + strconv.FormatInt(anHourAgo, 10) + `,"owner":"zapier"}]`,
+		},
+		{
+			name:           "get /triggers/links with an invalid cursor",
+			configure:      configureTriggers(),
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/triggers/links?since=garbage!", nil), "Authorization", "Bearer zap-key"),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			// links aren't listed to everyone
+			name:           "get /triggers/links without api keys",
+			httpRequest:    httpRequest(http.MethodGet, "/triggers/links", nil),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			// the links trigger reads the change log
+			name: "get /triggers/links without the change feed",
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "zap-key", principal: principal{name: "zapier", role: roleViewer}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/triggers/links", nil), "Authorization", "Bearer zap-key"),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			// today hasn't ended, the limit doesn't split yesterday between polls and other people's links are skipped
+			name: "get /triggers/clicks",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.ImportURL(context.Background(), URLObject{ShortID: "a", URL: "https://example.com/a", Campaign: "launch", Owner: "zapier", Usage: map[string]int64{
+					strconv.FormatInt(twoDaysAgo.Unix(), 10): 3, strconv.FormatInt(yesterday.Unix(), 10): 5, strconv.FormatInt(today.Unix(), 10): 8,
+				}}))
+				require.NoError(t, storage.ImportURL(context.Background(), URLObject{ShortID: "b", URL: "https://example.com/b", Campaign: "launch", Owner: "zapier", Usage: map[string]int64{
+					strconv.FormatInt(yesterday.Unix(), 10): 2,
+				}}))
+				require.NoError(t, storage.ImportURL(context.Background(), URLObject{ShortID: "other", URL: "https://example.com/other", Campaign: "launch", Owner: "someone", Usage: map[string]int64{
+					strconv.FormatInt(twoDaysAgo.Unix(), 10): 4,
+				}}))
+			},
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "zap-key", principal: principal{name: "zapier", role: roleViewer}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/triggers/clicks?campaign=launch&limit=2", nil), "Authorization", "Bearer zap-key"),
+			expectedStatus: http.StatusOK,
+			expectedBody: `[{"id":"a@day:` + strconv.FormatInt(twoDaysAgo.Unix(), 10) + `","shortID":"a","shortUrl":"http://localhost:8421/shortie/a",` +
+				`"start":` + strconv.FormatInt(twoDaysAgo.Unix(), 10) + `,"end":` + strconv.FormatInt(yesterday.Unix(), 10) + `,"clicks":3}]`,
+		},
+		{
+			// a period is never split, so the response goes over the limit
+			name: "get /triggers/clicks since a day",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.ImportURL(context.Background(), URLObject{ShortID: "a", URL: "https://example.com/a", Campaign: "launch", Owner: "zapier", Usage: map[string]int64{
+					strconv.FormatInt(twoDaysAgo.Unix(), 10): 3, strconv.FormatInt(yesterday.Unix(), 10): 5,
+				}}))
+				require.NoError(t, storage.ImportURL(context.Background(), URLObject{ShortID: "b", URL: "https://example.com/b", Campaign: "launch", Owner: "zapier", Usage: map[string]int64{
+					strconv.FormatInt(yesterday.Unix(), 10): 2,
+				}}))
+			},
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "zap-key", principal: principal{name: "zapier", role: roleViewer}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/triggers/clicks?campaign=launch&limit=1&since="+strconv.FormatInt(yesterday.Unix(), 10), nil), "Authorization", "Bearer zap-key"),
+			expectedStatus: http.StatusOK,
+			expectedBody: `[{"id":"b@day:` + strconv.FormatInt(yesterday.Unix(), 10) + `","shortID":"b","shortUrl":"http://localhost:8421/shortie/b",` +
+				`"start":` + strconv.FormatInt(yesterday.Unix(), 10) + `,"end":` + strconv.FormatInt(today.Unix(), 10) + `,"clicks":2},` +
+				`{"id":"a@day:` + strconv.FormatInt(yesterday.Unix(), 10) + `","shortID":"a","shortUrl":"http://localhost:8421/shortie/a",` +
+				`"start":` + strconv.FormatInt(yesterday.Unix(), 10) + `,"end":` + strconv.FormatInt(today.Unix(), 10) + `,"clicks":5}]`,
+		},
+		{
+			name: "get /triggers/clicks since today",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.ImportURL(context.Background(), URLObject{ShortID: "a", URL: "https://example.com/a", Campaign: "launch", Owner: "zapier", Usage: map[string]int64{
+					strconv.FormatInt(today.Unix(), 10): 8,
+				}}))
+			},
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "zap-key", principal: principal{name: "zapier", role: roleViewer}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/triggers/clicks?campaign=launch&since="+strconv.FormatInt(today.Unix(), 10), nil), "Authorization", "Bearer zap-key"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+		{
+			name: "get /triggers/clicks per hour",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.ImportURL(context.Background(), URLObject{ShortID: "b", URL: "https://example.com/b", Campaign: "launch", Owner: "zapier", Usage: map[string]int64{
+					strconv.FormatInt(yesterday.Unix(), 10): 2, hourUsageKey(yesterday): 2,
+				}}))
+			},
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "zap-key", principal: principal{name: "zapier", role: roleViewer}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/triggers/clicks?id=b&period=hour", nil), "Authorization", "Bearer zap-key"),
+			expectedStatus: http.StatusOK,
+			expectedBody: `[{"id":"b@hour:` + strconv.FormatInt(yesterday.Unix(), 10) + `","shortID":"b","shortUrl":"http://localhost:8421/shortie/b",` +
+				`"start":` + strconv.FormatInt(yesterday.Unix(), 10) + `,"end":` + strconv.FormatInt(yesterday.Add(time.Hour).Unix(), 10) + `,"clicks":2}]`,
+		},
+		{
+			// polling the clicks of every link would read the whole table
+			name: "get /triggers/clicks without id or campaign",
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "zap-key", principal: principal{name: "zapier", role: roleViewer}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/triggers/clicks", nil), "Authorization", "Bearer zap-key"),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "post /integrations/email",
+			configure: configureEmail,
+			httpRequest: snsRequest(sns.sign(t, snsMessage{Type: "Notification", MessageID: "1", TopicArn: testEmailTopic,
+				Message: receivedEmail(t, "Alice <alice@example.com>", "PASS", multipartEmail)})),
+			expectedStatus: http.StatusOK,
+			expectations: func(t *testing.T, storage urlStorage) {
+				require.Len(t, emailReplies.sent, 1)
+				reply := emailReplies.sent[0]
+				assert.Equal(t, "alice@example.com", reply.to)
+				assert.Equal(t, "Re: links", reply.subject)
+				assert.Equal(t, "<abc@mail.example.com>", reply.inReplyTo)
+				assert.Contains(t, reply.body, "https://example.com/a/very/long/path?x=1&y=2\n  http://localhost:8421/shortie/")
+				assert.Contains(t, reply.body, "https://docs.example.com/guide\n  http://localhost:8421/shortie/")
+				assert.NotContains(t, reply.body, "ftp://")
+
+				shortID, err := hashIDs{}.NewID("https://docs.example.com/guide", "")
+				require.NoError(t, err)
+				object, err := storage.GetURL(context.Background(), shortID)
+				require.NoError(t, err)
+				require.NotNil(t, object)
+				assert.Equal(t, "email:alice@example.com", object.Owner)
+			},
+		},
+		{
+			// forged senders are dropped without a reply
+			name:      "post /integrations/email from a forged sender",
+			configure: configureEmail,
+			httpRequest: snsRequest(sns.sign(t, snsMessage{Type: "Notification", MessageID: "2", TopicArn: testEmailTopic,
+				Message: receivedEmail(t, "alice@example.com", "FAIL", untrustedEmail)})),
+			expectedStatus: http.StatusOK,
+			expectations: func(t *testing.T, storage urlStorage) {
+				assert.Empty(t, emailReplies.sent)
+			},
+		},
+		{
+			name:      "post /integrations/email from a sender that isn't allowed",
+			configure: configureEmail,
+			httpRequest: snsRequest(sns.sign(t, snsMessage{Type: "Notification", MessageID: "2", TopicArn: testEmailTopic,
+				Message: receivedEmail(t, "mallory@evil.example.net", "PASS", untrustedEmail)})),
+			expectedStatus: http.StatusOK,
+			expectations: func(t *testing.T, storage urlStorage) {
+				assert.Empty(t, emailReplies.sent)
+				shortIDs, err := storage.ListShortIDs(context.Background())
+				require.NoError(t, err)
+				assert.Empty(t, shortIDs)
+			},
+		},
+		{
+			name:      "post /integrations/email tampered with",
+			configure: configureEmail,
+			httpRequest: snsRequest(func() snsMessage {
+				message := sns.sign(t, snsMessage{Type: "Notification", MessageID: "3", TopicArn: testEmailTopic,
+					Message: receivedEmail(t, "alice@example.com", "PASS", untrustedEmail)})
+				message.Message = strings.Replace(message.Message, "alice@", "alicia@", 1)
+				return message
+			}()),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:      "post /integrations/email from another topic",
+			configure: configureEmail,
+			httpRequest: snsRequest(sns.sign(t, snsMessage{Type: "Notification", MessageID: "4", TopicArn: "arn:aws:sns:us-west-2:123456789012:other",
+				Message: receivedEmail(t, "alice@example.com", "PASS", untrustedEmail)})),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:      "post /integrations/email subscription confirmation",
+			configure: configureEmail,
+			httpRequest: snsRequest(sns.sign(t, snsMessage{Type: "SubscriptionConfirmation", MessageID: "5", TopicArn: testEmailTopic,
+				Token: "abc", Message: "You have chosen to subscribe", SubscribeURL: "https://sns.us-west-2.amazonaws.com/?Action=ConfirmSubscription&Token=abc"})),
+			expectedStatus: http.StatusOK,
+			expectations: func(t *testing.T, storage urlStorage) {
+				assert.Equal(t, "https://sns.us-west-2.amazonaws.com/?Action=ConfirmSubscription&Token=abc", confirmedSubscription)
+			},
+		},
+		{
+			name: "post /shortie/abc123/stats/share",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc123", URL: "https://example.com/launch-plan", Owner: "alice"}))
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "def456", URL: "https://example.com/other", Owner: "bob"}))
+			},
+			configure:      configureSharing,
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/shortie/abc123/stats/share", strings.NewReader(`{"expiration":`+strconv.FormatInt(shareExpiration, 10)+`}`)), "Authorization", "Bearer alice-key"),
+			expectedStatus: http.StatusOK,
+			expectedBody: `{"url":"http://localhost:8421` + sharedStatsPath("abc123", shareExpiration, shareSignature("share-secret", "abc123", shareExpiration)) + `",` +
+				`"expiration":` + strconv.FormatInt(shareExpiration, 10) + `}`,
+		},
+		{
+			name: "post /shortie/abc123/stats/share with an expiration in the past",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc123", URL: "https://example.com/launch-plan", Owner: "alice"}))
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "def456", URL: "https://example.com/other", Owner: "bob"}))
+			},
+			configure:      configureSharing,
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/shortie/abc123/stats/share", strings.NewReader(`{"expiration":`+strconv.FormatInt(anHourAgo, 10)+`}`)), "Authorization", "Bearer alice-key"),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "post /shortie/abc123/stats/share with an expiration too far away",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc123", URL: "https://example.com/launch-plan", Owner: "alice"}))
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "def456", URL: "https://example.com/other", Owner: "bob"}))
+			},
+			configure:      configureSharing,
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/shortie/abc123/stats/share", strings.NewReader(`{"expiration":`+strconv.FormatInt(time.Now().Add(maxShareTTL+time.Hour).Unix(), 10)+`}`)), "Authorization", "Bearer alice-key"),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "post /shortie/def456/stats/share of someone else's link",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc123", URL: "https://example.com/launch-plan", Owner: "alice"}))
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "def456", URL: "https://example.com/other", Owner: "bob"}))
+			},
+			configure:      configureSharing,
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/shortie/def456/stats/share", nil), "Authorization", "Bearer alice-key"),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "post /shortie/missing/stats/share",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc123", URL: "https://example.com/launch-plan", Owner: "alice"}))
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "def456", URL: "https://example.com/other", Owner: "bob"}))
+			},
+			configure:      configureSharing,
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/shortie/missing/stats/share", nil), "Authorization", "Bearer alice-key"),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "post /shortie/abc123/stats/share without an api key",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc123", URL: "https://example.com/launch-plan", Owner: "alice"}))
+			},
+			configure:      configureSharing,
+			httpRequest:    httpRequest(http.MethodPost, "/shortie/abc123/stats/share", nil),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "post /shortie/abc123/stats/share without a share secret",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc123", URL: "https://example.com/launch-plan", Owner: "alice"}))
+			},
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "alice-key", principal: principal{name: "alice", role: roleEditor}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/shortie/abc123/stats/share", nil), "Authorization", "Bearer alice-key"),
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"code":"FEATURE_DISABLED","message":"sharing stats requires SHORTIE_STATS_SHARE_SECRET","requestID":"test"}`,
+		},
+		{
+			name: "get /shared/stats/abc123",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc123", URL: "https://example.com/launch-plan", Owner: "alice", Title: "Launch plan"}))
+				for i := 0; i < 3; i++ {
+					require.NoError(t, storage.IncrementUsage(context.Background(), "abc123", "", 1, 1))
+				}
+			},
+			configure:       configureSharing,
+			httpRequest:     httpRequest(http.MethodGet, sharedStatsPath("abc123", shareExpiration, shareSignature("share-secret", "abc123", shareExpiration)), nil),
+			expectedStatus:  http.StatusOK,
+			expectedHeaders: map[string]string{"Cache-Control": "private, no-store", "Referrer-Policy": "no-referrer"},
+			bodyExpectations: func(t *testing.T, body string) {
+				assert.Contains(t, body, "Launch plan")
+				assert.Contains(t, body, "<th>All time</th><td>3</td>")
+				assert.Contains(t, body, "<th>"+today.Format(time.DateOnly)+"</th><td>3</td>")
+				assert.NotContains(t, body, "launch-plan", "the destination isn't shared")
+			},
+		},
+		{
+			name: "get /shared/stats/def456 with the signature of another link",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc123", URL: "https://example.com/launch-plan", Owner: "alice"}))
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "def456", URL: "https://example.com/other", Owner: "bob"}))
+			},
+			configure:      configureSharing,
+			httpRequest:    httpRequest(http.MethodGet, sharedStatsPath("def456", shareExpiration, shareSignature("share-secret", "abc123", shareExpiration)), nil),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "get /shared/stats/abc123 with another expiration",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc123", URL: "https://example.com/launch-plan", Owner: "alice"}))
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "def456", URL: "https://example.com/other", Owner: "bob"}))
+			},
+			configure:      configureSharing,
+			httpRequest:    httpRequest(http.MethodGet, sharedStatsPath("abc123", shareExpiration+1, shareSignature("share-secret", "abc123", shareExpiration)), nil),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "get /shared/stats/abc123 expired",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc123", URL: "https://example.com/launch-plan", Owner: "alice"}))
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "def456", URL: "https://example.com/other", Owner: "bob"}))
+			},
+			configure:      configureSharing,
+			httpRequest:    httpRequest(http.MethodGet, sharedStatsPath("abc123", anHourAgo, shareSignature("share-secret", "abc123", anHourAgo)), nil),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "get /shared/stats/abc123 with a signed link's signature",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc123", URL: "https://example.com/launch-plan", Owner: "alice"}))
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "def456", URL: "https://example.com/other", Owner: "bob"}))
+			},
+			configure:      configureSharing,
+			httpRequest:    httpRequest(http.MethodGet, sharedStatsPath("abc123", shareExpiration, signShortID("share-secret", "abc123", shareExpiration)), nil),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "get /shared/stats/abc123 after the secret is rotated",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc123", URL: "https://example.com/launch-plan", Owner: "alice"}))
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "def456", URL: "https://example.com/other", Owner: "bob"}))
+			},
+			configure:      func(api *shortieAPI) { api.statsShareSecret = "rotated" },
+			httpRequest:    httpRequest(http.MethodGet, sharedStatsPath("abc123", shareExpiration, shareSignature("share-secret", "abc123", shareExpiration)), nil),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "get /me",
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "alice-key", principal: principal{name: "alice", role: roleEditor}}}
+				api.maxURLLength = 2048
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/me", nil), "Authorization", "Bearer alice-key"),
+			expectedStatus: http.StatusOK,
+			expectedBody: `{"authenticated":true,"name":"alice","role":"editor",
+				"limits":{"maxTTL":0,"maxURLLength":2048,"maxBodyBytes":0,"captchaRequired":false}}`,
+		},
+		{
+			name: "get /me with an unknown key",
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "alice-key", principal: principal{name: "alice", role: roleEditor}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/me", nil), "Authorization", "Bearer stolen-key"),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "get /v1/me without api keys",
+			httpRequest:    httpRequest(http.MethodGet, "/v1/me", nil),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"authenticated":false,"limits":{"maxTTL":0,"maxURLLength":0,"maxBodyBytes":0,"captchaRequired":false}}`,
+		},
+		{
+			name: "create a url with dedupe",
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "alice-key", principal: principal{name: "alice", role: roleEditor}}}
+				api.ids = randomIDs{}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"https://example.com/page","dedupe":true}`)), "Authorization", "Bearer alice-key"),
+			expectedStatus: http.StatusOK,
+			bodyExpectations: func(t *testing.T, body string) {
+				assert.NotContains(t, body, "existing")
+			},
+		},
+		{
+			name: "create a url with dedupe the caller already shortened",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc", URL: "https://example.com/page", Owner: "alice"}))
+			},
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "alice-key", principal: principal{name: "alice", role: roleEditor}}}
+				api.ids = randomIDs{}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"https://example.com/page","dedupe":true}`)), "Authorization", "Bearer alice-key"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"shortUrl":"http://localhost:8421/shortie/abc","existing":true}`,
+		},
+		{
+			// another caller's link isn't handed out, they couldn't manage it
+			name: "create a url with dedupe someone else shortened",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc", URL: "https://example.com/page", Owner: "bob"}))
+			},
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "alice-key", principal: principal{name: "alice", role: roleEditor}}}
+				api.ids = randomIDs{}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"https://example.com/page","dedupe":true}`)), "Authorization", "Bearer alice-key"),
+			expectedStatus: http.StatusOK,
+			expectations: func(t *testing.T, storage urlStorage) {
+				matches, err := storage.FindByURL(context.Background(), "https://example.com/page")
+				require.NoError(t, err)
+				assert.Len(t, matches, 2)
+			},
+		},
+		{
+			// without dedupe every request creates a link
+			name: "create a url without dedupe the caller already shortened",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc", URL: "https://example.com/page", Owner: "alice"}))
+			},
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "alice-key", principal: principal{name: "alice", role: roleEditor}}}
+				api.ids = randomIDs{}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"https://example.com/page"}`)), "Authorization", "Bearer alice-key"),
+			expectedStatus: http.StatusOK,
+			expectations: func(t *testing.T, storage urlStorage) {
+				matches, err := storage.FindByURL(context.Background(), "https://example.com/page")
+				require.NoError(t, err)
+				assert.Len(t, matches, 2)
+			},
+		},
+		{
+			name: "create a url with dedupe and burn after read",
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "alice-key", principal: principal{name: "alice", role: roleEditor}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"https://example.com/page","dedupe":true,"burnAfterRead":true}`)), "Authorization", "Bearer alice-key"),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "get /me/links",
+			setup: func(t *testing.T, storage urlStorage) {
+				for _, object := range []URLObject{
+					{ShortID: "old", URL: "https://example.com/old", Owner: "alice", Created: anHourAgo - 48*60*60},
+					{ShortID: "new", URL: "https://example.com/new", Owner: "alice", Created: anHourAgo},
+					{ShortID: "legacy", URL: "https://example.com/legacy", Owner: "alice"},
+					{ShortID: "gone", URL: "https://example.com/gone", Owner: "alice", Created: anHourAgo, Expiration: anHourAgo},
+					{ShortID: "bobs", URL: "https://example.com/bobs", Owner: "bob", Created: anHourAgo},
+				} {
+					require.NoError(t, storage.SaveURL(context.Background(), object))
+				}
+			},
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "alice-key", principal: principal{name: "alice", role: roleEditor}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/me/links", nil), "Authorization", "Bearer alice-key"),
+			expectedStatus: http.StatusOK,
+			expectedBody: `{"links":[` +
+				`{"shortID":"new","shortUrl":"http://localhost:8421/shortie/new","url":"https://example.com/new","created":` + strconv.FormatInt(anHourAgo, 10) + `},` +
+				`{"shortID":"old","shortUrl":"http://localhost:8421/shortie/old","url":"https://example.com/old","created":` + strconv.FormatInt(anHourAgo-48*60*60, 10) + `},` +
+				`{"shortID":"legacy","shortUrl":"http://localhost:8421/shortie/legacy","url":"https://example.com/legacy"}]}`,
+		},
+		{
+			name: "get /me/links?limit=1",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "old", URL: "https://example.com/old", Owner: "alice", Created: anHourAgo - 48*60*60}))
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "new", URL: "https://example.com/new", Owner: "alice", Created: anHourAgo}))
+			},
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "alice-key", principal: principal{name: "alice", role: roleEditor}}}
+				api.cursors = cursors
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/me/links?limit=1", nil), "Authorization", "Bearer alice-key"),
+			expectedStatus: http.StatusOK,
+			expectedBody: `{"links":[{"shortID":"new","shortUrl":"http://localhost:8421/shortie/new","url":"https://example.com/new","created":` + strconv.FormatInt(anHourAgo, 10) + `}],` +
+				`"nextCursor":"` + cursors.Encode("new") + `"}`,
+		},
+		{
+			name: "get /me/links with a cursor",
+			setup: func(t *testing.T, storage urlStorage) {
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "old", URL: "https://example.com/old", Owner: "alice", Created: anHourAgo - 48*60*60}))
+				require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "new", URL: "https://example.com/new", Owner: "alice", Created: anHourAgo}))
+			},
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "alice-key", principal: principal{name: "alice", role: roleEditor}}}
+				api.cursors = cursors
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/me/links?limit=1&cursor="+cursors.Encode("new"), nil), "Authorization", "Bearer alice-key"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"links":[{"shortID":"old","shortUrl":"http://localhost:8421/shortie/old","url":"https://example.com/old","created":` + strconv.FormatInt(anHourAgo-48*60*60, 10) + `}]}`,
+		},
+		{
+			name: "get /me/links with an invalid cursor",
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "alice-key", principal: principal{name: "alice", role: roleEditor}}}
+				api.cursors = cursors
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/me/links?cursor=garbage", nil), "Authorization", "Bearer alice-key"),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "get /me/links?limit=1000",
+			configure: func(api *shortieAPI) {
+				api.apiKeys = []apiKey{{key: "alice-key", principal: principal{name: "alice", role: roleEditor}}}
+			},
+			httpRequest:    headerRequest(httpRequest(http.MethodGet, "/me/links?limit=1000", nil), "Authorization", "Bearer alice-key"),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "get /me/links without api keys",
+			httpRequest:    httpRequest(http.MethodGet, "/me/links", nil),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"code":"FEATURE_DISABLED","message":"recent links require api keys, jwts or access tokens to tell callers apart","requestID":"test"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage := &LocalStorage{
+				Objects: map[string]URLObject{},
+				lock:    sync.Mutex{},
+			}
+			if test.setup != nil {
+				test.setup(t, storage)
+			}
+
+			api := shortieAPI{storage: storage}
+			if test.configure != nil {
+				test.configure(&api)
+			}
+			router := api.GetRouter()
+			// a fixed request id keeps error bodies comparable
+			if test.httpRequest.Header.Get(requestIDHeader) == "" {
+				test.httpRequest.Header.Set(requestIDHeader, "test")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, test.httpRequest)
+			assert.Equal(t, test.expectedStatus, w.Code)
+			if test.expectedBody != "" {
+				assert.JSONEq(t, test.expectedBody, w.Body.String())
+			}
+			for header, value := range test.expectedHeaders {
+				assert.Equal(t, value, w.Header().Get(header))
+			}
+			if test.bodyExpectations != nil {
+				test.bodyExpectations(t, w.Body.String())
 			}
 			if test.expectations != nil {
 				test.expectations(t, storage)
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// AppLink deep links mobile clients into an app, the link's resolved destination is used as the web fallback
+type AppLink struct {
+	// URI is the app's custom scheme URI, e.g. myapp://items/123
+	URI string `dynamodbav:"uri" json:"uri"`
+	// AndroidPackage is needed for Android intent URLs, without it Android clients get the iOS style page
+	AndroidPackage string `dynamodbav:"androidPackage" json:"androidPackage,omitempty"`
+}
+
+var (
+	// appSchemePattern is the syntax of a custom scheme, which also keeps it from breaking out of an intent url
+	appSchemePattern = regexp.MustCompile(`^[a-z][a-z0-9+.-]*$`)
+	// androidPackagePattern is the syntax of an Android application id, e.g. com.example.app
+	androidPackagePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z][A-Za-z0-9_]*)+$`)
+)
+
+// blockedAppSchemes run or read content in the browser instead of opening an app, so they aren't app links
+var blockedAppSchemes = map[string]bool{
+	"javascript": true,
+	"data":       true,
+	"vbscript":   true,
+	"file":       true,
+	"blob":       true,
+	"about":      true,
+}
+
+func (appLink AppLink) Validate() error {
+	if appLink.URI == "" {
+		if appLink.AndroidPackage != "" {
+			return fmt.Errorf("app links require a uri")
+		}
+		return nil
+	}
+	parsed, err := url.Parse(appLink.URI)
+	if err != nil || !appSchemePattern.MatchString(parsed.Scheme) || blockedAppSchemes[parsed.Scheme] {
+		return fmt.Errorf("invalid app link uri %q", appLink.URI)
+	}
+	if appLink.AndroidPackage != "" && !androidPackagePattern.MatchString(appLink.AndroidPackage) {
+		return fmt.Errorf("invalid androidPackage %q", appLink.AndroidPackage)
+	}
+	return nil
+}
+
+// isWebURL reports whether a destination is an http or https url, the only ones links and app fallbacks send clients to
+func isWebURL(destination string) bool {
+	parsed, err := url.Parse(destination)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+type platform int
+
+const (
+	platformOther platform = iota
+	platformAndroid
+	platformIOS
+)
+
+func detectPlatform(userAgent string) platform {
+	switch {
+	case strings.Contains(userAgent, "Android"):
+		return platformAndroid
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"), strings.Contains(userAgent, "iPod"):
+		return platformIOS
+	default:
+		return platformOther
+	}
+}
+
+// intentURL builds an Android intent URL that opens the app or falls back to the web destination
+func (appLink AppLink) intentURL(fallback string) string {
+	parsed, err := url.Parse(appLink.URI)
+	if err != nil {
+		return fallback
+	}
+	scheme := parsed.Scheme
+	parsed.Scheme = ""
+	// the intent's own parameters follow the fragment
+	parsed.Fragment = ""
+	parsed.RawFragment = ""
+	target := strings.TrimPrefix(parsed.String(), "//")
+
+	return fmt.Sprintf("intent://%s#Intent;scheme=%s;package=%s;S.browser_fallback_url=%s;end",
+		target, scheme, appLink.AndroidPackage, url.QueryEscape(fallback))
+}
+
+// appLinkPage tries to open the app and sends the browser to the web fallback if the app doesn't take over
+var appLinkPage = template.Must(template.New("applink").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Opening...</title>
+<link rel="stylesheet" href="/static/style.css">
+</head>
+<body>
+<p>Opening the app. <a href="{{.Fallback}}">Continue in the browser</a> if nothing happens.</p>
+<script>
+setTimeout(function() { window.location.replace({{.Fallback}}); }, 1500);
+window.location.href = {{.URI}};
+</script>
+{{if .Operator}}<footer><small>Short link by {{.Operator}} &middot; <a href="/abuse">Report abuse</a></small></footer>
+{{end}}</body>
+</html>
+`))
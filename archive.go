@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Expired links are archived for a grace period before the cleanup job purges them,
+// they answer with a 410 and show where they used to go instead of disappearing right away.
+
+// IsArchived reports whether the link has expired but is still within the archive grace period
+func (object URLObject) IsArchived(now time.Time, grace time.Duration) bool {
+	if object.Expiration == 0 || now.Unix() < object.Expiration {
+		return false
+	}
+	return now.Before(time.Unix(object.Expiration, 0).Add(grace))
+}
+
+var archivedPage = template.Must(template.New("archived").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Link expired</title>
+<link rel="stylesheet" href="/static/style.css">
+</head>
+<body>
+<h1>This link has expired</h1>
+<p>It used to go to:</p>
+<p><code>{{.URL}}</code></p>
+<p>The destination may have changed since, check it before visiting.</p>
+</body>
+</html>
+`))
+
+// renderArchivedPage shows an archived link's destination without redirecting to it
+// links that were never public, because they are signed, restricted or quarantined, don't reveal their destination
+func (api shortieAPI) renderArchivedPage(c *gin.Context, object *URLObject) {
+	if object.SigningSecret != "" || object.Quarantined || !object.IPRules.Permits(c.ClientIP()) {
+		c.String(http.StatusGone, "Gone")
+		return
+	}
+	var page bytes.Buffer
+	err := archivedPage.Execute(&page, map[string]any{"URL": object.URL})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	c.Data(http.StatusGone, "text/html; charset=utf-8", page.Bytes())
+}
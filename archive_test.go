@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanupKeepsArchivedLinks(t *testing.T) {
+	storage := &LocalStorage{Objects: map[string]URLObject{}}
+	now := time.Now()
+	for shortID, expiration := range map[string]time.Time{
+		"active":   now.Add(time.Hour),
+		"archived": now.Add(-time.Hour),
+		"purged":   now.Add(-48 * time.Hour),
+	} {
+		err := storage.SaveURL(context.Background(), URLObject{ShortID: shortID, URL: "http://redirection.com/" + shortID, Expiration: expiration.Unix()})
+		require.NoError(t, err)
+	}
+
+	err := cleanupExpiredLinks(context.Background(), storage, 24*time.Hour)
+	require.NoError(t, err)
+
+	shortIDs, err := storage.ListShortIDs(context.Background())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"active", "archived"}, shortIDs)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// loadAWSConfig builds the config shared by every aws client, with an http client tuned for
+// many small DynamoDB calls: a pool large enough that bursts of redirects don't open new connections,
+// and timeouts short enough that a stuck connection fails over to a retry instead of holding up a redirect
+func loadAWSConfig(ctx context.Context, env Environment) (aws.Config, error) {
+	maxConns, err := strconv.Atoi(env.DynamoMaxConns)
+	if err != nil || maxConns < 1 {
+		return aws.Config{}, fmt.Errorf("SHORTIE_DYNAMO_MAX_CONNS must be a positive integer")
+	}
+	dialTimeout, err := time.ParseDuration(env.DynamoDialTimeout)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("invalid SHORTIE_DYNAMO_DIAL_TIMEOUT: %w", err)
+	}
+	timeout, err := time.ParseDuration(env.DynamoTimeout)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("invalid SHORTIE_DYNAMO_TIMEOUT: %w", err)
+	}
+	keepAlive, err := time.ParseDuration(env.DynamoKeepAlive)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("invalid SHORTIE_DYNAMO_KEEP_ALIVE: %w", err)
+	}
+	maxAttempts, err := strconv.Atoi(env.DynamoMaxAttempts)
+	if err != nil || maxAttempts < 1 {
+		return aws.Config{}, fmt.Errorf("SHORTIE_DYNAMO_MAX_ATTEMPTS must be a positive integer")
+	}
+
+	httpClient := awshttp.NewBuildableClient().
+		WithTimeout(timeout).
+		WithDialerOptions(func(dialer *net.Dialer) {
+			dialer.Timeout = dialTimeout
+			dialer.KeepAlive = keepAlive
+		}).
+		WithTransportOptions(func(transport *http.Transport) {
+			transport.MaxIdleConns = maxConns
+			transport.MaxIdleConnsPerHost = maxConns
+			transport.IdleConnTimeout = keepAlive
+		})
+
+	var retryer func() aws.Retryer
+	switch env.DynamoRetryMode {
+	case "standard":
+		retryer = func() aws.Retryer {
+			return retry.NewStandard(func(options *retry.StandardOptions) {
+				options.MaxAttempts = maxAttempts
+			})
+		}
+	case "adaptive":
+		// adaptive retries also slow down sending once DynamoDB starts throttling, instead of retrying into it
+		retryer = func() aws.Retryer {
+			return retry.NewAdaptiveMode(func(options *retry.AdaptiveModeOptions) {
+				options.StandardOptions = append(options.StandardOptions, func(options *retry.StandardOptions) {
+					options.MaxAttempts = maxAttempts
+				})
+			})
+		}
+	default:
+		return aws.Config{}, fmt.Errorf("unknown SHORTIE_DYNAMO_RETRY_MODE %q, expected standard or adaptive", env.DynamoRetryMode)
+	}
+
+	options := []func(*config.LoadOptions) error{
+		config.WithRegion(env.AWSRegion),
+		config.WithHTTPClient(httpClient),
+		config.WithRetryer(retryer),
+	}
+	// without static keys the default chain is used: the environment, shared config files, or an instance role
+	if env.AWSAccessKeyID != "" {
+		options = append(options, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(env.AWSAccessKeyID, env.AWSSecretAccessKey, ""),
+		))
+	}
+	awsConfig, err := config.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load the aws config: %w", err)
+	}
+	return awsConfig, nil
+}
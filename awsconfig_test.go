@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadAWSConfig(t *testing.T) {
+	env := Environment{
+		AWSRegion:          "us-west-2",
+		AWSAccessKeyID:     "dev",
+		AWSSecretAccessKey: "dev",
+		DynamoMaxConns:     "100",
+		DynamoDialTimeout:  "2s",
+		DynamoTimeout:      "10s",
+		DynamoKeepAlive:    "30s",
+		DynamoRetryMode:    "adaptive",
+		DynamoMaxAttempts:  "5",
+	}
+	awsConfig, err := loadAWSConfig(context.Background(), env)
+	require.NoError(t, err)
+	assert.Equal(t, "us-west-2", awsConfig.Region)
+	retryer := awsConfig.Retryer()
+	assert.IsType(t, &retry.AdaptiveMode{}, retryer)
+	assert.Equal(t, 5, retryer.MaxAttempts())
+	credentials, err := awsConfig.Credentials.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "dev", credentials.AccessKeyID)
+
+	env.DynamoRetryMode = "legacy"
+	_, err = loadAWSConfig(context.Background(), env)
+	assert.Error(t, err)
+	env.DynamoRetryMode = "standard"
+	env.DynamoMaxConns = "0"
+	_, err = loadAWSConfig(context.Background(), env)
+	assert.Error(t, err)
+}
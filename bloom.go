@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// bloomFilter answers "definitely not present" or "maybe present" for a set of strings
+type bloomFilter struct {
+	bits   []uint64
+	hashes uint64
+}
+
+// newBloomFilter sizes a filter for the expected number of items at the given false positive rate
+func newBloomFilter(expectedItems int, falsePositiveRate float64) *bloomFilter {
+	if expectedItems < 1 {
+		expectedItems = 1
+	}
+	size := math.Ceil(-float64(expectedItems) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := math.Max(1, math.Round(size/float64(expectedItems)*math.Ln2))
+	return &bloomFilter{
+		bits:   make([]uint64, int(size)/64+1),
+		hashes: uint64(hashes),
+	}
+}
+
+// locations uses double hashing to derive the filter's hash functions from one 64 bit hash
+func (filter *bloomFilter) locations(value string) []uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(value))
+	first := hash.Sum64()
+	// a splitmix64 style finalizer gives a second, independent enough hash
+	second := first ^ (first >> 30)
+	second *= 0xbf58476d1ce4e5b9
+	second ^= second >> 27
+	second *= 0x94d049bb133111eb
+	second ^= second >> 31
+
+	size := uint64(len(filter.bits) * 64)
+	locations := make([]uint64, filter.hashes)
+	for i := uint64(0); i < filter.hashes; i++ {
+		locations[i] = (first + i*second) % size
+	}
+	return locations
+}
+
+func (filter *bloomFilter) Add(value string) {
+	for _, location := range filter.locations(value) {
+		filter.bits[location/64] |= 1 << (location % 64)
+	}
+}
+
+func (filter *bloomFilter) MayContain(value string) bool {
+	for _, location := range filter.locations(value) {
+		if filter.bits[location/64]&(1<<(location%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// FilteredStorage rejects lookups for shortIDs that definitely don't exist before they reach the wrapped storage
+// the filter is rebuilt from storage periodically so deleted links stop taking up space in it
+type FilteredStorage struct {
+	urlStorage
+
+	lock   sync.RWMutex
+	filter *bloomFilter
+	// added holds shortIDs saved while a rebuild is running, so the rebuilt filter doesn't miss them
+	added      []string
+	rebuilding bool
+}
+
+const bloomFalsePositiveRate = 0.01
+
+func NewFilteredStorage(ctx context.Context, storage urlStorage) (*FilteredStorage, error) {
+	filtered := &FilteredStorage{urlStorage: storage}
+	err := filtered.Rebuild(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return filtered, nil
+}
+
+func (filtered *FilteredStorage) GetURL(ctx context.Context, shortID string) (*URLObject, error) {
+	filtered.lock.RLock()
+	mayExist := filtered.filter.MayContain(shortID)
+	filtered.lock.RUnlock()
+	if !mayExist {
+		return nil, nil
+	}
+	return filtered.urlStorage.GetURL(ctx, shortID)
+}
+
+func (filtered *FilteredStorage) SaveURL(ctx context.Context, object URLObject) error {
+	// added before saving so that there's no window where the link exists but is filtered out
+	filtered.add(object.ShortID)
+	return filtered.urlStorage.SaveURL(ctx, object)
+}
+
+func (filtered *FilteredStorage) ImportURL(ctx context.Context, object URLObject) error {
+	filtered.add(object.ShortID)
+	return filtered.urlStorage.ImportURL(ctx, object)
+}
+
+// HandleLinkEvent adds links saved by other replicas, which would otherwise be filtered out until the next rebuild
+func (filtered *FilteredStorage) HandleLinkEvent(event linkEvent) {
+	if event.Type != linkSaved {
+		return
+	}
+	filtered.add(event.ShortID)
+}
+
+func (filtered *FilteredStorage) add(shortID string) {
+	filtered.lock.Lock()
+	defer filtered.lock.Unlock()
+	filtered.filter.Add(shortID)
+	if filtered.rebuilding {
+		filtered.added = append(filtered.added, shortID)
+	}
+}
+
+func (filtered *FilteredStorage) Rebuild(ctx context.Context) error {
+	filtered.lock.Lock()
+	filtered.rebuilding = true
+	filtered.added = nil
+	filtered.lock.Unlock()
+
+	shortIDs, err := filtered.urlStorage.ListShortIDs(ctx)
+
+	filtered.lock.Lock()
+	defer filtered.lock.Unlock()
+	filtered.rebuilding = false
+	if err != nil {
+		return fmt.Errorf("failed to rebuild the bloom filter: %w", err)
+	}
+
+	// leave room to grow until the next rebuild
+	filter := newBloomFilter(2*len(shortIDs), bloomFalsePositiveRate)
+	for _, shortID := range append(shortIDs, filtered.added...) {
+		filter.Add(shortID)
+	}
+	filtered.filter = filter
+	filtered.added = nil
+	return nil
+}
+
+// RebuildEvery rebuilds the filter on an interval until the context is done
+func (filtered *FilteredStorage) RebuildEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := filtered.Rebuild(ctx)
+			if err != nil {
+				log.Println("error: " + err.Error())
+			}
+		}
+	}
+}
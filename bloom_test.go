@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBloomFilter(t *testing.T) {
+	filter := newBloomFilter(1000, bloomFalsePositiveRate)
+	for i := 0; i < 1000; i++ {
+		filter.Add(fmt.Sprintf("present-%d", i))
+	}
+
+	for i := 0; i < 1000; i++ {
+		assert.True(t, filter.MayContain(fmt.Sprintf("present-%d", i)))
+	}
+
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		if filter.MayContain(fmt.Sprintf("absent-%d", i)) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 50)
+}
+
+func TestFilteredStorage(t *testing.T) {
+	storage := &LocalStorage{
+		Objects: map[string]URLObject{},
+		lock:    sync.Mutex{},
+	}
+	err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"})
+	require.NoError(t, err)
+
+	filtered, err := NewFilteredStorage(context.Background(), storage)
+	require.NoError(t, err)
+	err = filtered.SaveURL(context.Background(), URLObject{ShortID: "222", URL: "http://redirection.com/other"})
+	require.NoError(t, err)
+
+	for _, shortID := range []string{"111", "222"} {
+		object, err := filtered.GetURL(context.Background(), shortID)
+		require.NoError(t, err)
+		assert.NotNil(t, object)
+	}
+	object, err := filtered.GetURL(context.Background(), "333")
+	require.NoError(t, err)
+	assert.Nil(t, object)
+
+	require.NoError(t, filtered.Rebuild(context.Background()))
+	object, err = filtered.GetURL(context.Background(), "222")
+	require.NoError(t, err)
+	assert.NotNil(t, object)
+}
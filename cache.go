@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CachedStorage keeps recently resolved links in memory in front of another storage
+// mutations made through this instance evict their entries, but changes made by other replicas
+// are only picked up once an entry's TTL runs out, unless a link event bus delivers them sooner
+type CachedStorage struct {
+	urlStorage
+
+	ttl     time.Duration
+	lock    sync.Mutex
+	entries map[string]cacheEntry
+	// tombstones hold deleted links off until they expire, a TTL after the delete, when no stale copy can be left:
+	// a read that raced the delete, an eventually consistent read of the backend or another cache's entry
+	tombstones map[string]time.Time
+	hits       int64
+	misses     int64
+}
+
+type cacheEntry struct {
+	object  *URLObject
+	expires time.Time
+}
+
+type CacheStatistics struct {
+	Entries int    `json:"entries"`
+	Hits    int64  `json:"hits"`
+	Misses  int64  `json:"misses"`
+	TTL     string `json:"ttl"`
+	// Tombstones are the links deleted within the TTL
+	Tombstones int `json:"tombstones,omitempty"`
+}
+
+func NewCachedStorage(storage urlStorage, ttl time.Duration) *CachedStorage {
+	return &CachedStorage{
+		urlStorage: storage,
+		ttl:        ttl,
+		entries:    map[string]cacheEntry{},
+		tombstones: map[string]time.Time{},
+	}
+}
+
+func (cache *CachedStorage) GetURL(ctx context.Context, shortID string) (*URLObject, error) {
+	cache.lock.Lock()
+	if cache.buried(shortID, time.Now()) {
+		cache.hits++
+		cache.lock.Unlock()
+		return nil, nil
+	}
+	entry, found := cache.entries[shortID]
+	// requests asking for a consistent read skip the cached copy, which can be as old as the ttl
+	if found && time.Now().Before(entry.expires) && !wantsConsistentRead(ctx) {
+		cache.hits++
+		cache.lock.Unlock()
+		return entry.object, nil
+	}
+	cache.misses++
+	cache.lock.Unlock()
+
+	object, err := cache.urlStorage.GetURL(ctx, shortID)
+	if err != nil {
+		return nil, err
+	}
+	// misses aren't cached so that newly created links resolve right away
+	if object != nil {
+		cache.lock.Lock()
+		defer cache.lock.Unlock()
+		// the link was deleted while it was being read
+		if cache.buried(shortID, time.Now()) {
+			return nil, nil
+		}
+		cache.entries[shortID] = cacheEntry{object: object, expires: time.Now().Add(cache.ttl)}
+	}
+	return object, nil
+}
+
+// buried reports whether a link has a tombstone, dropping it once it expired, the caller holds the lock
+func (cache *CachedStorage) buried(shortID string, now time.Time) bool {
+	expires, found := cache.tombstones[shortID]
+	if found && !now.Before(expires) {
+		delete(cache.tombstones, shortID)
+		return false
+	}
+	return found
+}
+
+// bury evicts a deleted link and holds it off for the TTL
+func (cache *CachedStorage) bury(shortID string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	now := time.Now()
+	for buried, expires := range cache.tombstones {
+		if !now.Before(expires) {
+			delete(cache.tombstones, buried)
+		}
+	}
+	delete(cache.entries, shortID)
+	cache.tombstones[shortID] = now.Add(cache.ttl)
+}
+
+// unbury lets a link created again resolve
+func (cache *CachedStorage) unbury(shortID string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	delete(cache.entries, shortID)
+	delete(cache.tombstones, shortID)
+}
+
+// IsDeleted reports whether a link was deleted within the TTL, for the caches built on top of the storage
+func (cache *CachedStorage) IsDeleted(shortID string) bool {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	return cache.buried(shortID, time.Now())
+}
+
+func (cache *CachedStorage) SaveURL(ctx context.Context, object URLObject) error {
+	err := cache.urlStorage.SaveURL(ctx, object)
+	if err != nil {
+		cache.Flush(object.ShortID)
+		return err
+	}
+	cache.unbury(object.ShortID)
+	return nil
+}
+
+func (cache *CachedStorage) ImportURL(ctx context.Context, object URLObject) error {
+	err := cache.urlStorage.ImportURL(ctx, object)
+	if err != nil {
+		cache.Flush(object.ShortID)
+		return err
+	}
+	cache.unbury(object.ShortID)
+	return nil
+}
+
+func (cache *CachedStorage) SetPaused(ctx context.Context, shortID string, paused bool) error {
+	defer cache.Flush(shortID)
+	return cache.urlStorage.SetPaused(ctx, shortID, paused)
+}
+
+func (cache *CachedStorage) SetQuarantined(ctx context.Context, shortID string, quarantined bool) error {
+	defer cache.Flush(shortID)
+	return cache.urlStorage.SetQuarantined(ctx, shortID, quarantined)
+}
+
+func (cache *CachedStorage) SetLocked(ctx context.Context, shortID string, locked bool) error {
+	defer cache.Flush(shortID)
+	return cache.urlStorage.SetLocked(ctx, shortID, locked)
+}
+
+func (cache *CachedStorage) SetContentHash(ctx context.Context, shortID string, hash string) error {
+	defer cache.Flush(shortID)
+	return cache.urlStorage.SetContentHash(ctx, shortID, hash)
+}
+
+func (cache *CachedStorage) SetTitle(ctx context.Context, shortID string, title string) error {
+	defer cache.Flush(shortID)
+	return cache.urlStorage.SetTitle(ctx, shortID, title)
+}
+
+func (cache *CachedStorage) SetExpiration(ctx context.Context, shortID string, expiration int64) error {
+	defer cache.Flush(shortID)
+	return cache.urlStorage.SetExpiration(ctx, shortID, expiration)
+}
+
+func (cache *CachedStorage) DeleteURL(ctx context.Context, shortID string) error {
+	err := cache.urlStorage.DeleteURL(ctx, shortID)
+	if err != nil {
+		cache.Flush(shortID)
+		return err
+	}
+	cache.bury(shortID)
+	return nil
+}
+
+func (cache *CachedStorage) ConsumeURL(ctx context.Context, shortID string) (bool, error) {
+	consumed, err := cache.urlStorage.ConsumeURL(ctx, shortID)
+	if err != nil || !consumed {
+		cache.Flush(shortID)
+		return consumed, err
+	}
+	cache.bury(shortID)
+	return consumed, nil
+}
+
+// HandleLinkEvent evicts links changed by other replicas, and holds off the ones they deleted
+// or lets them resolve again once they are created again
+func (cache *CachedStorage) HandleLinkEvent(event linkEvent) {
+	switch event.Type {
+	case linkDeleted:
+		cache.bury(event.ShortID)
+	case linkSaved:
+		cache.unbury(event.ShortID)
+	default:
+		cache.Flush(event.ShortID)
+	}
+}
+
+// Flush evicts the given shortIDs, or every entry if none are given
+func (cache *CachedStorage) Flush(shortIDs ...string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if len(shortIDs) == 0 {
+		cache.entries = map[string]cacheEntry{}
+		return
+	}
+	for _, shortID := range shortIDs {
+		delete(cache.entries, shortID)
+	}
+}
+
+func (cache *CachedStorage) Statistics() CacheStatistics {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	// expired tombstones are only dropped when they are looked at
+	tombstones := 0
+	now := time.Now()
+	for _, expires := range cache.tombstones {
+		if now.Before(expires) {
+			tombstones++
+		}
+	}
+	return CacheStatistics{
+		Entries:    len(cache.entries),
+		Hits:       cache.hits,
+		Misses:     cache.misses,
+		TTL:        cache.ttl.String(),
+		Tombstones: tombstones,
+	}
+}
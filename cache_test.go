@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// laggingStorage still returns a link after it is deleted, like an eventually consistent read or a lagging replica,
+// and runs duringRead in the middle of reads
+type laggingStorage struct {
+	urlStorage
+	stale      *URLObject
+	duringRead func()
+}
+
+func (lagging *laggingStorage) GetURL(ctx context.Context, shortID string) (*URLObject, error) {
+	object, err := lagging.urlStorage.GetURL(ctx, shortID)
+	if lagging.duringRead != nil {
+		lagging.duringRead()
+	}
+	if object == nil && lagging.stale != nil && lagging.stale.ShortID == shortID {
+		return lagging.stale, err
+	}
+	return object, err
+}
+
+func TestCachedStorageTombstones(t *testing.T) {
+	ctx := context.Background()
+	link := URLObject{ShortID: "abc", URL: "https://example.com"}
+	backend := &laggingStorage{urlStorage: newContractLocalStorage(t), stale: &link}
+	require.NoError(t, backend.ImportURL(ctx, link))
+	cache := NewCachedStorage(backend, time.Minute)
+	object, err := cache.GetURL(ctx, "abc")
+	require.NoError(t, err)
+	require.NotNil(t, object)
+
+	// the backend still has the link after the delete, the tombstone holds it off
+	require.NoError(t, cache.DeleteURL(ctx, "abc"))
+	object, err = cache.GetURL(ctx, "abc")
+	require.NoError(t, err)
+	assert.Nil(t, object)
+	assert.True(t, cache.IsDeleted("abc"))
+	assert.Equal(t, 1, cache.Statistics().Tombstones)
+	// flushing the cache keeps the tombstones
+	cache.Flush()
+	object, err = cache.GetURL(ctx, "abc")
+	require.NoError(t, err)
+	assert.Nil(t, object)
+
+	// once the tombstone expires the backend is trusted again
+	cache.tombstones["abc"] = time.Now().Add(-time.Second)
+	assert.Equal(t, 0, cache.Statistics().Tombstones)
+	object, err = cache.GetURL(ctx, "abc")
+	require.NoError(t, err)
+	assert.NotNil(t, object)
+	assert.False(t, cache.IsDeleted("abc"))
+
+	// creating the link again lets it resolve right away
+	require.NoError(t, cache.DeleteURL(ctx, "abc"))
+	require.NoError(t, cache.SaveURL(ctx, link))
+	object, err = cache.GetURL(ctx, "abc")
+	require.NoError(t, err)
+	assert.NotNil(t, object)
+}
+
+func TestCachedStorageTombstonesReadsRacingTheDelete(t *testing.T) {
+	ctx := context.Background()
+	link := URLObject{ShortID: "abc", URL: "https://example.com"}
+	backend := &laggingStorage{urlStorage: newContractLocalStorage(t)}
+	require.NoError(t, backend.ImportURL(ctx, link))
+	cache := NewCachedStorage(backend, time.Minute)
+
+	// the read got the link before the delete, it isn't cached after it
+	backend.duringRead = func() {
+		backend.duringRead = nil
+		require.NoError(t, cache.DeleteURL(ctx, "abc"))
+	}
+	object, err := cache.GetURL(ctx, "abc")
+	require.NoError(t, err)
+	assert.Nil(t, object)
+	assert.Zero(t, cache.Statistics().Entries)
+
+	// consumed links are buried too
+	require.NoError(t, cache.ImportURL(ctx, URLObject{ShortID: "once", URL: "https://example.com/once", BurnAfterRead: true}))
+	backend.stale = &URLObject{ShortID: "once", URL: "https://example.com/once"}
+	consumed, err := cache.ConsumeURL(ctx, "once")
+	require.NoError(t, err)
+	require.True(t, consumed)
+	object, err = cache.GetURL(ctx, "once")
+	require.NoError(t, err)
+	assert.Nil(t, object)
+}
+
+func TestCachedStorageTombstonesFromOtherReplicas(t *testing.T) {
+	ctx := context.Background()
+	link := URLObject{ShortID: "abc", URL: "https://example.com"}
+	backend := &laggingStorage{urlStorage: newContractLocalStorage(t), stale: &link}
+	cache := NewCachedStorage(backend, time.Minute)
+	object, err := cache.GetURL(ctx, "abc")
+	require.NoError(t, err)
+	require.NotNil(t, object)
+
+	cache.HandleLinkEvent(linkEvent{Type: linkDeleted, ShortID: "abc"})
+	object, err = cache.GetURL(ctx, "abc")
+	require.NoError(t, err)
+	assert.Nil(t, object)
+
+	cache.HandleLinkEvent(linkEvent{Type: linkSaved, ShortID: "abc"})
+	object, err = cache.GetURL(ctx, "abc")
+	require.NoError(t, err)
+	assert.NotNil(t, object)
+}
+
+func TestStatsCacheHonorsTombstones(t *testing.T) {
+	ctx := context.Background()
+	cache := NewCachedStorage(newContractLocalStorage(t), time.Minute)
+	require.NoError(t, cache.ImportURL(ctx, URLObject{ShortID: "abc", URL: "https://example.com"}))
+	require.NoError(t, cache.IncrementUsage(ctx, "abc", "", 1, 1))
+	api := shortieAPI{storage: cache, cache: cache, statsCache: newStatsCache(time.Minute)}
+	w := serveAs(api, "", http.MethodGet, "/shortie/abc/stats", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"allTime":1`)
+
+	require.NoError(t, cache.DeleteURL(ctx, "abc"))
+	w = serveAs(api, "", http.MethodGet, "/shortie/abc/stats", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"allTime":0`)
+	assert.Equal(t, "shortie-stats; fwd=stale", w.Header().Get("Cache-Status"))
+}
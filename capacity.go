@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/applicationautoscaling"
+	scalingtypes "github.com/aws/aws-sdk-go-v2/service/applicationautoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// tableCapacity is the provisioned throughput of a table created in provisioned mode,
+// which costs less than paying per request for steady, predictable traffic.
+// The indexes get the same capacity as the table, every write to a link writes to them too.
+type tableCapacity struct {
+	read  int64
+	write int64
+	// maxRead and maxWrite enable autoscaling between the provisioned capacity and them, 0 leaves the capacity fixed
+	maxRead  int64
+	maxWrite int64
+	// targetUtilization is the percentage of the capacity autoscaling keeps consumed
+	targetUtilization float64
+}
+
+// parseTableCapacity reads the provisioned capacity, nil for pay-per-request tables
+func parseTableCapacity(env Environment) (*tableCapacity, error) {
+	switch env.DynamoBillingMode {
+	case "pay-per-request":
+		return nil, nil
+	case "provisioned":
+	default:
+		return nil, fmt.Errorf("unknown SHORTIE_DYNAMO_BILLING_MODE %q, expected pay-per-request or provisioned", env.DynamoBillingMode)
+	}
+
+	capacity := &tableCapacity{}
+	var err error
+	for _, setting := range []struct {
+		name     string
+		value    string
+		target   *int64
+		optional bool
+	}{
+		{"SHORTIE_DYNAMO_READ_CAPACITY", env.DynamoReadCapacity, &capacity.read, false},
+		{"SHORTIE_DYNAMO_WRITE_CAPACITY", env.DynamoWriteCapacity, &capacity.write, false},
+		{"SHORTIE_DYNAMO_MAX_READ_CAPACITY", env.DynamoMaxReadCapacity, &capacity.maxRead, true},
+		{"SHORTIE_DYNAMO_MAX_WRITE_CAPACITY", env.DynamoMaxWriteCapacity, &capacity.maxWrite, true},
+	} {
+		if setting.optional && setting.value == "" {
+			continue
+		}
+		*setting.target, err = strconv.ParseInt(setting.value, 10, 64)
+		if err != nil || *setting.target < 1 {
+			return nil, fmt.Errorf("%s must be a positive integer", setting.name)
+		}
+	}
+	if capacity.maxRead != 0 && capacity.maxRead < capacity.read {
+		return nil, errors.New("SHORTIE_DYNAMO_MAX_READ_CAPACITY must be at least SHORTIE_DYNAMO_READ_CAPACITY")
+	}
+	if capacity.maxWrite != 0 && capacity.maxWrite < capacity.write {
+		return nil, errors.New("SHORTIE_DYNAMO_MAX_WRITE_CAPACITY must be at least SHORTIE_DYNAMO_WRITE_CAPACITY")
+	}
+	capacity.targetUtilization, err = strconv.ParseFloat(env.DynamoTargetUtilization, 64)
+	if err != nil || capacity.targetUtilization < 20 || capacity.targetUtilization > 90 {
+		// the range autoscaling accepts for dynamodb
+		return nil, errors.New("SHORTIE_DYNAMO_TARGET_UTILIZATION must be a percentage between 20 and 90")
+	}
+	// replicas of a global table must all accept the same writes, which AWS only allows with write autoscaling
+	if env.DynamoReplicaRegions != "" && capacity.maxWrite == 0 {
+		return nil, errors.New("provisioned global tables require SHORTIE_DYNAMO_MAX_WRITE_CAPACITY for write autoscaling")
+	}
+	return capacity, nil
+}
+
+func (capacity *tableCapacity) billingMode() types.BillingMode {
+	if capacity == nil {
+		return types.BillingModePayPerRequest
+	}
+	return types.BillingModeProvisioned
+}
+
+// throughput is the table's and every index's provisioned throughput, nil for pay-per-request tables
+func (capacity *tableCapacity) throughput() *types.ProvisionedThroughput {
+	if capacity == nil {
+		return nil
+	}
+	return &types.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(capacity.read),
+		WriteCapacityUnits: aws.Int64(capacity.write),
+	}
+}
+
+// withThroughput gives an index the table's throughput
+func (capacity *tableCapacity) withThroughput(index types.GlobalSecondaryIndex) types.GlobalSecondaryIndex {
+	index.ProvisionedThroughput = capacity.throughput()
+	return index
+}
+
+// scalingTarget is one autoscaled capacity of the table or an index
+type scalingTarget struct {
+	resourceID string
+	dimension  scalingtypes.ScalableDimension
+	metric     scalingtypes.MetricType
+	min        int64
+	max        int64
+}
+
+func (capacity *tableCapacity) scalingTargets() []scalingTarget {
+	var targets []scalingTarget
+	for _, resource := range []struct {
+		id    string
+		read  scalingtypes.ScalableDimension
+		write scalingtypes.ScalableDimension
+	}{
+		{"table/" + tableName, scalingtypes.ScalableDimensionDynamoDBTableReadCapacityUnits, scalingtypes.ScalableDimensionDynamoDBTableWriteCapacityUnits},
+		{"table/" + tableName + "/index/" + urlHashIndexName, scalingtypes.ScalableDimensionDynamoDBIndexReadCapacityUnits, scalingtypes.ScalableDimensionDynamoDBIndexWriteCapacityUnits},
+		{"table/" + tableName + "/index/" + campaignIndexName, scalingtypes.ScalableDimensionDynamoDBIndexReadCapacityUnits, scalingtypes.ScalableDimensionDynamoDBIndexWriteCapacityUnits},
+	} {
+		if capacity.maxRead != 0 {
+			targets = append(targets, scalingTarget{resource.id, resource.read, scalingtypes.MetricTypeDynamoDBReadCapacityUtilization, capacity.read, capacity.maxRead})
+		}
+		if capacity.maxWrite != 0 {
+			targets = append(targets, scalingTarget{resource.id, resource.write, scalingtypes.MetricTypeDynamoDBWriteCapacityUtilization, capacity.write, capacity.maxWrite})
+		}
+	}
+	return targets
+}
+
+// ensureAutoscaling registers the table and its indexes with application autoscaling in this region,
+// registering again updates the limits and policies, so changed settings apply on the next start
+func (storage *DynamoStorage) ensureAutoscaling(ctx context.Context) error {
+	targets := storage.capacity.scalingTargets()
+	if len(targets) == 0 {
+		return nil
+	}
+	scaling := applicationautoscaling.NewFromConfig(storage.awsConfig, func(options *applicationautoscaling.Options) {
+		if storage.endpoint != "" {
+			options.BaseEndpoint = aws.String(storage.endpoint)
+		}
+	})
+	for _, target := range targets {
+		_, err := scaling.RegisterScalableTarget(ctx, &applicationautoscaling.RegisterScalableTargetInput{
+			ServiceNamespace:  scalingtypes.ServiceNamespaceDynamodb,
+			ResourceId:        aws.String(target.resourceID),
+			ScalableDimension: target.dimension,
+			MinCapacity:       aws.Int32(int32(target.min)),
+			MaxCapacity:       aws.Int32(int32(target.max)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to register %s for autoscaling: %w", target.resourceID, err)
+		}
+		_, err = scaling.PutScalingPolicy(ctx, &applicationautoscaling.PutScalingPolicyInput{
+			PolicyName:        aws.String(string(target.metric) + "-" + target.resourceID),
+			ServiceNamespace:  scalingtypes.ServiceNamespaceDynamodb,
+			ResourceId:        aws.String(target.resourceID),
+			ScalableDimension: target.dimension,
+			PolicyType:        scalingtypes.PolicyTypeTargetTrackingScaling,
+			TargetTrackingScalingPolicyConfiguration: &scalingtypes.TargetTrackingScalingPolicyConfiguration{
+				TargetValue: aws.Float64(storage.capacity.targetUtilization),
+				PredefinedMetricSpecification: &scalingtypes.PredefinedMetricSpecification{
+					PredefinedMetricType: target.metric,
+				},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set the autoscaling policy of %s: %w", target.resourceID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTableCapacity(t *testing.T) {
+	env := Environment{
+		DynamoBillingMode:       "pay-per-request",
+		DynamoReadCapacity:      "5",
+		DynamoWriteCapacity:     "10",
+		DynamoTargetUtilization: "70",
+	}
+	capacity, err := parseTableCapacity(env)
+	require.NoError(t, err)
+	assert.Nil(t, capacity)
+	assert.Equal(t, types.BillingModePayPerRequest, capacity.billingMode())
+	assert.Nil(t, capacity.throughput())
+	assert.Nil(t, capacity.withThroughput(campaignIndex).ProvisionedThroughput)
+
+	env.DynamoBillingMode = "provisioned"
+	capacity, err = parseTableCapacity(env)
+	require.NoError(t, err)
+	assert.Equal(t, &tableCapacity{read: 5, write: 10, targetUtilization: 70}, capacity)
+	assert.Equal(t, types.BillingModeProvisioned, capacity.billingMode())
+	assert.Equal(t, int64(10), *capacity.withThroughput(campaignIndex).ProvisionedThroughput.WriteCapacityUnits)
+	assert.Nil(t, campaignIndex.ProvisionedThroughput)
+	assert.Empty(t, capacity.scalingTargets())
+
+	env.DynamoMaxWriteCapacity = "100"
+	capacity, err = parseTableCapacity(env)
+	require.NoError(t, err)
+	// write autoscaling for the table and both indexes
+	targets := capacity.scalingTargets()
+	require.Len(t, targets, 3)
+	assert.Equal(t, "table/shortie-urls/index/urlHash-index", targets[1].resourceID)
+	assert.Equal(t, int64(10), targets[1].min)
+	assert.Equal(t, int64(100), targets[1].max)
+
+	for _, invalid := range []func(env *Environment){
+		func(env *Environment) { env.DynamoBillingMode = "on-demand" },
+		func(env *Environment) { env.DynamoReadCapacity = "0" },
+		func(env *Environment) { env.DynamoMaxWriteCapacity = "5" },
+		func(env *Environment) { env.DynamoTargetUtilization = "95" },
+		func(env *Environment) {
+			env.DynamoReplicaRegions = "us-east-1"
+			env.DynamoMaxWriteCapacity = ""
+		},
+	} {
+		invalidEnv := env
+		invalid(&invalidEnv)
+		_, err = parseTableCapacity(invalidEnv)
+		assert.Error(t, err)
+	}
+}
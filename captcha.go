@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// captchaVerifyURLs are the siteverify endpoints of the supported providers, which share the same api
+var captchaVerifyURLs = map[string]string{
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+// captchaHeader carries the token the captcha widget produced on the client
+const captchaHeader = "X-Captcha-Token"
+
+type CaptchaVerifier struct {
+	verifyURL string
+	secret    string
+	client    *http.Client
+}
+
+func NewCaptchaVerifier(provider string, secret string) (*CaptchaVerifier, error) {
+	verifyURL, found := captchaVerifyURLs[provider]
+	if !found {
+		return nil, fmt.Errorf("unknown captcha provider %q", provider)
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("the %s captcha requires SHORTIE_CAPTCHA_SECRET", provider)
+	}
+	return &CaptchaVerifier{verifyURL: verifyURL, secret: secret, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// Verify asks the provider whether the token is from a solved challenge
+func (verifier *CaptchaVerifier) Verify(ctx context.Context, token string, remoteIP string) (bool, error) {
+	form := url.Values{"secret": {verifier.secret}, "response": {token}, "remoteip": {remoteIP}}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, verifier.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := verifier.client.Do(request)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify the captcha: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("the captcha provider responded with %d", response.StatusCode)
+	}
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return false, fmt.Errorf("failed to read the captcha verification: %w", err)
+	}
+	return result.Success, nil
+}
+
+// RequireCaptcha only lets requests through with a solved captcha, if a captcha is configured
+func (api shortieAPI) RequireCaptcha(c *gin.Context) {
+	if api.captcha == nil {
+		c.Next()
+		return
+	}
+	token := c.GetHeader(captchaHeader)
+	if token == "" {
+		respondError(c, http.StatusBadRequest, codeCaptchaRequired, "a captcha token is required in the "+captchaHeader+" header")
+		return
+	}
+	solved, err := api.captcha.Verify(c, token, c.ClientIP())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if !solved {
+		respondError(c, http.StatusForbidden, codeCaptchaFailed, "the captcha was not solved")
+		return
+	}
+	c.Next()
+}
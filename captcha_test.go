@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireCaptcha(t *testing.T) {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.PostForm.Get("secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"success": r.PostForm.Get("response") == "solved"})
+	}))
+	defer provider.Close()
+
+	verifier, err := NewCaptchaVerifier("turnstile", "secret")
+	require.NoError(t, err)
+	verifier.verifyURL = provider.URL
+	api := shortieAPI{storage: &LocalStorage{Objects: map[string]URLObject{}, lock: sync.Mutex{}}, captcha: verifier}
+	router := api.GetRouter()
+
+	for token, expectedStatus := range map[string]int{"": http.StatusBadRequest, "unsolved": http.StatusForbidden, "solved": http.StatusOK} {
+		request := httptest.NewRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"https://example.com/data/hi"}`))
+		request.Header.Set(captchaHeader, token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		assert.Equal(t, expectedStatus, w.Code, "token %q", token)
+	}
+
+	_, err = NewCaptchaVerifier("recaptcha", "secret")
+	assert.Error(t, err)
+	_, err = NewCaptchaVerifier("hcaptcha", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+)
+
+// the types of link changes, in the change feed and the events republished from the table's stream
+const (
+	changeCreated = "created"
+	changeUpdated = "updated"
+	changeDeleted = "deleted"
+)
+
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 500
+	// changesSettle holds back changes made within it, a change recorded a moment late would sort before a cursor
+	// already handed out and be skipped
+	changesSettle = 5 * time.Second
+	// changesNow is the since of a client that only wants the changes from now on, e.g. before copying every link
+	changesNow = "now"
+)
+
+// linkChange is an entry of the change log. It only names the link, the feed reads the link as it is when listed.
+type linkChange struct {
+	// Seq orders the changes, it is the time of the change in unix nanoseconds and a random suffix
+	Seq     string `dynamodbav:"seq"`
+	Type    string `dynamodbav:"type"`
+	ShortID string `dynamodbav:"shortID"`
+	// Time is in unix milliseconds
+	Time      int64 `dynamodbav:"time"`
+	ExpiresAt int64 `dynamodbav:"expiresAt"`
+	// Owner and Campaign are kept for created links, the links trigger picks the caller's links without reading every link
+	Owner    string `dynamodbav:"owner,omitempty"`
+	Campaign string `dynamodbav:"campaign,omitempty"`
+}
+
+// changeSeq is the position of changes made at now, every change made later sorts after it
+func changeSeq(now time.Time) string {
+	return fmt.Sprintf("%020d", now.UnixNano())
+}
+
+// changeLog keeps the changes to links in order for the change feed, shared by every replica
+type changeLog interface {
+	AppendChange(ctx context.Context, change linkChange) error
+	// ListChanges returns up to limit changes ordered by Seq, from after (exclusive) up to before (exclusive)
+	ListChanges(ctx context.Context, after string, before string, limit int) ([]linkChange, error)
+}
+
+// LocalChangeLog keeps changes in memory, they are lost when the process exits
+type LocalChangeLog struct {
+	lock    sync.Mutex
+	changes []linkChange
+}
+
+func NewLocalChangeLog() *LocalChangeLog {
+	return &LocalChangeLog{}
+}
+
+func (changes *LocalChangeLog) AppendChange(ctx context.Context, change linkChange) error {
+	changes.lock.Lock()
+	defer changes.lock.Unlock()
+
+	// the expired changes are the oldest ones
+	expired := sort.Search(len(changes.changes), func(i int) bool { return changes.changes[i].ExpiresAt > time.Now().Unix() })
+	changes.changes = changes.changes[expired:]
+	// changes recorded at nearly the same time can be appended out of order
+	at := sort.Search(len(changes.changes), func(i int) bool { return changes.changes[i].Seq > change.Seq })
+	changes.changes = append(changes.changes, linkChange{})
+	copy(changes.changes[at+1:], changes.changes[at:])
+	changes.changes[at] = change
+	return nil
+}
+
+func (changes *LocalChangeLog) ListChanges(ctx context.Context, after string, before string, limit int) ([]linkChange, error) {
+	changes.lock.Lock()
+	defer changes.lock.Unlock()
+
+	start := sort.Search(len(changes.changes), func(i int) bool { return changes.changes[i].Seq > after })
+	listed := []linkChange{}
+	for _, change := range changes.changes[start:] {
+		if change.Seq >= before || len(listed) == limit {
+			break
+		}
+		listed = append(listed, change)
+	}
+	return listed, nil
+}
+
+const changesTableName = "shortie-changes"
+
+// the change log is a single partition sorted by seq, changes to links are rare next to redirects
+const (
+	attributeFeed = "feed"
+	attributeSeq  = "seq"
+	linksFeed     = "links"
+)
+
+// DynamoChangeLog keeps changes in their own table next to the links table, dynamo deletes them once they expire
+type DynamoChangeLog struct {
+	dynamo *dynamodb.Client
+}
+
+func NewDynamoChangeLog(storage *DynamoStorage) *DynamoChangeLog {
+	return &DynamoChangeLog{dynamo: storage.dynamo}
+}
+
+func (changes *DynamoChangeLog) InitializeTable() error {
+	ctx := context.Background()
+	_, err := changes.dynamo.CreateTable(ctx, &dynamodb.CreateTableInput{
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String(attributeFeed),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String(attributeSeq),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String(attributeFeed),
+				KeyType:       types.KeyTypeHash,
+			},
+			{
+				AttributeName: aws.String(attributeSeq),
+				KeyType:       types.KeyTypeRange,
+			},
+		},
+		TableName: aws.String(changesTableName),
+	})
+	if err != nil {
+		var alreadyExists *types.TableAlreadyExistsException
+		var inUse *types.ResourceInUseException
+		if errors.As(err, &alreadyExists) || errors.As(err, &inUse) {
+			return nil
+		}
+		return fmt.Errorf("failed to create the changes table: %w", err)
+	}
+
+	// time to live can only be turned on once the table is active
+	err = dynamodb.NewTableExistsWaiter(changes.dynamo).Wait(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(changesTableName),
+	}, tableWaitTimeout)
+	if err != nil {
+		return fmt.Errorf("failed waiting for the changes table: %w", err)
+	}
+	_, err = changes.dynamo.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(changesTableName),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String("expiresAt"),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to expire the changes: %w", err)
+	}
+	return nil
+}
+
+func (changes *DynamoChangeLog) AppendChange(ctx context.Context, change linkChange) error {
+	item, err := attributevalue.MarshalMap(&change)
+	if err != nil {
+		return err
+	}
+	item[attributeFeed] = &types.AttributeValueMemberS{Value: linksFeed}
+	_, err = changes.dynamo.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(changesTableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record the change: %w", err)
+	}
+	return nil
+}
+
+func (changes *DynamoChangeLog) ListChanges(ctx context.Context, after string, before string, limit int) ([]linkChange, error) {
+	listed := []linkChange{}
+	// BETWEEN is inclusive, a change at exactly after is the last one of the previous page
+	pages := dynamodb.NewQueryPaginator(changes.dynamo, &dynamodb.QueryInput{
+		TableName:              aws.String(changesTableName),
+		KeyConditionExpression: aws.String("#feed = :feed AND #seq BETWEEN :after AND :before"),
+		ExpressionAttributeNames: map[string]string{
+			"#feed": attributeFeed,
+			"#seq":  attributeSeq,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":feed":   &types.AttributeValueMemberS{Value: linksFeed},
+			":after":  &types.AttributeValueMemberS{Value: after},
+			":before": &types.AttributeValueMemberS{Value: before},
+		},
+		Limit: aws.Int32(int32(limit + 1)),
+	})
+	for pages.HasMorePages() && len(listed) < limit {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the changes: %w", err)
+		}
+		var pageChanges []linkChange
+		err = attributevalue.UnmarshalListOfMaps(page.Items, &pageChanges)
+		if err != nil {
+			return nil, err
+		}
+		for _, change := range pageChanges {
+			if change.Seq > after && change.Seq < before && len(listed) < limit {
+				listed = append(listed, change)
+			}
+		}
+	}
+	return listed, nil
+}
+
+// changeFeed records every change made to links through this replica in the change log for
+// GET /internal/links/changes, which lets edge caches and read replicas sync every link without scanning the table
+type changeFeed struct {
+	log changeLog
+	// retention is how long changes are kept, clients that fall further behind copy every link again
+	retention time.Duration
+}
+
+// initChangeFeed keeps changes with the storage backend, nil if the change feed isn't enabled
+func initChangeFeed(env Environment, dynamoStorage *DynamoStorage) (*changeFeed, error) {
+	if env.ChangeFeedRetention == "" {
+		return nil, nil
+	}
+	retention, err := time.ParseDuration(env.ChangeFeedRetention)
+	if err != nil || retention < time.Hour {
+		return nil, fmt.Errorf("invalid SHORTIE_CHANGE_FEED_RETENTION %q, it must be at least 1h", env.ChangeFeedRetention)
+	}
+	if dynamoStorage == nil {
+		return &changeFeed{log: NewLocalChangeLog(), retention: retention}, nil
+	}
+	changes := NewDynamoChangeLog(dynamoStorage)
+	if dynamoStorage.initializeTables {
+		err = changes.InitializeTable()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &changeFeed{log: changes, retention: retention}, nil
+}
+
+// Record is best-effort, the change to the link was already made. A change missing from the log reaches
+// clients the next time the link changes, or when they copy every link again.
+func (feed *changeFeed) Record(ctx context.Context, changeType string, shortID string) {
+	feed.record(ctx, linkChange{Type: changeType, ShortID: shortID})
+}
+
+// RecordCreated also keeps who created the link and its campaign
+func (feed *changeFeed) RecordCreated(ctx context.Context, object URLObject) {
+	feed.record(ctx, linkChange{Type: changeCreated, ShortID: object.ShortID, Owner: object.Owner, Campaign: object.Campaign})
+}
+
+func (feed *changeFeed) record(ctx context.Context, change linkChange) {
+	now := time.Now()
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	change.Seq = changeSeq(now) + "-" + hex.EncodeToString(suffix)
+	change.Time = now.UnixMilli()
+	change.ExpiresAt = now.Add(feed.retention).Unix()
+	err := feed.log.AppendChange(ctx, change)
+	if err != nil {
+		log.Println("error: failed to record a link change: " + err.Error())
+	}
+}
+
+// JournalingStorage records every successful change to a link in the change feed
+type JournalingStorage struct {
+	urlStorage
+	feed *changeFeed
+}
+
+func NewJournalingStorage(storage urlStorage, feed *changeFeed) *JournalingStorage {
+	return &JournalingStorage{urlStorage: storage, feed: feed}
+}
+
+func (journaling *JournalingStorage) SaveURL(ctx context.Context, object URLObject) error {
+	err := journaling.urlStorage.SaveURL(ctx, object)
+	if err == nil {
+		journaling.feed.RecordCreated(ctx, object)
+	}
+	return err
+}
+
+func (journaling *JournalingStorage) ImportURL(ctx context.Context, object URLObject) error {
+	err := journaling.urlStorage.ImportURL(ctx, object)
+	if err == nil {
+		journaling.feed.RecordCreated(ctx, object)
+	}
+	return err
+}
+
+func (journaling *JournalingStorage) SetPaused(ctx context.Context, shortID string, paused bool) error {
+	err := journaling.urlStorage.SetPaused(ctx, shortID, paused)
+	if err == nil {
+		journaling.feed.Record(ctx, changeUpdated, shortID)
+	}
+	return err
+}
+
+func (journaling *JournalingStorage) SetQuarantined(ctx context.Context, shortID string, quarantined bool) error {
+	err := journaling.urlStorage.SetQuarantined(ctx, shortID, quarantined)
+	if err == nil {
+		journaling.feed.Record(ctx, changeUpdated, shortID)
+	}
+	return err
+}
+
+func (journaling *JournalingStorage) SetLocked(ctx context.Context, shortID string, locked bool) error {
+	err := journaling.urlStorage.SetLocked(ctx, shortID, locked)
+	if err == nil {
+		journaling.feed.Record(ctx, changeUpdated, shortID)
+	}
+	return err
+}
+
+func (journaling *JournalingStorage) SetContentHash(ctx context.Context, shortID string, hash string) error {
+	err := journaling.urlStorage.SetContentHash(ctx, shortID, hash)
+	if err == nil {
+		journaling.feed.Record(ctx, changeUpdated, shortID)
+	}
+	return err
+}
+
+func (journaling *JournalingStorage) SetTitle(ctx context.Context, shortID string, title string) error {
+	err := journaling.urlStorage.SetTitle(ctx, shortID, title)
+	if err == nil {
+		journaling.feed.Record(ctx, changeUpdated, shortID)
+	}
+	return err
+}
+
+func (journaling *JournalingStorage) SetExpiration(ctx context.Context, shortID string, expiration int64) error {
+	err := journaling.urlStorage.SetExpiration(ctx, shortID, expiration)
+	if err == nil {
+		journaling.feed.Record(ctx, changeUpdated, shortID)
+	}
+	return err
+}
+
+func (journaling *JournalingStorage) DeleteURL(ctx context.Context, shortID string) error {
+	err := journaling.urlStorage.DeleteURL(ctx, shortID)
+	if err == nil {
+		journaling.feed.Record(ctx, changeDeleted, shortID)
+	}
+	return err
+}
+
+func (journaling *JournalingStorage) ConsumeURL(ctx context.Context, shortID string) (bool, error) {
+	consumed, err := journaling.urlStorage.ConsumeURL(ctx, shortID)
+	if consumed {
+		journaling.feed.Record(ctx, changeDeleted, shortID)
+	}
+	return consumed, err
+}
+
+// RequireChangeFeed hides the change feed until it is enabled and callers authenticate, since it lists every link
+func (api shortieAPI) RequireChangeFeed(c *gin.Context) {
+	if api.changeFeed == nil || (!api.dev && !api.rbacEnabled()) {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "the change feed requires SHORTIE_CHANGE_FEED_RETENTION and api keys, jwts or access tokens")
+		return
+	}
+	c.Next()
+}
+
+// GetLinkChanges lists the changes to links after the since cursor, oldest first, with each link as it is now.
+// Links deleted since their change are listed as deleted, so clients only ever apply the latest state.
+func (api shortieAPI) GetLinkChanges(c *gin.Context) {
+	limit := defaultChangesLimit
+	if c.Query("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(c.Query("limit"))
+		if err != nil || limit < 1 || limit > maxChangesLimit {
+			respondError(c, http.StatusBadRequest, codeInvalidParameter, "limit must be between 1 and "+strconv.Itoa(maxChangesLimit))
+			return
+		}
+	}
+	now := time.Now()
+	settled := changeSeq(now.Add(-changesSettle))
+	horizon := changeSeq(now.Add(-api.changeFeed.retention))
+
+	after := horizon
+	switch since := c.Query("since"); since {
+	case "":
+	case changesNow:
+		c.JSON(http.StatusOK, gin.H{"changes": []linkChangeEvent{}, "cursor": api.cursors.Encode(settled), "more": false})
+		return
+	default:
+		position, err := api.cursors.Decode(since)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, codeInvalidParameter, err.Error())
+			return
+		}
+		if position < horizon {
+			respondError(c, http.StatusGone, codeCursorExpired, "the changes after the cursor are no longer kept, copy every link again and continue from since=now")
+			return
+		}
+		after = position
+	}
+
+	// a cursor from a replica whose clock runs ahead is past what has settled here
+	changes := []linkChange{}
+	if after < settled {
+		var err error
+		changes, err = api.changeFeed.log.ListChanges(c, after, settled, limit+1)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+	}
+	more := len(changes) > limit
+	if more {
+		changes = changes[:limit]
+	}
+
+	events := make([]linkChangeEvent, 0, len(changes))
+	for _, change := range changes {
+		event := linkChangeEvent{Type: change.Type, ShortID: change.ShortID, Time: time.UnixMilli(change.Time).UTC()}
+		if change.Type != changeDeleted {
+			object, err := api.storage.GetURL(c, change.ShortID)
+			if err != nil {
+				respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+				return
+			}
+			if object == nil {
+				event.Type = changeDeleted
+			} else {
+				// a copy, the cache hands out the links it keeps. The signing secret must never leave the service,
+				// and the usage counters aren't changes.
+				link := *object
+				link.SigningSecret = ""
+				link.Usage = nil
+				link.RuleUsage = nil
+				event.Link = &link
+			}
+		}
+		events = append(events, event)
+	}
+
+	// a cursor past the last change when there are no more keeps quiet feeds from falling behind the retention
+	cursor := max(after, settled)
+	if more {
+		cursor = changes[len(changes)-1].Seq
+	}
+	c.JSON(http.StatusOK, gin.H{"changes": events, "cursor": api.cursors.Encode(cursor), "more": more})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// backdate moves every recorded change into the past, past the settle window
+func backdate(t *testing.T, changes *LocalChangeLog, by time.Duration) {
+	for i, change := range changes.changes {
+		nanos, suffix, _ := strings.Cut(change.Seq, "-")
+		at, err := strconv.ParseInt(nanos, 10, 64)
+		require.NoError(t, err)
+		changes.changes[i].Seq = changeSeq(time.Unix(0, at).Add(-by)) + "-" + suffix
+		changes.changes[i].Time -= by.Milliseconds()
+	}
+}
+
+type changesPage struct {
+	Changes []linkChangeEvent `json:"changes"`
+	Cursor  string            `json:"cursor"`
+	More    bool              `json:"more"`
+}
+
+func TestGetLinkChanges(t *testing.T) {
+	ctx := context.Background()
+	changes := NewLocalChangeLog()
+	feed := &changeFeed{log: changes, retention: 24 * time.Hour}
+	keys, err := parseAPIKeys("edge=viewer:edge-key")
+	require.NoError(t, err)
+	cursors, err := newCursorCodec("")
+	require.NoError(t, err)
+	api := shortieAPI{storage: NewJournalingStorage(newContractLocalStorage(t), feed), apiKeys: keys, cursors: cursors, changeFeed: feed}
+	poll := func(query string) changesPage {
+		w := serveAs(api, "edge-key", http.MethodGet, "/internal/links/changes"+query, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var page changesPage
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		return page
+	}
+
+	require.NoError(t, api.storage.SaveURL(ctx, URLObject{ShortID: "a", URL: "https://example.com/a", SigningSecret: "secret", Owner: "alice"}))
+	require.NoError(t, api.storage.SaveURL(ctx, URLObject{ShortID: "b", URL: "https://example.com/b"}))
+	require.NoError(t, api.storage.SetPaused(ctx, "a", true))
+	require.NoError(t, api.storage.DeleteURL(ctx, "b"))
+	// a failed change isn't recorded
+	require.Error(t, api.storage.SetTitle(ctx, "missing", "title"))
+	backdate(t, changes, time.Minute)
+	// created links keep their owner for the links trigger
+	assert.Equal(t, "alice", changes.changes[0].Owner)
+
+	first := poll("?limit=3")
+	require.Len(t, first.Changes, 3)
+	assert.True(t, first.More)
+	assert.Equal(t, changeCreated, first.Changes[0].Type)
+	require.NotNil(t, first.Changes[0].Link)
+	// links are listed as they are now, without their secrets
+	assert.True(t, first.Changes[0].Link.Paused)
+	assert.Empty(t, first.Changes[0].Link.SigningSecret)
+	// b was deleted since it was created
+	assert.Equal(t, linkChangeEvent{Type: changeDeleted, ShortID: "b", Time: first.Changes[1].Time}, first.Changes[1])
+	assert.Equal(t, changeUpdated, first.Changes[2].Type)
+
+	second := poll("?since=" + first.Cursor)
+	require.Len(t, second.Changes, 1)
+	assert.Equal(t, changeDeleted, second.Changes[0].Type)
+	assert.False(t, second.More)
+
+	// changes that haven't settled are held back until the next poll
+	require.NoError(t, api.storage.SaveURL(ctx, URLObject{ShortID: "c", URL: "https://example.com/c"}))
+	assert.Empty(t, poll("?since="+second.Cursor).Changes)
+	assert.Empty(t, poll("?since=now").Changes)
+	backdate(t, changes, time.Minute)
+	// without a cursor every change kept is listed
+	all := poll("")
+	require.Len(t, all.Changes, 5)
+	assert.Equal(t, "c", all.Changes[4].ShortID)
+
+	w := serveAs(api, "edge-key", http.MethodGet, "/internal/links/changes?since=garbage", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serveAs(api, "edge-key", http.MethodGet, "/internal/links/changes?limit=501", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	// clients further behind than the retention copy every link again
+	expired := cursors.Encode(changeSeq(time.Now().Add(-25 * time.Hour)))
+	w = serveAs(api, "edge-key", http.MethodGet, "/internal/links/changes?since="+expired, "")
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Contains(t, w.Body.String(), codeCursorExpired)
+
+	api.changeFeed = nil
+	assert.Equal(t, http.StatusNotFound, serveAs(api, "edge-key", http.MethodGet, "/internal/links/changes", "").Code)
+}
+
+func TestLocalChangeLog(t *testing.T) {
+	ctx := context.Background()
+	changes := NewLocalChangeLog()
+	now := time.Now()
+	// appended out of order, and one already expired
+	require.NoError(t, changes.AppendChange(ctx, linkChange{Seq: changeSeq(now.Add(-time.Hour)), ShortID: "old", ExpiresAt: now.Add(-time.Minute).Unix()}))
+	require.NoError(t, changes.AppendChange(ctx, linkChange{Seq: changeSeq(now.Add(2 * time.Second)), ShortID: "b", ExpiresAt: now.Add(time.Hour).Unix()}))
+	require.NoError(t, changes.AppendChange(ctx, linkChange{Seq: changeSeq(now.Add(time.Second)), ShortID: "a", ExpiresAt: now.Add(time.Hour).Unix()}))
+
+	listed, err := changes.ListChanges(ctx, "", changeSeq(now.Add(time.Minute)), 10)
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+	assert.Equal(t, "a", listed[0].ShortID)
+	assert.Equal(t, "b", listed[1].ShortID)
+
+	listed, err = changes.ListChanges(ctx, listed[0].Seq, changeSeq(now.Add(time.Minute)), 10)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, "b", listed[0].ShortID)
+}
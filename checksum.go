@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checksumIDs appends a Luhn mod N check character to generated ids, which catches any single mistyped
+// character and most swapped neighbours, so typos can be told apart from links that never existed
+type checksumIDs struct {
+	idGenerator
+	alphabet string
+}
+
+func (checksum checksumIDs) NewID(url string, signingSecret string) (string, error) {
+	id, err := checksum.idGenerator.NewID(url, signingSecret)
+	if err != nil {
+		return "", err
+	}
+	base := len(checksum.alphabet)
+	check := (base - luhnSum(id, checksum.alphabet, true)%base) % base
+	return id + string(checksum.alphabet[check]), nil
+}
+
+// luhnSum adds up the id's characters from the right, doubling every other one starting with the rightmost if double is set
+// it returns -1 if the id has a character outside the alphabet
+func luhnSum(id string, alphabet string, double bool) int {
+	base := len(alphabet)
+	sum := 0
+	for i := len(id) - 1; i >= 0; i-- {
+		codePoint := strings.IndexByte(alphabet, id[i])
+		if codePoint < 0 {
+			return -1
+		}
+		if double {
+			codePoint *= 2
+		}
+		sum += codePoint/base + codePoint%base
+		double = !double
+	}
+	return sum
+}
+
+func validChecksum(id string, alphabet string) bool {
+	sum := luhnSum(id, alphabet, false)
+	return len(id) > 1 && sum >= 0 && sum%len(alphabet) == 0
+}
+
+// lookalikes are characters that are easily mistaken for each other when reading or typing an id
+var lookalikes = map[byte]string{
+	'0': "Oo", 'O': "0o", 'o': "0O",
+	'1': "lI", 'l': "1I", 'I': "1l",
+	'2': "Z", 'Z': "2",
+	'5': "S", 'S': "5",
+	'8': "B", 'B': "8",
+}
+
+// checksumSuggestions are the ids one swap of neighbours or one lookalike character away from a mistyped id
+// that have a valid checksum
+func checksumSuggestions(id string, alphabet string) []string {
+	var candidates []string
+	for i := 0; i+1 < len(id); i++ {
+		if id[i] != id[i+1] {
+			swapped := []byte(id)
+			swapped[i], swapped[i+1] = swapped[i+1], swapped[i]
+			candidates = append(candidates, string(swapped))
+		}
+	}
+	for i := 0; i < len(id); i++ {
+		replacements := lookalikes[id[i]]
+		if unicode.IsLetter(rune(id[i])) {
+			replacements += string(unicode.SimpleFold(rune(id[i])))
+		}
+		for _, replacement := range []byte(replacements) {
+			candidates = append(candidates, id[:i]+string(replacement)+id[i+1:])
+		}
+	}
+
+	var suggestions []string
+	seen := map[string]bool{}
+	for _, candidate := range candidates {
+		if !seen[candidate] && validChecksum(candidate, alphabet) {
+			seen[candidate] = true
+			suggestions = append(suggestions, candidate)
+		}
+	}
+	return suggestions
+}
+
+// respondMistyped answers a missing link whose checksum is wrong, suggesting existing links it was likely meant to be
+func (api shortieAPI) respondMistyped(c *gin.Context, shortID string) {
+	didYouMean := []string{}
+	for _, suggestion := range checksumSuggestions(shortID, api.checksumAlphabet) {
+		object, err := api.storage.GetURL(c, suggestion)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		if object != nil {
+			didYouMean = append(didYouMean, suggestion)
+		}
+	}
+	respondErrorDetails(c, http.StatusNotFound, codeIDMistyped, "the shortie id is mistyped", map[string]any{
+		"didYouMean": didYouMean,
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumIDs(t *testing.T) {
+	ids := checksumIDs{idGenerator: hashIDs{}, alphabet: base62Alphabet}
+	id, err := ids.NewID("https://example.com/data/hi", "")
+	require.NoError(t, err)
+	assert.Len(t, id, 11)
+	assert.True(t, validChecksum(id, base62Alphabet))
+
+	for i := 0; i < len(id); i++ {
+		for _, replacement := range []byte(base62Alphabet) {
+			if replacement == id[i] {
+				continue
+			}
+			mistyped := id[:i] + string(replacement) + id[i+1:]
+			assert.False(t, validChecksum(mistyped, base62Alphabet), "a mistyped %q at %d is caught", replacement, i)
+		}
+	}
+	assert.False(t, validChecksum("abc-def", base62Alphabet))
+}
+
+func TestChecksumSuggestions(t *testing.T) {
+	id, err := checksumIDs{idGenerator: fixedIDs("10Ol5a"), alphabet: base62Alphabet}.NewID("", "")
+	require.NoError(t, err)
+
+	swapped := id[:1] + id[2:3] + id[1:2] + id[3:]
+	assert.Contains(t, checksumSuggestions(swapped, base62Alphabet), id)
+	lookalike := "l" + id[1:]
+	assert.Contains(t, checksumSuggestions(lookalike, base62Alphabet), id)
+	for _, suggestion := range checksumSuggestions(lookalike, base62Alphabet) {
+		assert.True(t, validChecksum(suggestion, base62Alphabet))
+	}
+}
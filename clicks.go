@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// clickEvent is one line of the click log, for batch analytics over the redirects
+type clickEvent struct {
+	// ID is the request id, the same for retries of a redirect that carry X-Request-ID, so replays can drop them
+	ID        string    `json:"id,omitempty"`
+	ShortID   string    `json:"shortID"`
+	Time      time.Time `json:"time"`
+	IP        string    `json:"ip"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+}
+
+// anonymizeIP drops the host part of an address, keeping the /24 of IPv4 and the /48 of IPv6 addresses
+func anonymizeIP(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ""
+	}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return ipv4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// clickLogger appends every redirect as a line of NDJSON
+type clickLogger struct {
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+func newClickLogger(writer io.Writer) *clickLogger {
+	return &clickLogger{writer: writer}
+}
+
+// Record logs a redirect, the log is best-effort and never fails the redirect
+func (logger *clickLogger) Record(c *gin.Context, shortID string, now time.Time) {
+	line, err := json.Marshal(clickEvent{
+		ID:        c.GetString(requestIDKey),
+		ShortID:   shortID,
+		Time:      now.UTC(),
+		IP:        anonymizeIP(c.ClientIP()),
+		Referrer:  c.Request.Referer(),
+		UserAgent: c.Request.UserAgent(),
+	})
+	if err != nil {
+		return
+	}
+	logger.lock.Lock()
+	defer logger.lock.Unlock()
+	_, err = logger.writer.Write(append(line, '\n'))
+	if err != nil {
+		log.Println("error: failed to write the click log: " + err.Error())
+	}
+}
+
+// s3API is the part of the s3 client the batch uploader calls
+type s3API interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// s3BatchMaxBytes uploads a batch early once it grows this large, so a burst of clicks doesn't pile up in memory
+const s3BatchMaxBytes = 8 << 20
+
+// S3BatchWriter collects lines in memory and uploads them as one object per batch, under keys like
+// clicks/2024/05/01/13/20240501T134500Z-1f2e3d4c.ndjson that partition by hour for Athena or Spark.
+// A batch whose upload fails is dropped, the log is for analytics and not an audit trail.
+type S3BatchWriter struct {
+	s3     s3API
+	bucket string
+	prefix string
+	// uploads receives the batches that are ready, so requests never wait on S3
+	uploads chan []byte
+
+	lock  sync.Mutex
+	batch bytes.Buffer
+}
+
+func NewS3BatchWriter(client s3API, bucket string, prefix string) *S3BatchWriter {
+	return &S3BatchWriter{s3: client, bucket: bucket, prefix: prefix, uploads: make(chan []byte, 4)}
+}
+
+func initS3BatchWriter(ctx context.Context, env Environment) (*S3BatchWriter, time.Duration, error) {
+	interval, err := time.ParseDuration(env.ClickLogS3Interval)
+	if err != nil || interval <= 0 {
+		return nil, 0, fmt.Errorf("invalid SHORTIE_CLICK_LOG_S3_INTERVAL %q", env.ClickLogS3Interval)
+	}
+	awsConfig, err := loadAWSConfig(ctx, env)
+	if err != nil {
+		return nil, 0, err
+	}
+	return NewS3BatchWriter(s3.NewFromConfig(awsConfig), env.ClickLogS3Bucket, env.ClickLogS3Prefix), interval, nil
+}
+
+func (writer *S3BatchWriter) Write(data []byte) (int, error) {
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+	writer.batch.Write(data)
+	if writer.batch.Len() >= s3BatchMaxBytes {
+		writer.handOff()
+	}
+	return len(data), nil
+}
+
+// handOff queues the current batch for upload, dropping it if the uploads are falling behind
+func (writer *S3BatchWriter) handOff() {
+	if writer.batch.Len() == 0 {
+		return
+	}
+	batch := bytes.Clone(writer.batch.Bytes())
+	writer.batch.Reset()
+	select {
+	case writer.uploads <- batch:
+	default:
+		log.Println("error: dropping a click log batch, the s3 uploads are falling behind")
+	}
+}
+
+// Run uploads a batch on every interval and whenever one fills up until ctx is done, then uploads what is left
+func (writer *S3BatchWriter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			writer.lock.Lock()
+			writer.handOff()
+			writer.lock.Unlock()
+		case batch := <-writer.uploads:
+			writer.upload(ctx, batch)
+		case <-ctx.Done():
+			// the service's context is already cancelled on shutdown, the last uploads get their own deadline
+			uploadCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			writer.lock.Lock()
+			writer.handOff()
+			writer.lock.Unlock()
+			for {
+				select {
+				case batch := <-writer.uploads:
+					writer.upload(uploadCtx, batch)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (writer *S3BatchWriter) upload(ctx context.Context, batch []byte) {
+	_, err := writer.s3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(writer.bucket),
+		Key:         aws.String(writer.key(time.Now())),
+		Body:        bytes.NewReader(batch),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	if err != nil {
+		log.Println("error: failed to upload a click log batch: " + err.Error())
+	}
+}
+
+// key names a batch by the hour it was uploaded in, the random suffix keeps the replicas' batches apart
+func (writer *S3BatchWriter) key(now time.Time) string {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	now = now.UTC()
+	return writer.prefix + now.Format("2006/01/02/15/20060102T150405Z") + "-" + hex.EncodeToString(suffix) + ".ndjson"
+}
+
+// initClickLog writes to a rotating file, s3 batches or both, it returns nil if neither is configured.
+// S3 uploads run in the background until ctx is done.
+func initClickLog(ctx context.Context, env Environment) (*clickLogger, error) {
+	var writers []io.Writer
+	if env.ClickLogS3Bucket != "" {
+		writer, interval, err := initS3BatchWriter(ctx, env)
+		if err != nil {
+			return nil, err
+		}
+		go writer.Run(ctx, interval)
+		writers = append(writers, writer)
+	}
+	if env.ClickLogFile != "" {
+		maxSizeMB, err := strconv.Atoi(env.ClickLogMaxSizeMB)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SHORTIE_CLICK_LOG_MAX_SIZE_MB: %w", err)
+		}
+		maxBackups, err := strconv.Atoi(env.ClickLogMaxBackups)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SHORTIE_CLICK_LOG_MAX_BACKUPS: %w", err)
+		}
+		file, err := openRotatingFile(env.ClickLogFile, int64(maxSizeMB)<<20, maxBackups)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, file)
+	}
+	if len(writers) == 0 {
+		return nil, nil
+	}
+	return newClickLogger(io.MultiWriter(writers...)), nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	assert.Equal(t, "203.0.113.0", anonymizeIP("203.0.113.57"))
+	assert.Equal(t, "2001:db8:85a3::", anonymizeIP("2001:db8:85a3:8d3:1319:8a2e:370:7348"))
+	assert.Equal(t, "", anonymizeIP("not an ip"))
+}
+
+func TestClickLog(t *testing.T) {
+	var log bytes.Buffer
+	storage := &LocalStorage{Objects: map[string]URLObject{}}
+	require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc", URL: "https://example.com"}))
+	api := shortieAPI{storage: storage, clicks: newClickLogger(&log)}
+	router := api.GetRouter()
+
+	request := httptest.NewRequest(http.MethodGet, "/shortie/abc", nil)
+	request.RemoteAddr = "203.0.113.57:1234"
+	request.Header.Set("Referer", "https://news.example.com/")
+	request.Header.Set("User-Agent", "curl/8.0")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+	var event clickEvent
+	require.NoError(t, json.Unmarshal(log.Bytes(), &event))
+	assert.Equal(t, "abc", event.ShortID)
+	assert.Equal(t, "203.0.113.0", event.IP)
+	assert.Equal(t, "https://news.example.com/", event.Referrer)
+	assert.Equal(t, "curl/8.0", event.UserAgent)
+	assert.WithinDuration(t, time.Now(), event.Time, time.Minute)
+
+	// requests that don't redirect aren't clicks
+	log.Reset()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/shortie/missing", nil))
+	assert.Empty(t, log.String())
+}
+
+// fakeS3 records the objects put, failing while err is set
+type fakeS3 struct {
+	lock    sync.Mutex
+	objects map[string]string
+	err     error
+}
+
+func (fake *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	if fake.err != nil {
+		return nil, fake.err
+	}
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	fake.objects[aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key)] = string(body)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestS3BatchWriter(t *testing.T) {
+	fake := &fakeS3{objects: map[string]string{}}
+	writer := NewS3BatchWriter(fake, "analytics", "clicks/")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		writer.Run(ctx, time.Hour)
+		close(done)
+	}()
+
+	_, err := writer.Write([]byte("{\"shortID\":\"abc\"}\n"))
+	require.NoError(t, err)
+	_, err = writer.Write([]byte("{\"shortID\":\"def\"}\n"))
+	require.NoError(t, err)
+	// nothing is uploaded before the interval, shutdown uploads what is left
+	assert.Empty(t, fake.objects)
+	cancel()
+	<-done
+
+	require.Len(t, fake.objects, 1)
+	for key, body := range fake.objects {
+		assert.Regexp(t, regexp.MustCompile(`^analytics/clicks/\d{4}/\d{2}/\d{2}/\d{2}/\d{8}T\d{6}Z-[0-9a-f]{8}\.ndjson$`), key)
+		assert.Equal(t, "{\"shortID\":\"abc\"}\n{\"shortID\":\"def\"}\n", body)
+	}
+}
+
+func TestS3BatchWriterFull(t *testing.T) {
+	fake := &fakeS3{objects: map[string]string{}}
+	writer := NewS3BatchWriter(fake, "analytics", "")
+	line := []byte(strings.Repeat("x", 1<<20) + "\n")
+	for i := 0; i < 8; i++ {
+		_, err := writer.Write(line)
+		require.NoError(t, err)
+	}
+	// a full batch is handed to the uploader without waiting for the interval
+	require.Len(t, writer.uploads, 1)
+
+	// a failed upload drops its batch
+	fake.err = errors.New("access denied")
+	writer.upload(context.Background(), <-writer.uploads)
+	assert.Empty(t, fake.objects)
+}
+
+func TestInitClickLog(t *testing.T) {
+	env, err := loadEnvironment("")
+	require.NoError(t, err)
+	logger, err := initClickLog(context.Background(), env)
+	require.NoError(t, err)
+	assert.Nil(t, logger)
+
+	env.ClickLogFile = filepath.Join(t.TempDir(), "clicks.ndjson")
+	logger, err = initClickLog(context.Background(), env)
+	require.NoError(t, err)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/shortie/abc", nil)
+	logger.Record(c, "abc", time.Date(2024, 5, 1, 13, 45, 0, 0, time.UTC))
+	written, err := os.ReadFile(env.ClickLogFile)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"shortID\":\"abc\",\"time\":\"2024-05-01T13:45:00Z\",\"ip\":\"192.0.2.0\"}\n", string(written))
+
+	env.ClickLogS3Bucket = "analytics"
+	env.ClickLogS3Interval = "never"
+	_, err = initClickLog(context.Background(), env)
+	assert.ErrorContains(t, err, "invalid SHORTIE_CLICK_LOG_S3_INTERVAL")
+}
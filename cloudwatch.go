@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cloudwatchtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// cloudWatchMaxDatums is how many metrics PutMetricData accepts per call
+const cloudWatchMaxDatums = 1000
+
+// cloudWatchAPI is the part of the cloudwatch client the sink calls
+type cloudWatchAPI interface {
+	PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error)
+}
+
+// CloudWatchSink aggregates metrics in memory and sends them with PutMetricData on every flush,
+// so the number of calls depends on the interval and the number of series, not on the traffic.
+// Tags become dimensions and timings are sent as statistic sets in milliseconds.
+type CloudWatchSink struct {
+	*metricsAggregator
+	cloudwatch cloudWatchAPI
+	namespace  string
+}
+
+func NewCloudWatchSink(ctx context.Context, env Environment, namespace string) (*CloudWatchSink, error) {
+	awsConfig, err := loadAWSConfig(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+	return &CloudWatchSink{
+		metricsAggregator: newMetricsAggregator(),
+		cloudwatch:        cloudwatch.NewFromConfig(awsConfig),
+		namespace:         namespace,
+	}, nil
+}
+
+// Run flushes on every interval until ctx is done, then flushes what is left
+func (sink *CloudWatchSink) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := sink.Flush(ctx)
+			if err != nil {
+				log.Println("error: failed to send metrics to cloudwatch: " + err.Error())
+			}
+		case <-ctx.Done():
+			// the service's context is already cancelled on shutdown, the last flush gets its own deadline
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err := sink.Flush(flushCtx)
+			cancel()
+			if err != nil {
+				log.Println("error: failed to send metrics to cloudwatch: " + err.Error())
+			}
+			return
+		}
+	}
+}
+
+// Flush sends the metrics collected since the last flush, metrics of a failed call are dropped
+func (sink *CloudWatchSink) Flush(ctx context.Context) error {
+	metrics := sink.drain()
+	if len(metrics) == 0 {
+		return nil
+	}
+	now := time.Now()
+	datums := make([]cloudwatchtypes.MetricDatum, 0, len(metrics))
+	for _, metric := range metrics {
+		datums = append(datums, cloudWatchDatum(metric, now))
+	}
+	for start := 0; start < len(datums); start += cloudWatchMaxDatums {
+		end := min(start+cloudWatchMaxDatums, len(datums))
+		_, err := sink.cloudwatch.PutMetricData(ctx, &cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(sink.namespace),
+			MetricData: datums[start:end],
+		})
+		if err != nil {
+			return fmt.Errorf("failed to put %d metrics: %w", end-start, err)
+		}
+	}
+	return nil
+}
+
+func cloudWatchDatum(metric *aggregatedMetric, timestamp time.Time) cloudwatchtypes.MetricDatum {
+	names := make([]string, 0, len(metric.tags))
+	for name := range metric.tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	dimensions := make([]cloudwatchtypes.Dimension, 0, len(names))
+	for _, name := range names {
+		dimensions = append(dimensions, cloudwatchtypes.Dimension{Name: aws.String(name), Value: aws.String(metric.tags[name])})
+	}
+
+	datum := cloudwatchtypes.MetricDatum{
+		MetricName: aws.String(metric.name),
+		Dimensions: dimensions,
+		Timestamp:  aws.Time(timestamp),
+	}
+	if metric.timing {
+		datum.Unit = cloudwatchtypes.StandardUnitMilliseconds
+		datum.StatisticValues = &cloudwatchtypes.StatisticSet{
+			SampleCount: aws.Float64(metric.count),
+			Sum:         aws.Float64(metric.sum),
+			Minimum:     aws.Float64(metric.min),
+			Maximum:     aws.Float64(metric.max),
+		}
+	} else {
+		datum.Unit = cloudwatchtypes.StandardUnitCount
+		datum.Value = aws.Float64(metric.sum)
+	}
+	return datum
+}
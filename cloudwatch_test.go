@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cloudwatchtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCloudWatch records the PutMetricData calls, failing them while err is set
+type fakeCloudWatch struct {
+	calls []*cloudwatch.PutMetricDataInput
+	err   error
+}
+
+func (fake *fakeCloudWatch) PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error) {
+	if fake.err != nil {
+		return nil, fake.err
+	}
+	fake.calls = append(fake.calls, params)
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func TestCloudWatchSink(t *testing.T) {
+	fake := &fakeCloudWatch{}
+	sink := &CloudWatchSink{metricsAggregator: newMetricsAggregator(), cloudwatch: fake, namespace: "Shortie"}
+	ctx := context.Background()
+
+	// nothing recorded, nothing sent
+	require.NoError(t, sink.Flush(ctx))
+	assert.Empty(t, fake.calls)
+
+	sink.Count(metricRedirects, 1, map[string]string{"status": "302"})
+	sink.Count(metricRedirects, 1, map[string]string{"status": "302"})
+	sink.Timing(metricStorageLatency, 4*time.Millisecond, map[string]string{"operation": "GetURL"})
+	sink.Timing(metricStorageLatency, 8*time.Millisecond, map[string]string{"operation": "GetURL"})
+	require.NoError(t, sink.Flush(ctx))
+	require.Len(t, fake.calls, 1)
+	assert.Equal(t, "Shortie", aws.ToString(fake.calls[0].Namespace))
+	require.Len(t, fake.calls[0].MetricData, 2)
+
+	redirects := fake.calls[0].MetricData[0]
+	assert.Equal(t, metricRedirects, aws.ToString(redirects.MetricName))
+	assert.Equal(t, cloudwatchtypes.StandardUnitCount, redirects.Unit)
+	assert.Equal(t, 2.0, aws.ToFloat64(redirects.Value))
+	assert.Equal(t, []cloudwatchtypes.Dimension{{Name: aws.String("status"), Value: aws.String("302")}}, redirects.Dimensions)
+
+	latency := fake.calls[0].MetricData[1]
+	assert.Equal(t, cloudwatchtypes.StandardUnitMilliseconds, latency.Unit)
+	assert.Nil(t, latency.Value)
+	assert.Equal(t, &cloudwatchtypes.StatisticSet{SampleCount: aws.Float64(2), Sum: aws.Float64(12), Minimum: aws.Float64(4), Maximum: aws.Float64(8)}, latency.StatisticValues)
+
+	// failed flushes drop their metrics instead of piling them up
+	fake.err = errors.New("throttled")
+	sink.Count(metricRedirects, 1, map[string]string{"status": "302"})
+	assert.ErrorContains(t, sink.Flush(ctx), "throttled")
+	fake.err = nil
+	require.NoError(t, sink.Flush(ctx))
+	assert.Len(t, fake.calls, 1)
+}
+
+func TestCloudWatchSinkBatches(t *testing.T) {
+	fake := &fakeCloudWatch{}
+	sink := &CloudWatchSink{metricsAggregator: newMetricsAggregator(), cloudwatch: fake, namespace: "Shortie"}
+	for i := 0; i < cloudWatchMaxDatums+1; i++ {
+		sink.Count(metricRequests, 1, map[string]string{"route": fmt.Sprintf("/%d", i)})
+	}
+	require.NoError(t, sink.Flush(context.Background()))
+	require.Len(t, fake.calls, 2)
+	assert.Len(t, fake.calls[0].MetricData, cloudWatchMaxDatums)
+	assert.Len(t, fake.calls[1].MetricData, 1)
+}
+
+func TestCloudWatchSinkRun(t *testing.T) {
+	fake := &fakeCloudWatch{}
+	sink := &CloudWatchSink{metricsAggregator: newMetricsAggregator(), cloudwatch: fake, namespace: "Shortie"}
+	sink.Count(metricRedirects, 1, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sink.Run(ctx, time.Hour)
+		close(done)
+	}()
+
+	// what is left is sent on shutdown
+	cancel()
+	<-done
+	assert.Len(t, fake.calls, 1)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bufferedWriter holds back the response body so it can be compressed once its size is known
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (writer *bufferedWriter) Write(data []byte) (int, error) {
+	return writer.body.Write(data)
+}
+
+func (writer *bufferedWriter) WriteString(data string) (int, error) {
+	return writer.body.WriteString(data)
+}
+
+// Compress gzips or deflates json responses of at least compressMinBytes for clients that accept it
+// small responses aren't worth the cpu, they barely shrink
+func (api shortieAPI) Compress(c *gin.Context) {
+	if !api.compression {
+		c.Next()
+		return
+	}
+	encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+	if encoding == "" {
+		c.Next()
+		return
+	}
+
+	original := c.Writer
+	writer := &bufferedWriter{ResponseWriter: original}
+	c.Writer = writer
+	c.Next()
+	c.Writer = original
+
+	original.Header().Add("Vary", "Accept-Encoding")
+	body := writer.body.Bytes()
+	if len(body) == 0 || len(body) < api.compressMinBytes || original.Header().Get("Content-Encoding") != "" {
+		_, _ = original.Write(body)
+		return
+	}
+
+	var compressed bytes.Buffer
+	var compressor io.WriteCloser
+	if encoding == "gzip" {
+		compressor = gzip.NewWriter(&compressed)
+	} else {
+		compressor, _ = flate.NewWriter(&compressed, flate.DefaultCompression)
+	}
+	_, err := compressor.Write(body)
+	if err == nil {
+		err = compressor.Close()
+	}
+	if err != nil {
+		_, _ = original.Write(body)
+		return
+	}
+	original.Header().Set("Content-Encoding", encoding)
+	original.Header().Set("Content-Length", strconv.Itoa(compressed.Len()))
+	_, _ = original.Write(compressed.Bytes())
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header, preferring gzip, or "" for neither
+func negotiateEncoding(acceptEncoding string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err == nil {
+				quality = parsed
+			}
+		}
+		qualities[strings.ToLower(strings.TrimSpace(name))] = quality
+	}
+	for _, encoding := range []string{"gzip", "deflate"} {
+		quality, found := qualities[encoding]
+		if !found {
+			// * covers every encoding the client didn't list
+			quality, found = qualities["*"]
+		}
+		if found && quality > 0 {
+			return encoding
+		}
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for acceptEncoding, expected := range map[string]string{
+		"":                       "",
+		"br":                     "",
+		"gzip":                   "gzip",
+		"deflate, gzip":          "gzip",
+		"deflate":                "deflate",
+		"gzip;q=0, deflate":      "deflate",
+		"GZIP; q=0.5":            "gzip",
+		"*":                      "gzip",
+		"*;q=0":                  "",
+		"gzip;q=0, *":            "deflate",
+		"identity, gzip;q=0.001": "gzip",
+	} {
+		assert.Equal(t, expected, negotiateEncoding(acceptEncoding), "Accept-Encoding %q", acceptEncoding)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	storage := &LocalStorage{Objects: map[string]URLObject{
+		"111": {URL: "https://example.com/" + strings.Repeat("a", 2000), Usage: map[string]int64{}},
+	}, lock: sync.Mutex{}}
+	api := shortieAPI{storage: storage, compression: true, compressMinBytes: 1024}
+	router := api.GetRouter()
+
+	request := httptest.NewRequest(http.MethodGet, "/shortie/lookup?url=https://example.com/"+strings.Repeat("a", 2000), nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+
+	// small responses are left alone
+	request = httptest.NewRequest(http.MethodGet, "/health", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+
+	// redirects are never compressed
+	request = httptest.NewRequest(http.MethodGet, "/shortie/111", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+
+	api.compressMinBytes = 0
+	router = api.GetRouter()
+	request = httptest.NewRequest(http.MethodGet, "/health", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	reader, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"healthy":true,"backend":"memory"}`, string(body))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// defaultConfig documents every variable and holds the defaults, it is overridden by the -config file and the environment
+//
+//go:embed defaults.env
+var defaultConfig []byte
+
+const redactedValue = "[redacted]"
+
+// loadEnvironment fills the Environment from, in order of precedence, environment variables,
+// secrets in files named by KEY_FILE variables, the config file if one is given, and the embedded defaults
+func loadEnvironment(configPath string) (Environment, error) {
+	values, err := parseConfig(defaultConfig)
+	if err != nil {
+		return Environment{}, fmt.Errorf("failed to parse the default config: %w", err)
+	}
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return Environment{}, fmt.Errorf("failed to read the config file: %w", err)
+		}
+		fileValues, err := parseConfig(data)
+		if err != nil {
+			return Environment{}, fmt.Errorf("failed to parse %s: %w", configPath, err)
+		}
+		for key, value := range fileValues {
+			values[key] = value
+		}
+	}
+
+	var env Environment
+	fields := reflect.ValueOf(&env).Elem()
+	for i := 0; i < fields.NumField(); i++ {
+		key := fields.Type().Field(i).Tag.Get("env")
+		if value, found := os.LookupEnv(key); found {
+			values[key] = value
+		} else if path := os.Getenv(key + "_FILE"); path != "" {
+			secret, err := os.ReadFile(path)
+			if err != nil {
+				return Environment{}, fmt.Errorf("failed to read %s_FILE: %w", key, err)
+			}
+			values[key] = strings.TrimRight(string(secret), "\r\n")
+		}
+		fields.Field(i).SetString(values[key])
+	}
+	return env, nil
+}
+
+// parseConfig reads KEY=VALUE lines, skipping blank lines and # comments
+func parseConfig(data []byte) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("line %d is not KEY=VALUE", lineNumber)
+		}
+		values[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return values, scanner.Err()
+}
+
+// Redacted maps every variable to its value, with secrets that are set replaced
+func (env Environment) Redacted() map[string]string {
+	redacted := map[string]string{}
+	fields := reflect.ValueOf(env)
+	for i := 0; i < fields.NumField(); i++ {
+		field := fields.Type().Field(i)
+		value := fields.Field(i).String()
+		if field.Tag.Get("secret") == "true" && value != "" {
+			value = redactedValue
+		}
+		redacted[field.Tag.Get("env")] = value
+	}
+	return redacted
+}
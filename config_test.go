@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	values, err := parseConfig([]byte("# comment\n\nSHORTIE_CACHE_TTL = 30s\nSHORTIE_ADMIN_TOKEN=\"quoted\"\nSHORTIE_ROBOTS_TXT=\n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"SHORTIE_CACHE_TTL":   "30s",
+		"SHORTIE_ADMIN_TOKEN": "quoted",
+		"SHORTIE_ROBOTS_TXT":  "",
+	}, values)
+
+	_, err = parseConfig([]byte("SHORTIE_CACHE_TTL\n"))
+	assert.Error(t, err)
+}
+
+func TestLoadEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "shortie.env")
+	require.NoError(t, os.WriteFile(configPath, []byte("SHORTIE_CACHE_TTL=30s\nSHORTIE_EVENT_BUS=redis\n"), 0o600))
+	secretPath := filepath.Join(dir, "admin-token")
+	require.NoError(t, os.WriteFile(secretPath, []byte("from-file\n"), 0o600))
+
+	t.Setenv("SHORTIE_EVENT_BUS", "")
+	t.Setenv("SHORTIE_ADMIN_TOKEN_FILE", secretPath)
+
+	env, err := loadEnvironment(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, ":8421", env.ListenAddr, "defaults apply when nothing else sets a variable")
+	assert.Equal(t, "30s", env.CacheTTL, "the config file overrides the defaults")
+	assert.Equal(t, "", env.EventBus, "environment variables override the config file, even when empty")
+	assert.Equal(t, "from-file", env.AdminToken, "secrets are read from _FILE variants")
+
+	redacted := env.Redacted()
+	assert.Equal(t, redactedValue, redacted["SHORTIE_ADMIN_TOKEN"])
+	assert.Equal(t, "", redacted["AWS_SECRET_ACCESS_KEY"])
+	assert.Equal(t, "30s", redacted["SHORTIE_CACHE_TTL"])
+
+	_, err = loadEnvironment(filepath.Join(dir, "missing.env"))
+	assert.Error(t, err)
+}
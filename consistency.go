@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// consistentReadHeader lets a redirect ask for a strongly consistent lookup, for links shared right after they are created
+const consistentReadHeader = "X-Shortie-Consistent-Read"
+
+// consistentReadKey marks the gin context of a request whose lookups should be strongly consistent
+const consistentReadKey = "consistentRead"
+
+// the values of SHORTIE_DYNAMO_CONSISTENT_READS besides true and false
+const consistentReadsHeader = "header"
+
+// parseConsistentReads reads whether every lookup is strongly consistent, or only those of redirects sending the header
+func parseConsistentReads(value string) (always bool, onRequest bool, err error) {
+	if value == consistentReadsHeader {
+		return false, true, nil
+	}
+	always, err = strconv.ParseBool(value)
+	if err != nil {
+		return false, false, fmt.Errorf("invalid SHORTIE_DYNAMO_CONSISTENT_READS %q, expected true, false or header", value)
+	}
+	return always, false, nil
+}
+
+// wantsConsistentRead reports whether the request being served asked for strongly consistent lookups.
+// The gin context handlers pass to storage resolves string keys from its own keys.
+func wantsConsistentRead(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	consistent, _ := ctx.Value(consistentReadKey).(bool)
+	return consistent
+}
+
+// requestConsistentRead marks a redirect for strongly consistent lookups when it sends the header and the header is honored
+func (api shortieAPI) requestConsistentRead(c *gin.Context) {
+	if !api.consistentReadHeader {
+		return
+	}
+	consistent, err := strconv.ParseBool(c.GetHeader(consistentReadHeader))
+	if err != nil || !consistent {
+		return
+	}
+	c.Set(consistentReadKey, true)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConsistentReads(t *testing.T) {
+	always, onRequest, err := parseConsistentReads("false")
+	require.NoError(t, err)
+	assert.False(t, always)
+	assert.False(t, onRequest)
+
+	always, onRequest, err = parseConsistentReads("true")
+	require.NoError(t, err)
+	assert.True(t, always)
+	assert.False(t, onRequest)
+
+	always, onRequest, err = parseConsistentReads("header")
+	require.NoError(t, err)
+	assert.False(t, always)
+	assert.True(t, onRequest)
+
+	_, _, err = parseConsistentReads("sometimes")
+	assert.ErrorContains(t, err, "SHORTIE_DYNAMO_CONSISTENT_READS")
+}
+
+func TestConsistentReadHeader(t *testing.T) {
+	ctx := context.Background()
+	local := newContractLocalStorage(t)
+	require.NoError(t, local.SaveURL(ctx, URLObject{ShortID: "abc", URL: "https://example.com/old"}))
+	cache := NewCachedStorage(local, time.Hour)
+	redirect := func(api shortieAPI, header string) string {
+		request := httptest.NewRequest(http.MethodGet, "/shortie/abc", nil)
+		if header != "" {
+			request.Header.Set(consistentReadHeader, header)
+		}
+		w := httptest.NewRecorder()
+		api.GetRouter().ServeHTTP(w, request)
+		return w.Header().Get("Location")
+	}
+
+	// the cache holds on to the old destination after another replica changes it
+	api := shortieAPI{storage: cache, consistentReadHeader: true}
+	assert.Equal(t, "https://example.com/old", redirect(api, ""))
+	require.NoError(t, local.ImportURL(ctx, URLObject{ShortID: "abc", URL: "https://example.com/new"}))
+	assert.Equal(t, "https://example.com/old", redirect(api, ""))
+	assert.Equal(t, "https://example.com/old", redirect(api, "false"))
+	// asking for a consistent read skips it
+	assert.Equal(t, "https://example.com/new", redirect(api, "true"))
+
+	// the header is ignored unless SHORTIE_DYNAMO_CONSISTENT_READS is header
+	require.NoError(t, local.ImportURL(ctx, URLObject{ShortID: "abc", URL: "https://example.com/newer"}))
+	api.consistentReadHeader = false
+	assert.Equal(t, "https://example.com/new", redirect(api, "true"))
+}
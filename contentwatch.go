@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math/bits"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// contentMaxBytes is how much of a destination's page is read and hashed
+const contentMaxBytes = 1 << 20
+
+const (
+	contentActionAlert      = "alert"
+	contentActionQuarantine = "quarantine"
+)
+
+var errPrivateAddress = errors.New("the destination resolves to a private address")
+
+// contentFetcher reads the page a link redirects to
+type contentFetcher interface {
+	Fetch(ctx context.Context, url string) ([]byte, error)
+}
+
+// HTTPContentFetcher fetches destinations over the internet, refusing private and loopback addresses
+// so links can't be used to probe the network shortie runs in
+type HTTPContentFetcher struct {
+	client    *http.Client
+	userAgent string
+	// maxBytes is how much of a page is read, the rest is ignored
+	maxBytes int64
+}
+
+func NewHTTPContentFetcher(userAgent string, maxBytes int64) *HTTPContentFetcher {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		// checked on the resolved address, so a public name pointing at a private address is refused too
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+	// no proxy from the environment, it would fetch on our behalf without the address check
+	transport := &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 5 * time.Second}
+	return &HTTPContentFetcher{client: &http.Client{Transport: transport, Timeout: 15 * time.Second}, userAgent: userAgent, maxBytes: maxBytes}
+}
+
+func (fetcher *HTTPContentFetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", fetcher.userAgent)
+
+	response, err := fetcher.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("fetching %s responded with %d", url, response.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, fetcher.maxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", url, err)
+	}
+	return body, nil
+}
+
+var (
+	contentScripts = regexp.MustCompile(`(?is)<(script|style)\b.*?</(script|style)\s*>`)
+	contentTags    = regexp.MustCompile(`(?s)<[^>]*>`)
+	contentWords   = regexp.MustCompile(`[\pL\pN]+`)
+)
+
+// contentHash is a simhash of the words of a page: similar pages get hashes that differ in few bits,
+// so small edits like a changed date or a rotated banner don't look like a different page
+func contentHash(page []byte) uint64 {
+	text := contentTags.ReplaceAllString(contentScripts.ReplaceAllString(string(page), " "), " ")
+	words := contentWords.FindAllString(strings.ToLower(text), -1)
+
+	var weights [64]int
+	for i := range words {
+		// pairs of words keep some of the order, a page with its words shuffled is a different page
+		feature := words[i]
+		if i+1 < len(words) {
+			feature += " " + words[i+1]
+		}
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(feature))
+		sum := hash.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+	var simhash uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			simhash |= 1 << bit
+		}
+	}
+	return simhash
+}
+
+// contentDistance is how many of the 64 bits differ between two stored hashes
+func contentDistance(a string, b string) (int, error) {
+	first, err := strconv.ParseUint(a, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid content hash %q", a)
+	}
+	second, err := strconv.ParseUint(b, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid content hash %q", b)
+	}
+	return bits.OnesCount64(first ^ second), nil
+}
+
+// contentWatch records the content of destinations when links are created and checks them again periodically,
+// catching destinations that were hijacked, e.g. an expired domain bought by someone else
+type contentWatch struct {
+	fetcher contentFetcher
+	// threshold is how many of the 64 bits of the hash can change before the content counts as changed
+	threshold  int
+	quarantine bool
+	metrics    metricsSink
+}
+
+func initContentWatch(env Environment, metrics metricsSink) (*contentWatch, time.Duration, error) {
+	interval, err := time.ParseDuration(env.ContentCheckInterval)
+	if err != nil || interval <= 0 {
+		return nil, 0, fmt.Errorf("invalid SHORTIE_CONTENT_CHECK_INTERVAL %q", env.ContentCheckInterval)
+	}
+	threshold, err := strconv.Atoi(env.ContentCheckThreshold)
+	if err != nil || threshold < 1 || threshold > 64 {
+		return nil, 0, errors.New("SHORTIE_CONTENT_CHECK_THRESHOLD must be between 1 and 64")
+	}
+	if env.ContentCheckAction != contentActionAlert && env.ContentCheckAction != contentActionQuarantine {
+		return nil, 0, fmt.Errorf("invalid SHORTIE_CONTENT_CHECK_ACTION %q, expected alert or quarantine", env.ContentCheckAction)
+	}
+	watch := &contentWatch{
+		fetcher:    NewHTTPContentFetcher("shortie-content-check", contentMaxBytes),
+		threshold:  threshold,
+		quarantine: env.ContentCheckAction == contentActionQuarantine,
+		metrics:    metrics,
+	}
+	return watch, interval, nil
+}
+
+// Hash fetches a destination and returns the hash of its content as stored with the link
+func (watch *contentWatch) Hash(ctx context.Context, url string) (string, error) {
+	page, err := watch.fetcher.Fetch(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%016x", contentHash(page)), nil
+}
+
+// Check compares the destinations of links with a content hash against what they were at creation.
+// Destinations that can't be fetched are skipped, an outage isn't a hijack and they are checked again next time.
+func (watch *contentWatch) Check(ctx context.Context, storage urlStorage) error {
+	shortIDs, err := storage.ListShortIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, shortID := range shortIDs {
+		object, err := storage.GetURL(ctx, shortID)
+		if err != nil {
+			return err
+		}
+		if object == nil || object.ContentHash == "" || object.Quarantined || (object.Expiration != 0 && now.Unix() >= object.Expiration) {
+			continue
+		}
+		current, err := watch.Hash(ctx, object.URL)
+		if err != nil {
+			log.Println("error: failed to check the content of " + shortID + ": " + err.Error())
+			continue
+		}
+		distance, err := contentDistance(object.ContentHash, current)
+		if err != nil {
+			return err
+		}
+		if distance <= watch.threshold {
+			continue
+		}
+		log.Printf("error: the content of %s at %s changed, %d of 64 bits of its hash differ", shortID, object.URL, distance)
+		if watch.metrics != nil {
+			watch.metrics.Count(metricContentChanges, 1, nil)
+		}
+		if !watch.quarantine {
+			continue
+		}
+		err = storage.SetQuarantined(ctx, shortID, true)
+		if errors.Is(err, errLocked) || errors.Is(err, errNotFound) {
+			log.Println("error: " + shortID + " can't be quarantined: " + err.Error())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeContentFetcher serves pages by url, urls without a page fail to fetch
+type fakeContentFetcher map[string]string
+
+func (fetcher fakeContentFetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	page, found := fetcher[url]
+	if !found {
+		return nil, errors.New("unreachable")
+	}
+	return []byte(page), nil
+}
+
+func productPage(price string) string {
+	return `<html><head><style>body { color: red }</style><script>var tracking = "` + price + `";</script></head><body>
+<h1>Acme Anvil 3000</h1>
+<p>The Acme Anvil 3000 is forged from a single block of steel and weighs forty kilograms.
+It ships with a lifetime warranty, a mounting plate and a set of four rubber feet for quiet hammering.
+Order today and get free delivery to any address within the continental United States.</p>
+<p>Price: ` + price + `</p>
+</body></html>`
+}
+
+func TestContentHash(t *testing.T) {
+	original := fmt.Sprintf("%016x", contentHash([]byte(productPage("$99"))))
+
+	edited := fmt.Sprintf("%016x", contentHash([]byte(productPage("$89"))))
+	distance, err := contentDistance(original, edited)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, distance, 16, "a small edit")
+
+	parked := fmt.Sprintf("%016x", contentHash([]byte(`<html><body><h1>This domain is for sale!</h1>
+<p>Buy this premium domain name today, fast and secure transfers, financing available. Related searches: loans, casino, insurance.</p></body></html>`)))
+	distance, err = contentDistance(original, parked)
+	require.NoError(t, err)
+	assert.Greater(t, distance, 16, "a different page")
+
+	_, err = contentDistance(original, "not-a-hash")
+	assert.Error(t, err)
+}
+
+func TestContentWatchCheck(t *testing.T) {
+	ctx := context.Background()
+	fetcher := fakeContentFetcher{
+		"https://example.com/anvil":  productPage("$99"),
+		"https://example.com/hammer": productPage("$15"),
+		"https://example.com/down":   productPage("$5"),
+	}
+	watch := &contentWatch{fetcher: fetcher, threshold: 16, quarantine: true}
+	storage := newContractLocalStorage(t)
+	for _, object := range []URLObject{
+		{ShortID: "anvil", URL: "https://example.com/anvil"},
+		{ShortID: "hammer", URL: "https://example.com/hammer"},
+		{ShortID: "down", URL: "https://example.com/down"},
+		{ShortID: "plain", URL: "https://example.com/plain"},
+	} {
+		if object.ShortID != "plain" {
+			hash, err := watch.Hash(ctx, object.URL)
+			require.NoError(t, err)
+			object.ContentHash = hash
+		}
+		require.NoError(t, storage.SaveURL(ctx, object))
+	}
+
+	// the anvil's domain expired and was bought by a parking page, the hammer only got cheaper
+	fetcher["https://example.com/anvil"] = "<html><body><h1>This domain is for sale!</h1><p>Buy this premium domain name today.</p></body></html>"
+	fetcher["https://example.com/hammer"] = productPage("$12")
+	delete(fetcher, "https://example.com/down")
+	require.NoError(t, watch.Check(ctx, storage))
+
+	quarantined := map[string]bool{}
+	for _, shortID := range []string{"anvil", "hammer", "down", "plain"} {
+		object, err := storage.GetURL(ctx, shortID)
+		require.NoError(t, err)
+		quarantined[shortID] = object.Quarantined
+	}
+	assert.Equal(t, map[string]bool{"anvil": true, "hammer": false, "down": false, "plain": false}, quarantined)
+}
+
+func TestContentApprovalRecordsNewContent(t *testing.T) {
+	ctx := context.Background()
+	fetcher := fakeContentFetcher{"https://example.com/anvil": productPage("$99")}
+	watch := &contentWatch{fetcher: fetcher, threshold: 16, quarantine: true}
+	storage := newContractLocalStorage(t)
+	require.NoError(t, storage.SaveURL(ctx, URLObject{ShortID: "anvil", URL: "https://example.com/anvil", ContentHash: "0000000000000000", Quarantined: true}))
+
+	api := shortieAPI{storage: storage, contents: watch, adminToken: "admin"}
+	request := httptest.NewRequest(http.MethodPost, "/admin/quarantine/anvil/approve", nil)
+	request.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	api.GetRouter().ServeHTTP(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// approving accepts what the destination shows now, so the next check leaves the link alone
+	require.NoError(t, watch.Check(ctx, storage))
+	object, err := storage.GetURL(ctx, "anvil")
+	require.NoError(t, err)
+	assert.False(t, object.Quarantined)
+	hash, err := watch.Hash(ctx, "https://example.com/anvil")
+	require.NoError(t, err)
+	assert.Equal(t, hash, object.ContentHash)
+}
+
+func TestVerifyContentRequiresContentChecks(t *testing.T) {
+	api := shortieAPI{storage: newContractLocalStorage(t)}
+	request := httptest.NewRequest(http.MethodPost, "/shortie", strings.NewReader(`{"url":"https://example.com","verifyContent":true}`))
+	w := httptest.NewRecorder()
+	api.GetRouter().ServeHTTP(w, request)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), codeFeatureDisabled)
+}
+
+func TestHTTPContentFetcherRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+
+	_, err := NewHTTPContentFetcher("shortie-content-check", contentMaxBytes).Fetch(context.Background(), server.URL)
+	assert.ErrorIs(t, err, errPrivateAddress)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsAllowedHeaders are the request headers clients on another origin may send
+var corsAllowedHeaders = strings.Join([]string{
+	"Authorization", "Content-Type", "If-None-Match", requestIDHeader, apiVersionHeader, captchaHeader, stepUpHeader,
+}, ", ")
+
+// parseCORSOrigins reads comma separated origins, e.g. chrome-extension://abcdefghijklmnop,https://intranet.example.com
+func parseCORSOrigins(config string) (map[string]bool, error) {
+	origins := map[string]bool{}
+	for _, origin := range strings.Split(config, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" || (parsed.Path != "" && parsed.Path != "/") {
+			return nil, fmt.Errorf("%q is not an origin like scheme://host", origin)
+		}
+		origins[strings.TrimSuffix(origin, "/")] = true
+	}
+	return origins, nil
+}
+
+// CORS lets pages and browser extensions from the configured origins call the api.
+// Tokens are sent in the Authorization header, never in cookies, so credentials aren't allowed.
+func (api shortieAPI) CORS(c *gin.Context) {
+	if len(api.corsOrigins) == 0 {
+		c.Next()
+		return
+	}
+	c.Writer.Header().Add("Vary", "Origin")
+	origin := c.GetHeader("Origin")
+	if !api.corsOrigins[origin] {
+		c.Next()
+		return
+	}
+	c.Header("Access-Control-Allow-Origin", origin)
+	c.Header("Access-Control-Expose-Headers", requestIDHeader+", ETag")
+	// preflights are answered here, gin doesn't route OPTIONS requests
+	if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+		c.Header("Access-Control-Allow-Headers", corsAllowedHeaders)
+		c.Header("Access-Control-Max-Age", "600")
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const extensionOrigin = "chrome-extension://abcdefghijklmnopabcdefghijklmnop"
+
+func TestParseCORSOrigins(t *testing.T) {
+	origins, err := parseCORSOrigins(extensionOrigin + ", https://intranet.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{extensionOrigin: true, "https://intranet.example.com": true}, origins)
+
+	_, err = parseCORSOrigins("https://intranet.example.com/shortener")
+	assert.Error(t, err)
+	_, err = parseCORSOrigins("intranet.example.com")
+	assert.Error(t, err)
+}
+
+func TestCORS(t *testing.T) {
+	api := shortieAPI{storage: newContractLocalStorage(t), corsOrigins: map[string]bool{extensionOrigin: true}}
+
+	preflight := httptest.NewRequest(http.MethodOptions, "/shortie", nil)
+	preflight.Header.Set("Origin", extensionOrigin)
+	preflight.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	preflight.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+	w := httptest.NewRecorder()
+	api.GetRouter().ServeHTTP(w, preflight)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, extensionOrigin, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Authorization")
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), http.MethodPost)
+
+	request := httptest.NewRequest(http.MethodGet, "/me", nil)
+	request.Header.Set("Origin", extensionOrigin)
+	w = httptest.NewRecorder()
+	api.GetRouter().ServeHTTP(w, request)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, extensionOrigin, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+
+	// other origins get no cors headers, so browsers keep them from reading the response
+	request = httptest.NewRequest(http.MethodGet, "/me", nil)
+	request.Header.Set("Origin", "https://evil.example.com")
+	w = httptest.NewRecorder()
+	api.GetRouter().ServeHTTP(w, request)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// List pages are resumed from where the storage left off rather than from an offset, so links created
+// while a client pages through don't shift the pages it hasn't read yet. The position is the last
+// LocalStorage shortID or the Dynamo LastEvaluatedKey, signed so clients can't craft one to start a scan anywhere.
+
+var errInvalidCursor = errors.New("cursor is invalid")
+
+type cursorCodec struct {
+	key []byte
+}
+
+// newCursorCodec signs cursors with the secret, or a random key if it is empty,
+// in which case cursors only work on the replica that made them until it restarts
+func newCursorCodec(secret string) (*cursorCodec, error) {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &cursorCodec{key: key}, nil
+}
+
+func (codec *cursorCodec) Encode(position string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(position))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(codec.sign(payload))
+}
+
+// Decode returns the storage position of a cursor, or errInvalidCursor if it wasn't made by Encode with this key
+func (codec *cursorCodec) Decode(cursor string) (string, error) {
+	payload, encodedSignature, found := strings.Cut(cursor, ".")
+	if !found {
+		return "", errInvalidCursor
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, codec.sign(payload)) {
+		return "", errInvalidCursor
+	}
+	position, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", errInvalidCursor
+	}
+	return string(position), nil
+}
+
+func (codec *cursorCodec) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, codec.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)[:16]
+}
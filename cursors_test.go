@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursorCodec(t *testing.T) {
+	codec, err := newCursorCodec("secret")
+	require.NoError(t, err)
+
+	cursor := codec.Encode("abc")
+	assert.NotContains(t, cursor, "abc")
+	position, err := codec.Decode(cursor)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", position)
+
+	// cursors made with another key or edited don't decode
+	other, err := newCursorCodec("")
+	require.NoError(t, err)
+	_, err = other.Decode(cursor)
+	assert.ErrorIs(t, err, errInvalidCursor)
+	payload, signature, _ := strings.Cut(cursor, ".")
+	_, err = codec.Decode(payload + "x." + signature)
+	assert.ErrorIs(t, err, errInvalidCursor)
+	_, err = codec.Decode("abc")
+	assert.ErrorIs(t, err, errInvalidCursor)
+}
+
+func TestListLinks(t *testing.T) {
+	storage := &LocalStorage{Objects: map[string]URLObject{}, lock: sync.Mutex{}}
+	for _, shortID := range []string{"b", "d", "f", "h"} {
+		storage.Objects[shortID] = URLObject{ShortID: shortID, URL: "https://example.com/" + shortID}
+	}
+	cursors, err := newCursorCodec("secret")
+	require.NoError(t, err)
+	api := shortieAPI{storage: storage, adminToken: "token", cursors: cursors}
+	router := api.GetRouter()
+
+	list := func(query string) (int, linkPage) {
+		request := httptest.NewRequest(http.MethodGet, "/admin/links?"+query, nil)
+		request.Header.Set("Authorization", "Bearer token")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		var page linkPage
+		_ = json.Unmarshal(w.Body.Bytes(), &page)
+		return w.Code, page
+	}
+	shortIDs := func(page linkPage) []string {
+		ids := []string{}
+		for _, link := range page.Links {
+			ids = append(ids, link.ShortID)
+		}
+		return ids
+	}
+
+	code, first := list("limit=2")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"b", "d"}, shortIDs(first))
+	require.NotEmpty(t, first.NextCursor)
+
+	// links created before the cursor's position don't push already listed links onto the next page
+	storage.Objects["a"] = URLObject{ShortID: "a", URL: "https://example.com/a"}
+	storage.Objects["e"] = URLObject{ShortID: "e", URL: "https://example.com/e"}
+
+	code, second := list("limit=2&cursor=" + first.NextCursor)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"e", "f"}, shortIDs(second))
+
+	code, last := list("limit=2&cursor=" + second.NextCursor)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"h"}, shortIDs(last))
+	assert.Empty(t, last.NextCursor)
+
+	code, _ = list("cursor=forged")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = list("limit=1001")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxDedupTokens bounds the memory of the deduper, clicks past it are counted without dedup until tokens expire
+const maxDedupTokens = 1 << 20
+
+// clickDeduper remembers the click tokens seen within a window, so a redirect retried by a proxy or client
+// with the same X-Request-ID counts once. Tokens are kept per replica, a retry landing on another replica counts again.
+type clickDeduper struct {
+	window time.Duration
+
+	lock      sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+func newClickDeduper(window time.Duration) *clickDeduper {
+	return &clickDeduper{window: window, seen: map[string]time.Time{}}
+}
+
+// First reports whether a click of shortID with the token is the first within the window, recording it if so
+func (deduper *clickDeduper) First(shortID string, token string, now time.Time) bool {
+	key := shortID + " " + token
+	deduper.lock.Lock()
+	defer deduper.lock.Unlock()
+
+	if now.Sub(deduper.lastSweep) >= deduper.window {
+		for seenKey, expiration := range deduper.seen {
+			if !now.Before(expiration) {
+				delete(deduper.seen, seenKey)
+			}
+		}
+		deduper.lastSweep = now
+	}
+	if expiration, found := deduper.seen[key]; found && now.Before(expiration) {
+		return false
+	}
+	if len(deduper.seen) < maxDedupTokens {
+		deduper.seen[key] = now.Add(deduper.window)
+	}
+	return true
+}
+
+// firstClick reports whether a redirect should be counted. Its token is the request id,
+// which only repeats when a client or proxy sends it, so generated ids are never remembered.
+func (api shortieAPI) firstClick(c *gin.Context, shortID string, now time.Time) bool {
+	token := c.GetString(requestIDKey)
+	if api.clickDedup == nil || c.GetHeader(requestIDHeader) != token {
+		return true
+	}
+	return api.clickDedup.First(shortID, token, now)
+}
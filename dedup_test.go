@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClickDeduper(t *testing.T) {
+	deduper := newClickDeduper(time.Minute)
+	now := time.Now()
+	assert.True(t, deduper.First("abc", "req-1", now))
+	assert.False(t, deduper.First("abc", "req-1", now.Add(30*time.Second)))
+	// tokens are per link
+	assert.True(t, deduper.First("def", "req-1", now))
+	// and forgotten after the window
+	assert.True(t, deduper.First("abc", "req-1", now.Add(time.Minute)))
+	deduper.First("ghi", "req-2", now.Add(3*time.Minute))
+	assert.Len(t, deduper.seen, 1)
+}
+
+func TestRedirectDedup(t *testing.T) {
+	storage := &LocalStorage{Objects: map[string]URLObject{}}
+	require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "abc", URL: "https://example.com"}))
+	api := shortieAPI{storage: storage, clickDedup: newClickDeduper(time.Minute)}
+	router := api.GetRouter()
+	redirect := func(requestID string) int {
+		request := httptest.NewRequest(http.MethodGet, "/shortie/abc", nil)
+		if requestID != "" {
+			request.Header.Set(requestIDHeader, requestID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w.Code
+	}
+	clicks := func() int64 {
+		statistics, err := storage.GetStatistics(context.Background(), "abc")
+		require.NoError(t, err)
+		var total int64
+		for _, count := range statistics.Usage {
+			total += count
+		}
+		return total
+	}
+
+	// a retry with the same request id redirects again but counts once
+	assert.Equal(t, http.StatusTemporaryRedirect, redirect("retried"))
+	assert.Equal(t, http.StatusTemporaryRedirect, redirect("retried"))
+	assert.Equal(t, int64(1), clicks())
+	// generated request ids never repeat
+	redirect("")
+	redirect("")
+	assert.Equal(t, int64(3), clicks())
+}
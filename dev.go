@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+)
+
+// devFixtures seeds the in-memory backend in dev mode unless another fixtures file is given
+//
+//go:embed dev-fixtures.json
+var devFixtures []byte
+
+// devFixture is a link in a fixtures file
+type devFixture struct {
+	ShortID     string            `json:"shortID"`
+	URL         string            `json:"url"`
+	Campaign    string            `json:"campaign"`
+	Paused      bool              `json:"paused"`
+	Notes       string            `json:"notes"`
+	Annotations map[string]string `json:"annotations"`
+	// DailyClicks is the usage of the last days, the last entry being today's,
+	// relative so that fixtures always have recent statistics to chart
+	DailyClicks []int64 `json:"dailyClicks"`
+}
+
+// devEnvironment turns off everything that needs infrastructure or credentials, so dev mode runs anywhere
+func devEnvironment(env Environment) Environment {
+	env.AWSCustomDynamoEndpoint = ""
+	env.MigrationDynamoEndpoint = ""
+	env.StreamSink = ""
+	env.EventBus = ""
+	env.LockBackend = "local"
+	env.CaptchaProvider = ""
+	// gin's request logging is more readable while developing than the access log
+	env.AccessLogFormat = ""
+	return env
+}
+
+// loadFixtures reads the fixtures file at path, the embedded fixtures if path is empty
+func loadFixtures(path string) ([]devFixture, error) {
+	data := devFixtures
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var fixtures []devFixture
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&fixtures)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fixtures: %w", err)
+	}
+	for _, fixture := range fixtures {
+		if fixture.ShortID == "" || fixture.URL == "" {
+			return nil, fmt.Errorf("invalid fixtures: every link needs a shortID and a url")
+		}
+	}
+	return fixtures, nil
+}
+
+// seedFixtures imports the fixtures into storage with their usage
+func seedFixtures(ctx context.Context, storage urlStorage, fixtures []devFixture) error {
+	today := UTCTimestampOfTodayRounded()
+	for _, fixture := range fixtures {
+		object := URLObject{
+			ShortID:     fixture.ShortID,
+			URL:         fixture.URL,
+			URLHash:     hashURL(fixture.URL),
+			Campaign:    fixture.Campaign,
+			Paused:      fixture.Paused,
+			Notes:       fixture.Notes,
+			Annotations: fixture.Annotations,
+			Usage:       map[string]int64{},
+			RuleUsage:   map[string]int64{},
+		}
+		for i, clicks := range fixture.DailyClicks {
+			day := today.AddDate(0, 0, i-len(fixture.DailyClicks)+1)
+			object.Usage[strconv.FormatInt(day.Unix(), 10)] = clicks
+		}
+		err := storage.ImportURL(ctx, object)
+		if err != nil {
+			return fmt.Errorf("failed to seed %s: %w", fixture.ShortID, err)
+		}
+	}
+	return nil
+}
+
+// printDevExamples shows curl commands against the listening address to get started with
+func printDevExamples(w io.Writer, addr net.Addr, fixtures []devFixture) {
+	base := "http://localhost"
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		base += ":" + strconv.Itoa(tcpAddr.Port)
+	}
+	example := "example"
+	if len(fixtures) > 0 {
+		example = fixtures[0].ShortID
+	}
+	fmt.Fprintf(w, "\ndev mode: in-memory backend seeded with %d links, admin auth disabled\n\n", len(fixtures))
+	fmt.Fprintf(w, "  # create a link\n  curl -X POST %s/shortie -H 'Content-Type: application/json' -d '{\"url\": \"https://example.com\"}'\n", base)
+	fmt.Fprintf(w, "  # follow a link\n  curl -i %s/shortie/%s\n", base, example)
+	fmt.Fprintf(w, "  # a link's statistics\n  curl %s/shortie/%s/stats\n", base, example)
+	fmt.Fprintf(w, "  # every link, as admins see them\n  curl %s/admin/links\n", base)
+	fmt.Fprintf(w, "  # the most used links\n  curl %s/admin/leaderboard\n\n", base)
+}
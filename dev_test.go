@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeedFixtures(t *testing.T) {
+	fixtures, err := loadFixtures("")
+	require.NoError(t, err)
+	require.NotEmpty(t, fixtures)
+
+	storage := &LocalStorage{Objects: map[string]URLObject{}}
+	require.NoError(t, seedFixtures(context.Background(), storage, []devFixture{
+		{ShortID: "docs", URL: "https://go.dev/doc/", Campaign: "launch", DailyClicks: []int64{3, 4}},
+	}))
+	object, err := storage.GetURL(context.Background(), "docs")
+	require.NoError(t, err)
+	require.NotNil(t, object)
+	assert.Equal(t, "launch", object.Campaign)
+	today := UTCTimestampOfTodayRounded()
+	assert.Equal(t, map[string]int64{
+		strconv.FormatInt(today.AddDate(0, 0, -1).Unix(), 10): 3,
+		strconv.FormatInt(today.Unix(), 10):                   4,
+	}, object.Usage)
+	found, err := storage.FindByURL(context.Background(), "https://go.dev/doc/")
+	require.NoError(t, err)
+	assert.Contains(t, found, "docs")
+
+	path := filepath.Join(t.TempDir(), "fixtures.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"shortID": "docs"}]`), 0o600))
+	_, err = loadFixtures(path)
+	assert.Error(t, err)
+	require.NoError(t, os.WriteFile(path, []byte(`[{"shortID": "docs", "url": "https://go.dev", "clicks": 3}]`), 0o600))
+	_, err = loadFixtures(path)
+	assert.Error(t, err)
+}
+
+func TestDevAdminAccess(t *testing.T) {
+	api := shortieAPI{storage: &LocalStorage{Objects: map[string]URLObject{}}, dev: true}
+	router := api.GetRouter()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/leaderboard", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// directoryRealm is the realm browsers are asked to sign in to, with any username and a token as the password
+const directoryRealm = `Basic realm="shortie directory", charset="UTF-8"`
+
+// directoryEntry is a team alias listed in the directory
+type directoryEntry struct {
+	Team   string
+	Alias  string
+	URL    string
+	Title  string
+	Paused bool
+}
+
+var directoryPage = template.Must(template.New("directory").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Link directory</title>
+<link rel="stylesheet" href="/static/style.css">
+</head>
+<body>
+<h1>Link directory</h1>
+<form method="get" action="/directory">
+<input type="search" name="q" value="{{.Query}}" placeholder="Search aliases and destinations" autofocus>
+<button type="submit">Search</button>
+</form>
+{{if .Entries}}
+<ul>
+{{range .Entries}}<li><a href="/t/{{.Team}}/{{.Alias}}">{{.Team}}/{{.Alias}}</a>{{if .Title}} {{.Title}}{{end}}{{if .Paused}} (paused){{end}}<br><code>{{.URL}}</code></li>
+{{end}}</ul>
+{{else if .Query}}
+<p>No aliases match <code>{{.Query}}</code>.</p>
+{{else}}
+<p>No aliases yet.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// RequireDirectoryAccess lets viewers and above see the directory. Browsers can't send a bearer token from the address bar,
+// so the token is also accepted as the password of basic auth, which makes them prompt for it.
+func (api shortieAPI) RequireDirectoryAccess(c *gin.Context) {
+	if !api.directory {
+		respondNotFound(c)
+		return
+	}
+	if api.dev {
+		c.Next()
+		return
+	}
+	caller := api.authenticate(c)
+	if caller == nil {
+		if _, password, found := c.Request.BasicAuth(); found {
+			caller = api.authenticateToken(c, password)
+		}
+	}
+	if caller == nil {
+		c.Header("WWW-Authenticate", directoryRealm)
+		respondError(c, http.StatusUnauthorized, codeUnauthorized, "a valid token is required")
+		return
+	}
+	if caller.role < roleViewer {
+		respondError(c, http.StatusForbidden, codeForbidden, "the directory requires the viewer role")
+		return
+	}
+	c.Set(principalKey, caller)
+	c.Next()
+}
+
+// GetDirectory lists every team alias that currently redirects, searched with ?q= by team, alias and destination.
+// It scans every link like ListTeamAliases does.
+func (api shortieAPI) GetDirectory(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	entries, err := api.directoryEntries(c, strings.ToLower(query))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	var page bytes.Buffer
+	err = directoryPage.Execute(&page, map[string]any{"Query": query, "Entries": entries})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
+
+// directoryEntries leaves out the aliases that don't redirect to everyone: expired, quarantined, signed and ip restricted ones
+func (api shortieAPI) directoryEntries(c *gin.Context, search string) ([]directoryEntry, error) {
+	shortIDs, err := api.storage.ListShortIDs(c)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	entries := []directoryEntry{}
+	for _, shortID := range shortIDs {
+		if !isTeamAlias(shortID) {
+			continue
+		}
+		object, err := api.storage.GetURL(c, shortID)
+		if err != nil {
+			return nil, err
+		}
+		if object == nil || object.Quarantined || object.SigningSecret != "" || !object.IPRules.Permits(c.ClientIP()) ||
+			(object.Expiration != 0 && now.Unix() >= object.Expiration) {
+			continue
+		}
+		team, alias, _ := strings.Cut(shortID, teamAliasSeparator)
+		if search != "" && !strings.Contains(strings.ToLower(shortID+" "+object.URL+" "+object.Title), search) {
+			continue
+		}
+		entries = append(entries, directoryEntry{Team: team, Alias: alias, URL: object.URL, Title: object.Title, Paused: object.Paused})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Team != entries[j].Team {
+			return entries[i].Team < entries[j].Team
+		}
+		return entries[i].Alias < entries[j].Alias
+	})
+	return entries, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The duplicate policy decides what creating a link to a url that is already shortened does
+const (
+	// duplicateReuse hands back the existing link, ids derived from the url do it by themselves
+	duplicateReuse = "reuse"
+	// duplicateNew always creates a new link with an id of its own
+	duplicateNew = "new"
+	// duplicateOwner hands back a link the same owner created to the url, and creates a new one for anyone else
+	duplicateOwner = "owner"
+)
+
+// maxFreshIDAttempts is how many ids are tried for a link that mustn't land on an existing one
+const maxFreshIDAttempts = 3
+
+func validateDuplicatePolicy(policy string) error {
+	switch policy {
+	case duplicateReuse, duplicateNew, duplicateOwner:
+		return nil
+	}
+	return fmt.Errorf("unknown duplicate policy %q, expected reuse, new or owner", policy)
+}
+
+// derivesFromURL reports whether a generator gives the same url the same id every time
+func derivesFromURL(generator idGenerator) bool {
+	switch generator := generator.(type) {
+	case nil, hashIDs:
+		return true
+	case checksumIDs:
+		return derivesFromURL(generator.idGenerator)
+	}
+	return false
+}
+
+// defaultDuplicatePolicy is the configured policy, or what the id generator does by itself if none is:
+// reusing links with ids derived from the url and creating new ones with snowflake and ksuid ids
+func (api shortieAPI) defaultDuplicatePolicy() string {
+	if api.duplicatePolicy != "" {
+		return api.duplicatePolicy
+	}
+	if derivesFromURL(api.ids) {
+		return duplicateReuse
+	}
+	return duplicateNew
+}
+
+// linkSettings are the options of a link that change how it redirects, a link is only handed back for a request
+// that asks for the same ones
+type linkSettings struct {
+	URL            string            `json:"url"`
+	Expiration     int64             `json:"expiration,omitempty"`
+	ActiveFrom     int64             `json:"activeFrom,omitempty"`
+	IPRules        IPRules           `json:"ipRules"`
+	Schedule       Schedule          `json:"schedule"`
+	ReferrerRules  []ReferrerRule    `json:"referrerRules,omitempty"`
+	LanguageRules  map[string]string `json:"languageRules,omitempty"`
+	AppLink        AppLink           `json:"appLink"`
+	NoIndex        bool              `json:"noIndex"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	ReferrerPolicy string            `json:"referrerPolicy,omitempty"`
+	Indexable      bool              `json:"indexable"`
+	Passthrough    bool              `json:"passthrough"`
+	Campaign       string            `json:"campaign,omitempty"`
+}
+
+// sameSettings reports whether the existing link redirects the way the requested one would, so handing it back
+// drops none of the request's options. A requested expiration of 0 takes the existing link's.
+func sameSettings(existing URLObject, requested URLObject) bool {
+	if requested.Expiration == 0 {
+		requested.Expiration = existing.Expiration
+	}
+	// json leaves out empty lists and maps, so a nil and an empty one compare equal
+	existingSettings, err := json.Marshal(settingsOf(existing))
+	if err != nil {
+		return false
+	}
+	requestedSettings, err := json.Marshal(settingsOf(requested))
+	return err == nil && bytes.Equal(existingSettings, requestedSettings)
+}
+
+// canReuse reports whether the existing link can be handed back for the requested one. Anonymous links are
+// only handed back to anonymous callers, who can't extend or change them.
+func canReuse(existing URLObject, requested URLObject) bool {
+	if existing.Anonymous && !requested.Anonymous {
+		return false
+	}
+	return sameSettings(existing, requested)
+}
+
+func settingsOf(object URLObject) linkSettings {
+	return linkSettings{
+		URL:            object.URL,
+		Expiration:     object.Expiration,
+		ActiveFrom:     object.ActiveFrom,
+		IPRules:        object.IPRules,
+		Schedule:       object.Schedule,
+		ReferrerRules:  object.ReferrerRules,
+		LanguageRules:  object.LanguageRules,
+		AppLink:        object.AppLink,
+		NoIndex:        object.NoIndex,
+		Headers:        object.Headers,
+		ReferrerPolicy: object.ReferrerPolicy,
+		Indexable:      object.Indexable,
+		Passthrough:    object.Passthrough,
+		Campaign:       object.Campaign,
+	}
+}
+
+// reusableLink returns the existing link that creating the requested one hands back under the policy, nil to create one
+func (api shortieAPI) reusableLink(c *gin.Context, policy string, requested URLObject) (*URLObject, error) {
+	switch {
+	case policy == duplicateOwner:
+		return api.findDuplicate(c, requested, true)
+	case policy == duplicateReuse && !derivesFromURL(api.ids):
+		return api.findDuplicate(c, requested, false)
+	}
+	return nil, nil
+}
+
+// newLinkID picks the shortID of the requested link. Under the reuse policy it is the generator's id, which for ids
+// derived from the url is the existing link's, unless that link has other settings, and the existing link is
+// returned with it. Otherwise a random salt keeps derived ids off existing links, and the rare id that is taken
+// anyway is drawn again.
+func (api shortieAPI) newLinkID(c *gin.Context, policy string, requested URLObject, signingSecret string) (string, *URLObject, error) {
+	if policy == duplicateReuse {
+		shortID, err := api.newShortID(requested.URL, signingSecret)
+		if err != nil {
+			return "", nil, err
+		}
+		existing, err := api.storage.GetURL(c, shortID)
+		if err != nil {
+			return "", nil, err
+		}
+		if existing == nil || canReuse(*existing, requested) {
+			return shortID, existing, nil
+		}
+	}
+	for attempt := 0; attempt < maxFreshIDAttempts; attempt++ {
+		salt, err := newSigningSecret()
+		if err != nil {
+			return "", nil, err
+		}
+		shortID, err := api.newShortID(requested.URL, signingSecret+salt)
+		if err != nil {
+			return "", nil, err
+		}
+		existing, err := api.storage.GetURL(c, shortID)
+		if err != nil {
+			return "", nil, err
+		}
+		if existing == nil {
+			return shortID, nil, nil
+		}
+	}
+	return "", nil, errors.New("failed to find a shortID that isn't taken")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuplicatePolicies(t *testing.T) {
+	keys, err := parseAPIKeys("alice=editor:alice-key,bob=editor:bob-key")
+	require.NoError(t, err)
+	hashAPI := shortieAPI{storage: newContractLocalStorage(t), apiKeys: keys}
+	randomAPI := shortieAPI{storage: newContractLocalStorage(t), apiKeys: keys, ids: randomIDs{}}
+	create := func(api shortieAPI, key string, body string) (string, bool) {
+		w := serveAs(api, key, http.MethodPost, "/shortie", body)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response struct {
+			ShortURL string `json:"shortUrl"`
+			Existing bool   `json:"existing"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.ShortURL, response.Existing
+	}
+	const page = `{"url":"https://example.com/page"}`
+
+	// by default hash ids reuse the link, and other ids make a new one
+	first, _ := create(hashAPI, "alice-key", page)
+	again, _ := create(hashAPI, "bob-key", page)
+	assert.Equal(t, first, again)
+	first, _ = create(randomAPI, "alice-key", page)
+	again, _ = create(randomAPI, "alice-key", page)
+	assert.NotEqual(t, first, again)
+
+	// new gives hash ids a link of their own
+	hashAPI.duplicatePolicy = duplicateNew
+	fresh, existing := create(hashAPI, "alice-key", page)
+	assert.NotEqual(t, first, fresh)
+	assert.False(t, existing)
+
+	// reuse looks the link up for other ids
+	randomAPI.duplicatePolicy = duplicateReuse
+	reused, existing := create(randomAPI, "bob-key", page)
+	assert.True(t, existing)
+	assert.Contains(t, []string{first, again}, reused)
+
+	// owner only reuses the caller's own link
+	for _, api := range []shortieAPI{hashAPI, randomAPI} {
+		api.duplicatePolicy = duplicateOwner
+		own, _ := create(api, "alice-key", `{"url":"https://example.com/owned"}`)
+		mine, existing := create(api, "alice-key", `{"url":"https://example.com/owned"}`)
+		assert.Equal(t, own, mine)
+		assert.True(t, existing)
+		theirs, existing := create(api, "bob-key", `{"url":"https://example.com/owned"}`)
+		assert.NotEqual(t, own, theirs)
+		assert.False(t, existing)
+
+		// requests pick their own policy
+		override, _ := create(api, "alice-key", `{"url":"https://example.com/owned","duplicates":"new"}`)
+		assert.NotEqual(t, own, override)
+	}
+
+	w := serveAs(hashAPI, "alice-key", http.MethodPost, "/shortie", `{"url":"https://example.com/page","duplicates":"sometimes"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serveAs(hashAPI, "alice-key", http.MethodPost, "/shortie", `{"url":"https://example.com/page","duplicates":"new","dedupe":true}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDerivesFromURL(t *testing.T) {
+	assert.True(t, derivesFromURL(nil))
+	assert.True(t, derivesFromURL(checksumIDs{idGenerator: hashIDs{}, alphabet: base62Alphabet}))
+	assert.False(t, derivesFromURL(ksuidIDs{alphabet: base62Alphabet}))
+	assert.False(t, derivesFromURL(checksumIDs{idGenerator: ksuidIDs{alphabet: base62Alphabet}, alphabet: base62Alphabet}))
+}
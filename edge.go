@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxEdgeBatch bounds how many links a single edge request can resolve or report clicks for
+const maxEdgeBatch = 100
+
+// maxEdgeClicks bounds the clicks a report can count for a link, each of them is written like a redirect
+const maxEdgeClicks = 10000
+
+// the reasons an edge worker has to forward a link's redirects to the origin
+const (
+	// edgeMissing links don't exist, or don't exist yet
+	edgeMissing = "missing"
+	// edgeRestricted links don't redirect every client, they are paused, scheduled, signed, ip restricted and so on
+	edgeRestricted = "restricted"
+	// edgeVaries links redirect requests differently, by their redirect rules, app link or passthrough path
+	edgeVaries = "varies"
+)
+
+// edgeLink is how an edge worker serves the redirects of a link for the next TTL seconds
+type edgeLink struct {
+	// Edge is true if the worker can redirect to Destination itself, it forwards the redirects to the origin otherwise
+	Edge        bool   `json:"edge"`
+	Destination string `json:"destination,omitempty"`
+	// NoIndex asks the worker for an X-Robots-Tag: noindex header
+	NoIndex bool `json:"noIndex,omitempty"`
+	// Headers are the link's extra response headers and its referrer policy, the worker sends them with the redirect
+	Headers map[string]string `json:"headers,omitempty"`
+	Reason  string            `json:"reason,omitempty"`
+	TTL     int64             `json:"ttl"`
+}
+
+func initEdgeTTL(env Environment) (time.Duration, error) {
+	ttl, err := time.ParseDuration(env.EdgeTTL)
+	if err != nil || ttl < time.Second {
+		return 0, fmt.Errorf("invalid SHORTIE_EDGE_TTL %q, it must be at least 1s", env.EdgeTTL)
+	}
+	return ttl, nil
+}
+
+// RequireEdge hides the edge api until it is enabled and callers authenticate, since it resolves links in bulk
+func (api shortieAPI) RequireEdge(c *gin.Context) {
+	if api.edgeTTL == 0 || (!api.dev && !api.rbacEnabled()) {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "the edge api requires SHORTIE_EDGE_TTL and api keys, jwts or access tokens")
+		return
+	}
+	c.Next()
+}
+
+// resolveForEdge decides how an edge worker serves a link. Only links that redirect every request to the same
+// destination are served at the edge, and not past their expiration.
+func (api shortieAPI) resolveForEdge(shortID string, object *URLObject, now time.Time) edgeLink {
+	ttl := int64(math.Ceil(api.edgeTTL.Seconds()))
+	// a rewrite rule wins over a link of the same id
+	if !isTeamAlias(shortID) {
+		for _, rule := range api.rewrites {
+			destination, matched := rule.Destination(shortID)
+			if matched {
+				return edgeLink{Edge: true, Destination: destination, TTL: ttl}
+			}
+		}
+	}
+	if object == nil {
+		return edgeLink{Reason: edgeMissing, TTL: ttl}
+	}
+	if !redirectsEveryone(*object, now) {
+		return edgeLink{Reason: edgeRestricted, TTL: ttl}
+	}
+	if object.Passthrough || object.AppLink.URI != "" || len(object.Schedule.Rules) != 0 || len(object.ReferrerRules) != 0 || len(object.LanguageRules) != 0 {
+		return edgeLink{Reason: edgeVaries, TTL: ttl}
+	}
+	if object.Expiration != 0 {
+		ttl = min(ttl, object.Expiration-now.Unix())
+	}
+	return edgeLink{Edge: true, Destination: object.URL, NoIndex: object.NoIndex, Headers: responseHeaders(*object), TTL: ttl}
+}
+
+// ResolveForEdge answers how an edge worker serves the redirects of a batch of links, keyed by shortID
+func (api shortieAPI) ResolveForEdge(c *gin.Context) {
+	shortIDs := strings.Split(c.Query("ids"), ",")
+	if c.Query("ids") == "" || len(shortIDs) > maxEdgeBatch {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, "ids must list between 1 and "+strconv.Itoa(maxEdgeBatch)+" shortie ids")
+		return
+	}
+
+	now := time.Now()
+	response := make(map[string]edgeLink, len(shortIDs))
+	for _, shortID := range shortIDs {
+		object, err := api.storage.GetURL(c, shortID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		response[shortID] = api.resolveForEdge(shortID, object, now)
+		// links over their owner's plan answer 429 on the service, which the worker can't tell apart from a redirect
+		if object != nil && response[shortID].Edge && !api.allowsRedirect(c, object, now) {
+			response[shortID] = edgeLink{Reason: edgeRestricted, TTL: response[shortID].TTL}
+		}
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, response)
+}
+
+// ReportEdgeClicks counts the redirects edge workers served, a JSON object of the clicks of each shortID since the
+// last report. Clicks of links that no longer exist are dropped.
+func (api shortieAPI) ReportEdgeClicks(c *gin.Context) {
+	var clicks map[string]int64
+	if !api.bindJSON(c, &clicks) {
+		return
+	}
+	if len(clicks) == 0 || len(clicks) > maxEdgeBatch {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, "the body must count the clicks of between 1 and "+strconv.Itoa(maxEdgeBatch)+" shortie ids")
+		return
+	}
+	for shortID, count := range clicks {
+		if count < 1 || count > maxEdgeClicks {
+			respondError(c, http.StatusBadRequest, codeInvalidParameter, "the clicks of "+shortID+" must be between 1 and "+strconv.Itoa(maxEdgeClicks))
+			return
+		}
+	}
+
+	now := time.Now()
+	counted := int64(0)
+	for shortID, count := range clicks {
+		object, err := api.storage.GetURL(c, shortID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		if object == nil {
+			continue
+		}
+		// the clicks of a link are counted at once, and a failure to count them doesn't fail the rest of the report
+		err = api.storage.IncrementUsage(c, shortID, "", count, count)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			continue
+		}
+		counted += count
+		if api.meter != nil {
+			api.meter.Add(object.Owner, now, 0, count)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"counted": counted})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveForEdge(t *testing.T) {
+	ctx := context.Background()
+	expiration := time.Now().Add(30 * time.Second).Unix()
+	storage := newContractLocalStorage(t)
+	for _, object := range []URLObject{
+		{ShortID: "plain", URL: "https://example.com/plain", NoIndex: true, Headers: map[string]string{"Referrer-Policy": "no-referrer"}},
+		{ShortID: "expiring", URL: "https://example.com/expiring", Expiration: expiration},
+		{ShortID: "paused", URL: "https://example.com/paused", Paused: true},
+		{ShortID: "signed", URL: "https://example.com/signed", SigningSecret: "secret"},
+		{ShortID: "app", URL: "https://example.com/app", AppLink: AppLink{URI: "myapp://items/1"}},
+		{ShortID: "lang", URL: "https://example.com/lang", LanguageRules: map[string]string{"de": "https://example.de"}},
+		{ShortID: "docs", URL: "https://example.com/shadowed"},
+	} {
+		require.NoError(t, storage.ImportURL(ctx, object))
+	}
+	keys, err := parseAPIKeys("zapier=viewer:zap-key")
+	require.NoError(t, err)
+	rule := &rewriteRule{Name: "docs", Pattern: "docs", Target: "https://docs.example.com"}
+	require.NoError(t, rule.compile())
+	api := shortieAPI{storage: storage, apiKeys: keys, rewrites: []*rewriteRule{rule}, edgeTTL: time.Minute}
+
+	w := serveAs(api, "zap-key", http.MethodGet, "/edge/resolve?ids=plain,expiring,paused,signed,app,lang,docs,missing", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	var links map[string]edgeLink
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &links))
+	assert.Equal(t, edgeLink{Edge: true, Destination: "https://example.com/plain", NoIndex: true, Headers: map[string]string{"Referrer-Policy": "no-referrer"}, TTL: 60}, links["plain"])
+	assert.True(t, links["expiring"].Edge)
+	assert.LessOrEqual(t, links["expiring"].TTL, int64(30))
+	assert.Equal(t, edgeLink{Reason: edgeRestricted, TTL: 60}, links["paused"])
+	assert.Equal(t, edgeLink{Reason: edgeRestricted, TTL: 60}, links["signed"])
+	assert.Equal(t, edgeLink{Reason: edgeVaries, TTL: 60}, links["app"])
+	assert.Equal(t, edgeLink{Reason: edgeVaries, TTL: 60}, links["lang"])
+	assert.Equal(t, edgeLink{Edge: true, Destination: "https://docs.example.com", TTL: 60}, links["docs"])
+	assert.Equal(t, edgeLink{Reason: edgeMissing, TTL: 60}, links["missing"])
+
+	w = serveAs(api, "zap-key", http.MethodGet, "/edge/resolve", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, serveAs(api, "", http.MethodGet, "/edge/resolve?ids=plain", "").Code)
+
+	// the edge api is off unless it is configured and callers authenticate
+	api.edgeTTL = 0
+	assert.Equal(t, http.StatusNotFound, serveAs(api, "zap-key", http.MethodGet, "/edge/resolve?ids=plain", "").Code)
+	api.edgeTTL = time.Minute
+	api.apiKeys = nil
+	assert.Equal(t, http.StatusNotFound, serveAs(api, "", http.MethodGet, "/edge/resolve?ids=plain", "").Code)
+}
+
+func TestReportEdgeClicks(t *testing.T) {
+	ctx := context.Background()
+	storage := newContractLocalStorage(t)
+	require.NoError(t, storage.ImportURL(ctx, URLObject{ShortID: "plain", URL: "https://example.com/plain"}))
+	keys, err := parseAPIKeys("zapier=viewer:zap-key")
+	require.NoError(t, err)
+	api := shortieAPI{storage: storage, apiKeys: keys, edgeTTL: time.Minute}
+
+	w := serveAs(api, "zap-key", http.MethodPost, "/edge/clicks", `{"plain": 3, "gone": 2}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"counted": 3}`, w.Body.String())
+	statistics, err := api.storage.GetStatistics(ctx, "plain")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), usageSince(statistics.Usage, time.Time{}))
+
+	for _, body := range []string{`{}`, `{"plain": 0}`, `{"plain": -1}`, `{"plain": 10001}`} {
+		assert.Equal(t, http.StatusBadRequest, serveAs(api, "zap-key", http.MethodPost, "/edge/clicks", body).Code, body)
+	}
+}
+
+func TestEdgeScope(t *testing.T) {
+	edge := accessToken{Name: "worker", Scopes: []string{scopeEdge}}.principal()
+	assert.Equal(t, roleViewer, edge.role)
+	assert.True(t, edge.allows(scopeEdge))
+	assert.False(t, accessToken{Name: "bot", Scopes: []string{scopeStats}}.principal().allows(scopeEdge))
+}
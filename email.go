@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The email gateway shortens the urls in emails sent to SHORTIE_EMAIL_ADDRESS. SES receives the email and a receipt rule
+// publishes it to an SNS topic, which posts it to /integrations/email. The results are sent back to the sender over SMTP,
+// which SES also offers.
+
+// maxEmailURLs is how many urls of one email are shortened, the rest are listed as skipped in the reply
+const maxEmailURLs = 10
+
+var (
+	snsHost    = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+	emailURLs  = regexp.MustCompile(`https?://[^\s<>"']+`)
+	errNotSNS  = errors.New("the url isn't an sns url")
+	errSNSSign = errors.New("invalid sns signature")
+)
+
+// snsMessage is what SNS posts to an https subscription
+type snsMessage struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL"`
+	UnsubscribeURL   string `json:"UnsubscribeURL"`
+}
+
+// signedString is what SNS signs, the message's fields in a fixed order that depends on its type
+func (message snsMessage) signedString() string {
+	fields := []string{"Message", message.Message, "MessageId", message.MessageID}
+	if message.Type == "Notification" {
+		if message.Subject != "" {
+			fields = append(fields, "Subject", message.Subject)
+		}
+		fields = append(fields, "Timestamp", message.Timestamp, "TopicArn", message.TopicArn, "Type", message.Type)
+	} else {
+		fields = append(fields, "SubscribeURL", message.SubscribeURL, "Timestamp", message.Timestamp, "Token", message.Token,
+			"TopicArn", message.TopicArn, "Type", message.Type)
+	}
+	return strings.Join(fields, "\n") + "\n"
+}
+
+func isSNSURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	return err == nil && parsed.Scheme == "https" && snsHost.MatchString(parsed.Hostname())
+}
+
+// snsCertificates fetches the certificates SNS signs messages with
+type snsCertificates interface {
+	Certificate(ctx context.Context, url string) (*x509.Certificate, error)
+}
+
+// HTTPSNSCertificates downloads certificates from SNS and keeps them, SNS rotates them rarely
+type HTTPSNSCertificates struct {
+	client *http.Client
+	lock   sync.Mutex
+	cache  map[string]*x509.Certificate
+}
+
+func NewHTTPSNSCertificates() *HTTPSNSCertificates {
+	return &HTTPSNSCertificates{client: &http.Client{Timeout: 5 * time.Second}, cache: map[string]*x509.Certificate{}}
+}
+
+func (certificates *HTTPSNSCertificates) Certificate(ctx context.Context, certificateURL string) (*x509.Certificate, error) {
+	if !isSNSURL(certificateURL) {
+		return nil, errNotSNS
+	}
+	certificates.lock.Lock()
+	defer certificates.lock.Unlock()
+	if certificate, found := certificates.cache[certificateURL]; found {
+		return certificate, nil
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, certificateURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := certificates.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the sns certificate: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching the sns certificate responded with %d", response.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the sns certificate: %w", err)
+	}
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, errors.New("the sns certificate isn't pem encoded")
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sns certificate: %w", err)
+	}
+	certificates.cache[certificateURL] = certificate
+	return certificate, nil
+}
+
+// verifySNSMessage checks the message was signed by SNS, with SHA1 for signature version 1 and SHA256 for version 2
+func verifySNSMessage(ctx context.Context, certificates snsCertificates, message snsMessage) error {
+	if !isSNSURL(message.SigningCertURL) {
+		return errSNSSign
+	}
+	certificate, err := certificates.Certificate(ctx, message.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	key, ok := certificate.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errSNSSign
+	}
+	signature, err := base64.StdEncoding.DecodeString(message.Signature)
+	if err != nil {
+		return errSNSSign
+	}
+	var hash crypto.Hash
+	var digest []byte
+	switch message.SignatureVersion {
+	case "1":
+		sum := sha1.Sum([]byte(message.signedString()))
+		hash, digest = crypto.SHA1, sum[:]
+	case "2":
+		sum := sha256.Sum256([]byte(message.signedString()))
+		hash, digest = crypto.SHA256, sum[:]
+	default:
+		return errSNSSign
+	}
+	if rsa.VerifyPKCS1v15(key, hash, digest, signature) != nil {
+		return errSNSSign
+	}
+	return nil
+}
+
+// sesVerdict is one of PASS, FAIL, GRAY or PROCESSING_FAILED
+type sesVerdict struct {
+	Status string `json:"status"`
+}
+
+// sesNotification is what an SES receipt rule's SNS action publishes, content is the raw email
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	Mail             struct {
+		CommonHeaders struct {
+			From      []string `json:"from"`
+			Subject   string   `json:"subject"`
+			MessageID string   `json:"messageId"`
+		} `json:"commonHeaders"`
+	} `json:"mail"`
+	Receipt struct {
+		Recipients   []string   `json:"recipients"`
+		DMARCVerdict sesVerdict `json:"dmarcVerdict"`
+		SpamVerdict  sesVerdict `json:"spamVerdict"`
+		VirusVerdict sesVerdict `json:"virusVerdict"`
+	} `json:"receipt"`
+	Content string `json:"content"`
+}
+
+// emailSender sends the replies of the gateway
+type emailSender interface {
+	Send(to string, subject string, body string, inReplyTo string) error
+}
+
+// SMTPSender sends emails through an SMTP server like SES's, with STARTTLS when the server offers it
+type SMTPSender struct {
+	addr     string
+	username string
+	password string
+	from     string
+}
+
+// headerValue keeps values from other emails from adding headers to the ones we send
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
+
+func (sender *SMTPSender) Send(to string, subject string, body string, inReplyTo string) error {
+	var message bytes.Buffer
+	message.WriteString("From: " + sender.from + "\r\n")
+	message.WriteString("To: " + headerValue(to) + "\r\n")
+	message.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", headerValue(subject)) + "\r\n")
+	if inReplyTo != "" {
+		message.WriteString("In-Reply-To: " + headerValue(inReplyTo) + "\r\n")
+		message.WriteString("References: " + headerValue(inReplyTo) + "\r\n")
+	}
+	// keeps vacation responders from answering the reply
+	message.WriteString("Auto-Submitted: auto-replied\r\n")
+	message.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if sender.username != "" {
+		host, _, err := net.SplitHostPort(sender.addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", sender.username, sender.password, host)
+	}
+	return smtp.SendMail(sender.addr, auth, sender.from, []string{to}, message.Bytes())
+}
+
+// emailGateway is the configuration of the email gateway
+type emailGateway struct {
+	address  string
+	topicARN string
+	// allowed are the sender addresses, and domains starting with @, that may shorten links
+	allowed      []string
+	certificates snsCertificates
+	// confirm fetches the SubscribeURL of a new subscription to the topic
+	confirm func(ctx context.Context, subscribeURL string) error
+	// sender is nil if replies aren't configured, the links are still created
+	sender emailSender
+}
+
+func initEmailGateway(env Environment) (*emailGateway, error) {
+	if env.EmailTopicARN == "" {
+		return nil, errors.New("SHORTIE_EMAIL_ADDRESS requires SHORTIE_EMAIL_TOPIC_ARN")
+	}
+	gateway := &emailGateway{
+		address:      strings.ToLower(env.EmailAddress),
+		topicARN:     env.EmailTopicARN,
+		certificates: NewHTTPSNSCertificates(),
+		confirm:      confirmSNSSubscription,
+	}
+	for _, sender := range strings.Split(env.EmailAllowedSenders, ",") {
+		sender = strings.ToLower(strings.TrimSpace(sender))
+		if sender != "" {
+			gateway.allowed = append(gateway.allowed, sender)
+		}
+	}
+	if len(gateway.allowed) == 0 {
+		return nil, errors.New("SHORTIE_EMAIL_ADDRESS requires SHORTIE_EMAIL_ALLOWED_SENDERS, anyone could create links otherwise")
+	}
+	if env.EmailSMTPAddr != "" {
+		gateway.sender = &SMTPSender{addr: env.EmailSMTPAddr, username: env.EmailSMTPUsername, password: env.EmailSMTPPassword, from: env.EmailAddress}
+	}
+	return gateway, nil
+}
+
+func confirmSNSSubscription(ctx context.Context, subscribeURL string) error {
+	if !isSNSURL(subscribeURL) {
+		return errNotSNS
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, subscribeURL, nil)
+	if err != nil {
+		return err
+	}
+	response, err := (&http.Client{Timeout: 5 * time.Second}).Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("confirming the subscription responded with %d", response.StatusCode)
+	}
+	return nil
+}
+
+// allows reports whether a sender may use the gateway
+func (gateway *emailGateway) allows(sender string) bool {
+	sender = strings.ToLower(sender)
+	_, domain, _ := strings.Cut(sender, "@")
+	for _, allowed := range gateway.allowed {
+		if allowed == sender || allowed == "@"+domain {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleEmail receives the SNS messages of the email gateway's topic. Anything that was delivered but can't be shortened
+// is answered with a 200, SNS would only retry it otherwise.
+func (api shortieAPI) HandleEmail(c *gin.Context) {
+	if api.email == nil {
+		respondNotFound(c)
+		return
+	}
+	var message snsMessage
+	if !api.bindJSON(c, &message) {
+		return
+	}
+	if message.TopicArn != api.email.topicARN {
+		respondError(c, http.StatusForbidden, codeForbidden, "the message isn't from the email gateway's topic")
+		return
+	}
+	err := verifySNSMessage(c, api.email.certificates, message)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, codeUnauthorized, err.Error())
+		return
+	}
+
+	switch message.Type {
+	case "SubscriptionConfirmation":
+		err = api.email.confirm(c, message.SubscribeURL)
+		if err != nil {
+			respondError(c, http.StatusBadGateway, codeInternal, "failed to confirm the subscription: "+err.Error())
+			return
+		}
+		log.Println("confirmed the subscription of the email gateway to " + message.TopicArn)
+	case "Notification":
+		var notification sesNotification
+		err = json.Unmarshal([]byte(message.Message), &notification)
+		if err != nil || notification.NotificationType != "Received" {
+			break
+		}
+		err = api.shortenFromEmail(c, notification)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+	}
+	c.Status(http.StatusOK)
+}
+
+// shortenFromEmail shortens the urls of an email and replies with the results. Emails that don't pass DMARC
+// or come from senders that aren't allowed are dropped without a reply, replying would answer forged senders.
+func (api shortieAPI) shortenFromEmail(c *gin.Context, notification sesNotification) error {
+	if len(notification.Mail.CommonHeaders.From) == 0 {
+		return nil
+	}
+	from, err := mail.ParseAddress(notification.Mail.CommonHeaders.From[0])
+	if err != nil || strings.EqualFold(from.Address, api.email.address) {
+		return nil
+	}
+	addressed := false
+	for _, recipient := range notification.Receipt.Recipients {
+		addressed = addressed || strings.EqualFold(recipient, api.email.address)
+	}
+	receipt := notification.Receipt
+	if !addressed || receipt.DMARCVerdict.Status != "PASS" || receipt.SpamVerdict.Status == "FAIL" || receipt.VirusVerdict.Status == "FAIL" {
+		log.Println("error: dropped an email to the gateway that didn't pass its checks")
+		return nil
+	}
+	if !api.email.allows(from.Address) {
+		log.Println("error: dropped an email to the gateway from " + from.Address + ", who isn't an allowed sender")
+		return nil
+	}
+
+	raw := []byte(notification.Content)
+	// the SNS action sends the email as is or base64 encoded, depending on its encoding setting
+	if decoded, err := base64.StdEncoding.DecodeString(notification.Content); err == nil {
+		raw = decoded
+	}
+	email, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		log.Println("error: failed to read an email to the gateway: " + err.Error())
+		return nil
+	}
+	// automatic replies, like an out of office answering our own reply, are never shortened
+	if autoSubmitted := email.Header.Get("Auto-Submitted"); autoSubmitted != "" && autoSubmitted != "no" {
+		return nil
+	}
+	text := emailText(textproto.MIMEHeader(email.Header), email.Body, 0)
+
+	var reply strings.Builder
+	urls := extractURLs(text)
+	switch {
+	case api.readOnly != nil && api.readOnly.Load():
+		reply.WriteString("Links can't be created right now, shortie is read-only.\n")
+		urls = nil
+	case len(urls) == 0:
+		reply.WriteString("There were no urls to shorten in your email, send one or more starting with http:// or https://\n")
+	}
+	for i, destination := range urls {
+		if i == maxEmailURLs {
+			reply.WriteString(fmt.Sprintf("Only the first %d urls of an email are shortened, the rest were skipped.\n", maxEmailURLs))
+			break
+		}
+		err = api.validatePlainURL(destination)
+		if err != nil {
+			reply.WriteString(destination + "\n  can't be shortened, " + err.Error() + "\n")
+			continue
+		}
+		object, err := api.createPlainLink(c, destination, "email:"+strings.ToLower(from.Address))
+		if err != nil {
+			return err
+		}
+		reply.WriteString(object.URL + "\n  http://localhost:8421/shortie/" + object.ShortID + "\n")
+		if object.Quarantined {
+			reply.WriteString("  won't redirect until an admin approves it\n")
+		}
+	}
+
+	if api.email.sender == nil {
+		return nil
+	}
+	err = api.email.sender.Send(from.Address, "Re: "+notification.Mail.CommonHeaders.Subject, reply.String(), notification.Mail.CommonHeaders.MessageID)
+	if err != nil {
+		// the links were created, a retry wouldn't do better
+		log.Println("error: failed to reply to an email to the gateway: " + err.Error())
+	}
+	return nil
+}
+
+// emailText returns the text of an email's plain text parts, or of its html parts if it has none
+func emailText(header textproto.MIMEHeader, body io.Reader, depth int) string {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && depth < 5 {
+		var plain, rich strings.Builder
+		parts := multipart.NewReader(body, params["boundary"])
+		for {
+			// NextRawPart leaves the transfer encoding to the recursive call
+			part, err := parts.NextRawPart()
+			if err != nil {
+				break
+			}
+			text := emailText(part.Header, part, depth+1)
+			partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			if partType == "text/html" {
+				rich.WriteString(text + "\n")
+			} else {
+				plain.WriteString(text + "\n")
+			}
+		}
+		if strings.TrimSpace(plain.String()) != "" {
+			return plain.String()
+		}
+		return rich.String()
+	}
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return ""
+	}
+	content, err := io.ReadAll(io.LimitReader(body, 1<<20))
+	if err != nil {
+		return ""
+	}
+	if mediaType == "text/html" {
+		// urls in attributes have their ampersands escaped
+		return html.UnescapeString(string(content))
+	}
+	return string(content)
+}
+
+// extractURLs finds the distinct http and https urls of a text, in order, without the punctuation ending a sentence
+func extractURLs(text string) []string {
+	var urls []string
+	seen := map[string]bool{}
+	for _, match := range emailURLs.FindAllString(text, -1) {
+		match = strings.TrimRight(match, ".,;:!?)]}")
+		if seen[match] {
+			continue
+		}
+		seen[match] = true
+		urls = append(urls, match)
+	}
+	return urls
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testEmailTopic   = "arn:aws:sns:us-west-2:123456789012:shortie-email"
+	testSigningCert  = "https://sns.us-west-2.amazonaws.com/SimpleNotificationService-0000.pem"
+	testEmailAddress = "shorten@links.example.com"
+)
+
+// fakeSNS signs messages like SNS does, with a certificate of its own
+type fakeSNS struct {
+	key         *rsa.PrivateKey
+	certificate *x509.Certificate
+}
+
+func newFakeSNS(t *testing.T) *fakeSNS {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &fakeSNS{key: key, certificate: certificate}
+}
+
+func (sns *fakeSNS) Certificate(ctx context.Context, url string) (*x509.Certificate, error) {
+	if url != testSigningCert {
+		return nil, errNotSNS
+	}
+	return sns.certificate, nil
+}
+
+func (sns *fakeSNS) sign(t *testing.T, message snsMessage) snsMessage {
+	message.SignatureVersion = "2"
+	message.SigningCertURL = testSigningCert
+	message.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	digest := sha256.Sum256([]byte(message.signedString()))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, sns.key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	message.Signature = base64.StdEncoding.EncodeToString(signature)
+	return message
+}
+
+type sentEmail struct {
+	to, subject, body, inReplyTo string
+}
+
+type fakeEmailSender struct {
+	sent []sentEmail
+}
+
+func (sender *fakeEmailSender) Send(to string, subject string, body string, inReplyTo string) error {
+	sender.sent = append(sender.sent, sentEmail{to, subject, body, inReplyTo})
+	return nil
+}
+
+func receivedEmail(t *testing.T, from string, dmarc string, raw string) string {
+	notification := map[string]any{
+		"notificationType": "Received",
+		"mail": map[string]any{
+			"commonHeaders": map[string]any{"from": []string{from}, "subject": "links", "messageId": "<abc@mail.example.com>"},
+		},
+		"receipt": map[string]any{
+			"recipients":   []string{testEmailAddress},
+			"dmarcVerdict": map[string]string{"status": dmarc},
+			"spamVerdict":  map[string]string{"status": "PASS"},
+			"virusVerdict": map[string]string{"status": "PASS"},
+		},
+		"content": raw,
+	}
+	encoded, err := json.Marshal(notification)
+	require.NoError(t, err)
+	return string(encoded)
+}
+
+const multipartEmail = "From: Alice <alice@example.com>\r\n" +
+	"To: shorten@links.example.com\r\n" +
+	"Subject: links\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/alternative; boundary=b1\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Please shorten https://example.com/a/very/long/path?x=3D1&y=3D2.\r\n" +
+	"And this one (https://docs.example.com/guide), and ftp://example.com/file\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>Please shorten <a href=\"https://example.com/a/very/long/path?x=1&amp;y=2\">this</a></p>\r\n" +
+	"--b1--\r\n"
+
+func TestExtractURLs(t *testing.T) {
+	assert.Equal(t, []string{"https://example.com/a", "http://example.com/b?c=d"},
+		extractURLs("See https://example.com/a, and <http://example.com/b?c=d>! Again: https://example.com/a."))
+}
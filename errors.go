@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiError is the body of every JSON error response
+// clients branch on the code, the message is for people and can change
+type apiError struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestID string         `json:"requestID"`
+}
+
+const (
+	codeInvalidJSON        = "INVALID_JSON"
+	codeBodyTooLarge       = "BODY_TOO_LARGE"
+	codeInvalidParameter   = "INVALID_PARAMETER"
+	codeURLInvalid         = "URL_INVALID"
+	codeRuleInvalid        = "RULE_INVALID"
+	codeExpirationInvalid  = "EXPIRATION_INVALID"
+	codeURLExists          = "URL_EXISTS"
+	codeAliasTaken         = "ALIAS_TAKEN"
+	codeLinkLocked         = "LINK_LOCKED"
+	codeNotFound           = "NOT_FOUND"
+	codeExpired            = "EXPIRED"
+	codeIDMistyped         = "ID_MISTYPED"
+	codeAdminDisabled      = "ADMIN_DISABLED"
+	codeUnauthorized       = "UNAUTHORIZED"
+	codeForbidden          = "FORBIDDEN"
+	codeStepUpRequired     = "STEP_UP_REQUIRED"
+	codeCaptchaRequired    = "CAPTCHA_REQUIRED"
+	codeCaptchaFailed      = "CAPTCHA_FAILED"
+	codeFeatureDisabled    = "FEATURE_DISABLED"
+	codeMigrationRunning   = "MIGRATION_RUNNING"
+	codeUnsupportedVersion = "UNSUPPORTED_VERSION"
+	codeReadOnly           = "READ_ONLY"
+	codeOverloaded         = "OVERLOADED"
+	codeCursorExpired      = "CURSOR_EXPIRED"
+	codeQuotaExceeded      = "QUOTA_EXCEEDED"
+	codeRateLimited        = "RATE_LIMITED"
+	codeInternal           = "INTERNAL"
+)
+
+// requestIDHeader carries the id of a request, taken from the client or a proxy when they send one
+const requestIDHeader = "X-Request-ID"
+
+const requestIDKey = "requestID"
+
+// maxRequestIDLength keeps clients from echoing arbitrary amounts of data back through the header
+const maxRequestIDLength = 128
+
+// RequestID gives every request an id, returned in the X-Request-ID header and error bodies so reports can be traced
+func RequestID(c *gin.Context) {
+	requestID := c.GetHeader(requestIDHeader)
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		random := make([]byte, 8)
+		_, _ = rand.Read(random)
+		requestID = hex.EncodeToString(random)
+	}
+	c.Set(requestIDKey, requestID)
+	if traceID := c.GetHeader(traceHeader); traceID != "" {
+		c.Set(traceIDKey, traceID)
+	}
+	c.Header(requestIDHeader, requestID)
+	c.Next()
+}
+
+// respondError aborts the request with an error body
+func respondError(c *gin.Context, status int, code string, message string) {
+	respondErrorDetails(c, status, code, message, nil)
+}
+
+// respondErrorDetails aborts the request with an error body carrying extra data for the code, like the existing shortID of URL_EXISTS
+func respondErrorDetails(c *gin.Context, status int, code string, message string, details map[string]any) {
+	c.AbortWithStatusJSON(status, apiError{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: c.GetString(requestIDKey),
+	})
+}
+
+// respondNotFound is the JSON api's 404, the redirect and page routes answer browsers in plain text instead
+func respondNotFound(c *gin.Context) {
+	respondError(c, http.StatusNotFound, codeNotFound, "the shortie id is not found")
+}
+
+func respondLocked(c *gin.Context) {
+	respondError(c, http.StatusConflict, codeLinkLocked, "the link is locked, an admin has to unlock it first")
+}
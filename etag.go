@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConditionalGET tags stats and list responses with an ETag of their content and answers a matching
+// If-None-Match with a 304, so dashboards polling unchanged stats don't download them again.
+// The tag is weak since compression changes the bytes on the wire but not the data.
+func ConditionalGET(c *gin.Context) {
+	original := c.Writer
+	writer := &bufferedWriter{ResponseWriter: original}
+	c.Writer = writer
+	c.Next()
+	c.Writer = original
+
+	body := writer.body.Bytes()
+	if c.Request.Method != http.MethodGet || original.Status() != http.StatusOK {
+		_, _ = original.Write(body)
+		return
+	}
+	hash := sha256.Sum256(body)
+	etag := `W/"` + hex.EncodeToString(hash[:16]) + `"`
+	original.Header().Set("ETag", etag)
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		original.WriteHeader(http.StatusNotModified)
+		original.WriteHeaderNow()
+		return
+	}
+	_, _ = original.Write(body)
+}
+
+// etagMatches compares an If-None-Match header against an etag, ignoring the weak prefix as RFC 9110 asks
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
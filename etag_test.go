@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionalGET(t *testing.T) {
+	storage := &LocalStorage{Objects: map[string]URLObject{
+		"111": {URL: "https://example.com", Usage: map[string]int64{}},
+	}, lock: sync.Mutex{}}
+	api := shortieAPI{storage: storage}
+	router := api.GetRouter()
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/shortie/111/stats", nil)
+		request.Header.Set("If-None-Match", ifNoneMatch)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w
+	}
+
+	first := get("")
+	require.Equal(t, http.StatusOK, first.Code)
+	etag := first.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	unchanged := get(etag)
+	assert.Equal(t, http.StatusNotModified, unchanged.Code)
+	assert.Empty(t, unchanged.Body.String())
+
+	// a click changes the stats and so the tag
+	require.NoError(t, storage.IncrementUsage(context.Background(), "111", "", 1, 1))
+	changed := get(etag)
+	assert.Equal(t, http.StatusOK, changed.Code)
+	assert.NotEqual(t, etag, changed.Header().Get("ETag"))
+
+	// errors aren't tagged
+	request := httptest.NewRequest(http.MethodGet, "/shortie/111/stats?days=0", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, w.Header().Get("ETag"))
+}
+
+func TestEtagMatches(t *testing.T) {
+	assert.False(t, etagMatches("", `W/"abc"`))
+	assert.True(t, etagMatches(`W/"abc"`, `W/"abc"`))
+	assert.True(t, etagMatches(`"abc"`, `W/"abc"`))
+	assert.True(t, etagMatches(`"xyz", W/"abc"`, `W/"abc"`))
+	assert.True(t, etagMatches(`*`, `W/"abc"`))
+	assert.False(t, etagMatches(`"xyz"`, `W/"abc"`))
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	linkSaved   = "saved"
+	linkChanged = "changed"
+	linkDeleted = "deleted"
+)
+
+// linkEvent announces a change to a link so that other replicas can update their local caches
+type linkEvent struct {
+	Type    string `json:"type"`
+	ShortID string `json:"shortID"`
+}
+
+type linkEventBus interface {
+	Publish(ctx context.Context, event linkEvent) error
+	// Subscribe calls handler for every published event, including our own, until the context is done
+	Subscribe(ctx context.Context, handler func(event linkEvent))
+}
+
+const redisLinkEventsChannel = "shortie:links"
+
+// RedisEventBus shares link events between replicas with redis pub/sub
+// pub/sub is fire and forget, so a replica that is disconnected misses events and relies on TTLs and rebuilds to catch up
+type RedisEventBus struct {
+	redis *redis.Client
+}
+
+func NewRedisEventBus(addr string) *RedisEventBus {
+	return &RedisEventBus{redis: redis.NewClient(&redis.Options{Addr: addr})}
+}
+
+func (bus *RedisEventBus) Publish(ctx context.Context, event linkEvent) error {
+	message, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return bus.redis.Publish(ctx, redisLinkEventsChannel, message).Err()
+}
+
+func (bus *RedisEventBus) Subscribe(ctx context.Context, handler func(event linkEvent)) {
+	subscription := bus.redis.Subscribe(ctx, redisLinkEventsChannel)
+	defer subscription.Close()
+
+	messages := subscription.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			var event linkEvent
+			err := json.Unmarshal([]byte(message.Payload), &event)
+			if err != nil {
+				log.Println("error: malformed link event: " + err.Error())
+				continue
+			}
+			handler(event)
+		}
+	}
+}
+
+// PublishingStorage publishes a link event after every successful change to a link
+type PublishingStorage struct {
+	urlStorage
+	bus linkEventBus
+}
+
+func NewPublishingStorage(storage urlStorage, bus linkEventBus) *PublishingStorage {
+	return &PublishingStorage{urlStorage: storage, bus: bus}
+}
+
+// publish is best-effort, a failure only delays other replicas seeing the change until their caches expire
+func (publishing *PublishingStorage) publish(ctx context.Context, eventType string, shortID string) {
+	err := publishing.bus.Publish(ctx, linkEvent{Type: eventType, ShortID: shortID})
+	if err != nil {
+		log.Println("error: failed to publish a link event: " + err.Error())
+	}
+}
+
+func (publishing *PublishingStorage) SaveURL(ctx context.Context, object URLObject) error {
+	err := publishing.urlStorage.SaveURL(ctx, object)
+	if err == nil {
+		publishing.publish(ctx, linkSaved, object.ShortID)
+	}
+	return err
+}
+
+func (publishing *PublishingStorage) ImportURL(ctx context.Context, object URLObject) error {
+	err := publishing.urlStorage.ImportURL(ctx, object)
+	if err == nil {
+		publishing.publish(ctx, linkSaved, object.ShortID)
+	}
+	return err
+}
+
+func (publishing *PublishingStorage) SetPaused(ctx context.Context, shortID string, paused bool) error {
+	err := publishing.urlStorage.SetPaused(ctx, shortID, paused)
+	if err == nil {
+		publishing.publish(ctx, linkChanged, shortID)
+	}
+	return err
+}
+
+func (publishing *PublishingStorage) SetQuarantined(ctx context.Context, shortID string, quarantined bool) error {
+	err := publishing.urlStorage.SetQuarantined(ctx, shortID, quarantined)
+	if err == nil {
+		publishing.publish(ctx, linkChanged, shortID)
+	}
+	return err
+}
+
+func (publishing *PublishingStorage) SetLocked(ctx context.Context, shortID string, locked bool) error {
+	err := publishing.urlStorage.SetLocked(ctx, shortID, locked)
+	if err == nil {
+		publishing.publish(ctx, linkChanged, shortID)
+	}
+	return err
+}
+
+func (publishing *PublishingStorage) SetContentHash(ctx context.Context, shortID string, hash string) error {
+	err := publishing.urlStorage.SetContentHash(ctx, shortID, hash)
+	if err == nil {
+		publishing.publish(ctx, linkChanged, shortID)
+	}
+	return err
+}
+
+func (publishing *PublishingStorage) SetTitle(ctx context.Context, shortID string, title string) error {
+	err := publishing.urlStorage.SetTitle(ctx, shortID, title)
+	if err == nil {
+		publishing.publish(ctx, linkChanged, shortID)
+	}
+	return err
+}
+
+func (publishing *PublishingStorage) SetExpiration(ctx context.Context, shortID string, expiration int64) error {
+	err := publishing.urlStorage.SetExpiration(ctx, shortID, expiration)
+	if err == nil {
+		publishing.publish(ctx, linkChanged, shortID)
+	}
+	return err
+}
+
+func (publishing *PublishingStorage) DeleteURL(ctx context.Context, shortID string) error {
+	err := publishing.urlStorage.DeleteURL(ctx, shortID)
+	if err == nil {
+		publishing.publish(ctx, linkDeleted, shortID)
+	}
+	return err
+}
+
+func (publishing *PublishingStorage) ConsumeURL(ctx context.Context, shortID string) (bool, error) {
+	consumed, err := publishing.urlStorage.ConsumeURL(ctx, shortID)
+	if consumed {
+		publishing.publish(ctx, linkDeleted, shortID)
+	}
+	return consumed, err
+}
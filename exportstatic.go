@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A static export is a directory of html pages, one per link, that redirect with a meta refresh. Hosted on s3 or
+// github pages it keeps links working as a read-only mirror while the service is down, or as an archival snapshot.
+// Pages can't check signatures, ip rules or expirations, and can't count clicks, so only links that redirect
+// everyone unconditionally are exported, and redirect rules fall back to the link's default destination.
+
+var staticRedirectPage = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<meta http-equiv="refresh" content="0; url={{.URL}}">
+<link rel="canonical" href="{{.URL}}">
+<title>Redirecting</title>
+</head>
+<body>
+<p>Redirecting to <a href="{{.URL}}">{{.URL}}</a></p>
+</body>
+</html>
+`))
+
+const staticNotFoundPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Not Found</title>
+</head>
+<body>
+<p>Not Found</p>
+</body>
+</html>
+`
+
+// exportStats summarizes an export for its log
+type exportStats struct {
+	exported int
+	skipped  int
+}
+
+// redirectsEveryone reports whether a link redirects any client without a signature, from any ip and more than once,
+// which static pages and the sitemap require
+func redirectsEveryone(object URLObject, now time.Time) bool {
+	if !object.IsActive(now) || object.Paused || object.Quarantined || object.BurnAfterRead || object.SigningSecret != "" {
+		return false
+	}
+	if len(object.IPRules.Allow) != 0 || len(object.IPRules.Deny) != 0 {
+		return false
+	}
+	destination, err := url.Parse(object.URL)
+	return err == nil && (destination.Scheme == "http" || destination.Scheme == "https")
+}
+
+// staticPagePath is where the page of a link goes, at the path the service redirects it from,
+// ids that aren't safe path segments, which the api never creates, have no page
+func staticPagePath(dir string, shortID string) (string, bool) {
+	segments := []string{"shortie", shortID}
+	if isTeamAlias(shortID) {
+		team, alias, _ := strings.Cut(shortID, teamAliasSeparator)
+		segments = []string{"t", team, alias}
+	}
+	for _, segment := range segments[1:] {
+		if segment == "" || segment == "." || segment == ".." || strings.ContainsAny(segment, `/\`) {
+			return "", false
+		}
+	}
+	return filepath.Join(append(append([]string{dir}, segments...), "index.html")...), true
+}
+
+// exportStatic writes the page of every link that redirects everyone and a 404.html for the rest into dir
+func exportStatic(ctx context.Context, storage urlStorage, dir string, now time.Time) (exportStats, error) {
+	var stats exportStats
+	shortIDs, err := storage.ListShortIDs(ctx)
+	if err != nil {
+		return stats, err
+	}
+	for _, shortID := range shortIDs {
+		object, err := storage.GetURL(ctx, shortID)
+		if err != nil {
+			return stats, err
+		}
+		if object == nil {
+			continue
+		}
+		path, ok := staticPagePath(dir, shortID)
+		if !ok || !redirectsEveryone(*object, now) {
+			stats.skipped++
+			continue
+		}
+		var page bytes.Buffer
+		err = staticRedirectPage.Execute(&page, map[string]any{"URL": object.URL})
+		if err != nil {
+			return stats, err
+		}
+		err = os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			return stats, err
+		}
+		err = os.WriteFile(path, page.Bytes(), 0o644)
+		if err != nil {
+			return stats, err
+		}
+		stats.exported++
+	}
+	err = os.WriteFile(filepath.Join(dir, "404.html"), []byte(staticNotFoundPage), 0o644)
+	if err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// runExportStatic is the export-static command, which writes a static mirror of the links into an empty directory,
+// e.g. shortie export-static ./mirror && aws s3 sync --delete ./mirror s3://links-mirror
+func runExportStatic(ctx context.Context, env Environment, args []string) error {
+	flags := flag.NewFlagSet("export-static", flag.ContinueOnError)
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("export-static needs the directory to write the pages to")
+	}
+	dir := flags.Arg(0)
+	if env.AWSCustomDynamoEndpoint == "" {
+		return errors.New("export-static requires the dynamodb backend, set AWS_CUSTOM_DYNAMO_ENDPOINT")
+	}
+	// pages of deleted links left over from an earlier export would keep redirecting
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(entries) != 0 {
+		return fmt.Errorf("%s isn't empty, export into a new directory", dir)
+	}
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	storage, err := InitDynamoStorage(env)
+	if err != nil {
+		return err
+	}
+	stats, err := exportStatic(ctx, storage, dir, time.Now())
+	if err != nil {
+		return err
+	}
+	log.Printf("exported %d links to %s, skipped %d links a static page can't serve", stats.exported, dir, stats.skipped)
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportStatic(t *testing.T) {
+	now := time.Now()
+	storage := newContractLocalStorage(t)
+	for _, object := range []URLObject{
+		{ShortID: "abc", URL: "https://example.com/a?b=1&c=\"2\""},
+		{ShortID: teamAliasID("docs", "handbook"), URL: "https://docs.example.com/handbook"},
+		{ShortID: "expired", URL: "https://example.com/expired", Expiration: now.Add(-time.Hour).Unix()},
+		{ShortID: "later", URL: "https://example.com/later", ActiveFrom: now.Add(time.Hour).Unix()},
+		{ShortID: "paused", URL: "https://example.com/paused", Paused: true},
+		{ShortID: "spam", URL: "https://example.com/spam", Quarantined: true},
+		{ShortID: "signed", URL: "https://example.com/signed", SigningSecret: "secret"},
+		{ShortID: "office", URL: "https://example.com/office", IPRules: IPRules{Allow: []string{"10.0.0.0/8"}}},
+		{ShortID: "once", URL: "https://example.com/once", BurnAfterRead: true},
+		{ShortID: "..", URL: "https://example.com/escape"},
+	} {
+		require.NoError(t, storage.ImportURL(context.Background(), object))
+	}
+
+	dir := t.TempDir()
+	stats, err := exportStatic(context.Background(), storage, dir, now)
+	require.NoError(t, err)
+	assert.Equal(t, exportStats{exported: 2, skipped: 8}, stats)
+
+	page, err := os.ReadFile(filepath.Join(dir, "shortie", "abc", "index.html"))
+	require.NoError(t, err)
+	assert.Contains(t, string(page), `<meta http-equiv="refresh" content="0; url=https://example.com/a?b=1&amp;c=&#34;2&#34;">`)
+	assert.Contains(t, string(page), `<meta name="robots" content="noindex">`)
+
+	page, err = os.ReadFile(filepath.Join(dir, "t", "docs", "handbook", "index.html"))
+	require.NoError(t, err)
+	assert.Contains(t, string(page), `<link rel="canonical" href="https://docs.example.com/handbook">`)
+
+	assert.NoFileExists(t, filepath.Join(dir, "shortie", "paused", "index.html"))
+	assert.NoFileExists(t, filepath.Join(dir, "index.html"), "an id that would escape shortie/")
+	assert.FileExists(t, filepath.Join(dir, "404.html"))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the routes below are what a browser extension needs: checking the key it was given, shortening the page it is on
+// without piling up a new link every time, and listing what its user shortened recently
+
+const (
+	defaultRecentLinks = 20
+	maxRecentLinks     = 100
+	// maxRecentLinksScan bounds the links one request for recent links reads, links have no owner index
+	maxRecentLinksScan = 500
+)
+
+// GetMe validates the caller's token and reports the limits new links are held to, so clients can check them up front
+func (api shortieAPI) GetMe(c *gin.Context) {
+	limits := map[string]any{
+		"maxTTL":          int64(api.maxTTL.Seconds()),
+		"maxURLLength":    api.maxURLLength,
+		"maxBodyBytes":    api.maxBodyBytes,
+		"captchaRequired": api.captcha != nil,
+	}
+	caller := callerOf(c)
+	if caller == nil && api.anonymous != nil {
+		limits["anonymousTTL"] = int64(api.anonymous.ttl.Seconds())
+	}
+	if caller == nil {
+		// roles aren't enforced, everyone can do everything
+		c.JSON(http.StatusOK, map[string]any{"authenticated": false, "limits": limits})
+		return
+	}
+	response := map[string]any{
+		"authenticated": true,
+		"name":          caller.name,
+		"role":          caller.role.String(),
+		"limits":        limits,
+	}
+	if caller.scopes != nil {
+		response["scopes"] = caller.scopes
+	}
+	if caller.campaign != "" {
+		response["campaign"] = caller.campaign
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+type recentLink struct {
+	ShortID  string `json:"shortID"`
+	ShortURL string `json:"shortUrl"`
+	URL      string `json:"url"`
+	Created  int64  `json:"created,omitempty"`
+	Paused   bool   `json:"paused,omitempty"`
+	Title    string `json:"title,omitempty"`
+}
+
+// GetMyLinks lists the links the caller created. Links have no owner index, so each request reads at most
+// maxRecentLinksScan links in storage order and the cursor query parameter continues from the nextCursor it returns,
+// the links of one response come newest first.
+func (api shortieAPI) GetMyLinks(c *gin.Context) {
+	caller := callerOf(c)
+	if caller == nil {
+		respondError(c, http.StatusBadRequest, codeFeatureDisabled, "recent links require api keys, jwts or access tokens to tell callers apart")
+		return
+	}
+	limit := defaultRecentLinks
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxRecentLinks {
+			respondError(c, http.StatusBadRequest, codeInvalidParameter, "limit must be between 1 and "+strconv.Itoa(maxRecentLinks))
+			return
+		}
+		limit = parsed
+	}
+	after := ""
+	if cursor := c.Query("cursor"); cursor != "" {
+		var err error
+		after, err = api.cursors.Decode(cursor)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, codeInvalidParameter, err.Error())
+			return
+		}
+	}
+
+	now := time.Now()
+	links := []recentLink{}
+	for scanned := 0; scanned < maxRecentLinksScan && len(links) < limit; {
+		shortIDs, next, err := api.storage.ListPage(c, after, maxRecentLinksScan-scanned)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		scanned += len(shortIDs)
+		for i, shortID := range shortIDs {
+			object, err := api.storage.GetURL(c, shortID)
+			if err != nil {
+				respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+				return
+			}
+			if object != nil && object.Owner == caller.name && (object.Expiration == 0 || now.Unix() < object.Expiration) {
+				links = append(links, recentLink{
+					ShortID:  shortID,
+					ShortURL: "http://localhost:8421/shortie/" + shortID,
+					URL:      object.URL,
+					Created:  object.Created,
+					Paused:   object.Paused,
+					Title:    object.Title,
+				})
+			}
+			// the rest of the page is left to the next request
+			if len(links) == limit && i < len(shortIDs)-1 {
+				next = shortID
+				break
+			}
+		}
+		after = next
+		if after == "" {
+			break
+		}
+	}
+	// links from before creation times were recorded come last
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].Created != links[j].Created {
+			return links[i].Created > links[j].Created
+		}
+		return links[i].ShortID < links[j].ShortID
+	})
+	response := map[string]any{"links": links}
+	if after != "" {
+		response["nextCursor"] = api.cursors.Encode(after)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// findDuplicate returns a link to the url that creating it again can hand back instead, nil if there is none.
+// Only plain, working links with the requested settings are reused, and with sameOwner only those the owner created.
+func (api shortieAPI) findDuplicate(c *gin.Context, requested URLObject, sameOwner bool) (*URLObject, error) {
+	matches, err := api.storage.FindByURL(c, requested.URL)
+	if err != nil {
+		return nil, err
+	}
+	// the same link is reused every time when several point at the url
+	shortIDs := make([]string, 0, len(matches))
+	for shortID := range matches {
+		shortIDs = append(shortIDs, shortID)
+	}
+	sort.Strings(shortIDs)
+
+	now := time.Now().Unix()
+	for _, shortID := range shortIDs {
+		object, err := api.storage.GetURL(c, shortID)
+		if err != nil {
+			return nil, err
+		}
+		if object == nil || isTeamAlias(shortID) || (sameOwner && object.Owner != requested.Owner) || !canReuse(*object, requested) {
+			continue
+		}
+		if object.Paused || object.Quarantined || object.BurnAfterRead || object.SigningSecret != "" ||
+			object.ActiveFrom > now || (object.Expiration != 0 && now >= object.Expiration) {
+			continue
+		}
+		return object, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// FaultyStorage injects latency and errors into the calls to another storage, to rehearse how retries,
+// caching and timeouts hold up when the backend misbehaves. It is meant for test environments only.
+type FaultyStorage struct {
+	urlStorage
+
+	// latency is added to latencyRate of the calls, errorRate of the calls fail with errInjectedFault
+	latency     time.Duration
+	latencyRate float64
+	errorRate   float64
+}
+
+var errInjectedFault = errors.New("injected storage fault")
+
+func NewFaultyStorage(storage urlStorage, latency time.Duration, latencyRate float64, errorRate float64) *FaultyStorage {
+	return &FaultyStorage{
+		urlStorage:  storage,
+		latency:     latency,
+		latencyRate: latencyRate,
+		errorRate:   errorRate,
+	}
+}
+
+func initFaultyStorage(env Environment, storage urlStorage) (*FaultyStorage, error) {
+	var latency time.Duration
+	var err error
+	if env.FaultLatency != "" {
+		latency, err = time.ParseDuration(env.FaultLatency)
+		if err != nil || latency < 0 {
+			return nil, fmt.Errorf("invalid SHORTIE_FAULT_LATENCY %q", env.FaultLatency)
+		}
+	}
+	latencyRate, err := parseRate("SHORTIE_FAULT_LATENCY_RATE", env.FaultLatencyRate)
+	if err != nil {
+		return nil, err
+	}
+	errorRate := 0.0
+	if env.FaultErrorRate != "" {
+		errorRate, err = parseRate("SHORTIE_FAULT_ERROR_RATE", env.FaultErrorRate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewFaultyStorage(storage, latency, latencyRate, errorRate), nil
+}
+
+func parseRate(name string, value string) (float64, error) {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("%s must be between 0 and 1", name)
+	}
+	return rate, nil
+}
+
+// inject delays and fails a call as configured, a delay is cut short when the call's context is done
+func (faulty *FaultyStorage) inject(ctx context.Context) error {
+	if faulty.latency > 0 && rand.Float64() < faulty.latencyRate {
+		timer := time.NewTimer(faulty.latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if rand.Float64() < faulty.errorRate {
+		return errInjectedFault
+	}
+	return nil
+}
+
+func (faulty *FaultyStorage) SaveURL(ctx context.Context, object URLObject) error {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return err
+	}
+	return faulty.urlStorage.SaveURL(ctx, object)
+}
+
+func (faulty *FaultyStorage) GetURL(ctx context.Context, shortID string) (*URLObject, error) {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return faulty.urlStorage.GetURL(ctx, shortID)
+}
+
+func (faulty *FaultyStorage) IncrementUsage(ctx context.Context, shortID string, rule string, clicks int64, weight int64) error {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return err
+	}
+	return faulty.urlStorage.IncrementUsage(ctx, shortID, rule, clicks, weight)
+}
+
+func (faulty *FaultyStorage) RollupUsage(ctx context.Context, shortID string, before time.Time) error {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return err
+	}
+	return faulty.urlStorage.RollupUsage(ctx, shortID, before)
+}
+
+func (faulty *FaultyStorage) SetPaused(ctx context.Context, shortID string, paused bool) error {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return err
+	}
+	return faulty.urlStorage.SetPaused(ctx, shortID, paused)
+}
+
+func (faulty *FaultyStorage) SetQuarantined(ctx context.Context, shortID string, quarantined bool) error {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return err
+	}
+	return faulty.urlStorage.SetQuarantined(ctx, shortID, quarantined)
+}
+
+func (faulty *FaultyStorage) SetLocked(ctx context.Context, shortID string, locked bool) error {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return err
+	}
+	return faulty.urlStorage.SetLocked(ctx, shortID, locked)
+}
+
+func (faulty *FaultyStorage) SetContentHash(ctx context.Context, shortID string, hash string) error {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return err
+	}
+	return faulty.urlStorage.SetContentHash(ctx, shortID, hash)
+}
+
+func (faulty *FaultyStorage) SetTitle(ctx context.Context, shortID string, title string) error {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return err
+	}
+	return faulty.urlStorage.SetTitle(ctx, shortID, title)
+}
+
+func (faulty *FaultyStorage) SetExpiration(ctx context.Context, shortID string, expiration int64) error {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return err
+	}
+	return faulty.urlStorage.SetExpiration(ctx, shortID, expiration)
+}
+
+func (faulty *FaultyStorage) DeleteURL(ctx context.Context, shortID string) error {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return err
+	}
+	return faulty.urlStorage.DeleteURL(ctx, shortID)
+}
+
+func (faulty *FaultyStorage) ConsumeURL(ctx context.Context, shortID string) (bool, error) {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return false, err
+	}
+	return faulty.urlStorage.ConsumeURL(ctx, shortID)
+}
+
+func (faulty *FaultyStorage) GetStatistics(ctx context.Context, shortID string) (Statistics, error) {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return Statistics{}, err
+	}
+	return faulty.urlStorage.GetStatistics(ctx, shortID)
+}
+
+func (faulty *FaultyStorage) GetStatisticsBatch(ctx context.Context, shortIDs []string) (map[string]Statistics, error) {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return faulty.urlStorage.GetStatisticsBatch(ctx, shortIDs)
+}
+
+func (faulty *FaultyStorage) FindByURL(ctx context.Context, url string) (map[string]string, error) {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return faulty.urlStorage.FindByURL(ctx, url)
+}
+
+func (faulty *FaultyStorage) FindByCampaign(ctx context.Context, campaign string) ([]string, error) {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return faulty.urlStorage.FindByCampaign(ctx, campaign)
+}
+
+func (faulty *FaultyStorage) HealthCheck(ctx context.Context) (HealthStatus, error) {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return HealthStatus{}, err
+	}
+	return faulty.urlStorage.HealthCheck(ctx)
+}
+
+func (faulty *FaultyStorage) ListShortIDs(ctx context.Context) ([]string, error) {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return faulty.urlStorage.ListShortIDs(ctx)
+}
+
+func (faulty *FaultyStorage) ListPage(ctx context.Context, after string, limit int) ([]string, string, error) {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return faulty.urlStorage.ListPage(ctx, after, limit)
+}
+
+func (faulty *FaultyStorage) ImportURL(ctx context.Context, object URLObject) error {
+	err := faulty.inject(ctx)
+	if err != nil {
+		return err
+	}
+	return faulty.urlStorage.ImportURL(ctx, object)
+}
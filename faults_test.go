@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultyStorageContract(t *testing.T) {
+	// without faults the wrapper is transparent
+	testStorageContract(t, func(t *testing.T) urlStorage {
+		return NewFaultyStorage(newContractLocalStorage(t), 0, 0, 0)
+	})
+}
+
+func TestFaultyStorage(t *testing.T) {
+	ctx := context.Background()
+	failing := NewFaultyStorage(newContractLocalStorage(t), 0, 0, 1)
+	assert.True(t, errors.Is(failing.SaveURL(ctx, URLObject{ShortID: "abc", URL: "https://example.com"}), errInjectedFault))
+	_, err := failing.GetURL(ctx, "abc")
+	assert.True(t, errors.Is(err, errInjectedFault))
+
+	slow := NewFaultyStorage(newContractLocalStorage(t), 20*time.Millisecond, 1, 0)
+	start := time.Now()
+	_, err = slow.GetURL(ctx, "abc")
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	// the delay gives up with the caller
+	slow = NewFaultyStorage(newContractLocalStorage(t), time.Hour, 1, 0)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = slow.GetURL(canceled, "abc")
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestInitFaultyStorage(t *testing.T) {
+	storage := newContractLocalStorage(t)
+	faulty, err := initFaultyStorage(Environment{FaultLatency: "200ms", FaultLatencyRate: "0.5"}, storage)
+	require.NoError(t, err)
+	assert.Equal(t, 200*time.Millisecond, faulty.latency)
+	assert.Equal(t, 0.5, faulty.latencyRate)
+	assert.Equal(t, 0.0, faulty.errorRate)
+
+	for _, env := range []Environment{
+		{FaultLatency: "slow", FaultLatencyRate: "1"},
+		{FaultLatency: "200ms", FaultLatencyRate: "2"},
+		{FaultErrorRate: "-0.1", FaultLatencyRate: "1"},
+	} {
+		_, err = initFaultyStorage(env, storage)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the reasons a click is suspect
+const (
+	// fraudSpike clicks come from a network that clicked the link more than the threshold within the window
+	fraudSpike = "spike"
+	// fraudGeography clicks come from a network already seen in another country within the window
+	fraudGeography = "geography"
+)
+
+// fraudDetector flags links whose clicks look automated. Clients are grouped by network, the /24 of IPv4 and the
+// /48 of IPv6 addresses, which stands in for the address range or ASN of a click farm. Counts are kept per replica
+// like the scanner detector's, so a spike spread over replicas needs more clicks to be caught.
+type fraudDetector struct {
+	// threshold clicks on a link from one network within window make the rest of them suspect
+	threshold int
+	window    time.Duration
+	// countryHeader is the header the CDN puts the client's country in, empty to skip the geography check
+	countryHeader string
+	// exclude leaves suspect clicks out of the statistics, they are only flagged otherwise
+	exclude bool
+
+	lock      sync.Mutex
+	clicks    map[string]*missCount
+	countries map[string]networkCountry
+	flagged   map[string]*fraudFlag
+	lastPrune time.Time
+}
+
+// networkCountry is the country a network was last seen in
+type networkCountry struct {
+	country string
+	seen    time.Time
+}
+
+// fraudFlag is a link with suspect clicks, listed for review until an admin dismisses it
+type fraudFlag struct {
+	ShortID string `json:"shortID"`
+	// Reasons counts the suspect clicks by reason
+	Reasons map[string]int64 `json:"reasons"`
+	// Networks are the networks the suspect clicks came from, at most maxFlagNetworks of them
+	Networks      []string  `json:"networks"`
+	SuspectClicks int64     `json:"suspectClicks"`
+	FlaggedAt     time.Time `json:"flaggedAt"`
+	LastSuspect   time.Time `json:"lastSuspect"`
+}
+
+// maxFlagNetworks bounds the networks listed per flag, a distributed attack shows up by its count of clicks anyway
+const maxFlagNetworks = 20
+
+func newFraudDetector(threshold int, window time.Duration, countryHeader string, exclude bool) *fraudDetector {
+	return &fraudDetector{
+		threshold:     threshold,
+		window:        window,
+		countryHeader: countryHeader,
+		exclude:       exclude,
+		clicks:        map[string]*missCount{},
+		countries:     map[string]networkCountry{},
+		flagged:       map[string]*fraudFlag{},
+	}
+}
+
+func initFraudDetector(env Environment) (*fraudDetector, error) {
+	threshold, err := strconv.Atoi(env.FraudThreshold)
+	if err != nil || threshold <= 0 {
+		return nil, errors.New("SHORTIE_FRAUD_THRESHOLD must be a positive integer")
+	}
+	window, err := time.ParseDuration(env.FraudWindow)
+	if err != nil || window <= 0 {
+		return nil, fmt.Errorf("invalid SHORTIE_FRAUD_WINDOW %q", env.FraudWindow)
+	}
+	exclude, err := strconv.ParseBool(env.FraudExclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHORTIE_FRAUD_EXCLUDE: %w", err)
+	}
+	return newFraudDetector(threshold, window, env.FraudCountryHeader, exclude), nil
+}
+
+// Check records a click of shortID from ip in country, an empty country if it isn't known, returning the reason it
+// is suspect or "" if it isn't. Links with suspect clicks are flagged.
+func (detector *fraudDetector) Check(shortID string, ip string, country string, now time.Time) string {
+	network := anonymizeIP(ip)
+	if network == "" {
+		return ""
+	}
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+
+	detector.prune(now)
+	reason := ""
+	key := shortID + " " + network
+	clicks, found := detector.clicks[key]
+	if !found || now.Sub(clicks.windowStart) >= detector.window {
+		clicks = &missCount{windowStart: now}
+		detector.clicks[key] = clicks
+	}
+	clicks.count++
+	if clicks.count > detector.threshold {
+		reason = fraudSpike
+	}
+	// a network is in one place, the same one clicking from two countries means spoofed or tunneled clients
+	if country != "" {
+		last, found := detector.countries[network]
+		if found && last.country != country && now.Sub(last.seen) < detector.window {
+			reason = fraudGeography
+		}
+		detector.countries[network] = networkCountry{country: country, seen: now}
+	}
+	if reason != "" {
+		detector.flag(shortID, network, reason, now)
+	}
+	return reason
+}
+
+// flag records a suspect click of a link, the caller must hold the lock
+func (detector *fraudDetector) flag(shortID string, network string, reason string, now time.Time) {
+	flag, found := detector.flagged[shortID]
+	if !found {
+		flag = &fraudFlag{ShortID: shortID, Reasons: map[string]int64{}, FlaggedAt: now}
+		detector.flagged[shortID] = flag
+		log.Printf("flagging %s for suspect clicks from %s\n", shortID, network)
+	}
+	flag.Reasons[reason]++
+	flag.SuspectClicks++
+	flag.LastSuspect = now
+	for _, listed := range flag.Networks {
+		if listed == network {
+			return
+		}
+	}
+	if len(flag.Networks) < maxFlagNetworks {
+		flag.Networks = append(flag.Networks, network)
+	}
+}
+
+// List copies the flagged links, the links with the most suspect clicks first
+func (detector *fraudDetector) List() []fraudFlag {
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+
+	list := make([]fraudFlag, 0, len(detector.flagged))
+	for _, flag := range detector.flagged {
+		copied := *flag
+		copied.Reasons = make(map[string]int64, len(flag.Reasons))
+		for reason, count := range flag.Reasons {
+			copied.Reasons[reason] = count
+		}
+		copied.Networks = append([]string(nil), flag.Networks...)
+		list = append(list, copied)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].SuspectClicks != list[j].SuspectClicks {
+			return list[i].SuspectClicks > list[j].SuspectClicks
+		}
+		return list[i].ShortID < list[j].ShortID
+	})
+	return list
+}
+
+// Dismiss drops the flag of a link after review along with its counts, so only new suspect clicks flag it again
+func (detector *fraudDetector) Dismiss(shortID string) bool {
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+
+	_, found := detector.flagged[shortID]
+	delete(detector.flagged, shortID)
+	for key := range detector.clicks {
+		if strings.HasPrefix(key, shortID+" ") {
+			delete(detector.clicks, key)
+		}
+	}
+	return found
+}
+
+// prune drops the counts and countries of past windows at most once per window, the caller must hold the lock
+func (detector *fraudDetector) prune(now time.Time) {
+	if now.Sub(detector.lastPrune) < detector.window {
+		return
+	}
+	detector.lastPrune = now
+	for key, clicks := range detector.clicks {
+		if now.Sub(clicks.windowStart) >= detector.window {
+			delete(detector.clicks, key)
+		}
+	}
+	for network, last := range detector.countries {
+		if now.Sub(last.seen) >= detector.window {
+			delete(detector.countries, network)
+		}
+	}
+}
+
+// countClick checks a click for fraud, reporting whether it still counts in the statistics
+func (api shortieAPI) countClick(c *gin.Context, shortID string, now time.Time) bool {
+	if api.fraud == nil {
+		return true
+	}
+	country := ""
+	if api.fraud.countryHeader != "" {
+		country = strings.ToUpper(c.GetHeader(api.fraud.countryHeader))
+	}
+	reason := api.fraud.Check(shortID, c.ClientIP(), country, now)
+	if reason == "" {
+		return true
+	}
+	if api.metrics != nil {
+		api.metrics.Count(metricSuspectClicks, 1, map[string]string{"reason": reason})
+	}
+	return !api.fraud.exclude
+}
+
+// GetFraud lists the links flagged for suspect clicks
+func (api shortieAPI) GetFraud(c *gin.Context) {
+	if api.fraud == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "fraud detection is not enabled")
+		return
+	}
+	c.JSON(http.StatusOK, api.fraud.List())
+}
+
+// DismissFraud clears the flag of a link that was reviewed
+func (api shortieAPI) DismissFraud(c *gin.Context) {
+	if api.fraud == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "fraud detection is not enabled")
+		return
+	}
+	if !api.fraud.Dismiss(c.Param("id")) {
+		respondNotFound(c)
+		return
+	}
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFraudDetector(t *testing.T) {
+	now := time.Now()
+	detector := newFraudDetector(2, time.Minute, "CF-IPCountry", false)
+
+	// clicks from one network past the threshold are suspect, other networks and links are counted apart
+	assert.Empty(t, detector.Check("abc", "192.0.2.10", "", now))
+	assert.Empty(t, detector.Check("abc", "192.0.2.11", "", now))
+	assert.Equal(t, fraudSpike, detector.Check("abc", "192.0.2.12", "", now))
+	assert.Empty(t, detector.Check("abc", "198.51.100.1", "", now))
+	assert.Empty(t, detector.Check("def", "192.0.2.10", "", now))
+	// a new window starts over
+	assert.Empty(t, detector.Check("abc", "192.0.2.10", "", now.Add(time.Minute)))
+
+	// the same network clicking from two countries at once
+	assert.Empty(t, detector.Check("geo", "203.0.113.5", "US", now))
+	assert.Equal(t, fraudGeography, detector.Check("geo", "203.0.113.6", "JP", now))
+	assert.Empty(t, detector.Check("geo", "203.0.113.6", "US", now.Add(2*time.Minute)))
+	assert.Empty(t, detector.Check("geo", "not an ip", "FR", now))
+
+	flags := detector.List()
+	require.Len(t, flags, 2)
+	assert.Equal(t, "abc", flags[0].ShortID)
+	assert.Equal(t, map[string]int64{fraudSpike: 1}, flags[0].Reasons)
+	assert.Equal(t, []string{"192.0.2.0"}, flags[0].Networks)
+	assert.Equal(t, map[string]int64{fraudGeography: 1}, flags[1].Reasons)
+
+	assert.True(t, detector.Dismiss("abc"))
+	assert.False(t, detector.Dismiss("abc"))
+	assert.Len(t, detector.List(), 1)
+}
+
+func TestExcludeSuspectClicks(t *testing.T) {
+	ctx := context.Background()
+	storage := newContractLocalStorage(t)
+	require.NoError(t, storage.ImportURL(ctx, URLObject{ShortID: "abc", URL: "https://example.com"}))
+	api := shortieAPI{storage: storage, adminToken: "admin", fraud: newFraudDetector(2, time.Minute, "", true)}
+
+	// suspect clicks still redirect, they just aren't counted
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusTemporaryRedirect, serveAs(api, "", http.MethodGet, "/shortie/abc", "").Code)
+	}
+	statistics, err := storage.GetStatistics(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), usageSince(statistics.Usage, time.Time{}))
+
+	w := serveAs(api, "admin", http.MethodGet, "/admin/fraud", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"suspectClicks":3`)
+	assert.Equal(t, http.StatusOK, serveAs(api, "admin", http.MethodDelete, "/admin/fraud/abc", "").Code)
+	assert.Equal(t, http.StatusNotFound, serveAs(api, "admin", http.MethodDelete, "/admin/fraud/abc", "").Code)
+
+	api.fraud = nil
+	assert.Equal(t, http.StatusNotFound, serveAs(api, "admin", http.MethodGet, "/admin/fraud", "").Code)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The problems fsck finds in the table. Missing usage maps and stale url hashes are repaired in place,
+// the rest can only be deleted.
+const (
+	// problemUndecodable is an item that doesn't decode into a link
+	problemUndecodable = "undecodable"
+	// problemMissingUsage is a link without its usage or rule usage map, which the usage increments fail on
+	problemMissingUsage = "missing-usage"
+	// problemStaleHash is a url hash that doesn't match the url, which hides the link from duplicate lookups
+	problemStaleHash = "stale-url-hash"
+	// problemInvalidURL is a destination that can't be redirected to
+	problemInvalidURL = "invalid-url"
+	// problemExpired is an expired link past its archive grace the cleanup missed
+	problemExpired = "expired"
+	// problemOrphanUsage is a regional usage item of a link that no longer exists
+	problemOrphanUsage = "orphan-usage"
+)
+
+var repairableProblems = map[string]bool{problemMissingUsage: true, problemStaleHash: true}
+
+// usageMaps are the map attributes of a link the usage increments write into
+var usageMaps = []string{"usage", "ruleUsage"}
+
+// fsckTable is the table fsck walks and fixes, the dynamodb backend
+type fsckTable interface {
+	scanItems(ctx context.Context, visit func(item map[string]types.AttributeValue) error) error
+	initMap(ctx context.Context, shortID string, attribute string) error
+	setAttribute(ctx context.Context, shortID string, attribute string, value types.AttributeValue, unlessLocked bool) error
+	DeleteURL(ctx context.Context, shortID string) error
+}
+
+// fsckStats summarizes a check for its log
+type fsckStats struct {
+	scanned  int
+	problems map[string]int
+	repaired int
+	deleted  int
+	// skipped are the problems left as they are, because their fix wasn't asked for or the link is locked
+	skipped int
+}
+
+// missingMaps lists the usage maps of an item that are missing or aren't maps
+func missingMaps(item map[string]types.AttributeValue) []string {
+	var missing []string
+	for _, attribute := range usageMaps {
+		if _, isMap := item[attribute].(*types.AttributeValueMemberM); !isMap {
+			missing = append(missing, attribute)
+		}
+	}
+	return missing
+}
+
+// checkItem lists the problems of a link item
+func checkItem(item map[string]types.AttributeValue, now time.Time, grace time.Duration) []string {
+	var object URLObject
+	err := attributevalue.UnmarshalMap(item, &object)
+	if err != nil {
+		return []string{problemUndecodable}
+	}
+	var problems []string
+	if len(missingMaps(item)) != 0 {
+		problems = append(problems, problemMissingUsage)
+	}
+	if object.URLHash != hashURL(object.URL) {
+		problems = append(problems, problemStaleHash)
+	}
+	destination, err := url.Parse(object.URL)
+	if err != nil || destination.Scheme == "" {
+		problems = append(problems, problemInvalidURL)
+	}
+	// locked links stay until an admin unlocks them, as they do in the cleanup
+	if object.Expiration != 0 && now.Unix() >= object.Expiration && !object.IsArchived(now, grace) && !object.Locked {
+		problems = append(problems, problemExpired)
+	}
+	return problems
+}
+
+// fsck checks every item of the table, repairing what can be repaired with repair and deleting what can't with remove.
+// It is meant for a quiet table, e.g. before a new deployment takes traffic, a link changed during the scan is judged by its old item.
+func fsck(ctx context.Context, table fsckTable, now time.Time, grace time.Duration, repair bool, remove bool) (fsckStats, error) {
+	stats := fsckStats{problems: map[string]int{}}
+	links := map[string]bool{}
+	var usageKeys []string
+	err := table.scanItems(ctx, func(item map[string]types.AttributeValue) error {
+		key := stringAttribute(item, attributeShortID)
+		if isRegionalUsageKey(key) {
+			usageKeys = append(usageKeys, key)
+			return nil
+		}
+		stats.scanned++
+		links[key] = true
+		problems := checkItem(item, now, grace)
+		for _, problem := range problems {
+			stats.problems[problem]++
+			log.Printf("%s: %s", key, problem)
+		}
+		fixable := len(problems) != 0
+		for _, problem := range problems {
+			fixable = fixable && repairableProblems[problem]
+		}
+		switch {
+		case len(problems) == 0:
+		case fixable && repair:
+			err := repairItem(ctx, table, key, item)
+			if err != nil {
+				return fmt.Errorf("failed to repair %s: %w", key, err)
+			}
+			stats.repaired++
+		case !fixable && remove:
+			deleted, err := deleteItem(ctx, table, key)
+			if err != nil {
+				return err
+			}
+			if deleted {
+				stats.deleted++
+				links[key] = false
+			} else {
+				stats.skipped++
+			}
+		default:
+			stats.skipped++
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	// usage items are left behind when their link is deleted, and a redirect racing the delete can recreate them
+	sort.Strings(usageKeys)
+	for _, key := range usageKeys {
+		shortID, _, _ := strings.Cut(key, regionalUsageSeparator)
+		if links[shortID] {
+			continue
+		}
+		stats.problems[problemOrphanUsage]++
+		log.Printf("%s: %s", key, problemOrphanUsage)
+		if !remove {
+			stats.skipped++
+			continue
+		}
+		deleted, err := deleteItem(ctx, table, key)
+		if err != nil {
+			return stats, err
+		}
+		if deleted {
+			stats.deleted++
+		} else {
+			stats.skipped++
+		}
+	}
+	return stats, nil
+}
+
+// repairItem gives a link its missing usage maps and the hash of its url
+func repairItem(ctx context.Context, table fsckTable, shortID string, item map[string]types.AttributeValue) error {
+	for _, attribute := range missingMaps(item) {
+		err := table.initMap(ctx, shortID, attribute)
+		if err != nil {
+			return err
+		}
+	}
+	destination := stringAttribute(item, "url")
+	if stringAttribute(item, attributeURLHash) != hashURL(destination) {
+		err := table.setAttribute(ctx, shortID, attributeURLHash, &types.AttributeValueMemberS{Value: hashURL(destination)}, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteItem deletes an item fsck can't repair, reporting false for the locked links it has to keep
+func deleteItem(ctx context.Context, table fsckTable, key string) (bool, error) {
+	err := table.DeleteURL(ctx, key)
+	if errors.Is(err, errLocked) {
+		log.Printf("%s: locked, kept", key)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// scanItems visits every item of the table, links and regional usage items alike
+func (storage *DynamoStorage) scanItems(ctx context.Context, visit func(item map[string]types.AttributeValue) error) error {
+	pages := dynamodb.NewScanPaginator(storage.dynamo, &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to scan the table: %w", err)
+		}
+		for _, item := range page.Items {
+			err = visit(item)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// initMap sets a map attribute of a link that is missing or null to an empty map, a map written meanwhile is kept
+func (storage *DynamoStorage) initMap(ctx context.Context, shortID string, attribute string) error {
+	_, err := storage.dynamo.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(tableName),
+		Key:                 shortIDKey(shortID),
+		UpdateExpression:    aws.String("SET #attribute = :empty"),
+		ConditionExpression: aws.String("attribute_exists(#shortID) AND (attribute_not_exists(#attribute) OR NOT attribute_type(#attribute, :map))"),
+		ExpressionAttributeNames: map[string]string{
+			"#shortID":   attributeShortID,
+			"#attribute": attribute,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":empty": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}},
+			":map":   &types.AttributeValueMemberS{Value: "M"},
+		},
+	})
+	if err != nil {
+		if isConditionFailed(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to update %s: %w", attribute, err)
+	}
+	return nil
+}
+
+// runFsck is the fsck command, which checks the table for links the service can't use and reports what it found,
+// e.g. shortie fsck -repair, and shortie fsck -repair -delete once the report was looked over
+func runFsck(ctx context.Context, env Environment, args []string) error {
+	flags := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "give links their missing usage maps and fix their url hashes")
+	remove := flags.Bool("delete", false, "delete the links that can't be repaired and the usage items of deleted links")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("unexpected arguments %q", flags.Args())
+	}
+	if env.AWSCustomDynamoEndpoint == "" {
+		return errors.New("fsck requires the dynamodb backend, set AWS_CUSTOM_DYNAMO_ENDPOINT")
+	}
+	var grace time.Duration
+	if env.ArchiveGrace != "" {
+		grace, err = time.ParseDuration(env.ArchiveGrace)
+		if err != nil || grace < 0 {
+			return fmt.Errorf("invalid SHORTIE_ARCHIVE_GRACE %q", env.ArchiveGrace)
+		}
+	}
+
+	storage, err := InitDynamoStorage(env)
+	if err != nil {
+		return err
+	}
+	stats, err := fsck(ctx, storage, time.Now(), grace, *repair, *remove)
+	if err != nil {
+		return err
+	}
+	problems := []string{}
+	for _, problem := range []string{problemUndecodable, problemMissingUsage, problemStaleHash, problemInvalidURL, problemExpired, problemOrphanUsage} {
+		if stats.problems[problem] != 0 {
+			problems = append(problems, fmt.Sprintf("%d %s", stats.problems[problem], problem))
+		}
+	}
+	if len(problems) == 0 {
+		log.Printf("checked %d links, found no problems", stats.scanned)
+		return nil
+	}
+	log.Printf("checked %d links, found %s; repaired %d, deleted %d and left %d as they are",
+		stats.scanned, strings.Join(problems, ", "), stats.repaired, stats.deleted, stats.skipped)
+	return nil
+}
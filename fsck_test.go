@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTable keeps raw items by key, like the dynamodb table fsck scans
+type fakeTable map[string]map[string]types.AttributeValue
+
+func (table fakeTable) scanItems(ctx context.Context, visit func(item map[string]types.AttributeValue) error) error {
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		err := visit(table[key])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (table fakeTable) initMap(ctx context.Context, shortID string, attribute string) error {
+	table[shortID][attribute] = &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}}
+	return nil
+}
+
+func (table fakeTable) setAttribute(ctx context.Context, shortID string, attribute string, value types.AttributeValue, unlessLocked bool) error {
+	table[shortID][attribute] = value
+	return nil
+}
+
+func (table fakeTable) DeleteURL(ctx context.Context, shortID string) error {
+	if locked, _ := table[shortID][attributeLocked].(*types.AttributeValueMemberBOOL); locked != nil && locked.Value {
+		return errLocked
+	}
+	delete(table, shortID)
+	return nil
+}
+
+// put adds a link as ImportURL writes it
+func (table fakeTable) put(t *testing.T, object URLObject) map[string]types.AttributeValue {
+	object.URLHash = hashURL(object.URL)
+	object.Usage = map[string]int64{}
+	object.RuleUsage = map[string]int64{}
+	item, err := attributevalue.MarshalMap(object)
+	require.NoError(t, err)
+	table[object.ShortID] = item
+	return item
+}
+
+func newFsckTable(t *testing.T, now time.Time) fakeTable {
+	table := fakeTable{}
+	table.put(t, URLObject{ShortID: "fine", URL: "https://example.com/fine"})
+	table.put(t, URLObject{ShortID: "archived", URL: "https://example.com/archived", Expiration: now.Add(-time.Hour).Unix()})
+	table.put(t, URLObject{ShortID: "expired", URL: "https://example.com/expired", Expiration: now.Add(-48 * time.Hour).Unix()})
+	table.put(t, URLObject{ShortID: "invalid", URL: "example.com/no-scheme"})
+	table.put(t, URLObject{ShortID: "locked", URL: "%zz", Locked: true})
+	delete(table.put(t, URLObject{ShortID: "unmapped", URL: "https://example.com/unmapped"}), "ruleUsage")
+	table.put(t, URLObject{ShortID: "moved", URL: "https://example.com/moved"})["url"] = &types.AttributeValueMemberS{Value: "https://example.com/moved-to"}
+	table["undecodable"] = map[string]types.AttributeValue{
+		attributeShortID: &types.AttributeValueMemberS{Value: "undecodable"},
+		"expiration":     &types.AttributeValueMemberS{Value: "tomorrow"},
+	}
+	table[regionalUsageKey("fine", "us-west-2")] = map[string]types.AttributeValue{attributeShortID: &types.AttributeValueMemberS{Value: regionalUsageKey("fine", "us-west-2")}}
+	table[regionalUsageKey("gone", "us-west-2")] = map[string]types.AttributeValue{attributeShortID: &types.AttributeValueMemberS{Value: regionalUsageKey("gone", "us-west-2")}}
+	return table
+}
+
+func TestCheckItem(t *testing.T) {
+	now := time.Now()
+	table := newFsckTable(t, now)
+	for shortID, expected := range map[string][]string{
+		"fine":        nil,
+		"archived":    nil,
+		"expired":     {problemExpired},
+		"invalid":     {problemInvalidURL},
+		"locked":      {problemInvalidURL},
+		"unmapped":    {problemMissingUsage},
+		"moved":       {problemStaleHash},
+		"undecodable": {problemUndecodable},
+	} {
+		assert.Equal(t, expected, checkItem(table[shortID], now, 24*time.Hour), shortID)
+	}
+	// without a grace, expired links are past it at once
+	assert.Equal(t, []string{problemExpired}, checkItem(table["archived"], now, 0))
+}
+
+func TestFsckReports(t *testing.T) {
+	now := time.Now()
+	table := newFsckTable(t, now)
+	stats, err := fsck(context.Background(), table, now, 24*time.Hour, false, false)
+	require.NoError(t, err)
+	assert.Equal(t, 8, stats.scanned)
+	assert.Equal(t, map[string]int{problemExpired: 1, problemInvalidURL: 2, problemMissingUsage: 1, problemStaleHash: 1, problemUndecodable: 1, problemOrphanUsage: 1}, stats.problems)
+	assert.Equal(t, 7, stats.skipped)
+	assert.Zero(t, stats.repaired)
+	assert.Zero(t, stats.deleted)
+	// nothing changes without -repair or -delete
+	assert.Equal(t, newFsckTable(t, now), table)
+}
+
+func TestFsckRepairs(t *testing.T) {
+	now := time.Now()
+	table := newFsckTable(t, now)
+	stats, err := fsck(context.Background(), table, now, 24*time.Hour, true, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.repaired)
+	assert.Equal(t, 5, stats.skipped)
+	assert.Empty(t, checkItem(table["unmapped"], now, 24*time.Hour))
+	assert.Empty(t, checkItem(table["moved"], now, 24*time.Hour))
+	assert.Contains(t, table, "expired")
+}
+
+func TestFsckDeletes(t *testing.T) {
+	now := time.Now()
+	table := newFsckTable(t, now)
+	table[regionalUsageKey("expired", "us-east-1")] = map[string]types.AttributeValue{attributeShortID: &types.AttributeValueMemberS{Value: regionalUsageKey("expired", "us-east-1")}}
+	stats, err := fsck(context.Background(), table, now, 24*time.Hour, true, true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.repaired)
+	// the usage item of a link deleted by fsck is an orphan too
+	assert.Equal(t, 5, stats.deleted)
+	assert.Equal(t, 1, stats.skipped)
+
+	keys := []string{}
+	for key := range table {
+		keys = append(keys, key)
+	}
+	assert.ElementsMatch(t, []string{"fine", "archived", "locked", "unmapped", "moved", regionalUsageKey("fine", "us-west-2")}, keys)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Global tables replicate whole items and resolve concurrent writes to the same item with last-writer-wins,
+// so usage increments made in two regions at once would lose one of them.
+// Instead each region counts usage in its own item, keyed by regionalUsageKey, which only that region writes to
+// and reads merge the regions back together.
+
+const regionalUsageSeparator = "#usage#"
+
+const ruleUsagePrefix = "rule:"
+
+func regionalUsageKey(shortID string, region string) string {
+	return shortID + regionalUsageSeparator + region
+}
+
+// isRegionalUsageKey reports whether a table key belongs to a regional usage item rather than a link
+func isRegionalUsageKey(key string) bool {
+	return strings.Contains(key, regionalUsageSeparator)
+}
+
+func (storage *DynamoStorage) isGlobal() bool {
+	return len(storage.replicaRegions) > 0
+}
+
+// regions lists this region followed by every replica region
+func (storage *DynamoStorage) regions() []string {
+	return append([]string{storage.region}, storage.replicaRegions...)
+}
+
+// incrementRegionalUsage counts usage in this region's usage item
+// the counters are top level attributes so ADD can create the item and attribute on first use
+func (storage *DynamoStorage) incrementRegionalUsage(ctx context.Context, shortID string, rule string, clicks int64, weight int64) error {
+	todayTimestamp := strconv.Itoa(int(UTCTimestampOfTodayRounded().Unix()))
+	updateExpression := "ADD #day :clicks"
+	names := map[string]string{
+		"#day": todayTimestamp,
+	}
+	values := map[string]types.AttributeValue{
+		":clicks": numberValue(clicks),
+	}
+	if weight > 0 && (storage.hourlyUsage || rule != "") {
+		values[":weight"] = numberValue(weight)
+	}
+	if weight > 0 && storage.hourlyUsage {
+		updateExpression += ", #hour :weight"
+		names["#hour"] = hourUsageKey(time.Now())
+	}
+	if weight > 0 && rule != "" {
+		updateExpression += ", #rule :weight"
+		names["#rule"] = ruleUsagePrefix + rule
+	}
+
+	_, err := storage.dynamo.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tableName),
+		Key:                       shortIDKey(regionalUsageKey(shortID, storage.region)),
+		UpdateExpression:          aws.String(updateExpression),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to increment regional usage: %w", err)
+	}
+	return nil
+}
+
+// rollupRegionalUsage drops the old hourly buckets from this region's usage item
+// the other regions' items are left to their own replicas, a write from here could clobber their concurrent increments
+func (storage *DynamoStorage) rollupRegionalUsage(ctx context.Context, shortID string, before time.Time) error {
+	key := regionalUsageKey(shortID, storage.region)
+	out, err := storage.dynamo.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key:       shortIDKey(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read regional usage: %w", err)
+	}
+	usage := map[string]int64{}
+	for name := range out.Item {
+		usage[name] = 0
+	}
+	stale := staleHourKeys(usage, before)
+	for start := 0; start < len(stale); start += maxUsageRemovals {
+		names := []string{}
+		attributeNames := map[string]string{}
+		for i, hour := range stale[start:min(start+maxUsageRemovals, len(stale))] {
+			name := "#hour" + strconv.Itoa(i)
+			names = append(names, name)
+			attributeNames[name] = hour
+		}
+		_, err = storage.dynamo.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                aws.String(tableName),
+			Key:                      shortIDKey(key),
+			UpdateExpression:         aws.String("REMOVE " + strings.Join(names, ", ")),
+			ExpressionAttributeNames: attributeNames,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to roll up regional usage: %w", err)
+		}
+	}
+	return nil
+}
+
+// mergeRegionalUsage adds every region's usage items into the statistics of the given links
+func (storage *DynamoStorage) mergeRegionalUsage(ctx context.Context, batch map[string]Statistics) error {
+	var keys []map[string]types.AttributeValue
+	for shortID := range batch {
+		for _, region := range storage.regions() {
+			keys = append(keys, shortIDKey(regionalUsageKey(shortID, region)))
+		}
+	}
+
+	for start := 0; start < len(keys); start += dynamoBatchGetLimit {
+		requestItems := map[string]types.KeysAndAttributes{
+			tableName: {Keys: keys[start:min(start+dynamoBatchGetLimit, len(keys))]},
+		}
+		for retry := 0; len(requestItems) > 0; retry++ {
+			if retry > 0 {
+				err := backOffUnprocessedKeys(ctx, retry)
+				if err != nil {
+					return fmt.Errorf("failed to read regional usage: %w", err)
+				}
+			}
+			out, err := storage.dynamo.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: requestItems,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to read regional usage: %w", err)
+			}
+			for _, item := range out.Responses[tableName] {
+				shortID, _, _ := strings.Cut(stringAttribute(item, attributeShortID), regionalUsageSeparator)
+				statistics, found := batch[shortID]
+				if !found {
+					continue
+				}
+				for name, value := range item {
+					number, isNumber := value.(*types.AttributeValueMemberN)
+					if name == attributeShortID || !isNumber {
+						continue
+					}
+					count, err := strconv.ParseInt(number.Value, 10, 64)
+					if err != nil {
+						continue
+					}
+					rule, isRule := strings.CutPrefix(name, ruleUsagePrefix)
+					if isRule {
+						statistics.RuleUsage[rule] += count
+					} else {
+						statistics.Usage[name] += count
+					}
+				}
+			}
+			requestItems = out.UnprocessedKeys
+		}
+	}
+	return nil
+}
+
+// ensureReplicas adds any configured replica region the global table doesn't have yet
+// this uses the 2019.11.21 version of global tables, which requires the table stream to be enabled first
+func (storage *DynamoStorage) ensureReplicas(ctx context.Context) error {
+	err := storage.waitForTable(ctx)
+	if err != nil {
+		return err
+	}
+	out, err := storage.dynamo.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe the table: %w", err)
+	}
+
+	existing := map[string]bool{}
+	for _, replica := range out.Table.Replicas {
+		existing[aws.ToString(replica.RegionName)] = true
+	}
+	for _, region := range storage.replicaRegions {
+		if existing[region] {
+			continue
+		}
+		// replicas have to be added one at a time, waiting for the table to be active in between
+		_, err = storage.dynamo.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+			TableName: aws.String(tableName),
+			ReplicaUpdates: []types.ReplicationGroupUpdate{
+				{Create: &types.CreateReplicationGroupMemberAction{RegionName: aws.String(region)}},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add a replica in %s: %w", region, err)
+		}
+		err = storage.waitForTable(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+const (
+	maxLinkHeaders     = 10
+	maxLinkHeaderValue = 1024
+)
+
+// allowedHeaders are the standard headers links can set, they only describe the redirect itself. Anything else could
+// change how browsers treat the short domain's whole origin, like Clear-Site-Data, NEL or Alt-Svc, so it isn't allowed.
+var allowedHeaders = map[string]bool{
+	"Referrer-Policy": true,
+	"Link":            true,
+}
+
+// customHeaderPrefix is the prefix of the custom headers links can set besides allowedHeaders
+const customHeaderPrefix = "X-"
+
+// reservedHeaders are custom headers the service sets or answers for itself
+var reservedHeaders = map[string]bool{
+	"X-Robots-Tag": true,
+	"X-Request-Id": true,
+}
+
+// reservedHeaderPrefixes cover the headers of the service
+var reservedHeaderPrefixes = []string{"X-Shortie-"}
+
+// referrerPolicies are the values of Referrer-Policy, no-referrer hides the short link and the page before it from the destination
+var referrerPolicies = map[string]bool{
+	"no-referrer":                     true,
+	"no-referrer-when-downgrade":      true,
+	"origin":                          true,
+	"origin-when-cross-origin":        true,
+	"same-origin":                     true,
+	"strict-origin":                   true,
+	"strict-origin-when-cross-origin": true,
+	"unsafe-url":                      true,
+}
+
+// validateReferrerPolicy checks the referrer policy of a link, which is either given with its own field or as a header
+func validateReferrerPolicy(policy string, headers map[string]string) error {
+	if policy == "" {
+		return nil
+	}
+	if !referrerPolicies[policy] {
+		return fmt.Errorf("invalid referrerPolicy %q", policy)
+	}
+	for name := range headers {
+		if http.CanonicalHeaderKey(name) == "Referrer-Policy" {
+			return errors.New("referrerPolicy can't be combined with a Referrer-Policy header")
+		}
+	}
+	return nil
+}
+
+// responseHeaders are the headers sent with the redirects of a link, leaving out those links saved before the
+// allowlist can no longer set
+func responseHeaders(object URLObject) map[string]string {
+	if len(object.Headers) == 0 && object.ReferrerPolicy == "" {
+		return nil
+	}
+	headers := make(map[string]string, len(object.Headers)+1)
+	for name, value := range object.Headers {
+		if linkCanSet(name) {
+			headers[name] = value
+		}
+	}
+	if object.ReferrerPolicy != "" {
+		headers["Referrer-Policy"] = object.ReferrerPolicy
+	}
+	return headers
+}
+
+// validateHeaders checks the response headers of a link, they must be valid allowed or custom http headers the service
+// doesn't set itself
+func validateHeaders(headers map[string]string) error {
+	if len(headers) > maxLinkHeaders {
+		return fmt.Errorf("links can have at most %d headers", maxLinkHeaders)
+	}
+	seen := map[string]bool{}
+	for name, value := range headers {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return fmt.Errorf("%q is not a valid header name", name)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) || len(value) > maxLinkHeaderValue {
+			return fmt.Errorf("the value of header %s must be at most %d characters without line breaks", name, maxLinkHeaderValue)
+		}
+		canonical := http.CanonicalHeaderKey(name)
+		if seen[canonical] {
+			return fmt.Errorf("header %s is given more than once", canonical)
+		}
+		seen[canonical] = true
+		if !linkCanSet(canonical) {
+			return fmt.Errorf("header %s can't be set, links can set Referrer-Policy, Link and custom X- headers the service doesn't set", canonical)
+		}
+	}
+	return nil
+}
+
+// linkCanSet reports whether links can set the header with the canonical name
+func linkCanSet(canonical string) bool {
+	if allowedHeaders[canonical] {
+		return true
+	}
+	if !strings.HasPrefix(canonical, customHeaderPrefix) || reservedHeaders[canonical] {
+		return false
+	}
+	for _, prefix := range reservedHeaderPrefixes {
+		if strings.HasPrefix(canonical, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// canonicalHeaders stores the headers of a link under their canonical names, so they are listed the way they are sent
+func canonicalHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	canonical := make(map[string]string, len(headers))
+	for name, value := range headers {
+		canonical[http.CanonicalHeaderKey(name)] = value
+	}
+	return canonical
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateHeaders(t *testing.T) {
+	assert.NoError(t, validateHeaders(nil))
+	assert.NoError(t, validateHeaders(map[string]string{"Referrer-Policy": "no-referrer", "x-campaign-source": "print", "link": "<https://example.com/style.css>; rel=preload"}))
+
+	for _, headers := range []map[string]string{
+		{"Bad Name": "value"},
+		{"X-Injected": "value\r\nSet-Cookie: session=1"},
+		{"X-Long": strings.Repeat("a", maxLinkHeaderValue+1)},
+		{"location": "https://evil.example.com"},
+		{"Set-Cookie": "session=1"},
+		{"X-Shortie-Owner": "someone else"},
+		{"x-robots-tag": "all"},
+		{"access-control-allow-origin": "*"},
+		{"Clear-Site-Data": `"*"`},
+		{"NEL": `{"report_to":"evil","max_age":31536000}`},
+		{"Report-To": `{"group":"evil"}`},
+		{"Reporting-Endpoints": `evil="https://evil.example.com"`},
+		{"Alt-Svc": `h3="evil.example.com:443"`},
+		{"Refresh": "0; url=https://evil.example.com"},
+		{"X-Tag": "a", "x-tag": "b"},
+	} {
+		assert.Error(t, validateHeaders(headers), headers)
+	}
+	tooMany := map[string]string{}
+	for _, name := range strings.Split("a b c d e f g h i j k", " ") {
+		tooMany["X-"+name] = name
+	}
+	assert.Error(t, validateHeaders(tooMany))
+}
+
+func TestLinkHeaders(t *testing.T) {
+	api := shortieAPI{storage: newContractLocalStorage(t)}
+
+	w := serveAs(api, "", http.MethodPost, "/shortie", `{"url": "https://example.com", "headers": {"referrer-policy": "no-referrer", "X-Source": "print"}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	shortIDs, err := api.storage.ListShortIDs(context.Background())
+	require.NoError(t, err)
+	require.Len(t, shortIDs, 1)
+	object, err := api.storage.GetURL(context.Background(), shortIDs[0])
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"Referrer-Policy": "no-referrer", "X-Source": "print"}, object.Headers)
+
+	w = serveAs(api, "", http.MethodGet, "/shortie/"+shortIDs[0], "")
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "no-referrer", w.Header().Get("Referrer-Policy"))
+	assert.Equal(t, "print", w.Header().Get("X-Source"))
+	assert.Equal(t, "https://example.com", w.Header().Get("Location"))
+
+	// headers saved before the allowlist aren't sent
+	require.NoError(t, api.storage.SaveURL(context.Background(), URLObject{ShortID: "old", URL: "https://example.com", Headers: map[string]string{"Clear-Site-Data": `"*"`, "X-Source": "print"}}))
+	w = serveAs(api, "", http.MethodGet, "/shortie/old", "")
+	assert.Empty(t, w.Header().Get("Clear-Site-Data"))
+	assert.Equal(t, "print", w.Header().Get("X-Source"))
+
+	w = serveAs(api, "", http.MethodPost, "/shortie", `{"url": "https://example.com/other", "headers": {"Location": "https://evil.example.com"}}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), codeInvalidParameter)
+}
+
+func TestReferrerPolicy(t *testing.T) {
+	assert.NoError(t, validateReferrerPolicy("", map[string]string{"Referrer-Policy": "origin"}))
+	assert.NoError(t, validateReferrerPolicy("no-referrer", nil))
+	assert.Error(t, validateReferrerPolicy("none", nil))
+	assert.Error(t, validateReferrerPolicy("no-referrer", map[string]string{"referrer-policy": "origin"}))
+
+	api := shortieAPI{storage: newContractLocalStorage(t)}
+	require.NoError(t, api.storage.SaveURL(context.Background(), URLObject{ShortID: "private", URL: "https://example.com", ReferrerPolicy: "no-referrer", Headers: map[string]string{"X-Source": "print"}}))
+	w := serveAs(api, "", http.MethodGet, "/shortie/private", "")
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "no-referrer", w.Header().Get("Referrer-Policy"))
+	assert.Equal(t, "print", w.Header().Get("X-Source"))
+
+	w = serveAs(api, "", http.MethodPost, "/shortie", `{"url": "https://example.com", "referrerPolicy": "nobody"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// policyDocument is an IAM policy, see https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_policies_elements.html
+type policyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []policyStatement `json:"Statement"`
+}
+
+type policyStatement struct {
+	Sid       string         `json:"Sid"`
+	Effect    string         `json:"Effect"`
+	Action    []string       `json:"Action"`
+	Resource  []string       `json:"Resource"`
+	Condition map[string]any `json:"Condition,omitempty"`
+}
+
+func allow(sid string, actions []string, resources ...string) policyStatement {
+	return policyStatement{Sid: sid, Effect: "Allow", Action: actions, Resource: resources}
+}
+
+// the actions of the links table, its indexes and the other tables, as called by the storage, lockers, token stores, change logs and the meter
+var (
+	linkActions          = []string{"dynamodb:BatchGetItem", "dynamodb:DeleteItem", "dynamodb:DescribeTable", "dynamodb:GetItem", "dynamodb:PutItem", "dynamodb:Query", "dynamodb:Scan", "dynamodb:UpdateItem"}
+	streamActions        = []string{"dynamodb:DescribeStream", "dynamodb:GetRecords", "dynamodb:GetShardIterator"}
+	lockActions          = []string{"dynamodb:DeleteItem", "dynamodb:PutItem"}
+	tokenActions         = []string{"dynamodb:GetItem", "dynamodb:PutItem", "dynamodb:Scan", "dynamodb:UpdateItem"}
+	changeActions        = []string{"dynamodb:PutItem", "dynamodb:Query"}
+	ownerUsageActions    = []string{"dynamodb:GetItem", "dynamodb:UpdateItem"}
+	tableCreateActions   = []string{"dynamodb:CreateTable"}
+	changesCreateActions = []string{"dynamodb:CreateTable", "dynamodb:DescribeTable", "dynamodb:UpdateTimeToLive"}
+	tableSettingsActions = []string{"dynamodb:CreateTable", "dynamodb:DescribeContinuousBackups", "dynamodb:DescribeTable", "dynamodb:TagResource", "dynamodb:UpdateContinuousBackups", "dynamodb:UpdateTable"}
+	autoscalingActions   = []string{"application-autoscaling:PutScalingPolicy", "application-autoscaling:RegisterScalableTarget"}
+)
+
+// tableARN names a table in a region, the account is left as a wildcard since the credentials pick it
+func tableARN(region string, table string) string {
+	if region == "" {
+		region = "*"
+	}
+	return fmt.Sprintf("arn:aws:dynamodb:%s:*:table/%s", region, table)
+}
+
+// serviceLinkedRole lets the credentials create the role an aws service acts with, once per account
+func serviceLinkedRole(sid string, service string) policyStatement {
+	statement := allow(sid, []string{"iam:CreateServiceLinkedRole"}, "*")
+	statement.Condition = map[string]any{"StringEquals": map[string]string{"iam:AWSServiceName": service}}
+	return statement
+}
+
+// usesLocks reports whether a background job or the stream consumer takes leases in the locks table
+func usesLocks(env Environment) bool {
+	if env.LockBackend != "" && env.LockBackend != "dynamodb" {
+		return false
+	}
+	return env.StreamSink != "" || env.CleanupInterval != "" || env.HourlyUsageDays != ""
+}
+
+// iamPolicy is the least privilege policy of the configured features, for the runtime or for -provision when provisioning is set.
+// The runtime also manages the tables unless SHORTIE_DYNAMO_INITIALIZE_TABLES is off.
+func iamPolicy(env Environment, provisioning bool) (policyDocument, error) {
+	if env.AWSCustomDynamoEndpoint == "" {
+		return policyDocument{}, errors.New("the in-memory backend needs no iam policy, set AWS_CUSTOM_DYNAMO_ENDPOINT")
+	}
+	capacity, err := parseTableCapacity(env)
+	if err != nil {
+		return policyDocument{}, err
+	}
+	initializeTables, err := strconv.ParseBool(env.DynamoInitializeTables)
+	if err != nil {
+		return policyDocument{}, fmt.Errorf("invalid SHORTIE_DYNAMO_INITIALIZE_TABLES: %w", err)
+	}
+	accessTokens, err := strconv.ParseBool(env.AccessTokens)
+	if err != nil {
+		return policyDocument{}, fmt.Errorf("invalid SHORTIE_ACCESS_TOKENS: %w", err)
+	}
+	metering, err := strconv.ParseBool(env.Metering)
+	if err != nil {
+		return policyDocument{}, fmt.Errorf("invalid SHORTIE_METERING: %w", err)
+	}
+	links := tableARN(env.AWSRegion, tableName)
+	var replicas []string
+	for _, region := range strings.Split(env.DynamoReplicaRegions, ",") {
+		region = strings.TrimSpace(region)
+		if region != "" && region != env.AWSRegion {
+			replicas = append(replicas, tableARN(region, tableName))
+		}
+	}
+	migrationRegion := env.MigrationDynamoRegion
+	if migrationRegion == "" {
+		migrationRegion = env.AWSRegion
+	}
+	migrationTarget := tableARN(migrationRegion, tableName)
+	locks := tableARN(env.AWSRegion, locksTableName)
+	tokens := tableARN(env.AWSRegion, tokensTableName)
+	changes := tableARN(env.AWSRegion, changesTableName)
+	ownerUsage := tableARN(env.AWSRegion, ownerUsageTableName)
+
+	var statements []policyStatement
+	if !provisioning {
+		statements = append(statements, allow("Links", linkActions, links, links+"/index/*"))
+		if env.StreamSink != "" {
+			statements = append(statements, allow("LinkStream", streamActions, links+"/stream/*"))
+		}
+		if env.StreamSink == "sns" && env.StreamSNSTopicARN != "" {
+			statements = append(statements, allow("LinkStreamTopic", []string{"sns:Publish"}, env.StreamSNSTopicARN))
+		}
+		if usesLocks(env) {
+			statements = append(statements, allow("Locks", lockActions, locks))
+		}
+		if accessTokens {
+			statements = append(statements, allow("AccessTokens", tokenActions, tokens))
+		}
+		if env.ChangeFeedRetention != "" {
+			statements = append(statements, allow("LinkChanges", changeActions, changes))
+		}
+		if metering {
+			statements = append(statements, allow("OwnerUsage", ownerUsageActions, ownerUsage))
+		}
+		if env.MigrationDynamoEndpoint != "" {
+			statements = append(statements, allow("MigrationTarget", linkActions, migrationTarget, migrationTarget+"/index/*"))
+		}
+		if env.Metrics == "cloudwatch" {
+			// PutMetricData has no resources, the namespace condition is all that scopes it
+			metrics := allow("Metrics", []string{"cloudwatch:PutMetricData"}, "*")
+			metrics.Condition = map[string]any{"StringEquals": map[string]string{"cloudwatch:namespace": env.CloudWatchNamespace}}
+			statements = append(statements, metrics)
+		}
+		if env.ClickLogS3Bucket != "" {
+			statements = append(statements, allow("ClickLog", []string{"s3:PutObject"}, "arn:aws:s3:::"+env.ClickLogS3Bucket+"/"+env.ClickLogS3Prefix+"*"))
+		}
+	}
+
+	if provisioning || initializeTables {
+		managed := []string{links}
+		if env.MigrationDynamoEndpoint != "" {
+			managed = append(managed, migrationTarget)
+		}
+		statements = append(statements, allow("ManageTables", tableSettingsActions, managed...))
+		var created []string
+		if usesLocks(env) || (provisioning && (env.LockBackend == "" || env.LockBackend == "dynamodb")) {
+			created = append(created, locks)
+		}
+		if accessTokens {
+			created = append(created, tokens)
+		}
+		if metering {
+			created = append(created, ownerUsage)
+		}
+		if len(created) > 0 {
+			statements = append(statements, allow("CreateTables", tableCreateActions, created...))
+		}
+		if env.ChangeFeedRetention != "" {
+			// the changes expire with a time to live, which is turned on once the table is created
+			statements = append(statements, allow("CreateChangesTable", changesCreateActions, changes))
+		}
+		if len(replicas) > 0 {
+			// adding a replica creates the table in its region and backfills it, which needs the data actions there too
+			statements = append(statements,
+				allow("AddReplicas", append([]string{"dynamodb:CreateTableReplica"}, linkActions...), append([]string{links}, replicas...)...),
+				serviceLinkedRole("GlobalTableRole", "replication.dynamodb.amazonaws.com"),
+			)
+		}
+		if capacity != nil && len(capacity.scalingTargets()) > 0 {
+			statements = append(statements,
+				allow("Autoscaling", autoscalingActions, "*"),
+				serviceLinkedRole("AutoscalingRole", "dynamodb.application-autoscaling.amazonaws.com"),
+			)
+		}
+	}
+	return policyDocument{Version: "2012-10-17", Statement: statements}, nil
+}
+
+// printIAMPolicy writes the policy as indented json, ready for aws iam put-role-policy or terraform's jsondecode
+func printIAMPolicy(w io.Writer, env Environment, provisioning bool) error {
+	policy, err := iamPolicy(env, provisioning)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(policy)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func policyEnvironment(t *testing.T) Environment {
+	env, err := loadEnvironment("")
+	require.NoError(t, err)
+	env.AWSRegion = "us-west-2"
+	env.AWSCustomDynamoEndpoint = "aws"
+	return env
+}
+
+func statementIDs(policy policyDocument) []string {
+	var ids []string
+	for _, statement := range policy.Statement {
+		ids = append(ids, statement.Sid)
+	}
+	return ids
+}
+
+func TestIAMPolicy(t *testing.T) {
+	env := policyEnvironment(t)
+	env.DynamoInitializeTables = "false"
+	policy, err := iamPolicy(env, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Links"}, statementIDs(policy))
+	assert.Equal(t, []string{"arn:aws:dynamodb:us-west-2:*:table/shortie-urls", "arn:aws:dynamodb:us-west-2:*:table/shortie-urls/index/*"}, policy.Statement[0].Resource)
+	assert.NotContains(t, policy.Statement[0].Action, "dynamodb:CreateTable")
+
+	env.StreamSink = "sns"
+	env.StreamSNSTopicARN = "arn:aws:sns:us-west-2:123456789012:links"
+	env.AccessTokens = "true"
+	env.MigrationDynamoEndpoint = "aws"
+	env.MigrationDynamoRegion = "eu-west-1"
+	env.Metrics = "cloudwatch"
+	env.ClickLogS3Bucket = "analytics"
+	env.ChangeFeedRetention = "168h"
+	env.Metering = "true"
+	policy, err = iamPolicy(env, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Links", "LinkStream", "LinkStreamTopic", "Locks", "AccessTokens", "LinkChanges", "OwnerUsage", "MigrationTarget", "Metrics", "ClickLog"}, statementIDs(policy))
+	assert.Equal(t, []string{"arn:aws:dynamodb:us-west-2:*:table/shortie-changes"}, policy.Statement[5].Resource)
+	assert.Equal(t, []string{"arn:aws:dynamodb:us-west-2:*:table/shortie-owner-usage"}, policy.Statement[6].Resource)
+	assert.Equal(t, []string{"arn:aws:dynamodb:eu-west-1:*:table/shortie-urls", "arn:aws:dynamodb:eu-west-1:*:table/shortie-urls/index/*"}, policy.Statement[7].Resource)
+	assert.Equal(t, map[string]any{"StringEquals": map[string]string{"cloudwatch:namespace": "Shortie"}}, policy.Statement[8].Condition)
+	assert.Equal(t, []string{"arn:aws:s3:::analytics/clicks/*"}, policy.Statement[9].Resource)
+}
+
+func TestIAMPolicyTableManagement(t *testing.T) {
+	env := policyEnvironment(t)
+	env.DynamoReplicaRegions = "us-west-2,eu-west-1"
+	env.DynamoBillingMode = "provisioned"
+	env.DynamoMaxWriteCapacity = "100"
+
+	// the runtime manages the tables while SHORTIE_DYNAMO_INITIALIZE_TABLES is on
+	policy, err := iamPolicy(env, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Links", "ManageTables", "AddReplicas", "GlobalTableRole", "Autoscaling", "AutoscalingRole"}, statementIDs(policy))
+	assert.Equal(t, []string{"arn:aws:dynamodb:us-west-2:*:table/shortie-urls", "arn:aws:dynamodb:eu-west-1:*:table/shortie-urls"}, policy.Statement[2].Resource)
+
+	// -provision only manages the tables, and always creates the locks table for the default lock backend
+	policy, err = iamPolicy(env, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ManageTables", "CreateTables", "AddReplicas", "GlobalTableRole", "Autoscaling", "AutoscalingRole"}, statementIDs(policy))
+}
+
+func TestPrintIAMPolicy(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, printIAMPolicy(&out, policyEnvironment(t), false))
+	var policy map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &policy))
+	assert.Equal(t, "2012-10-17", policy["Version"])
+
+	assert.Error(t, printIAMPolicy(&out, Environment{}, false))
+}
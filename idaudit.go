@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the reasons a link's shortID isn't the one its url derives
+const (
+	// conflictCollision is a link whose derived id holds another url, the two urls hashed to the same id
+	// or one of the links was moved
+	conflictCollision = "collision"
+	// conflictMismatch is a link whose derived id is free, it was edited, imported from another generator
+	// or created under a duplicate policy that salts ids
+	conflictMismatch = "mismatch"
+)
+
+type idConflict struct {
+	ShortID string `json:"shortID"`
+	URL     string `json:"url"`
+	// ExpectedID is the id the generator derives from the url
+	ExpectedID string `json:"expectedID"`
+	Reason     string `json:"reason"`
+	// ExpectedIDURL is the url the expected id holds on a collision
+	ExpectedIDURL string `json:"expectedIDURL,omitempty"`
+	Owner         string `json:"owner,omitempty"`
+	Created       int64  `json:"created,omitempty"`
+}
+
+// GetIDConflicts lists the links whose shortID isn't the one the generator derives from their url, which requires
+// reading every link. Team aliases are named by their teams and left out.
+func (api shortieAPI) GetIDConflicts(c *gin.Context) {
+	if !derivesFromURL(api.ids) {
+		respondError(c, http.StatusBadRequest, codeFeatureDisabled, "shortIDs aren't derived from urls with this id generator")
+		return
+	}
+	shortIDs, err := api.storage.ListShortIDs(c)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	sort.Strings(shortIDs)
+
+	links := make(map[string]*URLObject, len(shortIDs))
+	for _, shortID := range shortIDs {
+		object, err := api.storage.GetURL(c, shortID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		if object != nil {
+			links[shortID] = object
+		}
+	}
+
+	conflicts := []idConflict{}
+	for _, shortID := range shortIDs {
+		object := links[shortID]
+		if object == nil || isTeamAlias(shortID) {
+			continue
+		}
+		// signed links derive their id from the url and their secret
+		expected, err := api.newShortID(object.URL, object.SigningSecret)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		if expected == shortID {
+			continue
+		}
+		conflict := idConflict{ShortID: shortID, URL: object.URL, ExpectedID: expected, Reason: conflictMismatch, Owner: object.Owner, Created: object.Created}
+		if holder := links[expected]; holder != nil && holder.URL != object.URL {
+			conflict.Reason = conflictCollision
+			conflict.ExpectedIDURL = holder.URL
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	c.JSON(http.StatusOK, conflicts)
+}
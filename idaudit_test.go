@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetIDConflicts(t *testing.T) {
+	ctx := context.Background()
+	storage := newContractLocalStorage(t)
+	hashed := func(url string, signingSecret string) string {
+		shortID, err := hashIDs{}.NewID(url, signingSecret)
+		require.NoError(t, err)
+		return shortID
+	}
+	for _, object := range []URLObject{
+		{ShortID: hashed("https://example.com/a", ""), URL: "https://example.com/a"},
+		{ShortID: hashed("https://example.com/signed", "secret"), URL: "https://example.com/signed", SigningSecret: "secret"},
+		{ShortID: teamAliasID("eng", "docs"), URL: "https://example.com/docs"},
+		// the id of b holds c, and b lives on elsewhere
+		{ShortID: hashed("https://example.com/b", ""), URL: "https://example.com/c", Owner: "alice", Created: 1700000000},
+		{ShortID: "moved", URL: "https://example.com/b"},
+	} {
+		require.NoError(t, storage.ImportURL(ctx, object))
+	}
+	api := shortieAPI{storage: storage, adminToken: "admin"}
+
+	w := serveAs(api, "admin", http.MethodGet, "/admin/id-conflicts", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var conflicts []idConflict
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &conflicts))
+	assert.ElementsMatch(t, []idConflict{
+		{ShortID: hashed("https://example.com/b", ""), URL: "https://example.com/c", ExpectedID: hashed("https://example.com/c", ""), Reason: conflictMismatch, Owner: "alice", Created: 1700000000},
+		{ShortID: "moved", URL: "https://example.com/b", ExpectedID: hashed("https://example.com/b", ""), Reason: conflictCollision, ExpectedIDURL: "https://example.com/c"},
+	}, conflicts)
+
+	// ids that aren't derived from urls have nothing to compare against
+	api.ids = randomIDs{}
+	w = serveAs(api, "admin", http.MethodGet, "/admin/id-conflicts", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, serveAs(shortieAPI{storage: storage, adminToken: "admin"}, "", http.MethodGet, "/admin/id-conflicts", "").Code)
+}
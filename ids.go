@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// idGenerator picks the shortID of a new link
+type idGenerator interface {
+	NewID(url string, signingSecret string) (string, error)
+}
+
+// newShortID picks the shortID of a new link with the configured generator, hashIDs if none is
+func (api shortieAPI) newShortID(url string, signingSecret string) (string, error) {
+	if api.ids != nil {
+		return api.ids.NewID(url, signingSecret)
+	}
+	return hashIDs{}.NewID(url, signingSecret)
+}
+
+// hashIDs derives the shortID from the url, so creating the same link twice returns the same shortID
+// the ids are 10 hex characters unless an alphabet is set
+type hashIDs struct {
+	alphabet string
+}
+
+// hashIDBits is how much of the url's hash goes into an id
+const hashIDBits = 40
+
+func (hash hashIDs) NewID(url string, signingSecret string) (string, error) {
+	// signed links can't be shared with other creators of the same url, the secret keeps their IDs distinct
+	guid := uuid.NewSHA1(uuid.NameSpaceURL, []byte(url+signingSecret))
+	if hash.alphabet != "" {
+		value := new(big.Int).SetBytes(guid[:hashIDBits/8])
+		return encode(value, hash.alphabet, encodedWidth(hashIDBits, hash.alphabet)), nil
+	}
+	// TODO: handle conflicts - we can check the DB and if we have a conflict give this a couple more characters
+	return strings.ReplaceAll(guid.String(), "-", "")[0:10], nil
+}
+
+// the alphabets are in ascii order so that fixed width ids sort like the numbers they encode
+const (
+	base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// safeAlphabet leaves out characters that are easily confused in print: 0 O o 1 l I
+	safeAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnpqrstuvwxyz"
+)
+
+// validateAlphabet makes sure every character of an alphabet is distinct and can be used in a url path unescaped
+func validateAlphabet(alphabet string) error {
+	if len(alphabet) < 16 {
+		return errors.New("id alphabets need at least 16 characters")
+	}
+	seen := map[rune]bool{}
+	for _, character := range alphabet {
+		if seen[character] {
+			return fmt.Errorf("the id alphabet repeats %q", character)
+		}
+		seen[character] = true
+		if !strings.ContainsRune(base62Alphabet+"-_~", character) {
+			return fmt.Errorf("the id alphabet can't contain %q", character)
+		}
+	}
+	return nil
+}
+
+// encodedWidth is how many characters of the alphabet it takes to encode any value of bits bits
+func encodedWidth(bits int, alphabet string) int {
+	return int(math.Ceil(float64(bits) / math.Log2(float64(len(alphabet)))))
+}
+
+// encode encodes value in the alphabet zero padded to width characters
+func encode(value *big.Int, alphabet string, width int) string {
+	base := big.NewInt(int64(len(alphabet)))
+	remainder := new(big.Int)
+	value = new(big.Int).Set(value)
+
+	encoded := make([]byte, width)
+	for i := width - 1; i >= 0; i-- {
+		value.DivMod(value, base, remainder)
+		encoded[i] = alphabet[remainder.Int64()]
+	}
+	return string(encoded)
+}
+
+// snowflakeEpoch is when snowflake timestamps start counting, which leaves room for ~69 years of IDs
+var snowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	snowflakeBits         = 63
+)
+
+// snowflakeIDs are 63 bit ids of a millisecond timestamp, the node that made them and a per-millisecond sequence,
+// so ids sort by creation time and never collide as long as every replica has its own node
+type snowflakeIDs struct {
+	node     int64
+	alphabet string
+
+	lock       sync.Mutex
+	lastMillis int64
+	sequence   int64
+}
+
+func newSnowflakeIDs(node int64, alphabet string) (*snowflakeIDs, error) {
+	if node < 0 || node >= 1<<snowflakeNodeBits {
+		return nil, fmt.Errorf("the snowflake node must be between 0 and %d", 1<<snowflakeNodeBits-1)
+	}
+	return &snowflakeIDs{node: node, alphabet: alphabet}, nil
+}
+
+func (snowflake *snowflakeIDs) NewID(url string, signingSecret string) (string, error) {
+	snowflake.lock.Lock()
+	defer snowflake.lock.Unlock()
+
+	millis := time.Since(snowflakeEpoch).Milliseconds()
+	if millis < snowflake.lastMillis {
+		// the clock went backwards, keep counting from the last timestamp rather than risk duplicates
+		millis = snowflake.lastMillis
+	}
+	if millis == snowflake.lastMillis {
+		snowflake.sequence = (snowflake.sequence + 1) & (1<<snowflakeSequenceBits - 1)
+		if snowflake.sequence == 0 {
+			// the sequence ran out within this millisecond, borrow the next one
+			millis++
+		}
+	} else {
+		snowflake.sequence = 0
+	}
+	snowflake.lastMillis = millis
+
+	id := millis<<(snowflakeNodeBits+snowflakeSequenceBits) | snowflake.node<<snowflakeSequenceBits | snowflake.sequence
+	return encode(big.NewInt(id), snowflake.alphabet, encodedWidth(snowflakeBits, snowflake.alphabet)), nil
+}
+
+const (
+	// ksuidEpoch is the KSUID spec's epoch, timestamps are seconds since then
+	ksuidEpoch   = 1400000000
+	ksuidPayload = 16
+	ksuidBits    = 160
+)
+
+// ksuidIDs are KSUIDs, a second timestamp and 128 random bits, which sort by creation time
+// and need no coordination between replicas at the cost of longer ids
+type ksuidIDs struct {
+	alphabet string
+}
+
+func (ksuid ksuidIDs) NewID(url string, signingSecret string) (string, error) {
+	id := make([]byte, 4+ksuidPayload)
+	binary.BigEndian.PutUint32(id, uint32(time.Now().Unix()-ksuidEpoch))
+	_, err := rand.Read(id[4:])
+	if err != nil {
+		return "", fmt.Errorf("failed to generate a ksuid: %w", err)
+	}
+	return encode(new(big.Int).SetBytes(id), ksuid.alphabet, encodedWidth(ksuidBits, ksuid.alphabet)), nil
+}
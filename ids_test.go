@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math/big"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixedIDs hands out the same id every time
+type fixedIDs string
+
+func (id fixedIDs) NewID(url string, signingSecret string) (string, error) {
+	return string(id), nil
+}
+
+// randomIDs gives every new link its own id, like the snowflake and ksuid generators
+type randomIDs struct{}
+
+func (randomIDs) NewID(url string, signingSecret string) (string, error) {
+	return newSigningSecret()
+}
+
+func TestEncode(t *testing.T) {
+	assert.Equal(t, "00000", encode(big.NewInt(0), base62Alphabet, 5))
+	assert.Equal(t, "0000z", encode(big.NewInt(61), base62Alphabet, 5))
+	assert.Equal(t, "00010", encode(big.NewInt(62), base62Alphabet, 5))
+	assert.Equal(t, "AzL8n0Y58m7", encode(big.NewInt(1<<63-1), base62Alphabet, encodedWidth(snowflakeBits, base62Alphabet)))
+	assert.Equal(t, 27, encodedWidth(ksuidBits, base62Alphabet))
+}
+
+func TestSafeAlphabet(t *testing.T) {
+	require.NoError(t, validateAlphabet(safeAlphabet))
+	assert.True(t, sort.StringsAreSorted(strings.Split(safeAlphabet, "")))
+	assert.True(t, sort.StringsAreSorted(strings.Split(base62Alphabet, "")))
+
+	id, err := hashIDs{alphabet: safeAlphabet}.NewID("https://example.com/data/hi", "")
+	require.NoError(t, err)
+	assert.Len(t, id, 7)
+	assert.False(t, strings.ContainsAny(id, "0Oo1lI"))
+
+	assert.Error(t, validateAlphabet("0123456789abcdeff"), "repeated characters")
+	assert.Error(t, validateAlphabet("0123456789abcdef/"), "characters that need escaping")
+	assert.Error(t, validateAlphabet("0123456789"), "too short")
+}
+
+func TestSortableIDs(t *testing.T) {
+	snowflake, err := newSnowflakeIDs(7, base62Alphabet)
+	require.NoError(t, err)
+	_, err = newSnowflakeIDs(1024, base62Alphabet)
+	assert.Error(t, err)
+
+	for name, generator := range map[string]idGenerator{"snowflake": snowflake, "ksuid": ksuidIDs{alphabet: base62Alphabet}} {
+		var ids []string
+		seen := map[string]bool{}
+		for i := 0; i < 5000; i++ {
+			id, err := generator.NewID("https://example.com/data/hi", "")
+			require.NoError(t, err)
+			assert.False(t, seen[id], "%s ids are unique", name)
+			seen[id] = true
+			ids = append(ids, id)
+		}
+		if name == "snowflake" {
+			assert.True(t, sort.StringsAreSorted(ids), "snowflake ids sort by creation")
+			assert.Len(t, ids[0], 11)
+		} else {
+			assert.Len(t, ids[0], 27)
+		}
+	}
+}
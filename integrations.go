@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Integrations like the Slack command and the email gateway create plain links for people who can't pass any options,
+// owned by who asked for them in the integration so no api key can change them and only admins manage them.
+
+// validatePlainURL checks a url given to an integration, its error is meant for the person who sent it
+func (api shortieAPI) validatePlainURL(destination string) error {
+	parsed, err := url.Parse(destination)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("it has to start with http:// or https://")
+	}
+	return api.validateURLLengths(destination)
+}
+
+// createPlainLink saves a link to the destination, the owner also counts its links for the spam heuristics,
+// since integrations send everything from the same few servers
+func (api shortieAPI) createPlainLink(c *gin.Context, destination string, owner string) (URLObject, error) {
+	if api.canonicalizer != nil {
+		destination = api.canonicalizer.Canonicalize(destination)
+	}
+	now := time.Now()
+	object := URLObject{
+		URL:     destination,
+		Owner:   owner,
+		Created: now.Unix(),
+	}
+	// there is no way to pick an expiration, so links get the longest one allowed
+	if api.maxTTL > 0 {
+		object.Expiration = now.Add(api.maxTTL).Unix()
+	}
+	policy := api.defaultDuplicatePolicy()
+	// the expiration isn't asked for, so a link with any other one will do
+	requested := object
+	requested.Expiration = 0
+	existing, err := api.reusableLink(c, policy, requested)
+	if err != nil {
+		return object, err
+	}
+	if existing != nil {
+		return *existing, nil
+	}
+	shortID, existing, err := api.newLinkID(c, policy, requested, "")
+	if err != nil {
+		return object, err
+	}
+	object.ShortID = shortID
+	if api.spam != nil {
+		object.QuarantineReason = api.spam.Check(c, destination, owner, now)
+		object.Quarantined = object.QuarantineReason != ""
+	}
+	if existing != nil {
+		// saving doesn't change a link that already exists, so report its own state instead
+		object.Quarantined = existing.Quarantined
+	}
+	err = api.storage.SaveURL(c, object)
+	return object, err
+}
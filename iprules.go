@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// IPRules restricts who can use a link by CIDR range
+// an empty Allow list allows every address that isn't denied
+type IPRules struct {
+	Allow []string `dynamodbav:"allow" json:"allow,omitempty"`
+	Deny  []string `dynamodbav:"deny" json:"deny,omitempty"`
+}
+
+func (rules IPRules) Validate() error {
+	for _, cidr := range append(append([]string{}, rules.Allow...), rules.Deny...) {
+		_, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid cidr %q: %w", cidr, err)
+		}
+	}
+	return nil
+}
+
+func (rules IPRules) Permits(clientIP string) bool {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return len(rules.Allow) == 0 && len(rules.Deny) == 0
+	}
+	if cidrsContain(rules.Deny, ip) {
+		return false
+	}
+	return len(rules.Allow) == 0 || cidrsContain(rules.Allow, ip)
+}
+
+func cidrsContain(cidrs []string, ip net.IP) bool {
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
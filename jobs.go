@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// cleanupExpiredLinks deletes links whose expiration has passed, they already stopped redirecting
+// but would otherwise stay in storage forever. Links are kept archived for the grace period first.
+func cleanupExpiredLinks(ctx context.Context, storage urlStorage, grace time.Duration) error {
+	shortIDs, err := storage.ListShortIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, shortID := range shortIDs {
+		object, err := storage.GetURL(ctx, shortID)
+		if err != nil {
+			return err
+		}
+		// locked links stay until an admin unlocks them, even once they expire
+		if object == nil || object.Locked || object.Expiration == 0 || now.Unix() < object.Expiration || object.IsArchived(now, grace) {
+			continue
+		}
+		err = storage.DeleteURL(ctx, shortID)
+		if err != nil {
+			return fmt.Errorf("failed to clean up %s: %w", shortID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LimitBody caps the size of the request body, reading past maxBodyBytes fails with a *http.MaxBytesError
+func (api shortieAPI) LimitBody(c *gin.Context) {
+	if api.maxBodyBytes > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, api.maxBodyBytes)
+	}
+	c.Next()
+}
+
+// bindJSON decodes the request body into body, responding with the error and returning false if it can't be decoded
+// unknown fields are rejected when strictJSON is set so that typos like "expiry" aren't silently ignored
+func (api shortieAPI) bindJSON(c *gin.Context, body any) bool {
+	decoder := json.NewDecoder(c.Request.Body)
+	if api.strictJSON {
+		decoder.DisallowUnknownFields()
+	}
+	err := decoder.Decode(body)
+	if err == nil && decoder.More() {
+		err = errors.New("the body must be a single json object")
+	}
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondError(c, http.StatusRequestEntityTooLarge, codeBodyTooLarge, fmt.Sprintf("the body is larger than %d bytes", maxBytesErr.Limit))
+			return false
+		}
+		respondError(c, http.StatusBadRequest, codeInvalidJSON, err.Error())
+		return false
+	}
+	return true
+}
+
+// validateURLLengths keeps huge urls out of the backend, dynamo items are limited to 400KB in total
+func (api shortieAPI) validateURLLengths(urls ...string) error {
+	if api.maxURLLength <= 0 {
+		return nil
+	}
+	for _, url := range urls {
+		if len(url) > api.maxURLLength {
+			return fmt.Errorf("urls can be at most %d characters", api.maxURLLength)
+		}
+	}
+	return nil
+}
+
+const (
+	maxNotesLength      = 4096
+	maxAnnotations      = 32
+	maxAnnotationKey    = 128
+	maxAnnotationLength = 1024
+)
+
+// validateNotes bounds the internal notes and annotations of a link, they are free-form but shouldn't grow items without limit
+func validateNotes(notes string, annotations map[string]string) error {
+	if len(notes) > maxNotesLength {
+		return fmt.Errorf("notes can be at most %d characters", maxNotesLength)
+	}
+	if len(annotations) > maxAnnotations {
+		return fmt.Errorf("links can have at most %d annotations", maxAnnotations)
+	}
+	for key, value := range annotations {
+		if key == "" || len(key) > maxAnnotationKey {
+			return fmt.Errorf("annotation keys must be between 1 and %d characters", maxAnnotationKey)
+		}
+		if len(value) > maxAnnotationLength {
+			return fmt.Errorf("annotation values can be at most %d characters", maxAnnotationLength)
+		}
+	}
+	return nil
+}
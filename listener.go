@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// systemd passes activated sockets starting at this file descriptor
+const listenFdsStart = 3
+
+// listen inherits the listener from systemd socket activation if there is one,
+// otherwise it binds addr, with SO_REUSEPORT if reusePort so a new process can bind alongside the old one during a restart
+func listen(ctx context.Context, addr string, reusePort bool) (net.Listener, error) {
+	listener, err := systemdListener()
+	if err != nil || listener != nil {
+		return listener, err
+	}
+
+	config := net.ListenConfig{}
+	if reusePort {
+		config.Control = setReusePort
+	}
+	return config.Listen(ctx, "tcp", addr)
+}
+
+// systemdListener returns nil if the process wasn't socket activated
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, errors.New("socket activated without any sockets")
+	}
+	if fds > 1 {
+		return nil, fmt.Errorf("expected one activated socket, got %d", fds)
+	}
+
+	// unset so that child processes don't think the sockets are theirs
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(uintptr(listenFdsStart), "systemd-socket")
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use the activated socket: %w", err)
+	}
+	return listener, nil
+}
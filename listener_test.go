@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenReusePort(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SO_REUSEPORT is not supported on windows")
+	}
+	first, err := listen(context.Background(), "127.0.0.1:0", true)
+	require.NoError(t, err)
+	defer first.Close()
+
+	second, err := listen(context.Background(), first.Addr().String(), true)
+	require.NoError(t, err, "a restarted process can bind while the old one drains")
+	defer second.Close()
+}
+
+func TestSystemdListener(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	listener, err := systemdListener()
+	require.NoError(t, err)
+	assert.Nil(t, listener, "sockets activated for another process are ignored")
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "2")
+	_, err = systemdListener()
+	assert.Error(t, err)
+}
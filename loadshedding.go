@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// concurrencyLimiter bounds the requests a route handles at once. Requests over the limit wait in a bounded queue
+// for up to queueTimeout, and are shed with a fast 503 when the queue is full or the wait runs out,
+// so a traffic spike can't pile up requests on the storage backend until everything times out.
+type concurrencyLimiter struct {
+	slots        chan struct{}
+	queue        chan struct{}
+	queueTimeout time.Duration
+}
+
+func newConcurrencyLimiter(limit int, queue int, queueTimeout time.Duration) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		slots:        make(chan struct{}, limit),
+		queue:        make(chan struct{}, queue),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// acquire takes a slot, waiting in the queue if there is room, and reports false if the request should be shed
+func (limiter *concurrencyLimiter) acquire(c *gin.Context) bool {
+	select {
+	case limiter.slots <- struct{}{}:
+		return true
+	default:
+	}
+	select {
+	case limiter.queue <- struct{}{}:
+	default:
+		return false
+	}
+	defer func() { <-limiter.queue }()
+
+	timer := time.NewTimer(limiter.queueTimeout)
+	defer timer.Stop()
+	select {
+	case limiter.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-c.Request.Context().Done():
+		return false
+	}
+}
+
+func (limiter *concurrencyLimiter) release() {
+	<-limiter.slots
+}
+
+// parseConcurrencyLimits reads comma separated route=limit pairs, e.g. /shortie/:id=200,/shortie/:id/stats=20
+func parseConcurrencyLimits(config string, queue int, queueTimeout time.Duration) (map[string]*concurrencyLimiter, error) {
+	limiters := map[string]*concurrencyLimiter{}
+	for _, pair := range strings.Split(config, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		route, limitString, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("concurrency limit %q is not route=limit", pair)
+		}
+		limit, err := strconv.Atoi(limitString)
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("concurrency limit for %s must be a positive integer", route)
+		}
+		limiters[route] = newConcurrencyLimiter(limit, queue, queueTimeout)
+	}
+	return limiters, nil
+}
+
+// scannedRoutes are the routes DetectScanners tarpits denied clients on
+var scannedRoutes = map[string]bool{
+	"/shortie/:id":    true,
+	"/t/:team/:alias": true,
+	passthroughRoute:  true,
+}
+
+// ShedLoad applies the concurrency limit of the matched route, routes without a limit are let through.
+// Passthrough redirects are only matched after the router, so they are limited here by their own route if it is
+// listed and by the redirects' otherwise.
+func (api shortieAPI) ShedLoad(c *gin.Context) {
+	route := c.FullPath()
+	limiter, found := api.concurrency[route]
+	if route == "" {
+		if _, _, passthrough := matchPassthrough(c.Request); passthrough {
+			route = passthroughRoute
+			limiter, found = api.concurrency[passthroughRoute]
+			if !found {
+				limiter, found = api.concurrency["/shortie/:id"]
+			}
+		}
+	}
+	// denied scanners are tarpitted on the redirect routes without reaching the storage, they mustn't hold a slot
+	// while they wait
+	if !found || (scannedRoutes[route] && api.scanners != nil && api.scanners.Denied(c.ClientIP(), time.Now())) {
+		c.Next()
+		return
+	}
+	if !limiter.acquire(c) {
+		c.Header("Retry-After", "1")
+		respondError(c, http.StatusServiceUnavailable, codeOverloaded, "too many requests in flight, try again shortly")
+		return
+	}
+	defer limiter.release()
+	c.Next()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShedLoad(t *testing.T) {
+	concurrency, err := parseConcurrencyLimits("/slow=1", 1, 200*time.Millisecond)
+	require.NoError(t, err)
+	api := shortieAPI{concurrency: concurrency}
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	router := gin.New()
+	router.Use(api.ShedLoad)
+	router.GET("/slow", func(c *gin.Context) {
+		started <- struct{}{}
+		<-release
+		c.Status(http.StatusOK)
+	})
+	router.GET("/fast", func(c *gin.Context) { c.Status(http.StatusOK) })
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		codes[0] = serve("/slow").Code
+	}()
+	<-started
+
+	// the second request waits in the queue and is shed once its wait runs out
+	w := serve("/slow")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "OVERLOADED")
+	// routes without a limit aren't affected
+	assert.Equal(t, http.StatusOK, serve("/fast").Code)
+
+	// a queued request gets the slot once it frees up
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		codes[1] = serve("/slow").Code
+	}()
+	time.Sleep(10 * time.Millisecond)
+	release <- struct{}{}
+	<-started
+	release <- struct{}{}
+	wg.Wait()
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK}, codes)
+}
+
+func TestParseConcurrencyLimits(t *testing.T) {
+	limiters, err := parseConcurrencyLimits("/shortie/:id=200, /health=5", 10, time.Second)
+	require.NoError(t, err)
+	assert.Len(t, limiters, 2)
+	assert.Equal(t, 200, cap(limiters["/shortie/:id"].slots))
+	assert.Equal(t, 10, cap(limiters["/health"].queue))
+
+	_, err = parseConcurrencyLimits("/shortie/:id", 10, time.Second)
+	assert.Error(t, err)
+	_, err = parseConcurrencyLimits("/shortie/:id=0", 10, time.Second)
+	assert.Error(t, err)
+}
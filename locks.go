@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// locker hands out named leases so that only one replica runs a background job at a time
+// a lease expires after its ttl, so a replica that dies while holding one doesn't block the job forever
+type locker interface {
+	TryLock(ctx context.Context, name string, ttl time.Duration) (bool, error)
+	// Renew extends a lease this replica holds, reporting false if another replica took it over
+	Renew(ctx context.Context, name string, ttl time.Duration) (bool, error)
+	Unlock(ctx context.Context, name string) error
+}
+
+// instanceID identifies this replica as a lock holder
+var instanceID = uuid.NewString()
+
+// LocalLocker only coordinates within this process, which is enough when running a single replica
+type LocalLocker struct {
+	lock   sync.Mutex
+	leases map[string]time.Time
+}
+
+func NewLocalLocker() *LocalLocker {
+	return &LocalLocker{leases: map[string]time.Time{}}
+}
+
+func (locker *LocalLocker) TryLock(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	locker.lock.Lock()
+	defer locker.lock.Unlock()
+
+	expires, found := locker.leases[name]
+	if found && time.Now().Before(expires) {
+		return false, nil
+	}
+	locker.leases[name] = time.Now().Add(ttl)
+	return true, nil
+}
+
+// Renew extends the lease, the only holder within the process is the caller
+func (locker *LocalLocker) Renew(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	locker.lock.Lock()
+	defer locker.lock.Unlock()
+
+	locker.leases[name] = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (locker *LocalLocker) Unlock(ctx context.Context, name string) error {
+	locker.lock.Lock()
+	defer locker.lock.Unlock()
+
+	delete(locker.leases, name)
+	return nil
+}
+
+const locksTableName = "shortie-locks"
+const attributeLockName = "lockName"
+
+// DynamoLocker keeps leases in their own table using conditional writes
+type DynamoLocker struct {
+	dynamo *dynamodb.Client
+}
+
+func NewDynamoLocker(storage *DynamoStorage) *DynamoLocker {
+	return &DynamoLocker{dynamo: storage.dynamo}
+}
+
+func (locker *DynamoLocker) InitializeTable() error {
+	_, err := locker.dynamo.CreateTable(context.Background(), &dynamodb.CreateTableInput{
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String(attributeLockName),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String(attributeLockName),
+				KeyType:       types.KeyTypeHash,
+			},
+		},
+		TableName: aws.String(locksTableName),
+	})
+	if err != nil {
+		var alreadyExists *types.TableAlreadyExistsException
+		var inUse *types.ResourceInUseException
+		if errors.As(err, &alreadyExists) || errors.As(err, &inUse) {
+			return nil
+		}
+		return fmt.Errorf("failed to create the locks table: %w", err)
+	}
+	return nil
+}
+
+func (locker *DynamoLocker) TryLock(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	_, err := locker.dynamo.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(locksTableName),
+		Item: map[string]types.AttributeValue{
+			attributeLockName: &types.AttributeValueMemberS{Value: name},
+			"holder":          &types.AttributeValueMemberS{Value: instanceID},
+			"expires":         numberValue(now.Add(ttl).UnixMilli()),
+		},
+		// the lease can be taken if nobody holds it, it ran out, or we already hold it
+		ConditionExpression: aws.String("attribute_not_exists(#name) OR #expires < :now OR #holder = :holder"),
+		ExpressionAttributeNames: map[string]string{
+			"#name":    attributeLockName,
+			"#expires": "expires",
+			"#holder":  "holder",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":    numberValue(now.UnixMilli()),
+			":holder": &types.AttributeValueMemberS{Value: instanceID},
+		},
+	})
+	if err != nil {
+		if isConditionFailed(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to take the %s lock: %w", name, err)
+	}
+	return true, nil
+}
+
+// Renew extends the lease, taking it only succeeds while this replica holds it or nobody does
+func (locker *DynamoLocker) Renew(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	return locker.TryLock(ctx, name, ttl)
+}
+
+func (locker *DynamoLocker) Unlock(ctx context.Context, name string) error {
+	_, err := locker.dynamo.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(locksTableName),
+		Key: map[string]types.AttributeValue{
+			attributeLockName: &types.AttributeValueMemberS{Value: name},
+		},
+		ConditionExpression: aws.String("#holder = :holder"),
+		ExpressionAttributeNames: map[string]string{
+			"#holder": "holder",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":holder": &types.AttributeValueMemberS{Value: instanceID},
+		},
+	})
+	if err != nil {
+		if isConditionFailed(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to release the %s lock: %w", name, err)
+	}
+	return nil
+}
+
+// RedisLocker keeps leases as expiring redis keys
+type RedisLocker struct {
+	redis *redis.Client
+}
+
+func NewRedisLocker(addr string) *RedisLocker {
+	return &RedisLocker{redis: redis.NewClient(&redis.Options{Addr: addr})}
+}
+
+func redisLockKey(name string) string {
+	return "shortie:lock:" + name
+}
+
+// renewOrTakeScript takes a free lease or extends one we already hold
+var renewOrTakeScript = redis.NewScript(`
+local holder = redis.call("GET", KEYS[1])
+if holder == false or holder == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// releaseScript only deletes the lease if we still hold it
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func (locker *RedisLocker) TryLock(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	taken, err := renewOrTakeScript.Run(ctx, locker.redis, []string{redisLockKey(name)}, instanceID, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to take the %s lock: %w", name, err)
+	}
+	return taken == 1, nil
+}
+
+// Renew extends the lease, the script only takes it while this replica holds it or nobody does
+func (locker *RedisLocker) Renew(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	return locker.TryLock(ctx, name, ttl)
+}
+
+func (locker *RedisLocker) Unlock(ctx context.Context, name string) error {
+	err := releaseScript.Run(ctx, locker.redis, []string{redisLockKey(name)}, instanceID).Err()
+	if err != nil {
+		return fmt.Errorf("failed to release the %s lock: %w", name, err)
+	}
+	return nil
+}
+
+// runExclusively runs job every interval on whichever replica holds the job's lock. The holder renews the lease on
+// every tick and every third of an interval while the job runs, so a job that outlasts the interval doesn't start on
+// a second replica, and another replica only takes over once the holder stops renewing it.
+func runExclusively(ctx context.Context, locker locker, name string, interval time.Duration, job func(ctx context.Context) error) {
+	// the lease outlasts the wait for the next tick, so the holder renews it before anyone else can take it
+	ttl := 2 * interval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	held := false
+	for {
+		select {
+		case <-ctx.Done():
+			// hand the job over right away instead of making the other replicas wait out the lease
+			err := locker.Unlock(context.Background(), name)
+			if err != nil {
+				log.Println("error: " + err.Error())
+			}
+			return
+		case <-ticker.C:
+			var err error
+			if held {
+				held, err = locker.Renew(ctx, name, ttl)
+			} else {
+				held, err = locker.TryLock(ctx, name, ttl)
+			}
+			if err != nil {
+				log.Println("error: " + err.Error())
+				held = false
+				continue
+			}
+			if !held {
+				continue
+			}
+			held, err = runHeartbeating(ctx, locker, name, interval/3, ttl, job)
+			if err != nil {
+				log.Printf("error: %s job failed: %s\n", name, err.Error())
+			}
+		}
+	}
+}
+
+// runHeartbeating runs job while renewing its lease every beat, canceling it if the lease is lost.
+// It reports whether the lease is still held once the job is done.
+func runHeartbeating(ctx context.Context, locker locker, name string, beat time.Duration, ttl time.Duration, job func(ctx context.Context) error) (bool, error) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- job(jobCtx)
+	}()
+
+	heartbeat := time.NewTicker(beat)
+	defer heartbeat.Stop()
+	held := true
+	renewed := time.Now()
+	for {
+		select {
+		case err := <-done:
+			return held, err
+		case now := <-heartbeat.C:
+			if !held {
+				continue
+			}
+			taken, err := locker.Renew(ctx, name, ttl)
+			if err != nil {
+				log.Println("error: " + err.Error())
+				// the lease is still ours until it runs out, the next beat tries again unless it is about to
+				taken = now.Sub(renewed) < ttl-beat
+			} else if taken {
+				renewed = now
+			}
+			if !taken {
+				log.Printf("error: lost the %s lock, canceling the job\n", name)
+				held = false
+				cancel()
+			}
+		}
+	}
+}
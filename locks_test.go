@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalLocker(t *testing.T) {
+	locker := NewLocalLocker()
+
+	acquired, err := locker.TryLock(context.Background(), "cleanup", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	acquired, err = locker.TryLock(context.Background(), "cleanup", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	require.NoError(t, locker.Unlock(context.Background(), "cleanup"))
+	acquired, err = locker.TryLock(context.Background(), "cleanup", time.Nanosecond)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	time.Sleep(time.Millisecond)
+	acquired, err = locker.TryLock(context.Background(), "cleanup", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, acquired, "an expired lease can be taken over")
+}
+
+func TestRunExclusively(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	locker := NewLocalLocker()
+	var lock sync.Mutex
+	running, most, runs := 0, 0, 0
+	// the job outlasts the interval, so a lease that isn't renewed would let the other replica start it too
+	job := func(ctx context.Context) error {
+		lock.Lock()
+		running++
+		runs++
+		most = max(most, running)
+		lock.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		lock.Lock()
+		running--
+		lock.Unlock()
+		return nil
+	}
+	var wg sync.WaitGroup
+	for replica := 0; replica < 2; replica++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runExclusively(ctx, locker, "cleanup", 15*time.Millisecond, job)
+		}()
+	}
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	wg.Wait()
+	assert.Equal(t, 1, most)
+	assert.Greater(t, runs, 1)
+}
+
+func TestCleanupExpiredLinks(t *testing.T) {
+	storage := &LocalStorage{
+		Objects: map[string]URLObject{},
+		lock:    sync.Mutex{},
+	}
+	err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", Expiration: time.Now().Add(-time.Hour).Unix()})
+	require.NoError(t, err)
+	err = storage.SaveURL(context.Background(), URLObject{ShortID: "222", URL: "http://redirection.com/other", Expiration: time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+
+	require.NoError(t, cleanupExpiredLinks(context.Background(), storage, 0))
+
+	shortIDs, err := storage.ListShortIDs(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"222"}, shortIDs)
+}
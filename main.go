@@ -2,41 +2,272 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"html/template"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
 )
 
 type Environment struct {
-	AWSRegion               string
-	AWSAccessKeyID          string
-	AWSSecretAccessKey      string
-	AWSCustomDynamoEndpoint string
+	ListenAddr                string `env:"SHORTIE_LISTEN_ADDR"`
+	ReusePort                 string `env:"SHORTIE_REUSE_PORT"`
+	ShutdownTimeout           string `env:"SHORTIE_SHUTDOWN_TIMEOUT"`
+	AWSRegion                 string `env:"AWS_REGION"`
+	AWSAccessKeyID            string `env:"AWS_ACCESS_KEY_ID"`
+	AWSSecretAccessKey        string `env:"AWS_SECRET_ACCESS_KEY" secret:"true"`
+	AWSCustomDynamoEndpoint   string `env:"AWS_CUSTOM_DYNAMO_ENDPOINT"`
+	DynamoReplicaRegions      string `env:"SHORTIE_DYNAMO_REPLICA_REGIONS"`
+	DynamoMaxConns            string `env:"SHORTIE_DYNAMO_MAX_CONNS"`
+	DynamoDialTimeout         string `env:"SHORTIE_DYNAMO_DIAL_TIMEOUT"`
+	DynamoTimeout             string `env:"SHORTIE_DYNAMO_TIMEOUT"`
+	DynamoKeepAlive           string `env:"SHORTIE_DYNAMO_KEEP_ALIVE"`
+	DynamoRetryMode           string `env:"SHORTIE_DYNAMO_RETRY_MODE"`
+	DynamoMaxAttempts         string `env:"SHORTIE_DYNAMO_MAX_ATTEMPTS"`
+	DynamoBillingMode         string `env:"SHORTIE_DYNAMO_BILLING_MODE"`
+	DynamoReadCapacity        string `env:"SHORTIE_DYNAMO_READ_CAPACITY"`
+	DynamoWriteCapacity       string `env:"SHORTIE_DYNAMO_WRITE_CAPACITY"`
+	DynamoMaxReadCapacity     string `env:"SHORTIE_DYNAMO_MAX_READ_CAPACITY"`
+	DynamoMaxWriteCapacity    string `env:"SHORTIE_DYNAMO_MAX_WRITE_CAPACITY"`
+	DynamoTargetUtilization   string `env:"SHORTIE_DYNAMO_TARGET_UTILIZATION"`
+	DynamoPointInTimeRecovery string `env:"SHORTIE_DYNAMO_POINT_IN_TIME_RECOVERY"`
+	DynamoDeletionProtection  string `env:"SHORTIE_DYNAMO_DELETION_PROTECTION"`
+	DynamoTableClass          string `env:"SHORTIE_DYNAMO_TABLE_CLASS"`
+	DynamoTags                string `env:"SHORTIE_DYNAMO_TAGS"`
+	DynamoInitializeTables    string `env:"SHORTIE_DYNAMO_INITIALIZE_TABLES"`
+	DynamoConsistentReads     string `env:"SHORTIE_DYNAMO_CONSISTENT_READS"`
+	PausedPagePath            string `env:"SHORTIE_PAUSED_PAGE"`
+	ScheduledPagePath         string `env:"SHORTIE_SCHEDULED_PAGE"`
+	RobotsTxtPath             string `env:"SHORTIE_ROBOTS_TXT"`
+	Sitemap                   string `env:"SHORTIE_SITEMAP"`
+	RewriteRulesPath          string `env:"SHORTIE_REWRITE_RULES_FILE"`
+	OperatorName              string `env:"SHORTIE_OPERATOR_NAME"`
+	OperatorContact           string `env:"SHORTIE_OPERATOR_CONTACT"`
+	AboutPagePath             string `env:"SHORTIE_ABOUT_PAGE"`
+	AbusePagePath             string `env:"SHORTIE_ABUSE_PAGE"`
+	CacheTTL                  string `env:"SHORTIE_CACHE_TTL"`
+	StatsCacheTTL             string `env:"SHORTIE_STATS_CACHE_TTL"`
+	EdgeTTL                   string `env:"SHORTIE_EDGE_TTL"`
+	ChangeFeedRetention       string `env:"SHORTIE_CHANGE_FEED_RETENTION"`
+	BloomFilterInterval       string `env:"SHORTIE_BLOOM_FILTER_INTERVAL"`
+	LockBackend               string `env:"SHORTIE_LOCK_BACKEND"`
+	RedisAddr                 string `env:"SHORTIE_REDIS_ADDR"`
+	CleanupInterval           string `env:"SHORTIE_CLEANUP_INTERVAL"`
+	HourlyUsageDays           string `env:"SHORTIE_HOURLY_USAGE_DAYS"`
+	MemoryMaxLinks            string `env:"SHORTIE_MEMORY_MAX_LINKS"`
+	MemoryMaxBytes            string `env:"SHORTIE_MEMORY_MAX_BYTES"`
+	MaxTTL                    string `env:"SHORTIE_MAX_TTL"`
+	Compression               string `env:"SHORTIE_COMPRESSION"`
+	CompressionMinBytes       string `env:"SHORTIE_COMPRESSION_MIN_BYTES"`
+	ArchiveGrace              string `env:"SHORTIE_ARCHIVE_GRACE"`
+	MaxTTLPolicy              string `env:"SHORTIE_MAX_TTL_POLICY"`
+	UsageSampleRate           string `env:"SHORTIE_USAGE_SAMPLE_RATE"`
+	UsageSampleThreshold      string `env:"SHORTIE_USAGE_SAMPLE_THRESHOLD"`
+	UsageDedupWindow          string `env:"SHORTIE_USAGE_DEDUP_WINDOW"`
+	EventBus                  string `env:"SHORTIE_EVENT_BUS"`
+	StreamSink                string `env:"SHORTIE_STREAM_SINK"`
+	StreamWebhookURL          string `env:"SHORTIE_STREAM_WEBHOOK_URL"`
+	StreamSNSTopicARN         string `env:"SHORTIE_STREAM_SNS_TOPIC_ARN"`
+	MigrationDynamoEndpoint   string `env:"SHORTIE_MIGRATION_DYNAMO_ENDPOINT"`
+	MigrationDynamoRegion     string `env:"SHORTIE_MIGRATION_DYNAMO_REGION"`
+	AccessLogFormat           string `env:"SHORTIE_ACCESS_LOG_FORMAT"`
+	AccessLogSampling         string `env:"SHORTIE_ACCESS_LOG_SAMPLING"`
+	AccessLogFile             string `env:"SHORTIE_ACCESS_LOG_FILE"`
+	AccessLogMaxSizeMB        string `env:"SHORTIE_ACCESS_LOG_MAX_SIZE_MB"`
+	AccessLogMaxBackups       string `env:"SHORTIE_ACCESS_LOG_MAX_BACKUPS"`
+	ClickLogFile              string `env:"SHORTIE_CLICK_LOG_FILE"`
+	ClickLogMaxSizeMB         string `env:"SHORTIE_CLICK_LOG_MAX_SIZE_MB"`
+	ClickLogMaxBackups        string `env:"SHORTIE_CLICK_LOG_MAX_BACKUPS"`
+	ClickLogS3Bucket          string `env:"SHORTIE_CLICK_LOG_S3_BUCKET"`
+	ClickLogS3Prefix          string `env:"SHORTIE_CLICK_LOG_S3_PREFIX"`
+	ClickLogS3Interval        string `env:"SHORTIE_CLICK_LOG_S3_INTERVAL"`
+	ConcurrencyLimits         string `env:"SHORTIE_CONCURRENCY_LIMITS"`
+	ConcurrencyQueue          string `env:"SHORTIE_CONCURRENCY_QUEUE"`
+	ConcurrencyQueueTimeout   string `env:"SHORTIE_CONCURRENCY_QUEUE_TIMEOUT"`
+	TrustedProxies            string `env:"SHORTIE_TRUSTED_PROXIES"`
+	CORSOrigins               string `env:"SHORTIE_CORS_ORIGINS"`
+	CaptchaProvider           string `env:"SHORTIE_CAPTCHA_PROVIDER"`
+	CaptchaSecret             string `env:"SHORTIE_CAPTCHA_SECRET" secret:"true"`
+	AnonymousTTL              string `env:"SHORTIE_ANONYMOUS_TTL"`
+	AnonymousRateLimit        string `env:"SHORTIE_ANONYMOUS_RATE_LIMIT"`
+	AnonymousRateWindow       string `env:"SHORTIE_ANONYMOUS_RATE_WINDOW"`
+	SpamMaxEntropy            string `env:"SHORTIE_SPAM_MAX_ENTROPY"`
+	SpamMinDomainAge          string `env:"SHORTIE_SPAM_MIN_DOMAIN_AGE"`
+	SpamMaxRepeats            string `env:"SHORTIE_SPAM_MAX_REPEATS"`
+	SpamRepeatWindow          string `env:"SHORTIE_SPAM_REPEAT_WINDOW"`
+	ContentCheckInterval      string `env:"SHORTIE_CONTENT_CHECK_INTERVAL"`
+	ContentCheckThreshold     string `env:"SHORTIE_CONTENT_CHECK_THRESHOLD"`
+	ContentCheckAction        string `env:"SHORTIE_CONTENT_CHECK_ACTION"`
+	LinkTitles                string `env:"SHORTIE_LINK_TITLES"`
+	ScreenshotURL             string `env:"SHORTIE_SCREENSHOT_URL" secret:"true"`
+	ScreenshotTTL             string `env:"SHORTIE_SCREENSHOT_TTL"`
+	ScannerThreshold          string `env:"SHORTIE_SCANNER_THRESHOLD"`
+	ScannerWindow             string `env:"SHORTIE_SCANNER_WINDOW"`
+	ScannerBan                string `env:"SHORTIE_SCANNER_BAN"`
+	ScannerTarpit             string `env:"SHORTIE_SCANNER_TARPIT"`
+	FraudThreshold            string `env:"SHORTIE_FRAUD_THRESHOLD"`
+	FraudWindow               string `env:"SHORTIE_FRAUD_WINDOW"`
+	FraudCountryHeader        string `env:"SHORTIE_FRAUD_COUNTRY_HEADER"`
+	FraudExclude              string `env:"SHORTIE_FRAUD_EXCLUDE"`
+	IDGenerator               string `env:"SHORTIE_ID_GENERATOR"`
+	IDNode                    string `env:"SHORTIE_ID_NODE"`
+	DuplicatePolicy           string `env:"SHORTIE_DUPLICATE_POLICY"`
+	IDChecksum                string `env:"SHORTIE_ID_CHECKSUM"`
+	IDAlphabet                string `env:"SHORTIE_ID_ALPHABET"`
+	MaxBodyBytes              string `env:"SHORTIE_MAX_BODY_BYTES"`
+	MaxURLLength              string `env:"SHORTIE_MAX_URL_LENGTH"`
+	StrictJSON                string `env:"SHORTIE_STRICT_JSON"`
+	CanonicalStripParams      string `env:"SHORTIE_CANONICAL_STRIP_PARAMS"`
+	CanonicalSortParams       string `env:"SHORTIE_CANONICAL_SORT_PARAMS"`
+	CanonicalStripFragment    string `env:"SHORTIE_CANONICAL_STRIP_FRAGMENT"`
+	AdminToken                string `env:"SHORTIE_ADMIN_TOKEN" secret:"true"`
+	CursorSecret              string `env:"SHORTIE_CURSOR_SECRET" secret:"true"`
+	StatsShareSecret          string `env:"SHORTIE_STATS_SHARE_SECRET" secret:"true"`
+	TeamTokens                string `env:"SHORTIE_TEAM_TOKENS" secret:"true"`
+	Directory                 string `env:"SHORTIE_DIRECTORY"`
+	SlackSigningSecret        string `env:"SHORTIE_SLACK_SIGNING_SECRET" secret:"true"`
+	EmailAddress              string `env:"SHORTIE_EMAIL_ADDRESS"`
+	EmailTopicARN             string `env:"SHORTIE_EMAIL_TOPIC_ARN"`
+	EmailAllowedSenders       string `env:"SHORTIE_EMAIL_ALLOWED_SENDERS"`
+	EmailSMTPAddr             string `env:"SHORTIE_EMAIL_SMTP_ADDR"`
+	EmailSMTPUsername         string `env:"SHORTIE_EMAIL_SMTP_USERNAME"`
+	EmailSMTPPassword         string `env:"SHORTIE_EMAIL_SMTP_PASSWORD" secret:"true"`
+	APIKeys                   string `env:"SHORTIE_API_KEYS" secret:"true"`
+	JWTSecret                 string `env:"SHORTIE_JWT_SECRET" secret:"true"`
+	AccessTokens              string `env:"SHORTIE_ACCESS_TOKENS"`
+	Metering                  string `env:"SHORTIE_METERING"`
+	Plans                     string `env:"SHORTIE_PLANS"`
+	OwnerPlans                string `env:"SHORTIE_OWNER_PLANS"`
+	DefaultPlan               string `env:"SHORTIE_DEFAULT_PLAN"`
+	AdminTOTPSecret           string `env:"SHORTIE_ADMIN_TOTP_SECRET" secret:"true"`
+	ReadOnly                  string `env:"SHORTIE_READ_ONLY"`
+	Maintenance               string `env:"SHORTIE_MAINTENANCE"`
+	Replica                   string `env:"SHORTIE_REPLICA"`
+	ReplicaSyncInterval       string `env:"SHORTIE_REPLICA_SYNC_INTERVAL"`
+	MaintenancePagePath       string `env:"SHORTIE_MAINTENANCE_PAGE"`
+	MaintenanceRetryAfter     string `env:"SHORTIE_MAINTENANCE_RETRY_AFTER"`
+	FaultLatency              string `env:"SHORTIE_FAULT_LATENCY"`
+	FaultLatencyRate          string `env:"SHORTIE_FAULT_LATENCY_RATE"`
+	FaultErrorRate            string `env:"SHORTIE_FAULT_ERROR_RATE"`
+	Metrics                   string `env:"SHORTIE_METRICS"`
+	MetricsInterval           string `env:"SHORTIE_METRICS_INTERVAL"`
+	CloudWatchNamespace       string `env:"SHORTIE_CLOUDWATCH_NAMESPACE"`
+	StatsDAddress             string `env:"SHORTIE_STATSD_ADDRESS"`
+	StatsDPrefix              string `env:"SHORTIE_STATSD_PREFIX"`
+	StatsDTags                string `env:"SHORTIE_STATSD_TAGS"`
 }
 
 func main() {
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	// systemd and docker stop services with SIGTERM
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	go func() {
-		<-ctx.Done()
-		// TODO: set this up so that the gin.Router can have a little time to finish requests
-		os.Exit(0)
-	}()
+	configPath := flag.String("config", "", "path to a KEY=VALUE config file, overridden by environment variables")
+	dev := flag.Bool("dev", false, "run with a seeded in-memory backend, verbose logging and no admin auth")
+	fixturesPath := flag.String("fixtures", "", "path to a json file of links seeded in dev mode, defaults to the bundled fixtures")
+	provision := flag.Bool("provision", false, "create or update the dynamodb tables and exit, so the runtime doesn't need permissions to manage them")
+	flag.Parse()
+
+	env, err := loadEnvironment(*configPath)
+	if err != nil {
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+	// iam-policy prints the policy the runtime needs, or -provision needs with -provision iam-policy
+	switch flag.Arg(0) {
+	case "":
+	case "iam-policy":
+		err = printIAMPolicy(os.Stdout, env, *provision)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		return
+	case "replay":
+		err = runReplay(ctx, env, flag.Args()[1:])
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		return
+	case "export-static":
+		err = runExportStatic(ctx, env, flag.Args()[1:])
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		return
+	case "fsck":
+		err = runFsck(ctx, env, flag.Args()[1:])
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		return
+	default:
+		err = fmt.Errorf("unknown command %q, expected iam-policy, replay, export-static or fsck", flag.Arg(0))
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+	if *provision {
+		err = provisionTables(env)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		log.Println("the tables are provisioned")
+		return
+	}
+	var fixtures []devFixture
+	if *dev {
+		env = devEnvironment(env)
+		fixtures, err = loadFixtures(*fixturesPath)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		log.SetFlags(log.LstdFlags | log.Lshortfile)
+		gin.SetMode(gin.DebugMode)
+	}
 
-	var env = Environment{
-		AWSRegion:               os.Getenv("AWS_REGION"),
-		AWSAccessKeyID:          os.Getenv("AWS_ACCESS_KEY_ID"),
-		AWSSecretAccessKey:      os.Getenv("AWS_SECRET_ACCESS_KEY"),
-		AWSCustomDynamoEndpoint: os.Getenv("AWS_CUSTOM_DYNAMO_ENDPOINT"),
+	// usage is counted by the hour as well for this many days, after which only the daily usage is kept
+	hourlyUsageDays := 0
+	if env.HourlyUsageDays != "" {
+		hourlyUsageDays, err = strconv.Atoi(env.HourlyUsageDays)
+		if err != nil || hourlyUsageDays < 1 {
+			err = fmt.Errorf("invalid SHORTIE_HOURLY_USAGE_DAYS %q, expected a number of days", env.HourlyUsageDays)
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
 	}
 
 	// in-memory storage if dynamo is not configured to be used
-	var storage urlStorage = &LocalStorage{
-		Objects: map[string]URLObject{},
-		lock:    sync.Mutex{},
+	maxLinks, maxBytes, err := initMemoryCaps(env)
+	if err != nil {
+		log.Println("error: " + err.Error())
+		panic(err)
 	}
+	localStorage := &LocalStorage{
+		Objects:     map[string]URLObject{},
+		lock:        sync.Mutex{},
+		hourlyUsage: hourlyUsageDays > 0,
+		maxLinks:    maxLinks,
+		maxBytes:    maxBytes,
+	}
+	var storage urlStorage = localStorage
+	var dynamoStorage *DynamoStorage
 
 	// set up a dynamo backend
 	//  - good for high reads/writes
@@ -50,25 +281,862 @@ func main() {
 			log.Println("error: " + err.Error())
 			panic(err)
 		}
-		err = dynamoClient.InitializeTable()
-		if err != nil {
-			log.Println("error: " + err.Error())
-			panic(err)
+		if dynamoClient.initializeTables {
+			err = dynamoClient.InitializeTable()
+			if err != nil {
+				log.Println("error: " + err.Error())
+				panic(err)
+			}
+		} else {
+			log.Println("skipping table initialization, the tables are expected to be provisioned already")
 		}
 		storage = dynamoClient
+		dynamoStorage = dynamoClient
 	} else {
 		log.Println("using in-memory backend")
 	}
+	if *dev {
+		err = seedFixtures(ctx, storage, fixtures)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+	}
 
-	// TODO: setup a local caching layer with the storage interface to optimize
-	//  - comes with the potential caveat of deletes not propagating immediately
+	// injected backend latency and errors, for rehearsing incidents in test environments
+	if env.FaultLatency != "" || env.FaultErrorRate != "" {
+		faulty, err := initFaultyStorage(env, storage)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		log.Println("warning: injecting storage faults, this must never be enabled in production")
+		storage = faulty
+	}
 
-	api := shortieAPI{storage: storage}
+	// export request, redirect and storage metrics to an observability backend
+	metrics, err := initMetrics(ctx, env)
+	if err != nil {
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+	if metrics != nil {
+		log.Println("exporting metrics to " + env.Metrics)
+		storage = NewMeteredStorage(storage, metrics)
+		localStorage.metrics = metrics
+	}
+
+	// dual-write to the backend being migrated to, the copy and verification passes are started from the admin api
+	var migration *MigratingStorage
+	if env.MigrationDynamoEndpoint != "" {
+		target, err := initMigrationTarget(env)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		log.Println("migrating to the dynamodb backend at " + env.MigrationDynamoEndpoint)
+		migration = NewMigratingStorage(storage, target)
+		storage = migration
+	}
+
+	// republish link changes from the table's stream for downstream systems
+	if env.StreamSink != "" {
+		sink, err := initStreamSink(env, dynamoStorage)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		streamLocker, err := initLocker(env, dynamoStorage)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		go NewStreamConsumer(dynamoStorage, sink, streamLocker).Run(ctx)
+	}
+
+	// the change feed lets edge caches and read replicas sync every link without scanning the table
+	changes, err := initChangeFeed(env, dynamoStorage)
+	if err != nil {
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+	if changes != nil {
+		log.Printf("keeping link changes for %s\n", changes.retention)
+		storage = NewJournalingStorage(storage, changes)
+	}
+
+	// metering counts the links and redirects of each owner for their plan's monthly limits
+	meter, err := initOwnerMeter(env, dynamoStorage)
+	if err != nil {
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+	if meter != nil {
+		go meter.Run(ctx)
+	}
+
+	// link events let replicas keep their local caches in sync with changes made elsewhere
+	eventBus, err := initEventBus(env)
+	if err != nil {
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+	if eventBus != nil {
+		storage = NewPublishingStorage(storage, eventBus)
+	}
+
+	// local caching layer in front of the storage
+	//  - comes with the potential caveat of deletes from other replicas not propagating until the ttl runs out
+	var cache *CachedStorage
+	if env.CacheTTL != "" {
+		ttl, err := time.ParseDuration(env.CacheTTL)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		log.Printf("caching links for %s\n", ttl)
+		cache = NewCachedStorage(storage, ttl)
+		storage = cache
+		if eventBus != nil {
+			go eventBus.Subscribe(ctx, cache.HandleLinkEvent)
+		}
+	}
+
+	// bloom filter of existing shortIDs so lookups for nonexistent links never reach the backend
+	if env.BloomFilterInterval != "" {
+		interval, err := time.ParseDuration(env.BloomFilterInterval)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		filtered, err := NewFilteredStorage(ctx, storage)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		go filtered.RebuildEvery(ctx, interval)
+		if eventBus != nil {
+			go eventBus.Subscribe(ctx, filtered.HandleLinkEvent)
+		}
+		storage = filtered
+	}
+
+	// read replicas only redirect, optionally from a copy of every link kept in memory
+	replica, snapshotInterval, err := initReplica(env)
+	if err != nil {
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+	if replica {
+		log.Println("running as a read replica, only redirects and pages are served")
+	}
+	if snapshotInterval != 0 {
+		snapshot, err := NewSnapshotStorage(ctx, storage, snapshotInterval)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		log.Printf("keeping a snapshot of every link, synced every %s\n", snapshotInterval)
+		go snapshot.SyncEvery(ctx)
+		if eventBus != nil {
+			go eventBus.Subscribe(ctx, snapshot.HandleLinkEvent)
+		}
+		storage = snapshot
+	}
+
+	// the sitemap wraps every other layer so that it sees every change made through this replica
+	var sitemap *SitemapStorage
+	sitemapEnabled, err := strconv.ParseBool(env.Sitemap)
+	if err != nil {
+		log.Println("error: invalid SHORTIE_SITEMAP: " + err.Error())
+		panic(err)
+	}
+	if sitemapEnabled {
+		sitemap = NewSitemapStorage(storage)
+		storage = sitemap
+		if eventBus != nil {
+			go eventBus.Subscribe(ctx, sitemap.HandleLinkEvent)
+		}
+	}
+
+	// expired links are archived for this long before they are purged
+	var archiveGrace time.Duration
+	if env.ArchiveGrace != "" {
+		archiveGrace, err = time.ParseDuration(env.ArchiveGrace)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+	}
+
+	// background jobs run on a single replica at a time, coordinated through a locker
+	if env.CleanupInterval != "" {
+		interval, err := time.ParseDuration(env.CleanupInterval)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		jobLocker, err := initLocker(env, dynamoStorage)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		go runExclusively(ctx, jobLocker, "cleanup", interval, func(ctx context.Context) error {
+			return cleanupExpiredLinks(ctx, storage, archiveGrace)
+		})
+	}
+	if hourlyUsageDays > 0 {
+		jobLocker, err := initLocker(env, dynamoStorage)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		// every region of a global table rolls up its own usage items
+		jobName := "usage-rollup"
+		if dynamoStorage != nil && dynamoStorage.isGlobal() {
+			jobName += "-" + dynamoStorage.region
+		}
+		keep := time.Duration(hourlyUsageDays) * 24 * time.Hour
+		go runExclusively(ctx, jobLocker, jobName, time.Hour, func(ctx context.Context) error {
+			return rollupHourlyUsage(ctx, storage, keep)
+		})
+	}
+
+	var contents *contentWatch
+	if env.ContentCheckInterval != "" {
+		var interval time.Duration
+		contents, interval, err = initContentWatch(env, metrics)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		jobLocker, err := initLocker(env, dynamoStorage)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		go runExclusively(ctx, jobLocker, "content-check", interval, func(ctx context.Context) error {
+			return contents.Check(ctx, storage)
+		})
+	}
+
+	api := shortieAPI{storage: storage, cache: cache, migration: migration, adminToken: env.AdminToken, slackSigningSecret: env.SlackSigningSecret, statsShareSecret: env.StatsShareSecret, config: env.Redacted(), sitemap: sitemap, changeFeed: changes, meter: meter, archiveGrace: archiveGrace, metrics: metrics, contents: contents, replica: replica, dev: *dev}
+
+	linkTitles, err := strconv.ParseBool(env.LinkTitles)
+	if err != nil {
+		log.Println("error: invalid SHORTIE_LINK_TITLES: " + err.Error())
+		panic(err)
+	}
+	if linkTitles {
+		api.titles = newLinkTitles(NewHTTPContentFetcher("shortie-title-fetch", titleMaxBytes))
+	}
+	if env.ScreenshotURL != "" {
+		api.thumbnails, err = initThumbnails(env)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+	}
+	if env.StatsCacheTTL != "" {
+		api.statsCache, err = initStatsCache(env)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		log.Printf("caching link stats for %s\n", api.statsCache.ttl)
+	}
+	if env.EdgeTTL != "" {
+		api.edgeTTL, err = initEdgeTTL(env)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+	}
+
+	readOnly, err := strconv.ParseBool(env.ReadOnly)
+	if err != nil {
+		log.Println("error: invalid SHORTIE_READ_ONLY: " + err.Error())
+		panic(err)
+	}
+	if readOnly {
+		log.Println("starting read-only, links can't be changed until it is switched off from the admin api")
+	}
+	api.readOnly = newSwitch(readOnly)
+
+	maintenance, err := strconv.ParseBool(env.Maintenance)
+	if err != nil {
+		log.Println("error: invalid SHORTIE_MAINTENANCE: " + err.Error())
+		panic(err)
+	}
+	if maintenance {
+		log.Println("starting in maintenance mode, switch it off from the admin api")
+	}
+	api.maintenance = newSwitch(maintenance)
+	if env.MaintenancePagePath != "" {
+		api.maintenancePage, err = os.ReadFile(env.MaintenancePagePath)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+	}
+	if env.MaintenanceRetryAfter != "" {
+		api.maintenanceRetryAfter, err = time.ParseDuration(env.MaintenanceRetryAfter)
+		if err != nil {
+			log.Println("error: invalid SHORTIE_MAINTENANCE_RETRY_AFTER: " + err.Error())
+			panic(err)
+		}
+	}
+
+	api.cursors, err = newCursorCodec(env.CursorSecret)
+	if err != nil {
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+	api.teamTokens, err = parseTeamTokens(env.TeamTokens)
+	if err != nil {
+		log.Println("error: invalid SHORTIE_TEAM_TOKENS: " + err.Error())
+		panic(err)
+	}
+	api.apiKeys, err = parseAPIKeys(env.APIKeys)
+	if err != nil {
+		log.Println("error: invalid SHORTIE_API_KEYS: " + err.Error())
+		panic(err)
+	}
+	api.jwtSecret = []byte(env.JWTSecret)
+	api.tokens, err = initTokenStore(env, dynamoStorage)
+	if err != nil {
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+	if env.AdminTOTPSecret != "" {
+		api.stepUp, err = newTOTPVerifier(env.AdminTOTPSecret)
+		if err != nil {
+			log.Println("error: invalid SHORTIE_ADMIN_TOTP_SECRET: " + err.Error())
+			panic(err)
+		}
+	}
+	if env.EmailAddress != "" {
+		api.email, err = initEmailGateway(env)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+	}
+	api.directory, err = strconv.ParseBool(env.Directory)
+	if err != nil {
+		log.Println("error: invalid SHORTIE_DIRECTORY: " + err.Error())
+		panic(err)
+	}
+	if api.directory && !api.dev && api.adminToken == "" && !api.rbacEnabled() {
+		// the directory lists every alias, it isn't served to everyone just because no tokens are configured
+		err = errors.New("SHORTIE_DIRECTORY requires SHORTIE_ADMIN_TOKEN, SHORTIE_API_KEYS, SHORTIE_JWT_SECRET or SHORTIE_ACCESS_TOKENS")
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+	if env.PausedPagePath != "" {
+		pausedPage, err := os.ReadFile(env.PausedPagePath)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		api.pausedPage = pausedPage
+	}
+	if env.ScheduledPagePath != "" {
+		scheduledPage, err := template.ParseFiles(env.ScheduledPagePath)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		api.scheduledPage = scheduledPage
+	}
+	if env.RobotsTxtPath != "" {
+		robotsTxt, err := os.ReadFile(env.RobotsTxtPath)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		api.robotsTxt = robotsTxt
+	}
+	if env.RewriteRulesPath != "" {
+		api.rewrites, err = loadRewriteRules(env.RewriteRulesPath)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+	}
+	api.operator = operatorInfo{Name: env.OperatorName, Contact: env.OperatorContact}
+	if env.AboutPagePath != "" {
+		aboutPage, err := os.ReadFile(env.AboutPagePath)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		api.aboutPage = aboutPage
+	}
+	if env.AbusePagePath != "" {
+		abusePage, err := os.ReadFile(env.AbusePagePath)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		api.abusePage = abusePage
+	}
+
+	idAlphabet, err := initIDAlphabet(env)
+	if err != nil {
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+	api.ids, err = initIDGenerator(env, idAlphabet)
+	if err != nil {
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+	idChecksum, err := strconv.ParseBool(env.IDChecksum)
+	if err != nil {
+		log.Println("error: invalid SHORTIE_ID_CHECKSUM: " + err.Error())
+		panic(err)
+	}
+	if idChecksum {
+		api.checksumAlphabet = idAlphabet
+		if api.checksumAlphabet == "" {
+			api.checksumAlphabet = base62Alphabet
+		}
+		api.ids = checksumIDs{idGenerator: api.ids, alphabet: api.checksumAlphabet}
+	}
+	if env.DuplicatePolicy != "" {
+		err = validateDuplicatePolicy(env.DuplicatePolicy)
+		if err != nil {
+			log.Println("error: invalid SHORTIE_DUPLICATE_POLICY: " + err.Error())
+			panic(err)
+		}
+		api.duplicatePolicy = env.DuplicatePolicy
+	}
+	api.maxBodyBytes, err = strconv.ParseInt(env.MaxBodyBytes, 10, 64)
+	if err != nil {
+		log.Println("error: invalid SHORTIE_MAX_BODY_BYTES: " + err.Error())
+		panic(err)
+	}
+	api.maxURLLength, err = strconv.Atoi(env.MaxURLLength)
+	if err != nil {
+		log.Println("error: invalid SHORTIE_MAX_URL_LENGTH: " + err.Error())
+		panic(err)
+	}
+	api.strictJSON, err = strconv.ParseBool(env.StrictJSON)
+	if err != nil {
+		log.Println("error: invalid SHORTIE_STRICT_JSON: " + err.Error())
+		panic(err)
+	}
+	sortParams, err := strconv.ParseBool(env.CanonicalSortParams)
+	if err != nil {
+		log.Println("error: invalid SHORTIE_CANONICAL_SORT_PARAMS: " + err.Error())
+		panic(err)
+	}
+	stripFragment, err := strconv.ParseBool(env.CanonicalStripFragment)
+	if err != nil {
+		log.Println("error: invalid SHORTIE_CANONICAL_STRIP_FRAGMENT: " + err.Error())
+		panic(err)
+	}
+	api.canonicalizer = parseCanonicalizer(env.CanonicalStripParams, sortParams, stripFragment)
+
+	for _, proxy := range strings.Split(env.TrustedProxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy != "" {
+			api.trustedProxies = append(api.trustedProxies, proxy)
+		}
+	}
+	api.corsOrigins, err = parseCORSOrigins(env.CORSOrigins)
+	if err != nil {
+		log.Println("error: invalid SHORTIE_CORS_ORIGINS: " + err.Error())
+		panic(err)
+	}
+
+	if env.CaptchaProvider != "" {
+		api.captcha, err = NewCaptchaVerifier(env.CaptchaProvider, env.CaptchaSecret)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+	}
+
+	api.anonymous, err = initAnonymousLimiter(env, api.rbacEnabled())
+	if err != nil {
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+
+	if env.SpamMaxEntropy != "" || env.SpamMinDomainAge != "" || env.SpamMaxRepeats != "" {
+		api.spam, err = initSpamChecker(env)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+	}
+
+	if env.ScannerThreshold != "" {
+		scanners, err := initScannerDetector(env)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		api.scanners = scanners
+	}
+
+	if env.FraudThreshold != "" {
+		api.fraud, err = initFraudDetector(env)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+	}
+
+	api.compression = env.Compression == "true"
+	if api.compression {
+		api.compressMinBytes, err = strconv.Atoi(env.CompressionMinBytes)
+		if err != nil || api.compressMinBytes < 0 {
+			err = fmt.Errorf("invalid SHORTIE_COMPRESSION_MIN_BYTES %q", env.CompressionMinBytes)
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+	}
+
+	if env.MaxTTL != "" {
+		api.maxTTL, err = time.ParseDuration(env.MaxTTL)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		switch env.MaxTTLPolicy {
+		case "clamp":
+		case "reject":
+			api.rejectPastMaxTTL = true
+		default:
+			err = fmt.Errorf("unknown SHORTIE_MAX_TTL_POLICY %q, expected clamp or reject", env.MaxTTLPolicy)
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+	}
+
+	if env.UsageSampleRate != "" {
+		sampler, err := initUsageSampler(env)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		api.sampler = sampler
+	}
+
+	_, api.consistentReadHeader, err = parseConsistentReads(env.DynamoConsistentReads)
+	if err != nil {
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+
+	if env.UsageDedupWindow != "" {
+		window, err := time.ParseDuration(env.UsageDedupWindow)
+		if err != nil || window <= 0 {
+			err = fmt.Errorf("invalid SHORTIE_USAGE_DEDUP_WINDOW %q", env.UsageDedupWindow)
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		api.clickDedup = newClickDeduper(window)
+	}
+
+	if env.AccessLogFormat != "" {
+		accessLog, err := initAccessLog(env)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+		api.accessLog = accessLog
+	}
+
+	api.clicks, err = initClickLog(ctx, env)
+	if err != nil {
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+
+	if env.ConcurrencyLimits != "" {
+		api.concurrency, err = initConcurrencyLimits(env)
+		if err != nil {
+			log.Println("error: " + err.Error())
+			panic(err)
+		}
+	}
 
 	router := api.GetRouter()
 
-	err := router.Run(":8421")
+	shutdownTimeout, err := time.ParseDuration(env.ShutdownTimeout)
+	if err != nil {
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+	reusePort, err := strconv.ParseBool(env.ReusePort)
 	if err != nil {
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+	listener, err := listen(ctx, env.ListenAddr, reusePort)
+	if err != nil {
+		log.Println("error: " + err.Error())
+		panic(err)
+	}
+	log.Printf("listening on %s\n", listener.Addr())
+	if *dev {
+		printDevExamples(os.Stdout, listener.Addr(), fixtures)
+	}
+
+	// on shutdown stop accepting connections and let in-flight requests finish,
+	// while a restarted process (or the systemd socket) takes over new connections
+	server := &http.Server{Handler: router}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancelShutdown()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Println("error: " + err.Error())
+		}
+	}()
+
+	err = server.Serve(listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Printf("exiting: %s\n", err.Error())
 	}
 }
+
+// initLocker picks the lock backend, defaulting to the storage backend's own
+func initLocker(env Environment, dynamoStorage *DynamoStorage) (locker, error) {
+	backend := env.LockBackend
+	if backend == "" {
+		backend = "local"
+		if dynamoStorage != nil {
+			backend = "dynamodb"
+		}
+	}
+
+	switch backend {
+	case "local":
+		return NewLocalLocker(), nil
+	case "dynamodb":
+		if dynamoStorage == nil {
+			return nil, errors.New("the dynamodb lock backend requires the dynamodb storage backend")
+		}
+		dynamoLocker := NewDynamoLocker(dynamoStorage)
+		if !dynamoStorage.initializeTables {
+			return dynamoLocker, nil
+		}
+		return dynamoLocker, dynamoLocker.InitializeTable()
+	case "redis":
+		if env.RedisAddr == "" {
+			return nil, errors.New("the redis lock backend requires SHORTIE_REDIS_ADDR")
+		}
+		return NewRedisLocker(env.RedisAddr), nil
+	default:
+		return nil, fmt.Errorf("unknown lock backend %q", backend)
+	}
+}
+
+// initEventBus returns nil if replicas aren't configured to share link events
+func initEventBus(env Environment) (linkEventBus, error) {
+	switch env.EventBus {
+	case "":
+		return nil, nil
+	case "redis":
+		if env.RedisAddr == "" {
+			return nil, errors.New("the redis event bus requires SHORTIE_REDIS_ADDR")
+		}
+		return NewRedisEventBus(env.RedisAddr), nil
+	default:
+		return nil, fmt.Errorf("unknown event bus %q", env.EventBus)
+	}
+}
+
+// initIDAlphabet returns the alphabet of generated ids, empty for the generators' own defaults
+func initIDAlphabet(env Environment) (string, error) {
+	switch env.IDAlphabet {
+	case "":
+		return "", nil
+	case "base62":
+		return base62Alphabet, nil
+	case "safe":
+		return safeAlphabet, nil
+	default:
+		return env.IDAlphabet, validateAlphabet(env.IDAlphabet)
+	}
+}
+
+func initIDGenerator(env Environment, alphabet string) (idGenerator, error) {
+	if alphabet == "" && env.IDGenerator != "" && env.IDGenerator != "hash" {
+		alphabet = base62Alphabet
+	}
+	switch env.IDGenerator {
+	case "", "hash":
+		return hashIDs{alphabet: alphabet}, nil
+	case "snowflake":
+		var node int64
+		if env.IDNode != "" {
+			var err error
+			node, err = strconv.ParseInt(env.IDNode, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SHORTIE_ID_NODE: %w", err)
+			}
+		} else {
+			// a random node, replicas only have a small chance of sharing one but should set SHORTIE_ID_NODE
+			node = int64(crc32.ChecksumIEEE([]byte(instanceID)) % (1 << snowflakeNodeBits))
+			log.Printf("generating snowflake ids as node %d\n", node)
+		}
+		return newSnowflakeIDs(node, alphabet)
+	case "ksuid":
+		return ksuidIDs{alphabet: alphabet}, nil
+	default:
+		return nil, fmt.Errorf("unknown id generator %q", env.IDGenerator)
+	}
+}
+
+// initSpamChecker enables each spam heuristic that is configured
+func initSpamChecker(env Environment) (*spamChecker, error) {
+	checker := newSpamChecker()
+	var err error
+	if env.SpamMaxEntropy != "" {
+		checker.maxEntropy, err = strconv.ParseFloat(env.SpamMaxEntropy, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SHORTIE_SPAM_MAX_ENTROPY: %w", err)
+		}
+	}
+	if env.SpamMinDomainAge != "" {
+		checker.minDomainAge, err = time.ParseDuration(env.SpamMinDomainAge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SHORTIE_SPAM_MIN_DOMAIN_AGE: %w", err)
+		}
+		checker.domainAges = NewRDAPLookup()
+	}
+	if env.SpamMaxRepeats != "" {
+		checker.maxRepeats, err = strconv.Atoi(env.SpamMaxRepeats)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SHORTIE_SPAM_MAX_REPEATS: %w", err)
+		}
+		checker.repeatWindow, err = time.ParseDuration(env.SpamRepeatWindow)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SHORTIE_SPAM_REPEAT_WINDOW: %w", err)
+		}
+	}
+	return checker, nil
+}
+
+func initScannerDetector(env Environment) (*scannerDetector, error) {
+	threshold, err := strconv.Atoi(env.ScannerThreshold)
+	if err != nil || threshold < 1 {
+		return nil, errors.New("SHORTIE_SCANNER_THRESHOLD must be a positive integer")
+	}
+	window, err := time.ParseDuration(env.ScannerWindow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHORTIE_SCANNER_WINDOW: %w", err)
+	}
+	banDuration, err := time.ParseDuration(env.ScannerBan)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHORTIE_SCANNER_BAN: %w", err)
+	}
+	tarpit, err := time.ParseDuration(env.ScannerTarpit)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHORTIE_SCANNER_TARPIT: %w", err)
+	}
+	log.Printf("denying clients with %d missing links within %s for %s\n", threshold, window, banDuration)
+	return newScannerDetector(threshold, window, banDuration, tarpit), nil
+}
+
+func initUsageSampler(env Environment) (*usageSampler, error) {
+	rate, err := strconv.ParseInt(env.UsageSampleRate, 10, 64)
+	if err != nil || rate < 1 {
+		return nil, errors.New("SHORTIE_USAGE_SAMPLE_RATE must be a positive integer")
+	}
+	threshold, err := strconv.Atoi(env.UsageSampleThreshold)
+	if err != nil || threshold < 0 {
+		return nil, errors.New("SHORTIE_USAGE_SAMPLE_THRESHOLD must be a non-negative integer")
+	}
+	log.Printf("sampling 1 in %d clicks of links with more than %d clicks a minute\n", rate, threshold)
+	return newUsageSampler(rate, threshold), nil
+}
+
+// initConcurrencyLimits gives every limited route its own queue of SHORTIE_CONCURRENCY_QUEUE waiting requests
+func initConcurrencyLimits(env Environment) (map[string]*concurrencyLimiter, error) {
+	queue, err := strconv.Atoi(env.ConcurrencyQueue)
+	if err != nil || queue < 0 {
+		return nil, fmt.Errorf("invalid SHORTIE_CONCURRENCY_QUEUE %q", env.ConcurrencyQueue)
+	}
+	queueTimeout, err := time.ParseDuration(env.ConcurrencyQueueTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHORTIE_CONCURRENCY_QUEUE_TIMEOUT: %w", err)
+	}
+	return parseConcurrencyLimits(env.ConcurrencyLimits, queue, queueTimeout)
+}
+
+// initAccessLog writes to stdout unless an access log file is configured
+func initAccessLog(env Environment) (*accessLogger, error) {
+	sampling, err := parseSampling(env.AccessLogSampling)
+	if err != nil {
+		return nil, err
+	}
+	var writer io.Writer = os.Stdout
+	if env.AccessLogFile != "" {
+		maxSizeMB, err := strconv.Atoi(env.AccessLogMaxSizeMB)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SHORTIE_ACCESS_LOG_MAX_SIZE_MB: %w", err)
+		}
+		maxBackups, err := strconv.Atoi(env.AccessLogMaxBackups)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SHORTIE_ACCESS_LOG_MAX_BACKUPS: %w", err)
+		}
+		writer, err = openRotatingFile(env.AccessLogFile, int64(maxSizeMB)<<20, maxBackups)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newAccessLogger(env.AccessLogFormat, sampling, writer)
+}
+
+// initMigrationTarget connects to the dynamo table links are being migrated to
+func initMigrationTarget(env Environment) (*DynamoStorage, error) {
+	if env.MigrationDynamoEndpoint == env.AWSCustomDynamoEndpoint &&
+		(env.MigrationDynamoRegion == "" || env.MigrationDynamoRegion == env.AWSRegion) {
+		return nil, errors.New("the migration target is the same table as the current backend")
+	}
+	targetEnv := env
+	targetEnv.AWSCustomDynamoEndpoint = env.MigrationDynamoEndpoint
+	if env.MigrationDynamoRegion != "" {
+		targetEnv.AWSRegion = env.MigrationDynamoRegion
+	}
+	// the target is a plain table, imported usage would be counted twice alongside a global table's regional usage
+	targetEnv.DynamoReplicaRegions = ""
+	targetEnv.StreamSink = ""
+
+	target, err := InitDynamoStorage(targetEnv)
+	if err != nil {
+		return nil, err
+	}
+	if !target.initializeTables {
+		return target, nil
+	}
+	return target, target.InitializeTable()
+}
+
+func initStreamSink(env Environment, dynamoStorage *DynamoStorage) (linkChangeSink, error) {
+	if dynamoStorage == nil {
+		return nil, errors.New("the stream consumer requires the dynamodb backend")
+	}
+	switch env.StreamSink {
+	case "webhook":
+		if env.StreamWebhookURL == "" {
+			return nil, errors.New("the webhook stream sink requires SHORTIE_STREAM_WEBHOOK_URL")
+		}
+		return NewWebhookSink(env.StreamWebhookURL), nil
+	case "sns":
+		if env.StreamSNSTopicARN == "" {
+			return nil, errors.New("the sns stream sink requires SHORTIE_STREAM_SNS_TOPIC_ARN")
+		}
+		return NewSNSSink(dynamoStorage, env.StreamSNSTopicARN), nil
+	default:
+		return nil, fmt.Errorf("unknown stream sink %q", env.StreamSink)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultMaintenancePage is served during maintenance unless SHORTIE_MAINTENANCE_PAGE replaces it
+var defaultMaintenancePage = []byte(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Down for maintenance</title>
+<link rel="stylesheet" href="/static/style.css">
+</head>
+<body>
+<h1>Down for maintenance</h1>
+<p>Short links are unavailable for a moment, please try again in a few minutes.</p>
+</body>
+</html>
+`)
+
+// servedDuringMaintenance are the routes that keep working during maintenance:
+// health checks so load balancers keep the replica, the admin api to switch maintenance off,
+// and the static files the maintenance page uses
+func servedDuringMaintenance(path string) bool {
+	path = strings.TrimPrefix(path, "/v1")
+	return path == "/health" || path == "/admin" || strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/static/")
+}
+
+// Maintenance answers every other route with the maintenance page and a 503 while maintenance mode is on
+func (api shortieAPI) Maintenance(c *gin.Context) {
+	if api.maintenance == nil || !api.maintenance.Load() || servedDuringMaintenance(c.Request.URL.Path) {
+		c.Next()
+		return
+	}
+	if api.maintenanceRetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(api.maintenanceRetryAfter.Seconds())))
+	}
+	page := api.maintenancePage
+	if len(page) == 0 {
+		page = defaultMaintenancePage
+	}
+	c.Data(http.StatusServiceUnavailable, "text/html; charset=utf-8", page)
+	c.Abort()
+}
+
+type maintenanceStatus struct {
+	Maintenance bool `json:"maintenance"`
+}
+
+func (api shortieAPI) GetMaintenance(c *gin.Context) {
+	c.JSON(http.StatusOK, maintenanceStatus{Maintenance: api.maintenance != nil && api.maintenance.Load()})
+}
+
+// SetMaintenance switches maintenance mode on this replica until it restarts, SHORTIE_MAINTENANCE sets it at startup
+func (api shortieAPI) SetMaintenance(c *gin.Context) {
+	if api.maintenance == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "maintenance mode can't be switched")
+		return
+	}
+	var body struct {
+		Maintenance *bool `json:"maintenance"`
+	}
+	if !api.bindJSON(c, &body) {
+		return
+	}
+	if body.Maintenance == nil {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, "maintenance is required")
+		return
+	}
+	api.maintenance.Store(*body.Maintenance)
+	c.JSON(http.StatusOK, maintenanceStatus{Maintenance: *body.Maintenance})
+}
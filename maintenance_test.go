@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenance(t *testing.T) {
+	storage := &LocalStorage{Objects: map[string]URLObject{"111": {ShortID: "111", URL: "https://example.com", Usage: map[string]int64{}}}}
+	api := shortieAPI{
+		storage:               storage,
+		adminToken:            "admin",
+		maintenance:           newSwitch(true),
+		maintenancePage:       []byte("<p>back soon</p>"),
+		maintenanceRetryAfter: 5 * time.Minute,
+	}
+	router := api.GetRouter()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer admin")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w
+	}
+
+	for _, path := range []string{"/shortie/111", "/shortie/111/stats", "/v1/shortie/111/stats", "/about", "/missing"} {
+		w := serve(http.MethodGet, path, "")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, path)
+		assert.Equal(t, "300", w.Header().Get("Retry-After"), path)
+		assert.Equal(t, "<p>back soon</p>", w.Body.String(), path)
+	}
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/health", "").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/v1/health", "").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/static/style.css", "").Code)
+	assert.JSONEq(t, `{"maintenance":true}`, serve(http.MethodGet, "/admin/maintenance", "").Body.String())
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodPut, "/admin/maintenance", `{"maintenance":false}`).Code)
+	assert.Equal(t, http.StatusTemporaryRedirect, serve(http.MethodGet, "/shortie/111", "").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPut, "/admin/maintenance", `{}`).Code)
+}
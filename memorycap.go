@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// linkOverheadBytes approximates what a link takes in memory past its strings and maps, its struct and bookkeeping,
+// and mapEntryOverheadBytes what each entry of its maps takes past its key and value
+const (
+	linkOverheadBytes     = 512
+	mapEntryOverheadBytes = 48
+)
+
+// evictions are tagged with why the link was chosen
+const (
+	evictedExpired = "expired"
+	evictedLRU     = "lru"
+)
+
+// MemoryUsage is how much of its caps the in-memory backend uses, the bytes are an estimate
+type MemoryUsage struct {
+	Links     int   `json:"links"`
+	MaxLinks  int   `json:"maxLinks,omitempty"`
+	Bytes     int64 `json:"estimatedBytes"`
+	MaxBytes  int64 `json:"maxBytes,omitempty"`
+	Evictions int64 `json:"evictions"`
+}
+
+// initMemoryCaps reads the caps of the in-memory backend, zero leaves it unlimited
+func initMemoryCaps(env Environment) (int, int64, error) {
+	maxLinks := 0
+	if env.MemoryMaxLinks != "" {
+		var err error
+		maxLinks, err = strconv.Atoi(env.MemoryMaxLinks)
+		if err != nil || maxLinks < 1 {
+			return 0, 0, fmt.Errorf("invalid SHORTIE_MEMORY_MAX_LINKS %q, expected a number of links", env.MemoryMaxLinks)
+		}
+	}
+	var maxBytes int64
+	if env.MemoryMaxBytes != "" {
+		var err error
+		maxBytes, err = strconv.ParseInt(env.MemoryMaxBytes, 10, 64)
+		if err != nil || maxBytes < 1 {
+			return 0, 0, fmt.Errorf("invalid SHORTIE_MEMORY_MAX_BYTES %q, expected a number of bytes", env.MemoryMaxBytes)
+		}
+	}
+	return maxLinks, maxBytes, nil
+}
+
+// estimatedSize approximates the memory a link takes, its strings and map entries plus a fixed overhead
+func estimatedSize(object URLObject) int64 {
+	size := int64(linkOverheadBytes)
+	for _, field := range []string{object.ShortID, object.URL, object.URLHash, object.SigningSecret, object.Campaign, object.Notes,
+		object.Owner, object.Title, object.ReferrerPolicy, object.ContentHash, object.QuarantineReason} {
+		size += int64(len(field))
+	}
+	for _, entries := range []map[string]string{object.LanguageRules, object.Annotations, object.Headers} {
+		for key, value := range entries {
+			size += int64(len(key)+len(value)) + mapEntryOverheadBytes
+		}
+	}
+	for _, counters := range []map[string]int64{object.Usage, object.RuleUsage} {
+		for key := range counters {
+			size += int64(len(key)) + 8 + mapEntryOverheadBytes
+		}
+	}
+	// the rules are rare and small, their encoding is close enough
+	rules, err := json.Marshal([]any{object.IPRules, object.Schedule, object.ReferrerRules, object.AppLink})
+	if err == nil {
+		size += int64(len(rules))
+	}
+	return size
+}
+
+// stored updates the bookkeeping of a link that was written, the caller holds the lock
+func (storage *LocalStorage) stored(shortID string, resized bool) {
+	if storage.accessed == nil {
+		storage.accessed = map[string]uint64{}
+		storage.sizes = map[string]int64{}
+	}
+	storage.touch(shortID)
+	if !resized {
+		return
+	}
+	size := estimatedSize(storage.Objects[shortID])
+	storage.bytes += size - storage.sizes[shortID]
+	storage.sizes[shortID] = size
+}
+
+// touch marks a link as used, the caller holds the lock
+func (storage *LocalStorage) touch(shortID string) {
+	if storage.accessed == nil {
+		return
+	}
+	storage.clock++
+	storage.accessed[shortID] = storage.clock
+}
+
+// forget drops a link and its bookkeeping, the caller holds the lock
+func (storage *LocalStorage) forget(shortID string) {
+	delete(storage.Objects, shortID)
+	storage.bytes -= storage.sizes[shortID]
+	delete(storage.sizes, shortID)
+	delete(storage.accessed, shortID)
+}
+
+func (storage *LocalStorage) overCapacity() bool {
+	return (storage.maxLinks > 0 && len(storage.Objects) > storage.maxLinks) ||
+		(storage.maxBytes > 0 && storage.bytes > storage.maxBytes)
+}
+
+// evict makes room once the links go past a cap, the caller holds the lock.
+// Expired links go first, then the links read or written the longest ago. Locked links are never evicted,
+// and neither is the link just written, so a cap smaller than one link keeps that link.
+func (storage *LocalStorage) evict(now time.Time) {
+	if !storage.overCapacity() {
+		return
+	}
+	for shortID, object := range storage.Objects {
+		if object.Expiration != 0 && now.Unix() >= object.Expiration && !object.Locked {
+			storage.forget(shortID)
+			storage.evicted(evictedExpired)
+		}
+	}
+	for storage.overCapacity() {
+		shortID, found := storage.leastRecentlyUsed()
+		if !found {
+			return
+		}
+		storage.forget(shortID)
+		storage.evicted(evictedLRU)
+	}
+}
+
+// leastRecentlyUsed finds the unlocked link used the longest ago, other than the most recent one
+func (storage *LocalStorage) leastRecentlyUsed() (string, bool) {
+	oldest := ""
+	found := false
+	for shortID, object := range storage.Objects {
+		used := storage.accessed[shortID]
+		if object.Locked || used == storage.clock {
+			continue
+		}
+		if !found || used < storage.accessed[oldest] {
+			oldest = shortID
+			found = true
+		}
+	}
+	return oldest, found
+}
+
+func (storage *LocalStorage) evicted(reason string) {
+	storage.evictions++
+	if storage.metrics != nil {
+		storage.metrics.Count(metricLocalEvictions, 1, map[string]string{"reason": reason})
+	}
+}
+
+// MemoryUsage reports the links kept against the caps
+func (storage *LocalStorage) MemoryUsage() MemoryUsage {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	return MemoryUsage{
+		Links:     len(storage.Objects),
+		MaxLinks:  storage.maxLinks,
+		Bytes:     storage.bytes,
+		MaxBytes:  storage.maxBytes,
+		Evictions: storage.evictions,
+	}
+}
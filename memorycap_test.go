@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCappedLocalStorageContract(t *testing.T) {
+	testStorageContract(t, func(t *testing.T) urlStorage {
+		return &LocalStorage{Objects: map[string]URLObject{}, maxLinks: 1000, maxBytes: 1 << 20}
+	})
+}
+
+func TestLocalStorageEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	aggregator := newMetricsAggregator()
+	storage := &LocalStorage{Objects: map[string]URLObject{}, maxLinks: 2, metrics: aggregator}
+	require.NoError(t, storage.SaveURL(ctx, URLObject{ShortID: "a", URL: "https://example.com/a"}))
+	require.NoError(t, storage.SaveURL(ctx, URLObject{ShortID: "b", URL: "https://example.com/b"}))
+	// reading a keeps it, b is used the longest ago when c comes in
+	_, err := storage.GetURL(ctx, "a")
+	require.NoError(t, err)
+	require.NoError(t, storage.SaveURL(ctx, URLObject{ShortID: "c", URL: "https://example.com/c"}))
+
+	shortIDs, err := storage.ListShortIDs(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "c"}, shortIDs)
+	assert.Equal(t, 1.0, findMetric(aggregator.drain(), metricLocalEvictions, map[string]string{"reason": evictedLRU}).sum)
+	assert.Equal(t, int64(1), storage.MemoryUsage().Evictions)
+}
+
+func TestLocalStorageEvictsExpiredFirst(t *testing.T) {
+	ctx := context.Background()
+	aggregator := newMetricsAggregator()
+	storage := &LocalStorage{Objects: map[string]URLObject{}, maxLinks: 2, metrics: aggregator}
+	require.NoError(t, storage.SaveURL(ctx, URLObject{ShortID: "old", URL: "https://example.com/old"}))
+	require.NoError(t, storage.SaveURL(ctx, URLObject{ShortID: "expired", URL: "https://example.com/expired", Expiration: time.Now().Add(-time.Minute).Unix()}))
+	require.NoError(t, storage.SaveURL(ctx, URLObject{ShortID: "new", URL: "https://example.com/new"}))
+
+	shortIDs, err := storage.ListShortIDs(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"old", "new"}, shortIDs)
+	assert.Equal(t, 1.0, findMetric(aggregator.drain(), metricLocalEvictions, map[string]string{"reason": evictedExpired}).sum)
+}
+
+func TestLocalStorageKeepsLockedLinks(t *testing.T) {
+	ctx := context.Background()
+	storage := &LocalStorage{Objects: map[string]URLObject{}, maxLinks: 1}
+	require.NoError(t, storage.ImportURL(ctx, URLObject{ShortID: "locked", URL: "https://example.com/locked", Locked: true}))
+	require.NoError(t, storage.SaveURL(ctx, URLObject{ShortID: "a", URL: "https://example.com/a"}))
+	require.NoError(t, storage.SaveURL(ctx, URLObject{ShortID: "b", URL: "https://example.com/b"}))
+
+	// a link past the cap that only a locked link could make room for stays, over the cap
+	shortIDs, err := storage.ListShortIDs(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"locked", "b"}, shortIDs)
+}
+
+func TestLocalStorageByteCap(t *testing.T) {
+	ctx := context.Background()
+	// the links all take the same space, saving fills in their url hash
+	link := URLObject{ShortID: "a", URL: "https://example.com/a", URLHash: hashURL("https://example.com/a")}
+	size := estimatedSize(link)
+	storage := &LocalStorage{Objects: map[string]URLObject{}, maxBytes: 2*size + size/2}
+	for _, shortID := range []string{"a", "b", "c"} {
+		require.NoError(t, storage.SaveURL(ctx, URLObject{ShortID: shortID, URL: "https://example.com/" + shortID}))
+	}
+	usage := storage.MemoryUsage()
+	assert.Equal(t, 2, usage.Links)
+	assert.Equal(t, 2*size, usage.Bytes)
+
+	// deleted links give their bytes back
+	require.NoError(t, storage.DeleteURL(ctx, "c"))
+	assert.Equal(t, size, storage.MemoryUsage().Bytes)
+	_, err := storage.ConsumeURL(ctx, "b")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), storage.MemoryUsage().Bytes)
+}
+
+func TestInitMemoryCaps(t *testing.T) {
+	env, err := loadEnvironment("")
+	require.NoError(t, err)
+	maxLinks, maxBytes, err := initMemoryCaps(env)
+	require.NoError(t, err)
+	assert.Zero(t, maxLinks)
+	assert.Zero(t, maxBytes)
+
+	env.MemoryMaxLinks, env.MemoryMaxBytes = "10000", "67108864"
+	maxLinks, maxBytes, err = initMemoryCaps(env)
+	require.NoError(t, err)
+	assert.Equal(t, 10000, maxLinks)
+	assert.Equal(t, int64(64<<20), maxBytes)
+
+	env.MemoryMaxLinks = "0"
+	_, _, err = initMemoryCaps(env)
+	assert.ErrorContains(t, err, "SHORTIE_MEMORY_MAX_LINKS")
+	env.MemoryMaxLinks, env.MemoryMaxBytes = "", "64MB"
+	_, _, err = initMemoryCaps(env)
+	assert.ErrorContains(t, err, "SHORTIE_MEMORY_MAX_BYTES")
+}
+
+func TestHealthReportsMemoryUsage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage := &LocalStorage{Objects: map[string]URLObject{}, maxLinks: 10}
+	require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "a", URL: "https://example.com/a"}))
+	api := shortieAPI{storage: storage}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, "/health", nil)
+	api.GetHealth(c)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"memory":{"links":1,"maxLinks":10,`)
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// metricsSink receives the service's metrics, each exporter batches and ships them its own way
+type metricsSink interface {
+	// Count adds to a counter
+	Count(name string, value int64, tags map[string]string)
+	// Timing records how long something took
+	Timing(name string, duration time.Duration, tags map[string]string)
+}
+
+// the metrics the service records
+const (
+	// metricRequests counts requests by route and status, metricRequestLatency times them by route
+	metricRequests       = "requests"
+	metricRequestLatency = "request_latency"
+	// metricRedirects counts the requests for short links by status
+	metricRedirects = "redirects"
+	// metricStorageLatency times storage calls by operation, metricStorageErrors counts the ones that failed
+	metricStorageLatency = "storage_latency"
+	metricStorageErrors  = "storage_errors"
+	// metricContentChanges counts the destinations the content check found changed
+	metricContentChanges = "content_changes"
+	// metricLocalEvictions counts the links the in-memory backend evicted to stay under its caps, by reason
+	metricLocalEvictions = "local_evictions"
+	// metricSuspectClicks counts the clicks fraud detection found suspect, by reason
+	metricSuspectClicks = "suspect_clicks"
+)
+
+// redirectRoutes are the routes that follow short links
+var redirectRoutes = map[string]bool{"/shortie/:id": true, "/t/:team/:alias": true, passthroughRoute: true}
+
+// RecordMetrics counts and times every request by its route pattern, which keeps the number of series bounded
+func (api shortieAPI) RecordMetrics(c *gin.Context) {
+	if api.metrics == nil {
+		c.Next()
+		return
+	}
+	start := time.Now()
+	c.Next()
+
+	route := routeOf(c)
+	if route == "" {
+		route = "unmatched"
+	}
+	status := strconv.Itoa(c.Writer.Status())
+	api.metrics.Count(metricRequests, 1, map[string]string{"route": route, "status": status})
+	api.metrics.Timing(metricRequestLatency, time.Since(start), map[string]string{"route": route})
+	if c.Request.Method == "GET" && redirectRoutes[route] {
+		api.metrics.Count(metricRedirects, 1, map[string]string{"status": status})
+	}
+}
+
+// metricKey identifies a series by its name and tags
+func metricKey(name string, tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return name + "|" + strings.Join(pairs, ",")
+}
+
+// aggregatedMetric sums a counter, or summarizes timings in milliseconds, until the next flush
+type aggregatedMetric struct {
+	name   string
+	tags   map[string]string
+	timing bool
+	count  float64
+	sum    float64
+	min    float64
+	max    float64
+}
+
+// metricsAggregator collects metrics between flushes for exporters that ship them in batches
+type metricsAggregator struct {
+	lock    sync.Mutex
+	metrics map[string]*aggregatedMetric
+}
+
+func newMetricsAggregator() *metricsAggregator {
+	return &metricsAggregator{metrics: map[string]*aggregatedMetric{}}
+}
+
+func (aggregator *metricsAggregator) add(name string, tags map[string]string, value float64, timing bool) {
+	key := metricKey(name, tags)
+	aggregator.lock.Lock()
+	defer aggregator.lock.Unlock()
+
+	metric, found := aggregator.metrics[key]
+	if !found {
+		metric = &aggregatedMetric{name: name, tags: tags, timing: timing, min: value, max: value}
+		aggregator.metrics[key] = metric
+	}
+	metric.count++
+	metric.sum += value
+	metric.min = min(metric.min, value)
+	metric.max = max(metric.max, value)
+}
+
+func (aggregator *metricsAggregator) Count(name string, value int64, tags map[string]string) {
+	aggregator.add(name, tags, float64(value), false)
+}
+
+func (aggregator *metricsAggregator) Timing(name string, duration time.Duration, tags map[string]string) {
+	aggregator.add(name, tags, float64(duration.Microseconds())/1000, true)
+}
+
+// drain returns the metrics collected since the last drain, ordered by series
+func (aggregator *metricsAggregator) drain() []*aggregatedMetric {
+	aggregator.lock.Lock()
+	collected := aggregator.metrics
+	aggregator.metrics = map[string]*aggregatedMetric{}
+	aggregator.lock.Unlock()
+
+	keys := make([]string, 0, len(collected))
+	for key := range collected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	metrics := make([]*aggregatedMetric, 0, len(keys))
+	for _, key := range keys {
+		metrics = append(metrics, collected[key])
+	}
+	return metrics
+}
+
+// multiMetricsSink sends every metric to several exporters
+type multiMetricsSink []metricsSink
+
+func (sinks multiMetricsSink) Count(name string, value int64, tags map[string]string) {
+	for _, sink := range sinks {
+		sink.Count(name, value, tags)
+	}
+}
+
+func (sinks multiMetricsSink) Timing(name string, duration time.Duration, tags map[string]string) {
+	for _, sink := range sinks {
+		sink.Timing(name, duration, tags)
+	}
+}
+
+// initMetrics starts the comma separated exporters of SHORTIE_METRICS, it returns nil if there are none.
+// Exporters that flush in the background run until ctx is done.
+func initMetrics(ctx context.Context, env Environment) (metricsSink, error) {
+	var sinks multiMetricsSink
+	for _, exporter := range strings.Split(env.Metrics, ",") {
+		exporter = strings.TrimSpace(exporter)
+		switch exporter {
+		case "":
+			continue
+		case "cloudwatch":
+			interval, err := time.ParseDuration(env.MetricsInterval)
+			if err != nil || interval <= 0 {
+				return nil, fmt.Errorf("invalid SHORTIE_METRICS_INTERVAL %q", env.MetricsInterval)
+			}
+			sink, err := NewCloudWatchSink(ctx, env, env.CloudWatchNamespace)
+			if err != nil {
+				return nil, err
+			}
+			go sink.Run(ctx, interval)
+			sinks = append(sinks, sink)
+		case "statsd":
+			sink, err := initStatsDSink(env)
+			if err != nil {
+				return nil, err
+			}
+			go sink.Run(ctx, statsdFlushInterval)
+			sinks = append(sinks, sink)
+		default:
+			return nil, fmt.Errorf("unknown SHORTIE_METRICS exporter %q, expected cloudwatch or statsd", exporter)
+		}
+	}
+	switch len(sinks) {
+	case 0:
+		return nil, nil
+	case 1:
+		return sinks[0], nil
+	default:
+		return sinks, nil
+	}
+}
+
+// MeteredStorage times the calls to another storage and counts the ones that fail, by operation
+type MeteredStorage struct {
+	urlStorage
+	metrics metricsSink
+}
+
+func NewMeteredStorage(storage urlStorage, metrics metricsSink) *MeteredStorage {
+	return &MeteredStorage{urlStorage: storage, metrics: metrics}
+}
+
+// record reports a call that started at start, errNotFound, errNotOwner and errLocked are answers rather than backend errors
+func (metered *MeteredStorage) record(operation string, start time.Time, err error) {
+	tags := map[string]string{"operation": operation}
+	metered.metrics.Timing(metricStorageLatency, time.Since(start), tags)
+	if err != nil && !errors.Is(err, errNotFound) && !errors.Is(err, errNotOwner) && !errors.Is(err, errLocked) {
+		metered.metrics.Count(metricStorageErrors, 1, tags)
+	}
+}
+
+func (metered *MeteredStorage) SaveURL(ctx context.Context, object URLObject) error {
+	start := time.Now()
+	err := metered.urlStorage.SaveURL(ctx, object)
+	metered.record("SaveURL", start, err)
+	return err
+}
+
+func (metered *MeteredStorage) GetURL(ctx context.Context, shortID string) (*URLObject, error) {
+	start := time.Now()
+	object, err := metered.urlStorage.GetURL(ctx, shortID)
+	metered.record("GetURL", start, err)
+	return object, err
+}
+
+func (metered *MeteredStorage) IncrementUsage(ctx context.Context, shortID string, rule string, clicks int64, weight int64) error {
+	start := time.Now()
+	err := metered.urlStorage.IncrementUsage(ctx, shortID, rule, clicks, weight)
+	metered.record("IncrementUsage", start, err)
+	return err
+}
+
+func (metered *MeteredStorage) RollupUsage(ctx context.Context, shortID string, before time.Time) error {
+	start := time.Now()
+	err := metered.urlStorage.RollupUsage(ctx, shortID, before)
+	metered.record("RollupUsage", start, err)
+	return err
+}
+
+func (metered *MeteredStorage) SetPaused(ctx context.Context, shortID string, paused bool) error {
+	start := time.Now()
+	err := metered.urlStorage.SetPaused(ctx, shortID, paused)
+	metered.record("SetPaused", start, err)
+	return err
+}
+
+func (metered *MeteredStorage) SetQuarantined(ctx context.Context, shortID string, quarantined bool) error {
+	start := time.Now()
+	err := metered.urlStorage.SetQuarantined(ctx, shortID, quarantined)
+	metered.record("SetQuarantined", start, err)
+	return err
+}
+
+func (metered *MeteredStorage) SetLocked(ctx context.Context, shortID string, locked bool) error {
+	start := time.Now()
+	err := metered.urlStorage.SetLocked(ctx, shortID, locked)
+	metered.record("SetLocked", start, err)
+	return err
+}
+
+func (metered *MeteredStorage) SetContentHash(ctx context.Context, shortID string, hash string) error {
+	start := time.Now()
+	err := metered.urlStorage.SetContentHash(ctx, shortID, hash)
+	metered.record("SetContentHash", start, err)
+	return err
+}
+
+func (metered *MeteredStorage) SetTitle(ctx context.Context, shortID string, title string) error {
+	start := time.Now()
+	err := metered.urlStorage.SetTitle(ctx, shortID, title)
+	metered.record("SetTitle", start, err)
+	return err
+}
+
+func (metered *MeteredStorage) SetExpiration(ctx context.Context, shortID string, expiration int64) error {
+	start := time.Now()
+	err := metered.urlStorage.SetExpiration(ctx, shortID, expiration)
+	metered.record("SetExpiration", start, err)
+	return err
+}
+
+func (metered *MeteredStorage) DeleteURL(ctx context.Context, shortID string) error {
+	start := time.Now()
+	err := metered.urlStorage.DeleteURL(ctx, shortID)
+	metered.record("DeleteURL", start, err)
+	return err
+}
+
+func (metered *MeteredStorage) ConsumeURL(ctx context.Context, shortID string) (bool, error) {
+	start := time.Now()
+	consumed, err := metered.urlStorage.ConsumeURL(ctx, shortID)
+	metered.record("ConsumeURL", start, err)
+	return consumed, err
+}
+
+func (metered *MeteredStorage) GetStatistics(ctx context.Context, shortID string) (Statistics, error) {
+	start := time.Now()
+	statistics, err := metered.urlStorage.GetStatistics(ctx, shortID)
+	metered.record("GetStatistics", start, err)
+	return statistics, err
+}
+
+func (metered *MeteredStorage) GetStatisticsBatch(ctx context.Context, shortIDs []string) (map[string]Statistics, error) {
+	start := time.Now()
+	statistics, err := metered.urlStorage.GetStatisticsBatch(ctx, shortIDs)
+	metered.record("GetStatisticsBatch", start, err)
+	return statistics, err
+}
+
+func (metered *MeteredStorage) FindByURL(ctx context.Context, url string) (map[string]string, error) {
+	start := time.Now()
+	links, err := metered.urlStorage.FindByURL(ctx, url)
+	metered.record("FindByURL", start, err)
+	return links, err
+}
+
+func (metered *MeteredStorage) FindByCampaign(ctx context.Context, campaign string) ([]string, error) {
+	start := time.Now()
+	shortIDs, err := metered.urlStorage.FindByCampaign(ctx, campaign)
+	metered.record("FindByCampaign", start, err)
+	return shortIDs, err
+}
+
+func (metered *MeteredStorage) HealthCheck(ctx context.Context) (HealthStatus, error) {
+	start := time.Now()
+	status, err := metered.urlStorage.HealthCheck(ctx)
+	metered.record("HealthCheck", start, err)
+	return status, err
+}
+
+func (metered *MeteredStorage) ListShortIDs(ctx context.Context) ([]string, error) {
+	start := time.Now()
+	shortIDs, err := metered.urlStorage.ListShortIDs(ctx)
+	metered.record("ListShortIDs", start, err)
+	return shortIDs, err
+}
+
+func (metered *MeteredStorage) ListPage(ctx context.Context, after string, limit int) ([]string, string, error) {
+	start := time.Now()
+	shortIDs, next, err := metered.urlStorage.ListPage(ctx, after, limit)
+	metered.record("ListPage", start, err)
+	return shortIDs, next, err
+}
+
+func (metered *MeteredStorage) ImportURL(ctx context.Context, object URLObject) error {
+	start := time.Now()
+	err := metered.urlStorage.ImportURL(ctx, object)
+	metered.record("ImportURL", start, err)
+	return err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// findMetric returns the aggregated series of a name and tags, nil if nothing was recorded for it
+func findMetric(metrics []*aggregatedMetric, name string, tags map[string]string) *aggregatedMetric {
+	for _, metric := range metrics {
+		if metricKey(metric.name, metric.tags) == metricKey(name, tags) {
+			return metric
+		}
+	}
+	return nil
+}
+
+func TestMetricsAggregator(t *testing.T) {
+	aggregator := newMetricsAggregator()
+	aggregator.Count("requests", 1, map[string]string{"route": "/a", "status": "200"})
+	aggregator.Count("requests", 2, map[string]string{"status": "200", "route": "/a"})
+	aggregator.Count("requests", 1, map[string]string{"route": "/b", "status": "200"})
+	aggregator.Timing("request_latency", 10*time.Millisecond, map[string]string{"route": "/a"})
+	aggregator.Timing("request_latency", 30*time.Millisecond, map[string]string{"route": "/a"})
+
+	metrics := aggregator.drain()
+	require.Len(t, metrics, 3)
+	counter := findMetric(metrics, "requests", map[string]string{"route": "/a", "status": "200"})
+	require.NotNil(t, counter)
+	assert.False(t, counter.timing)
+	assert.Equal(t, 3.0, counter.sum)
+	timing := findMetric(metrics, "request_latency", map[string]string{"route": "/a"})
+	require.NotNil(t, timing)
+	assert.True(t, timing.timing)
+	assert.Equal(t, 2.0, timing.count)
+	assert.Equal(t, 40.0, timing.sum)
+	assert.Equal(t, 10.0, timing.min)
+	assert.Equal(t, 30.0, timing.max)
+
+	// every flush starts over
+	assert.Empty(t, aggregator.drain())
+}
+
+func TestRecordMetrics(t *testing.T) {
+	aggregator := newMetricsAggregator()
+	api := shortieAPI{metrics: aggregator}
+	router := gin.New()
+	router.Use(api.RecordMetrics)
+	router.GET("/shortie/:id", func(c *gin.Context) { c.Status(http.StatusFound) })
+	router.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+	for _, path := range []string{"/shortie/abc", "/shortie/def", "/health", "/missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	metrics := aggregator.drain()
+	// requests are tagged with their route pattern, not the path
+	assert.Equal(t, 2.0, findMetric(metrics, metricRequests, map[string]string{"route": "/shortie/:id", "status": "302"}).sum)
+	assert.Equal(t, 1.0, findMetric(metrics, metricRequests, map[string]string{"route": "/health", "status": "200"}).sum)
+	assert.Equal(t, 1.0, findMetric(metrics, metricRequests, map[string]string{"route": "unmatched", "status": "404"}).sum)
+	assert.Equal(t, 2.0, findMetric(metrics, metricRequestLatency, map[string]string{"route": "/shortie/:id"}).count)
+	assert.Equal(t, 2.0, findMetric(metrics, metricRedirects, map[string]string{"status": "302"}).sum)
+}
+
+func TestMeteredStorageContract(t *testing.T) {
+	testStorageContract(t, func(t *testing.T) urlStorage {
+		return NewMeteredStorage(newContractLocalStorage(t), newMetricsAggregator())
+	})
+}
+
+func TestMeteredStorage(t *testing.T) {
+	ctx := context.Background()
+	aggregator := newMetricsAggregator()
+	storage := NewMeteredStorage(NewFaultyStorage(newContractLocalStorage(t), 0, 0, 1), aggregator)
+	_, err := storage.GetURL(ctx, "abc")
+	assert.True(t, errors.Is(err, errInjectedFault))
+
+	metrics := aggregator.drain()
+	assert.Equal(t, 1.0, findMetric(metrics, metricStorageLatency, map[string]string{"operation": "GetURL"}).count)
+	assert.Equal(t, 1.0, findMetric(metrics, metricStorageErrors, map[string]string{"operation": "GetURL"}).sum)
+
+	// missing links aren't backend errors
+	storage = NewMeteredStorage(newContractLocalStorage(t), aggregator)
+	err = storage.SetExpiration(ctx, "abc", 0)
+	assert.True(t, errors.Is(err, errNotFound))
+	metrics = aggregator.drain()
+	assert.NotNil(t, findMetric(metrics, metricStorageLatency, map[string]string{"operation": "SetExpiration"}))
+	assert.Nil(t, findMetric(metrics, metricStorageErrors, map[string]string{"operation": "SetExpiration"}))
+}
+
+func TestInitMetrics(t *testing.T) {
+	env, err := loadEnvironment("")
+	require.NoError(t, err)
+	metrics, err := initMetrics(context.Background(), env)
+	require.NoError(t, err)
+	assert.Nil(t, metrics)
+
+	env.Metrics = "prometheus"
+	_, err = initMetrics(context.Background(), env)
+	assert.ErrorContains(t, err, "unknown SHORTIE_METRICS exporter")
+
+	env.Metrics = "cloudwatch"
+	env.MetricsInterval = "0s"
+	_, err = initMetrics(context.Background(), env)
+	assert.ErrorContains(t, err, "invalid SHORTIE_METRICS_INTERVAL")
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// MigratingStorage moves links from one backend to another without downtime
+// reads are served by the source while every change is written to both backends (the dual-write window),
+// a copy pass then brings existing links over and a verification pass compares the two
+// once verification finds no mismatches the service can be pointed at the target
+type MigratingStorage struct {
+	urlStorage
+	target urlStorage
+
+	lock   sync.Mutex
+	status MigrationStatus
+}
+
+type MigrationStatus struct {
+	State      string     `json:"state"`
+	Copied     int        `json:"copied"`
+	Failed     int        `json:"failed"`
+	Verified   int        `json:"verified"`
+	Mismatches int        `json:"mismatches"`
+	Mismatched []string   `json:"mismatched"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+const (
+	migrationPending   = "pending"
+	migrationCopying   = "copying"
+	migrationVerifying = "verifying"
+	migrationVerified  = "verified"
+	// migrationMismatched is a verification pass that found links that differ, the target isn't ready yet
+	migrationMismatched = "mismatched"
+	migrationFailed     = "failed"
+)
+
+var errMigrationRunning = errors.New("a migration pass is already running")
+
+// maxReportedMismatches keeps the status small when the backends are far apart
+const maxReportedMismatches = 100
+
+func NewMigratingStorage(source urlStorage, target urlStorage) *MigratingStorage {
+	return &MigratingStorage{
+		urlStorage: source,
+		target:     target,
+		status:     MigrationStatus{State: migrationPending, Mismatched: []string{}},
+	}
+}
+
+// dualWrite mirrors a change to the target, a failure there is logged for the verification pass to catch
+// rather than failing a request the source already served
+func (migrating *MigratingStorage) dualWrite(err error, write func() error) error {
+	if err != nil {
+		return err
+	}
+	targetErr := write()
+	if targetErr != nil {
+		log.Println("error: migration target write failed: " + targetErr.Error())
+	}
+	return nil
+}
+
+func (migrating *MigratingStorage) SaveURL(ctx context.Context, object URLObject) error {
+	return migrating.dualWrite(migrating.urlStorage.SaveURL(ctx, object), func() error {
+		return migrating.target.SaveURL(ctx, object)
+	})
+}
+
+func (migrating *MigratingStorage) ImportURL(ctx context.Context, object URLObject) error {
+	return migrating.dualWrite(migrating.urlStorage.ImportURL(ctx, object), func() error {
+		return migrating.target.ImportURL(ctx, object)
+	})
+}
+
+func (migrating *MigratingStorage) IncrementUsage(ctx context.Context, shortID string, rule string, clicks int64, weight int64) error {
+	return migrating.dualWrite(migrating.urlStorage.IncrementUsage(ctx, shortID, rule, clicks, weight), func() error {
+		return migrating.target.IncrementUsage(ctx, shortID, rule, clicks, weight)
+	})
+}
+
+func (migrating *MigratingStorage) RollupUsage(ctx context.Context, shortID string, before time.Time) error {
+	return migrating.dualWrite(migrating.urlStorage.RollupUsage(ctx, shortID, before), func() error {
+		return migrating.target.RollupUsage(ctx, shortID, before)
+	})
+}
+
+func (migrating *MigratingStorage) SetPaused(ctx context.Context, shortID string, paused bool) error {
+	return migrating.dualWrite(migrating.urlStorage.SetPaused(ctx, shortID, paused), func() error {
+		return migrating.target.SetPaused(ctx, shortID, paused)
+	})
+}
+
+func (migrating *MigratingStorage) SetExpiration(ctx context.Context, shortID string, expiration int64) error {
+	return migrating.dualWrite(migrating.urlStorage.SetExpiration(ctx, shortID, expiration), func() error {
+		return migrating.target.SetExpiration(ctx, shortID, expiration)
+	})
+}
+
+func (migrating *MigratingStorage) SetQuarantined(ctx context.Context, shortID string, quarantined bool) error {
+	return migrating.dualWrite(migrating.urlStorage.SetQuarantined(ctx, shortID, quarantined), func() error {
+		return migrating.target.SetQuarantined(ctx, shortID, quarantined)
+	})
+}
+
+func (migrating *MigratingStorage) SetContentHash(ctx context.Context, shortID string, hash string) error {
+	return migrating.dualWrite(migrating.urlStorage.SetContentHash(ctx, shortID, hash), func() error {
+		return migrating.target.SetContentHash(ctx, shortID, hash)
+	})
+}
+
+func (migrating *MigratingStorage) SetTitle(ctx context.Context, shortID string, title string) error {
+	return migrating.dualWrite(migrating.urlStorage.SetTitle(ctx, shortID, title), func() error {
+		return migrating.target.SetTitle(ctx, shortID, title)
+	})
+}
+
+func (migrating *MigratingStorage) SetLocked(ctx context.Context, shortID string, locked bool) error {
+	return migrating.dualWrite(migrating.urlStorage.SetLocked(ctx, shortID, locked), func() error {
+		return migrating.target.SetLocked(ctx, shortID, locked)
+	})
+}
+
+func (migrating *MigratingStorage) DeleteURL(ctx context.Context, shortID string) error {
+	return migrating.dualWrite(migrating.urlStorage.DeleteURL(ctx, shortID), func() error {
+		return migrating.target.DeleteURL(ctx, shortID)
+	})
+}
+
+func (migrating *MigratingStorage) ConsumeURL(ctx context.Context, shortID string) (bool, error) {
+	consumed, err := migrating.urlStorage.ConsumeURL(ctx, shortID)
+	if consumed {
+		_ = migrating.dualWrite(err, func() error {
+			return migrating.target.DeleteURL(ctx, shortID)
+		})
+	}
+	return consumed, err
+}
+
+func (migrating *MigratingStorage) Status() MigrationStatus {
+	migrating.lock.Lock()
+	defer migrating.lock.Unlock()
+
+	status := migrating.status
+	status.Mismatched = append([]string{}, migrating.status.Mismatched...)
+	return status
+}
+
+func (migrating *MigratingStorage) Running() bool {
+	state := migrating.Status().State
+	return state == migrationCopying || state == migrationVerifying
+}
+
+func (migrating *MigratingStorage) updateStatus(update func(status *MigrationStatus)) {
+	migrating.lock.Lock()
+	defer migrating.lock.Unlock()
+	update(&migrating.status)
+}
+
+// begin resets the status for a new pass unless one is already running
+func (migrating *MigratingStorage) begin(state string) error {
+	migrating.lock.Lock()
+	defer migrating.lock.Unlock()
+
+	if migrating.status.State == migrationCopying || migrating.status.State == migrationVerifying {
+		return errMigrationRunning
+	}
+	now := time.Now()
+	migrating.status = MigrationStatus{State: state, Mismatched: []string{}, StartedAt: &now}
+	return nil
+}
+
+func (migrating *MigratingStorage) fail(err error) error {
+	migrating.updateStatus(func(status *MigrationStatus) {
+		status.State = migrationFailed
+		now := time.Now()
+		status.FinishedAt = &now
+	})
+	return err
+}
+
+// Copy copies every link to the target and then verifies the copy
+func (migrating *MigratingStorage) Copy(ctx context.Context) error {
+	err := migrating.begin(migrationCopying)
+	if err != nil {
+		return err
+	}
+	err = migrating.copy(ctx)
+	if err != nil {
+		return migrating.fail(err)
+	}
+	return migrating.verify(ctx)
+}
+
+func (migrating *MigratingStorage) copy(ctx context.Context) error {
+	shortIDs, err := migrating.urlStorage.ListShortIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, shortID := range shortIDs {
+		object, err := migrating.exportURL(ctx, shortID)
+		if err == nil && object != nil {
+			err = migrating.target.ImportURL(ctx, *object)
+		}
+		migrating.updateStatus(func(status *MigrationStatus) {
+			if err != nil {
+				log.Printf("error: failed to migrate %s: %s\n", shortID, err.Error())
+				status.Failed++
+			} else {
+				status.Copied++
+			}
+		})
+	}
+	return nil
+}
+
+// exportURL reads a link along with its full usage, which on some backends is stored apart from the link
+func (migrating *MigratingStorage) exportURL(ctx context.Context, shortID string) (*URLObject, error) {
+	object, err := migrating.urlStorage.GetURL(ctx, shortID)
+	if err != nil || object == nil {
+		return object, err
+	}
+	statistics, err := migrating.urlStorage.GetStatistics(ctx, shortID)
+	if err != nil {
+		return nil, err
+	}
+	exported := *object
+	exported.Usage = statistics.Usage
+	exported.RuleUsage = statistics.RuleUsage
+	return &exported, nil
+}
+
+// Verify compares every link between the backends, usage that changes during the pass can show up as a mismatch
+func (migrating *MigratingStorage) Verify(ctx context.Context) error {
+	err := migrating.begin(migrationVerifying)
+	if err != nil {
+		return err
+	}
+	return migrating.verify(ctx)
+}
+
+func (migrating *MigratingStorage) verify(ctx context.Context) error {
+	migrating.updateStatus(func(status *MigrationStatus) {
+		status.State = migrationVerifying
+	})
+
+	shortIDs, err := migrating.urlStorage.ListShortIDs(ctx)
+	if err != nil {
+		return migrating.fail(err)
+	}
+
+	for _, shortID := range shortIDs {
+		matches, err := migrating.matches(ctx, shortID)
+		if err != nil {
+			log.Printf("error: failed to verify %s: %s\n", shortID, err.Error())
+		}
+		migrating.updateStatus(func(status *MigrationStatus) {
+			status.Verified++
+			if !matches {
+				status.Mismatches++
+				if len(status.Mismatched) < maxReportedMismatches {
+					status.Mismatched = append(status.Mismatched, shortID)
+				}
+			}
+		})
+	}
+
+	migrating.updateStatus(func(status *MigrationStatus) {
+		// links that failed to copy or differ keep the migration from counting as verified
+		switch {
+		case status.Failed > 0:
+			status.State = migrationFailed
+		case status.Mismatches > 0:
+			status.State = migrationMismatched
+		default:
+			status.State = migrationVerified
+		}
+		now := time.Now()
+		status.FinishedAt = &now
+	})
+	return nil
+}
+
+func (migrating *MigratingStorage) matches(ctx context.Context, shortID string) (bool, error) {
+	source, err := migrating.exportURL(ctx, shortID)
+	if err != nil {
+		return false, err
+	}
+	target, err := migrating.target.GetURL(ctx, shortID)
+	if err != nil {
+		return false, err
+	}
+	targetStatistics, err := migrating.target.GetStatistics(ctx, shortID)
+	if err != nil {
+		return false, err
+	}
+	if source == nil || target == nil {
+		return source == target, nil
+	}
+
+	return !linkDiffers(*source, *target) &&
+		reflect.DeepEqual(source.Usage, targetStatistics.Usage) &&
+		reflect.DeepEqual(source.RuleUsage, targetStatistics.RuleUsage), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigratingStorage(t *testing.T) {
+	ctx := context.Background()
+	source := &LocalStorage{Objects: map[string]URLObject{}, lock: sync.Mutex{}}
+	target := &LocalStorage{Objects: map[string]URLObject{}, lock: sync.Mutex{}}
+
+	// links that existed before the migration only reach the target through the copy pass
+	require.NoError(t, source.SaveURL(ctx, URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal"}))
+	require.NoError(t, source.IncrementUsage(ctx, "111", "mobile", 1, 1))
+
+	migrating := NewMigratingStorage(source, target)
+	require.NoError(t, migrating.SaveURL(ctx, URLObject{ShortID: "222", URL: "http://redirection.com/other"}))
+	require.NoError(t, migrating.IncrementUsage(ctx, "222", "", 1, 1))
+
+	object, err := target.GetURL(ctx, "222")
+	require.NoError(t, err)
+	require.NotNil(t, object, "changes during the migration are written to both backends")
+
+	require.NoError(t, migrating.Verify(ctx))
+	status := migrating.Status()
+	assert.Equal(t, migrationMismatched, status.State)
+	assert.Equal(t, 1, status.Mismatches)
+	assert.Equal(t, []string{"111"}, status.Mismatched)
+
+	require.NoError(t, migrating.Copy(ctx))
+	status = migrating.Status()
+	assert.Equal(t, migrationVerified, status.State)
+	assert.Equal(t, 2, status.Copied)
+	assert.Equal(t, 2, status.Verified)
+	assert.Zero(t, status.Mismatches)
+	assert.Empty(t, status.Mismatched)
+
+	statistics, err := target.GetStatistics(ctx, "111")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"mobile": 1}, statistics.RuleUsage, "usage is copied along with the link")
+
+	// the copy doesn't share usage with the source
+	require.NoError(t, source.IncrementUsage(ctx, "111", "mobile", 1, 1))
+	require.NoError(t, migrating.Verify(ctx))
+	assert.Equal(t, []string{"111"}, migrating.Status().Mismatched)
+	assert.Equal(t, migrationMismatched, migrating.Status().State)
+
+	require.NoError(t, migrating.DeleteURL(ctx, "222"))
+	object, err = target.GetURL(ctx, "222")
+	require.NoError(t, err)
+	assert.Nil(t, object)
+}
+
+func TestMigrationCountsEveryMismatch(t *testing.T) {
+	ctx := context.Background()
+	source := &LocalStorage{Objects: map[string]URLObject{}, lock: sync.Mutex{}}
+	target := &LocalStorage{Objects: map[string]URLObject{}, lock: sync.Mutex{}}
+	for i := 0; i < maxReportedMismatches+5; i++ {
+		require.NoError(t, source.SaveURL(ctx, URLObject{ShortID: strconv.Itoa(i), URL: "http://redirection.com/" + strconv.Itoa(i)}))
+	}
+
+	migrating := NewMigratingStorage(source, target)
+	require.NoError(t, migrating.Verify(ctx))
+	status := migrating.Status()
+	assert.Equal(t, migrationMismatched, status.State)
+	assert.Equal(t, maxReportedMismatches+5, status.Mismatches)
+	assert.Len(t, status.Mismatched, maxReportedMismatches)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// operatorHeader identifies who runs the shortener on every redirect, some registrars require it
+const operatorHeader = "X-Shortie-Owner"
+
+type operatorInfo struct {
+	Name    string
+	Contact string
+}
+
+func (operator operatorInfo) String() string {
+	if operator.Name == "" || operator.Contact == "" {
+		return operator.Name
+	}
+	return operator.Name + " <" + operator.Contact + ">"
+}
+
+// operatorPage is the default /about and /abuse page, replaced by SHORTIE_ABOUT_PAGE and SHORTIE_ABUSE_PAGE
+var operatorPage = template.Must(template.New("operator").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="/static/style.css">
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Abuse}}<p>To report a short link used for spam, phishing or malware, contact {{.Operator.Name}}{{if .Operator.Contact}} at <a href="mailto:{{.Operator.Contact}}">{{.Operator.Contact}}</a>{{end}} and include the short link.</p>
+{{else}}<p>This link shortener is operated by {{.Operator.Name}}.{{if .Operator.Contact}} Contact: <a href="mailto:{{.Operator.Contact}}">{{.Operator.Contact}}</a>.{{end}}</p>
+<p><a href="/abuse">Report abuse</a></p>
+{{end}}</body>
+</html>
+`))
+
+func (api shortieAPI) GetAbout(c *gin.Context) {
+	api.renderOperatorPage(c, api.aboutPage, "About", false)
+}
+
+func (api shortieAPI) GetAbuse(c *gin.Context) {
+	api.renderOperatorPage(c, api.abusePage, "Report Abuse", true)
+}
+
+// renderOperatorPage serves the configured page, or the default page if the operator is configured
+func (api shortieAPI) renderOperatorPage(c *gin.Context, custom []byte, title string, abuse bool) {
+	if len(custom) > 0 {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", custom)
+		return
+	}
+	if api.operator.Name == "" {
+		c.String(http.StatusNotFound, "Not Found")
+		return
+	}
+	var page bytes.Buffer
+	err := operatorPage.Execute(&page, map[string]any{
+		"Title":    title,
+		"Abuse":    abuse,
+		"Operator": api.operator,
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
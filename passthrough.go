@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// passthroughRoute is the route passthrough redirects are reported as. gin can't route /shortie/:id/*path
+// next to /shortie/:id/stats, so they are matched by the router's NoRoute handlers instead.
+const passthroughRoute = "/shortie/:id/*path"
+
+// routeKey holds the route of requests matched outside of gin's router
+const routeKey = "route"
+
+// passthroughPathKey holds the path a passthrough redirect appends to the link's destination
+const passthroughPathKey = "passthroughPath"
+
+// routeOf returns the route pattern a request matched, empty if it matched none
+func routeOf(c *gin.Context) string {
+	if route := c.FullPath(); route != "" {
+		return route
+	}
+	return c.GetString(routeKey)
+}
+
+// MatchPassthrough lets requests for a path below a short link, like /shortie/docs/guide/intro, through to
+// HandlePassthrough. Anything else gets gin's plain 404.
+func (api shortieAPI) MatchPassthrough(c *gin.Context) {
+	shortID, remaining, found := matchPassthrough(c.Request)
+	if !found {
+		c.Abort()
+		return
+	}
+	c.Set(routeKey, passthroughRoute)
+	c.Set(passthroughPathKey, remaining)
+	c.AddParam("id", shortID)
+	c.Next()
+}
+
+// matchPassthrough splits a request for a path below a short link into the shortID and the path below it
+func matchPassthrough(request *http.Request) (string, string, bool) {
+	rest, found := strings.CutPrefix(request.URL.Path, "/shortie/")
+	shortID, remaining, below := strings.Cut(rest, "/")
+	if request.Method != http.MethodGet || !found || !below || shortID == "" {
+		return "", "", false
+	}
+	return shortID, remaining, true
+}
+
+func (api shortieAPI) HandlePassthrough(c *gin.Context) {
+	api.redirect(c, c.Param("id"))
+}
+
+// passthroughDestination appends the path below the short link and the request's query to a destination,
+// so /shortie/docs/guide/intro?lang=en for a link to https://docs.example.com/ goes to https://docs.example.com/guide/intro?lang=en.
+// The path is cleaned first, it can't climb out of the destination's own path with ../
+func passthroughDestination(destination string, remaining string, query url.Values) (string, error) {
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return "", err
+	}
+	if remaining != "" {
+		cleaned := path.Clean("/" + remaining)
+		if strings.HasSuffix(remaining, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		parsed = parsed.JoinPath(cleaned)
+	}
+
+	// the link's own signature isn't meant for the destination
+	query.Del(signatureQueryParam)
+	query.Del(expiresQueryParam)
+	if len(query) > 0 {
+		merged := parsed.Query()
+		for key, values := range query {
+			for _, value := range values {
+				merged.Add(key, value)
+			}
+		}
+		parsed.RawQuery = merged.Encode()
+	}
+	return parsed.String(), nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPassthroughDestination(t *testing.T) {
+	tests := []struct {
+		destination string
+		remaining   string
+		query       string
+		expected    string
+	}{
+		{"https://docs.example.com", "guide/intro", "", "https://docs.example.com/guide/intro"},
+		{"https://docs.example.com/v2/", "guide/", "", "https://docs.example.com/v2/guide/"},
+		{"https://docs.example.com/v2", "", "", "https://docs.example.com/v2"},
+		{"https://docs.example.com/v2?ref=short", "search", "q=tls", "https://docs.example.com/v2/search?q=tls&ref=short"},
+		// the path can't climb out of the destination's own path
+		{"https://docs.example.com/v2/", "../../admin", "", "https://docs.example.com/v2/admin"},
+		// the link's signature stays with the link
+		{"https://docs.example.com", "guide", "sig=abc&exp=1&lang=en", "https://docs.example.com/guide?lang=en"},
+		{"https://docs.example.com", "a b", "", "https://docs.example.com/a%20b"},
+	}
+	for _, test := range tests {
+		query, err := url.ParseQuery(test.query)
+		require.NoError(t, err)
+		destination, err := passthroughDestination(test.destination, test.remaining, query)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, destination, test.destination+" + "+test.remaining)
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+)
+
+// meteringFlushInterval is how often counted usage is written to the store and how long usage read from it is reused,
+// so plan limits are enforced within about this long across replicas
+const meteringFlushInterval = 10 * time.Second
+
+// plan caps the links an owner creates and the redirects of their links each calendar month (UTC), 0 for no cap
+type plan struct {
+	Name      string `json:"name"`
+	Links     int64  `json:"links"`
+	Redirects int64  `json:"redirects"`
+}
+
+// ownerUsage is what an owner used in a month, a month being like 2024-05
+type ownerUsage struct {
+	Owner     string `dynamodbav:"owner" json:"-"`
+	Month     string `dynamodbav:"month" json:"month"`
+	Links     int64  `dynamodbav:"links" json:"links"`
+	Redirects int64  `dynamodbav:"redirects" json:"redirects"`
+}
+
+func usageMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// ownerUsageStore keeps the monthly usage of owners, adding to it is atomic so every replica can add its own counts
+type ownerUsageStore interface {
+	AddOwnerUsage(ctx context.Context, usage ownerUsage) error
+	// GetOwnerUsage returns zero usage for owners that used nothing in the month
+	GetOwnerUsage(ctx context.Context, owner string, month string) (ownerUsage, error)
+}
+
+type LocalOwnerUsage struct {
+	lock  sync.Mutex
+	usage map[string]ownerUsage
+}
+
+func NewLocalOwnerUsage() *LocalOwnerUsage {
+	return &LocalOwnerUsage{usage: map[string]ownerUsage{}}
+}
+
+func (store *LocalOwnerUsage) AddOwnerUsage(ctx context.Context, usage ownerUsage) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	key := usage.Owner + " " + usage.Month
+	existing := store.usage[key]
+	usage.Links += existing.Links
+	usage.Redirects += existing.Redirects
+	store.usage[key] = usage
+	return nil
+}
+
+func (store *LocalOwnerUsage) GetOwnerUsage(ctx context.Context, owner string, month string) (ownerUsage, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	usage, found := store.usage[owner+" "+month]
+	if !found {
+		return ownerUsage{Owner: owner, Month: month}, nil
+	}
+	return usage, nil
+}
+
+const ownerUsageTableName = "shortie-owner-usage"
+
+const (
+	attributeOwner = "owner"
+	attributeMonth = "month"
+)
+
+// DynamoOwnerUsage keeps one item per owner and month in its own table next to the links table
+type DynamoOwnerUsage struct {
+	dynamo *dynamodb.Client
+}
+
+func NewDynamoOwnerUsage(storage *DynamoStorage) *DynamoOwnerUsage {
+	return &DynamoOwnerUsage{dynamo: storage.dynamo}
+}
+
+func (store *DynamoOwnerUsage) InitializeTable() error {
+	_, err := store.dynamo.CreateTable(context.Background(), &dynamodb.CreateTableInput{
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String(attributeOwner),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String(attributeMonth),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String(attributeOwner),
+				KeyType:       types.KeyTypeHash,
+			},
+			{
+				AttributeName: aws.String(attributeMonth),
+				KeyType:       types.KeyTypeRange,
+			},
+		},
+		TableName: aws.String(ownerUsageTableName),
+	})
+	if err != nil {
+		var alreadyExists *types.TableAlreadyExistsException
+		var inUse *types.ResourceInUseException
+		if errors.As(err, &alreadyExists) || errors.As(err, &inUse) {
+			return nil
+		}
+		return fmt.Errorf("failed to create the owner usage table: %w", err)
+	}
+	return nil
+}
+
+func ownerUsageKey(owner string, month string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		attributeOwner: &types.AttributeValueMemberS{Value: owner},
+		attributeMonth: &types.AttributeValueMemberS{Value: month},
+	}
+}
+
+func (store *DynamoOwnerUsage) AddOwnerUsage(ctx context.Context, usage ownerUsage) error {
+	_, err := store.dynamo.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(ownerUsageTableName),
+		Key:              ownerUsageKey(usage.Owner, usage.Month),
+		UpdateExpression: aws.String("ADD #links :links, #redirects :redirects"),
+		ExpressionAttributeNames: map[string]string{
+			"#links":     "links",
+			"#redirects": "redirects",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":links":     numberValue(usage.Links),
+			":redirects": numberValue(usage.Redirects),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add the usage of %s: %w", usage.Owner, err)
+	}
+	return nil
+}
+
+func (store *DynamoOwnerUsage) GetOwnerUsage(ctx context.Context, owner string, month string) (ownerUsage, error) {
+	output, err := store.dynamo.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(ownerUsageTableName),
+		Key:       ownerUsageKey(owner, month),
+	})
+	if err != nil {
+		return ownerUsage{}, fmt.Errorf("failed to get the usage of %s: %w", owner, err)
+	}
+	usage := ownerUsage{Owner: owner, Month: month}
+	if output.Item == nil {
+		return usage, nil
+	}
+	err = attributevalue.UnmarshalMap(output.Item, &usage)
+	if err != nil {
+		return ownerUsage{}, err
+	}
+	return usage, nil
+}
+
+// ownerMeter counts the links owners create and the redirects of their links. Counts are added up in memory and
+// written every meteringFlushInterval, so metering doesn't add a write to every redirect.
+type ownerMeter struct {
+	store ownerUsageStore
+	plans map[string]plan
+	// ownerPlans picks the plan of an owner, defaultPlan that of everyone else, "" for no limits
+	ownerPlans  map[string]string
+	defaultPlan string
+
+	lock    sync.Mutex
+	pending map[string]ownerUsage
+	// flushing are the counts being written, still counted until the store has them
+	flushing map[string]ownerUsage
+	// flushes counts the writes of each key, a read that overlaps one may or may not include it and isn't reused
+	flushes map[string]int
+	stored  map[string]storedUsage
+}
+
+// storedUsage is usage read from the store, reused until it is meteringFlushInterval old
+type storedUsage struct {
+	usage  ownerUsage
+	readAt time.Time
+}
+
+func newOwnerMeter(store ownerUsageStore, plans map[string]plan, ownerPlans map[string]string, defaultPlan string) *ownerMeter {
+	return &ownerMeter{
+		store:       store,
+		plans:       plans,
+		ownerPlans:  ownerPlans,
+		defaultPlan: defaultPlan,
+		pending:     map[string]ownerUsage{},
+		flushing:    map[string]ownerUsage{},
+		flushes:     map[string]int{},
+		stored:      map[string]storedUsage{},
+	}
+}
+
+// parsePlans reads comma separated name=links:redirects plans, e.g. free=100:10000,pro=10000:0
+func parsePlans(config string) (map[string]plan, error) {
+	plans := map[string]plan{}
+	for _, entry := range strings.Split(config, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, limits, found := strings.Cut(entry, "=")
+		linksString, redirectsString, hasRedirects := strings.Cut(limits, ":")
+		if !found || !hasRedirects || name == "" {
+			return nil, fmt.Errorf("plan %q is not name=links:redirects", entry)
+		}
+		links, err := strconv.ParseInt(linksString, 10, 64)
+		if err != nil || links < 0 {
+			return nil, fmt.Errorf("the links of plan %s must be a positive integer, or 0 for no limit", name)
+		}
+		redirects, err := strconv.ParseInt(redirectsString, 10, 64)
+		if err != nil || redirects < 0 {
+			return nil, fmt.Errorf("the redirects of plan %s must be a positive integer, or 0 for no limit", name)
+		}
+		plans[name] = plan{Name: name, Links: links, Redirects: redirects}
+	}
+	return plans, nil
+}
+
+// parseOwnerPlans reads comma separated owner=plan pairs, the owner being an api key name, jwt subject or token name
+func parseOwnerPlans(config string, plans map[string]plan) (map[string]string, error) {
+	ownerPlans := map[string]string{}
+	for _, pair := range strings.Split(config, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		owner, name, found := strings.Cut(pair, "=")
+		if !found || owner == "" {
+			return nil, fmt.Errorf("owner plan %q is not owner=plan", pair)
+		}
+		if _, known := plans[name]; !known {
+			return nil, fmt.Errorf("owner %s has unknown plan %q", owner, name)
+		}
+		ownerPlans[owner] = name
+	}
+	return ownerPlans, nil
+}
+
+// initOwnerMeter keeps owner usage with the storage backend, nil if metering isn't enabled
+func initOwnerMeter(env Environment, dynamoStorage *DynamoStorage) (*ownerMeter, error) {
+	metering, err := strconv.ParseBool(env.Metering)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHORTIE_METERING: %w", err)
+	}
+	if !metering {
+		if env.Plans != "" || env.OwnerPlans != "" || env.DefaultPlan != "" {
+			return nil, errors.New("plans require SHORTIE_METERING=true")
+		}
+		return nil, nil
+	}
+	plans, err := parsePlans(env.Plans)
+	if err != nil {
+		return nil, err
+	}
+	ownerPlans, err := parseOwnerPlans(env.OwnerPlans, plans)
+	if err != nil {
+		return nil, err
+	}
+	if _, known := plans[env.DefaultPlan]; env.DefaultPlan != "" && !known {
+		return nil, fmt.Errorf("unknown SHORTIE_DEFAULT_PLAN %q", env.DefaultPlan)
+	}
+	if dynamoStorage == nil {
+		return newOwnerMeter(NewLocalOwnerUsage(), plans, ownerPlans, env.DefaultPlan), nil
+	}
+	store := NewDynamoOwnerUsage(dynamoStorage)
+	if dynamoStorage.initializeTables {
+		err = store.InitializeTable()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newOwnerMeter(store, plans, ownerPlans, env.DefaultPlan), nil
+}
+
+// PlanOf returns the plan of an owner, false if the owner isn't limited
+func (meter *ownerMeter) PlanOf(owner string) (plan, bool) {
+	name, found := meter.ownerPlans[owner]
+	if !found {
+		name = meter.defaultPlan
+	}
+	limits, found := meter.plans[name]
+	return limits, found
+}
+
+// Add counts links created and redirects of an owner's links, links without an owner aren't metered
+func (meter *ownerMeter) Add(owner string, now time.Time, links int64, redirects int64) {
+	if owner == "" {
+		return
+	}
+	month := usageMonth(now)
+	meter.lock.Lock()
+	defer meter.lock.Unlock()
+	meter.addPending(ownerUsage{Owner: owner, Month: month, Links: links, Redirects: redirects})
+}
+
+// addPending adds to the counts not written yet, the caller must hold the lock
+func (meter *ownerMeter) addPending(usage ownerUsage) {
+	addUsage(meter.pending, usage)
+}
+
+// addUsage adds usage to its owner and month's counts in counts, negative counts take it away again
+func addUsage(counts map[string]ownerUsage, usage ownerUsage) {
+	key := usage.Owner + " " + usage.Month
+	counted, found := counts[key]
+	if found {
+		usage.Links += counted.Links
+		usage.Redirects += counted.Redirects
+	}
+	if usage.Links == 0 && usage.Redirects == 0 {
+		delete(counts, key)
+		return
+	}
+	counts[key] = usage
+}
+
+// Usage returns what an owner used in a month, with this replica's counts that weren't written yet
+func (meter *ownerMeter) Usage(ctx context.Context, owner string, month string, now time.Time) (ownerUsage, error) {
+	key := owner + " " + month
+	meter.lock.Lock()
+	stored, found := meter.stored[key]
+	flushes := meter.flushes[key]
+	meter.lock.Unlock()
+	for !found || now.Sub(stored.readAt) >= meteringFlushInterval {
+		usage, err := meter.store.GetOwnerUsage(ctx, owner, month)
+		if err != nil {
+			return ownerUsage{}, err
+		}
+		stored = storedUsage{usage: usage, readAt: now}
+		meter.lock.Lock()
+		// a write that finished during the read is no longer counted as flushing, so read again to be sure to have it
+		if meter.flushes[key] == flushes {
+			meter.stored[key] = stored
+			found = true
+		}
+		flushes = meter.flushes[key]
+		meter.lock.Unlock()
+	}
+
+	usage := stored.usage
+	meter.lock.Lock()
+	defer meter.lock.Unlock()
+	for _, counts := range []map[string]ownerUsage{meter.pending, meter.flushing} {
+		usage.Links += counts[key].Links
+		usage.Redirects += counts[key].Redirects
+	}
+	return usage, nil
+}
+
+// Allows reports whether an owner's plan has room for another link, or another redirect when link is false
+func (meter *ownerMeter) Allows(ctx context.Context, owner string, link bool, now time.Time) (bool, error) {
+	limits, limited := meter.PlanOf(owner)
+	if owner == "" || !limited {
+		return true, nil
+	}
+	limit := limits.Redirects
+	if link {
+		limit = limits.Links
+	}
+	if limit == 0 {
+		return true, nil
+	}
+	usage, err := meter.Usage(ctx, owner, usageMonth(now), now)
+	if err != nil {
+		return false, err
+	}
+	if link {
+		return usage.Links < limit, nil
+	}
+	return usage.Redirects < limit, nil
+}
+
+// Flush writes the counts added since the last flush, counts that fail to be written are kept for the next one.
+// Counts being written stay counted until the store has them, so limits hold during a flush.
+func (meter *ownerMeter) Flush(ctx context.Context) error {
+	meter.lock.Lock()
+	pending := meter.pending
+	meter.pending = map[string]ownerUsage{}
+	for _, usage := range pending {
+		addUsage(meter.flushing, usage)
+	}
+	meter.lock.Unlock()
+
+	var failed error
+	for key, usage := range pending {
+		err := meter.store.AddOwnerUsage(ctx, usage)
+		meter.lock.Lock()
+		addUsage(meter.flushing, ownerUsage{Owner: usage.Owner, Month: usage.Month, Links: -usage.Links, Redirects: -usage.Redirects})
+		if err != nil {
+			failed = err
+			meter.addPending(usage)
+		} else {
+			// the stored usage no longer includes what was just written
+			delete(meter.stored, key)
+			meter.flushes[key]++
+		}
+		meter.lock.Unlock()
+	}
+	return failed
+}
+
+// Run flushes on every interval until ctx is done, then flushes what is left
+func (meter *ownerMeter) Run(ctx context.Context) {
+	ticker := time.NewTicker(meteringFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := meter.Flush(ctx)
+			if err != nil {
+				log.Println("error: failed to write owner usage: " + err.Error())
+			}
+		case <-ctx.Done():
+			// the service's context is already cancelled on shutdown, the last flush gets its own deadline
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err := meter.Flush(flushCtx)
+			cancel()
+			if err != nil {
+				log.Println("error: failed to write owner usage: " + err.Error())
+			}
+			return
+		}
+	}
+}
+
+// allowsRedirect checks the plan of a link's owner before it redirects, a failure to read the usage lets the redirect through
+func (api shortieAPI) allowsRedirect(ctx context.Context, object *URLObject, now time.Time) bool {
+	if api.meter == nil {
+		return true
+	}
+	allowed, err := api.meter.Allows(ctx, object.Owner, false, now)
+	if err != nil {
+		log.Println("error: " + err.Error())
+		return true
+	}
+	return allowed
+}
+
+// GetMyUsage shows the caller's usage this month, or the month given as ?month=2024-05, and the limits of their plan
+func (api shortieAPI) GetMyUsage(c *gin.Context) {
+	if api.meter == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "metering is not enabled")
+		return
+	}
+	caller := callerOf(c)
+	if caller == nil {
+		respondError(c, http.StatusBadRequest, codeFeatureDisabled, "usage requires api keys, jwts or access tokens to tell callers apart")
+		return
+	}
+	now := time.Now()
+	month := usageMonth(now)
+	if value := c.Query("month"); value != "" {
+		parsed, err := time.Parse("2006-01", value)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, codeInvalidParameter, "month must be like 2024-05")
+			return
+		}
+		month = usageMonth(parsed)
+	}
+	usage, err := api.meter.Usage(c, caller.name, month, now)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	response := map[string]any{"month": usage.Month, "links": usage.Links, "redirects": usage.Redirects}
+	if limits, limited := api.meter.PlanOf(caller.name); limited {
+		response["plan"] = limits
+	}
+	c.JSON(http.StatusOK, response)
+}
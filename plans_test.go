@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlans(t *testing.T) {
+	plans, err := parsePlans("free=2:3, pro=100:0")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]plan{"free": {Name: "free", Links: 2, Redirects: 3}, "pro": {Name: "pro", Links: 100}}, plans)
+	for _, config := range []string{"free", "free=2", "free=-1:3", "=1:1", "free=1:x"} {
+		_, err = parsePlans(config)
+		assert.Error(t, err, config)
+	}
+
+	ownerPlans, err := parseOwnerPlans("alice=pro", plans)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"alice": "pro"}, ownerPlans)
+	_, err = parseOwnerPlans("alice=gold", plans)
+	assert.Error(t, err)
+}
+
+func TestOwnerMeter(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalOwnerUsage()
+	plans, err := parsePlans("free=2:3")
+	require.NoError(t, err)
+	meter := newOwnerMeter(store, plans, map[string]string{"unlimited": ""}, "free")
+	now := time.Date(2024, 5, 31, 23, 0, 0, 0, time.UTC)
+
+	meter.Add("alice", now, 1, 2)
+	allowed, err := meter.Allows(ctx, "alice", true, now)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	meter.Add("alice", now, 1, 1)
+	allowed, err = meter.Allows(ctx, "alice", true, now)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	allowed, err = meter.Allows(ctx, "alice", false, now)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	// every month starts over
+	allowed, err = meter.Allows(ctx, "alice", true, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	// owners without a plan and links without an owner aren't limited
+	meter.Add("unlimited", now, 10, 10)
+	allowed, err = meter.Allows(ctx, "unlimited", true, now)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, err = meter.Allows(ctx, "", false, now)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	// counts reach the store on flush, and aren't counted twice
+	require.NoError(t, meter.Flush(ctx))
+	stored, err := store.GetOwnerUsage(ctx, "alice", "2024-05")
+	require.NoError(t, err)
+	assert.Equal(t, ownerUsage{Owner: "alice", Month: "2024-05", Links: 2, Redirects: 3}, stored)
+	usage, err := meter.Usage(ctx, "alice", "2024-05", now)
+	require.NoError(t, err)
+	assert.Equal(t, stored, usage)
+}
+
+// blockingOwnerUsage holds writes until release is closed
+type blockingOwnerUsage struct {
+	*LocalOwnerUsage
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (store blockingOwnerUsage) AddOwnerUsage(ctx context.Context, usage ownerUsage) error {
+	store.writing <- struct{}{}
+	<-store.release
+	return store.LocalOwnerUsage.AddOwnerUsage(ctx, usage)
+}
+
+func TestOwnerMeterCountsFlushingUsage(t *testing.T) {
+	ctx := context.Background()
+	store := blockingOwnerUsage{LocalOwnerUsage: NewLocalOwnerUsage(), writing: make(chan struct{}), release: make(chan struct{})}
+	plans, err := parsePlans("free=1:0")
+	require.NoError(t, err)
+	meter := newOwnerMeter(store, plans, nil, "free")
+	now := time.Now()
+
+	meter.Add("alice", now, 1, 0)
+	flushed := make(chan error)
+	go func() { flushed <- meter.Flush(ctx) }()
+	<-store.writing
+	allowed, err := meter.Allows(ctx, "alice", true, now)
+	require.NoError(t, err)
+	assert.False(t, allowed, "a link being written is still counted")
+	close(store.release)
+	require.NoError(t, <-flushed)
+
+	usage, err := meter.Usage(ctx, "alice", usageMonth(now), now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), usage.Links, "a written link is counted once")
+}
+
+func TestPlanEnforcement(t *testing.T) {
+	ctx := context.Background()
+	keys, err := parseAPIKeys("alice=editor:alice-key")
+	require.NoError(t, err)
+	plans, err := parsePlans("free=1:2")
+	require.NoError(t, err)
+	api := shortieAPI{storage: newContractLocalStorage(t), apiKeys: keys, meter: newOwnerMeter(NewLocalOwnerUsage(), plans, nil, "free")}
+
+	w := serveAs(api, "alice-key", http.MethodPost, "/shortie", `{"url": "https://example.com/a"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serveAs(api, "alice-key", http.MethodPost, "/shortie", `{"url": "https://example.com/b"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), codeQuotaExceeded)
+	// handing back the existing link doesn't use up the plan
+	w = serveAs(api, "alice-key", http.MethodPost, "/shortie", `{"url": "https://example.com/a"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	shortIDs, err := api.storage.ListShortIDs(ctx)
+	require.NoError(t, err)
+	require.Len(t, shortIDs, 1)
+	assert.Equal(t, http.StatusTemporaryRedirect, serveAs(api, "", http.MethodGet, "/shortie/"+shortIDs[0], "").Code)
+	assert.Equal(t, http.StatusTemporaryRedirect, serveAs(api, "", http.MethodGet, "/shortie/"+shortIDs[0], "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveAs(api, "", http.MethodGet, "/shortie/"+shortIDs[0], "").Code)
+
+	w = serveAs(api, "alice-key", http.MethodGet, "/me/usage", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var usage map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &usage))
+	assert.Equal(t, usageMonth(time.Now()), usage["month"])
+	assert.Equal(t, float64(1), usage["links"])
+	assert.Equal(t, float64(2), usage["redirects"])
+	assert.Equal(t, map[string]any{"name": "free", "links": float64(1), "redirects": float64(2)}, usage["plan"])
+
+	assert.Equal(t, http.StatusBadRequest, serveAs(api, "alice-key", http.MethodGet, "/me/usage?month=may", "").Code)
+	api.meter = nil
+	assert.Equal(t, http.StatusNotFound, serveAs(api, "alice-key", http.MethodGet, "/me/usage", "").Code)
+}
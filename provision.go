@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"log"
+)
+
+// provisionTables creates the tables the configuration uses, or updates them to match it, for -provision.
+// Production runtimes usually lack the permissions to manage tables, so this runs apart from them,
+// e.g. from a deploy pipeline, and the runtime sets SHORTIE_DYNAMO_INITIALIZE_TABLES=false.
+// Tables managed by terraform don't need it at all, as long as they match what InitializeTable would create.
+func provisionTables(env Environment) error {
+	if env.AWSCustomDynamoEndpoint == "" {
+		return errors.New("-provision requires the dynamodb backend, set AWS_CUSTOM_DYNAMO_ENDPOINT")
+	}
+	env.DynamoInitializeTables = "true"
+
+	storage, err := InitDynamoStorage(env)
+	if err != nil {
+		return err
+	}
+	log.Println("provisioning the " + tableName + " table")
+	err = storage.InitializeTable()
+	if err != nil {
+		return err
+	}
+
+	if env.LockBackend == "" || env.LockBackend == "dynamodb" {
+		log.Println("provisioning the " + locksTableName + " table")
+		err = NewDynamoLocker(storage).InitializeTable()
+		if err != nil {
+			return err
+		}
+	}
+
+	tokens, err := initTokenStore(env, storage)
+	if err != nil {
+		return err
+	}
+	if tokens != nil {
+		log.Println("provisioned the " + tokensTableName + " table")
+	}
+
+	changes, err := initChangeFeed(env, storage)
+	if err != nil {
+		return err
+	}
+	if changes != nil {
+		log.Println("provisioned the " + changesTableName + " table")
+	}
+
+	meter, err := initOwnerMeter(env, storage)
+	if err != nil {
+		return err
+	}
+	if meter != nil {
+		log.Println("provisioned the " + ownerUsageTableName + " table")
+	}
+
+	if env.MigrationDynamoEndpoint != "" {
+		log.Println("provisioning the migration target's " + tableName + " table")
+		_, err = initMigrationTarget(env)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvisionTablesRequiresDynamo(t *testing.T) {
+	err := provisionTables(Environment{})
+	assert.ErrorContains(t, err, "AWS_CUSTOM_DYNAMO_ENDPOINT")
+}
+
+func TestInitDynamoStorageInitializeTables(t *testing.T) {
+	env, err := loadEnvironment("")
+	require.NoError(t, err)
+	env.AWSRegion = "us-west-2"
+	env.AWSCustomDynamoEndpoint = "http://127.0.0.1:4566"
+
+	env.DynamoInitializeTables = "false"
+	storage, err := InitDynamoStorage(env)
+	if assert.NoError(t, err) {
+		assert.False(t, storage.initializeTables)
+	}
+
+	env.DynamoInitializeTables = "sometimes"
+	_, err = InitDynamoStorage(env)
+	assert.ErrorContains(t, err, "SHORTIE_DYNAMO_INITIALIZE_TABLES")
+}
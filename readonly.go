@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RejectWhenReadOnly answers changes to links with a 503 while the service is read-only,
+// redirects and statistics keep working, as does counting usage
+func (api shortieAPI) RejectWhenReadOnly(c *gin.Context) {
+	if api.readOnly != nil && api.readOnly.Load() {
+		respondError(c, http.StatusServiceUnavailable, codeReadOnly, "links can't be changed while the service is read-only")
+		return
+	}
+	c.Next()
+}
+
+type readOnlyStatus struct {
+	ReadOnly bool `json:"readOnly"`
+}
+
+func (api shortieAPI) GetReadOnly(c *gin.Context) {
+	c.JSON(http.StatusOK, readOnlyStatus{ReadOnly: api.readOnly != nil && api.readOnly.Load()})
+}
+
+// SetReadOnly switches read-only mode on this replica until it restarts, SHORTIE_READ_ONLY sets it at startup
+func (api shortieAPI) SetReadOnly(c *gin.Context) {
+	if api.readOnly == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "read-only mode can't be switched")
+		return
+	}
+	var body struct {
+		ReadOnly *bool `json:"readOnly"`
+	}
+	if !api.bindJSON(c, &body) {
+		return
+	}
+	if body.ReadOnly == nil {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, "readOnly is required")
+		return
+	}
+	api.readOnly.Store(*body.ReadOnly)
+	c.JSON(http.StatusOK, readOnlyStatus{ReadOnly: *body.ReadOnly})
+}
+
+// newSwitch is a mode that can be flipped at runtime by concurrent requests
+func newSwitch(on bool) *atomic.Bool {
+	modeSwitch := &atomic.Bool{}
+	modeSwitch.Store(on)
+	return modeSwitch
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSwitchReadOnly(t *testing.T) {
+	api := shortieAPI{storage: &LocalStorage{Objects: map[string]URLObject{}}, adminToken: "admin", readOnly: newSwitch(false)}
+	router := api.GetRouter()
+	serve := func(method, path, body string) int {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer admin")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/shortie", `{"url":"https://example.com/one"}`))
+	assert.Equal(t, http.StatusOK, serve(http.MethodPut, "/admin/read-only", `{"readOnly":true}`))
+	assert.Equal(t, http.StatusServiceUnavailable, serve(http.MethodPost, "/shortie", `{"url":"https://example.com/two"}`))
+	// the switch is shared by the versioned routes
+	assert.Equal(t, http.StatusServiceUnavailable, serve(http.MethodPost, "/v1/shortie", `{"url":"https://example.com/two"}`))
+	assert.Equal(t, http.StatusOK, serve(http.MethodPut, "/admin/read-only", `{"readOnly":false}`))
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/shortie", `{"url":"https://example.com/two"}`))
+}
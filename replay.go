@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clickCounts maps a shortID to its clicks by the usage key of their UTC day
+type clickCounts map[string]map[string]int64
+
+func (counts clickCounts) add(event clickEvent) {
+	day := strconv.FormatInt(event.Time.UTC().Truncate(24*time.Hour).Unix(), 10)
+	if counts[event.ShortID] == nil {
+		counts[event.ShortID] = map[string]int64{}
+	}
+	counts[event.ShortID][day]++
+}
+
+// replayStats summarizes a replay for its log
+type replayStats struct {
+	events     int
+	invalid    int
+	duplicates int
+	links      int
+	days       int
+	missing    int
+}
+
+// readClickLog counts the events of one log, lines that aren't events, like one cut short by a crash, are skipped
+// Events with the id of an event already read are retries, or the same click in overlapping logs, and are skipped.
+func readClickLog(reader io.Reader, counts clickCounts, seen map[string]bool, stats *replayStats) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var event clickEvent
+		err := json.Unmarshal(line, &event)
+		if err != nil || event.ShortID == "" || event.Time.IsZero() {
+			stats.invalid++
+			continue
+		}
+		if event.ID != "" {
+			key := event.ShortID + " " + event.ID
+			if seen[key] {
+				stats.duplicates++
+				continue
+			}
+			seen[key] = true
+		}
+		counts.add(event)
+		stats.events++
+	}
+	return scanner.Err()
+}
+
+// readClickLogFile reads a log as written by the file or s3 click log, gzipped if it ends with .gz
+func readClickLogFile(path string, counts clickCounts, seen map[string]bool, stats *replayStats) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+	err = readClickLog(reader, counts, seen, stats)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
+
+// readClickLogs reads the given files, and the .ndjson files under the given directories,
+// including rotated files like clicks.ndjson.1 and the batches synced from s3
+func readClickLogs(paths []string) (clickCounts, replayStats, error) {
+	counts := clickCounts{}
+	seen := map[string]bool{}
+	var stats replayStats
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, stats, err
+		}
+		if !info.IsDir() {
+			err = readClickLogFile(path, counts, seen, &stats)
+			if err != nil {
+				return nil, stats, err
+			}
+			continue
+		}
+		err = filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || !strings.Contains(entry.Name(), ".ndjson") {
+				return nil
+			}
+			return readClickLogFile(path, counts, seen, &stats)
+		})
+		if err != nil {
+			return nil, stats, err
+		}
+	}
+	return counts, stats, nil
+}
+
+// replayClicks writes the counted clicks to the daily usage of their links. A rebuild replaces the counters
+// of the days the logs cover, a backfill only fills the days a link has no clicks for. Counters of days
+// the logs don't cover, hourly usage and rule usage are kept as they are.
+// Clicks of links that no longer exist are skipped.
+func replayClicks(ctx context.Context, storage urlStorage, counts clickCounts, backfill bool, dryRun bool, stats *replayStats) error {
+	shortIDs := make([]string, 0, len(counts))
+	for shortID := range counts {
+		shortIDs = append(shortIDs, shortID)
+	}
+	sort.Strings(shortIDs)
+
+	for _, shortID := range shortIDs {
+		object, err := storage.GetURL(ctx, shortID)
+		if err != nil {
+			return err
+		}
+		if object == nil {
+			stats.missing++
+			continue
+		}
+		// a copy, the in-memory backend hands out its own map
+		object.Usage = maps.Clone(object.Usage)
+		if object.Usage == nil {
+			object.Usage = map[string]int64{}
+		}
+		changed := 0
+		for day, clicks := range counts[shortID] {
+			if object.Usage[day] == clicks || (backfill && object.Usage[day] != 0) {
+				continue
+			}
+			object.Usage[day] = clicks
+			changed++
+		}
+		if changed == 0 {
+			continue
+		}
+		stats.links++
+		stats.days += changed
+		if dryRun {
+			continue
+		}
+		err = storage.ImportURL(ctx, *object)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runReplay is the replay command, which rebuilds or backfills the daily usage of links from click logs
+// after the counters were corrupted or lost in a migration, e.g. shortie replay -backfill /var/log/shortie/clicks
+func runReplay(ctx context.Context, env Environment, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	backfill := flags.Bool("backfill", false, "only fill the days links have no clicks for, instead of replacing the counters of every day the logs cover")
+	dryRun := flags.Bool("dry-run", false, "count the changes without writing them")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("replay needs the click log files or directories to read")
+	}
+	if env.AWSCustomDynamoEndpoint == "" {
+		return errors.New("replay requires the dynamodb backend, set AWS_CUSTOM_DYNAMO_ENDPOINT")
+	}
+
+	counts, stats, err := readClickLogs(flags.Args())
+	if err != nil {
+		return err
+	}
+	log.Printf("read %d click events of %d links, skipped %d duplicates and %d invalid lines", stats.events, len(counts), stats.duplicates, stats.invalid)
+
+	storage, err := InitDynamoStorage(env)
+	if err != nil {
+		return err
+	}
+	err = replayClicks(ctx, storage, counts, *backfill, *dryRun, &stats)
+	if err != nil {
+		return err
+	}
+	verb := "updated"
+	if *dryRun {
+		verb = "would update"
+	}
+	log.Printf("%s %d days of %d links, skipped %d links that no longer exist", verb, stats.days, stats.links, stats.missing)
+	return nil
+}
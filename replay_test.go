@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const replayLog = `{"id":"r1","shortID":"abc","time":"2024-05-01T13:45:00Z","ip":"203.0.113.0"}
+{"id":"r1","shortID":"abc","time":"2024-05-01T13:45:01Z","ip":"203.0.113.0"}
+{"shortID":"abc","time":"2024-05-01T23:59:59Z","ip":"203.0.113.0"}
+{"shortID":"abc","time":"2024-05-02T00:00:00Z","ip":"203.0.113.0"}
+{"shortID":"gone","time":"2024-05-01T13:45:00Z","ip":"203.0.113.0"}
+{"shortID":"abc","time":"2024-05-0
+`
+
+// 2024-05-01 and 2024-05-02 as usage keys
+const (
+	replayDay1 = "1714521600"
+	replayDay2 = "1714608000"
+)
+
+func TestReadClickLogs(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "clicks.ndjson.1"), []byte(replayLog), 0o644))
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write([]byte(strings.SplitAfter(replayLog, "\n")[0]))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "2024", "05"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "2024", "05", "batch.ndjson.gz"), compressed.Bytes(), 0o644))
+	// files that aren't click logs are left out of directories
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a log"), 0o644))
+
+	counts, stats, err := readClickLogs([]string{dir})
+	require.NoError(t, err)
+	// the retry of r1 and its copy in the s3 batch count once
+	assert.Equal(t, clickCounts{
+		"abc":  {replayDay1: 2, replayDay2: 1},
+		"gone": {replayDay1: 1},
+	}, counts)
+	assert.Equal(t, 4, stats.events)
+	assert.Equal(t, 2, stats.duplicates)
+	// the line cut short by a crash
+	assert.Equal(t, 1, stats.invalid)
+
+	_, _, err = readClickLogs([]string{filepath.Join(dir, "missing.ndjson")})
+	assert.Error(t, err)
+}
+
+func TestReplayClicks(t *testing.T) {
+	ctx := context.Background()
+	counts := clickCounts{
+		"abc":  {replayDay1: 2, replayDay2: 1},
+		"gone": {replayDay1: 1},
+	}
+	newStorage := func() *LocalStorage {
+		storage := &LocalStorage{Objects: map[string]URLObject{}}
+		require.NoError(t, storage.SaveURL(ctx, URLObject{ShortID: "abc", URL: "https://example.com"}))
+		object := storage.Objects["abc"]
+		object.Usage[replayDay1] = 7
+		object.Usage["1714435200"] = 4
+		storage.Objects["abc"] = object
+		return storage
+	}
+
+	// a rebuild replaces the days the logs cover and keeps the others
+	storage := newStorage()
+	var stats replayStats
+	require.NoError(t, replayClicks(ctx, storage, counts, false, false, &stats))
+	assert.Equal(t, map[string]int64{replayDay1: 2, replayDay2: 1, "1714435200": 4}, storage.Objects["abc"].Usage)
+	assert.Equal(t, replayStats{links: 1, days: 2, missing: 1}, stats)
+
+	// a backfill only fills the days without clicks
+	storage = newStorage()
+	stats = replayStats{}
+	require.NoError(t, replayClicks(ctx, storage, counts, true, false, &stats))
+	assert.Equal(t, map[string]int64{replayDay1: 7, replayDay2: 1, "1714435200": 4}, storage.Objects["abc"].Usage)
+	assert.Equal(t, 1, stats.days)
+
+	// a dry run counts without writing
+	storage = newStorage()
+	stats = replayStats{}
+	require.NoError(t, replayClicks(ctx, storage, counts, false, true, &stats))
+	assert.Equal(t, int64(7), storage.Objects["abc"].Usage[replayDay1])
+	assert.Equal(t, 2, stats.days)
+}
+
+func TestRunReplay(t *testing.T) {
+	env, err := loadEnvironment("")
+	require.NoError(t, err)
+	assert.ErrorContains(t, runReplay(context.Background(), env, nil), "needs the click log files")
+	assert.ErrorContains(t, runReplay(context.Background(), env, []string{"-backfill", "clicks.ndjson"}), "requires the dynamodb backend")
+}
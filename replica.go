@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// staleSnapshotSyncs is how many sync intervals a snapshot can go without syncing before the replica reports unhealthy,
+// so load balancers stop sending it redirects for links that may have been deleted or paused since
+const staleSnapshotSyncs = 3
+
+// SnapshotStatus is how fresh a read replica's copy of the links is
+type SnapshotStatus struct {
+	Links    int       `json:"links"`
+	SyncedAt time.Time `json:"syncedAt"`
+}
+
+// SnapshotStorage serves the links of a read replica from a copy of every link kept in memory, so redirects don't
+// wait on the backend. The copy is read from the backend again on an interval and kept fresh in between by link events,
+// clicks and everything else still go to the backend.
+type SnapshotStorage struct {
+	urlStorage
+	interval time.Duration
+
+	lock     sync.RWMutex
+	links    map[string]URLObject
+	syncedAt time.Time
+	// changed holds the shortIDs changed while a sync is running, which are read again once it is done
+	changed map[string]bool
+	syncing bool
+}
+
+// initReplica reads whether the instance is a read replica and how often it syncs its snapshot, 0 for no snapshot
+func initReplica(env Environment) (bool, time.Duration, error) {
+	replica, err := strconv.ParseBool(env.Replica)
+	if err != nil {
+		return false, 0, fmt.Errorf("invalid SHORTIE_REPLICA: %w", err)
+	}
+	if !replica {
+		if env.ReplicaSyncInterval != "" {
+			return false, 0, errors.New("SHORTIE_REPLICA_SYNC_INTERVAL requires SHORTIE_REPLICA=true")
+		}
+		return false, 0, nil
+	}
+	if env.AWSCustomDynamoEndpoint == "" {
+		return false, 0, errors.New("a read replica serves the links of a shared backend, set AWS_CUSTOM_DYNAMO_ENDPOINT")
+	}
+	if env.ReplicaSyncInterval == "" {
+		return true, 0, nil
+	}
+	interval, err := time.ParseDuration(env.ReplicaSyncInterval)
+	if err != nil || interval <= 0 {
+		return false, 0, fmt.Errorf("invalid SHORTIE_REPLICA_SYNC_INTERVAL %q", env.ReplicaSyncInterval)
+	}
+	return true, interval, nil
+}
+
+// NewSnapshotStorage copies every link before returning, so a replica never serves from an empty snapshot
+func NewSnapshotStorage(ctx context.Context, storage urlStorage, interval time.Duration) (*SnapshotStorage, error) {
+	snapshot := &SnapshotStorage{urlStorage: storage, interval: interval, links: map[string]URLObject{}}
+	err := snapshot.Sync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (snapshot *SnapshotStorage) GetURL(ctx context.Context, shortID string) (*URLObject, error) {
+	snapshot.lock.RLock()
+	defer snapshot.lock.RUnlock()
+	object, found := snapshot.links[shortID]
+	if !found {
+		return nil, nil
+	}
+	return &object, nil
+}
+
+// ConsumeURL drops a link burned after reading right away, the backend decides which redirect consumed it
+func (snapshot *SnapshotStorage) ConsumeURL(ctx context.Context, shortID string) (bool, error) {
+	consumed, err := snapshot.urlStorage.ConsumeURL(ctx, shortID)
+	if consumed {
+		snapshot.lock.Lock()
+		delete(snapshot.links, shortID)
+		snapshot.lock.Unlock()
+	}
+	return consumed, err
+}
+
+// HealthCheck reports the replica unhealthy once its snapshot missed too many syncs
+func (snapshot *SnapshotStorage) HealthCheck(ctx context.Context) (HealthStatus, error) {
+	status, err := snapshot.urlStorage.HealthCheck(ctx)
+	snapshot.lock.RLock()
+	status.Snapshot = &SnapshotStatus{Links: len(snapshot.links), SyncedAt: snapshot.syncedAt.UTC()}
+	stale := time.Since(snapshot.syncedAt) > staleSnapshotSyncs*snapshot.interval
+	snapshot.lock.RUnlock()
+	if stale {
+		status.Healthy = false
+	}
+	return status, err
+}
+
+// HandleLinkEvent reads the links changed on other instances again, which would otherwise be stale until the next sync
+func (snapshot *SnapshotStorage) HandleLinkEvent(event linkEvent) {
+	snapshot.refresh(context.Background(), event.ShortID)
+}
+
+func (snapshot *SnapshotStorage) refresh(ctx context.Context, shortID string) {
+	object, err := snapshot.urlStorage.GetURL(ctx, shortID)
+	if err != nil {
+		log.Println("error: failed to refresh a link of the snapshot: " + err.Error())
+		return
+	}
+	snapshot.lock.Lock()
+	defer snapshot.lock.Unlock()
+	if snapshot.syncing {
+		snapshot.changed[shortID] = true
+	}
+	if object == nil {
+		delete(snapshot.links, shortID)
+		return
+	}
+	snapshot.links[shortID] = withoutUsage(*object)
+}
+
+// withoutUsage leaves the usage counters out of the snapshot, redirects don't read them and they take most of the memory
+func withoutUsage(object URLObject) URLObject {
+	object.Usage = nil
+	object.RuleUsage = nil
+	return object
+}
+
+// Sync copies every link from the backend, which reads the whole table
+func (snapshot *SnapshotStorage) Sync(ctx context.Context) error {
+	snapshot.lock.Lock()
+	snapshot.syncing = true
+	snapshot.changed = map[string]bool{}
+	snapshot.lock.Unlock()
+
+	links, err := snapshot.readLinks(ctx)
+
+	snapshot.lock.Lock()
+	changed := snapshot.changed
+	snapshot.syncing = false
+	snapshot.changed = nil
+	if err != nil {
+		snapshot.lock.Unlock()
+		return fmt.Errorf("failed to sync the snapshot: %w", err)
+	}
+	snapshot.links = links
+	snapshot.syncedAt = time.Now()
+	snapshot.lock.Unlock()
+
+	// links changed during the sync may have been copied from before the change
+	for shortID := range changed {
+		snapshot.refresh(ctx, shortID)
+	}
+	return nil
+}
+
+func (snapshot *SnapshotStorage) readLinks(ctx context.Context) (map[string]URLObject, error) {
+	shortIDs, err := snapshot.urlStorage.ListShortIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	links := make(map[string]URLObject, len(shortIDs))
+	for _, shortID := range shortIDs {
+		object, err := snapshot.urlStorage.GetURL(ctx, shortID)
+		if err != nil {
+			return nil, err
+		}
+		if object != nil {
+			links[shortID] = withoutUsage(*object)
+		}
+	}
+	return links, nil
+}
+
+// SyncEvery syncs the snapshot on its interval until the context is done
+func (snapshot *SnapshotStorage) SyncEvery(ctx context.Context) {
+	ticker := time.NewTicker(snapshot.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := snapshot.Sync(ctx)
+			if err != nil {
+				log.Println("error: " + err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotStorage(t *testing.T) {
+	ctx := context.Background()
+	backend := newContractLocalStorage(t)
+	require.NoError(t, backend.ImportURL(ctx, URLObject{ShortID: "abc", URL: "https://example.com"}))
+	snapshot, err := NewSnapshotStorage(ctx, backend, time.Minute)
+	require.NoError(t, err)
+
+	object, err := snapshot.GetURL(ctx, "abc")
+	require.NoError(t, err)
+	require.NotNil(t, object)
+	// changes on other instances show up with their link events, or the next sync
+	require.NoError(t, backend.ImportURL(ctx, URLObject{ShortID: "new", URL: "https://example.com/new"}))
+	object, err = snapshot.GetURL(ctx, "new")
+	require.NoError(t, err)
+	assert.Nil(t, object)
+	snapshot.HandleLinkEvent(linkEvent{Type: linkSaved, ShortID: "new"})
+	object, err = snapshot.GetURL(ctx, "new")
+	require.NoError(t, err)
+	assert.NotNil(t, object)
+
+	require.NoError(t, backend.DeleteURL(ctx, "abc"))
+	require.NoError(t, snapshot.Sync(ctx))
+	object, err = snapshot.GetURL(ctx, "abc")
+	require.NoError(t, err)
+	assert.Nil(t, object)
+
+	// clicks still reach the backend
+	require.NoError(t, snapshot.IncrementUsage(ctx, "new", "", 1, 1))
+	statistics, err := backend.GetStatistics(ctx, "new")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), usageSince(statistics.Usage, time.Time{}))
+
+	status, err := snapshot.HealthCheck(ctx)
+	require.NoError(t, err)
+	assert.True(t, status.Healthy)
+	assert.Equal(t, 1, status.Snapshot.Links)
+	// a snapshot that stopped syncing takes the replica out of rotation
+	snapshot.syncedAt = time.Now().Add(-time.Hour)
+	status, err = snapshot.HealthCheck(ctx)
+	require.NoError(t, err)
+	assert.False(t, status.Healthy)
+}
+
+func TestReplicaRoutes(t *testing.T) {
+	ctx := context.Background()
+	storage := newContractLocalStorage(t)
+	require.NoError(t, storage.ImportURL(ctx, URLObject{ShortID: "abc", URL: "https://example.com"}))
+	api := shortieAPI{storage: storage, adminToken: "admin", replica: true}
+
+	w := serveAs(api, "", http.MethodGet, "/shortie/abc", "")
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "https://example.com", w.Header().Get("Location"))
+	assert.Equal(t, http.StatusOK, serveAs(api, "", http.MethodGet, "/health", "").Code)
+	assert.Equal(t, http.StatusOK, serveAs(api, "", http.MethodGet, "/robots.txt", "").Code)
+
+	// nothing changes links on a replica
+	assert.Equal(t, http.StatusNotFound, serveAs(api, "", http.MethodPost, "/shortie", `{"url": "https://example.com/new"}`).Code)
+	assert.Equal(t, http.StatusNotFound, serveAs(api, "admin", http.MethodGet, "/admin/links", "").Code)
+	assert.Equal(t, http.StatusNotFound, serveAs(api, "", http.MethodPost, "/integrations/slack", "").Code)
+	assert.Equal(t, http.StatusNotFound, serveAs(api, "", http.MethodGet, "/v1/shortie/abc/stats", "").Code)
+}
+
+func TestInitReplica(t *testing.T) {
+	env, err := loadEnvironment("")
+	require.NoError(t, err)
+	replica, interval, err := initReplica(env)
+	require.NoError(t, err)
+	assert.False(t, replica)
+	assert.Zero(t, interval)
+
+	env.Replica = "true"
+	_, _, err = initReplica(env)
+	assert.Error(t, err, "replicas need a shared backend")
+	env.AWSCustomDynamoEndpoint = "aws"
+	env.ReplicaSyncInterval = "10m"
+	replica, interval, err = initReplica(env)
+	require.NoError(t, err)
+	assert.True(t, replica)
+	assert.Equal(t, 10*time.Minute, interval)
+
+	env.Replica = "false"
+	_, _, err = initReplica(env)
+	assert.Error(t, err)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rewriteRule redirects every path below /shortie/ that matches its pattern, without a link for each of them,
+// like go links: jira/{ticket} to https://company.atlassian.net/browse/{ticket}
+type rewriteRule struct {
+	Name string `json:"name"`
+	// Pattern is a path template where {name} matches one path segment and {name*} matches the rest of the path
+	Pattern string `json:"pattern,omitempty"`
+	// Regex is a regular expression matched against the whole path instead of a pattern, its named groups are the placeholders
+	Regex string `json:"regex,omitempty"`
+	// Target is the destination, with {name} replaced by what the placeholder of the same name matched
+	Target string `json:"target"`
+
+	matcher *regexp.Regexp
+}
+
+var (
+	rewritePlaceholder = regexp.MustCompile(`\{(\w+)(\*?)\}`)
+	targetPlaceholder  = regexp.MustCompile(`\{(\w+)\}`)
+)
+
+// compile builds the rule's matcher and checks that every placeholder of the target is matched
+func (rule *rewriteRule) compile() error {
+	if rule.Name == "" {
+		return fmt.Errorf("rewrite rules require a name")
+	}
+	if (rule.Pattern == "") == (rule.Regex == "") {
+		return fmt.Errorf("rewrite rule %s requires either a pattern or a regex", rule.Name)
+	}
+	expression := "^(?:" + rule.Regex + ")$"
+	if rule.Pattern != "" {
+		expression = patternExpression(strings.TrimPrefix(rule.Pattern, "/"))
+	}
+	matcher, err := regexp.Compile(expression)
+	if err != nil {
+		return fmt.Errorf("invalid rewrite rule %s: %w", rule.Name, err)
+	}
+	rule.matcher = matcher
+
+	for _, placeholder := range targetPlaceholder.FindAllStringSubmatch(rule.Target, -1) {
+		if matcher.SubexpIndex(placeholder[1]) < 0 {
+			return fmt.Errorf("rewrite rule %s has no placeholder %s for its target", rule.Name, placeholder[1])
+		}
+	}
+	target, err := url.Parse(targetPlaceholder.ReplaceAllString(rule.Target, "x"))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("rewrite rule %s requires an absolute http or https target", rule.Name)
+	}
+	return nil
+}
+
+// patternExpression anchors a path template as a regular expression with a named group for each placeholder
+func patternExpression(pattern string) string {
+	var expression strings.Builder
+	expression.WriteString("^")
+	last := 0
+	for _, match := range rewritePlaceholder.FindAllStringSubmatchIndex(pattern, -1) {
+		expression.WriteString(regexp.QuoteMeta(pattern[last:match[0]]))
+		name := pattern[match[2]:match[3]]
+		if match[5] > match[4] {
+			expression.WriteString("(?P<" + name + ">.+)")
+		} else {
+			expression.WriteString("(?P<" + name + ">[^/]+)")
+		}
+		last = match[1]
+	}
+	expression.WriteString(regexp.QuoteMeta(pattern[last:]))
+	expression.WriteString("$")
+	return expression.String()
+}
+
+// Destination returns the rule's target for a path below /shortie/, false if the path doesn't match.
+// Matched values are escaped for where they land in the target, so they can't add path segments to a query or parameters to it.
+func (rule *rewriteRule) Destination(path string) (string, bool) {
+	match := rule.matcher.FindStringSubmatch(path)
+	if match == nil {
+		return "", false
+	}
+	queryStart := strings.Index(rule.Target, "?")
+	var destination strings.Builder
+	last := 0
+	for _, placeholder := range targetPlaceholder.FindAllStringSubmatchIndex(rule.Target, -1) {
+		destination.WriteString(rule.Target[last:placeholder[0]])
+		value := match[rule.matcher.SubexpIndex(rule.Target[placeholder[2]:placeholder[3]])]
+		if queryStart >= 0 && placeholder[0] > queryStart {
+			destination.WriteString(url.QueryEscape(value))
+		} else {
+			// {name*} values keep their slashes in the path
+			segments := strings.Split(value, "/")
+			for i, segment := range segments {
+				segments[i] = url.PathEscape(segment)
+			}
+			destination.WriteString(strings.Join(segments, "/"))
+		}
+		last = placeholder[1]
+	}
+	destination.WriteString(rule.Target[last:])
+	return destination.String(), true
+}
+
+// loadRewriteRules reads a JSON array of rules, which are tried in order
+func loadRewriteRules(path string) ([]*rewriteRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*rewriteRule
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rewrite rules in %s: %w", path, err)
+	}
+	names := map[string]bool{}
+	for _, rule := range rules {
+		err = rule.compile()
+		if err != nil {
+			return nil, err
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate rewrite rule %s", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	return rules, nil
+}
+
+// Rewrite redirects paths matching a rewrite rule before any link is looked up, so a rule wins over a link of the same id
+func (api shortieAPI) Rewrite(c *gin.Context) {
+	path := strings.TrimPrefix(c.Request.URL.Path, "/shortie/")
+	for _, rule := range api.rewrites {
+		destination, matched := rule.Destination(path)
+		if !matched {
+			continue
+		}
+		if api.operator.Name != "" {
+			c.Header(operatorHeader, api.operator.String())
+		}
+		c.Header("Location", destination)
+		c.Status(http.StatusTemporaryRedirect)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// GetRewrites lists the rewrite rules in the order they are tried
+func (api shortieAPI) GetRewrites(c *gin.Context) {
+	rules := api.rewrites
+	if rules == nil {
+		rules = []*rewriteRule{}
+	}
+	c.JSON(http.StatusOK, rules)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteRuleDestination(t *testing.T) {
+	rules := []*rewriteRule{
+		{Name: "jira", Pattern: "jira/{ticket}", Target: "https://company.atlassian.net/browse/{ticket}"},
+		{Name: "code", Pattern: "code/{repo}/{path*}", Target: "https://github.com/company/{repo}/blob/main/{path}"},
+		{Name: "search", Regex: "s/(?P<query>.+)", Target: "https://wiki.example.com/search?q={query}"},
+	}
+	for _, rule := range rules {
+		require.NoError(t, rule.compile())
+	}
+
+	tests := []struct {
+		rule     int
+		path     string
+		expected string
+	}{
+		{0, "jira/ABC-123", "https://company.atlassian.net/browse/ABC-123"},
+		{0, "jira/ABC-123/comments", ""},
+		{0, "jira", ""},
+		{0, "xjira/ABC-123", ""},
+		{1, "code/shortie/cmd/main.go", "https://github.com/company/shortie/blob/main/cmd/main.go"},
+		{1, "code/shortie", ""},
+		// the whole path has to match a regex
+		{2, "s/tls certificates", "https://wiki.example.com/search?q=tls+certificates"},
+		{2, "docs/s/tls", ""},
+		// values can't escape the part of the target they land in
+		{0, "jira/a?b", "https://company.atlassian.net/browse/a%3Fb"},
+		{2, "s/a&admin=true", "https://wiki.example.com/search?q=a%26admin%3Dtrue"},
+	}
+	for _, test := range tests {
+		destination, matched := rules[test.rule].Destination(test.path)
+		assert.Equal(t, test.expected != "", matched, test.path)
+		assert.Equal(t, test.expected, destination, test.path)
+	}
+}
+
+func TestRewriteRuleCompile(t *testing.T) {
+	assert.Error(t, (&rewriteRule{Pattern: "jira/{ticket}", Target: "https://example.com/{ticket}"}).compile(), "no name")
+	assert.Error(t, (&rewriteRule{Name: "both", Pattern: "a", Regex: "a", Target: "https://example.com"}).compile())
+	assert.Error(t, (&rewriteRule{Name: "neither", Target: "https://example.com"}).compile())
+	assert.Error(t, (&rewriteRule{Name: "unmatched", Pattern: "jira/{ticket}", Target: "https://example.com/{issue}"}).compile())
+	assert.Error(t, (&rewriteRule{Name: "relative", Pattern: "jira/{ticket}", Target: "/browse/{ticket}"}).compile())
+	assert.Error(t, (&rewriteRule{Name: "scheme", Pattern: "jira/{ticket}", Target: "javascript:{ticket}"}).compile())
+	assert.Error(t, (&rewriteRule{Name: "regex", Regex: "(", Target: "https://example.com"}).compile())
+}
+
+func TestLoadRewriteRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rewrites.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"name":"jira","pattern":"jira/{ticket}","target":"https://company.atlassian.net/browse/{ticket}"}]`), 0o600))
+	rules, err := loadRewriteRules(path)
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+
+	require.NoError(t, os.WriteFile(path, []byte(`[{"name":"a","pattern":"a","target":"https://example.com"},{"name":"a","pattern":"b","target":"https://example.com"}]`), 0o600))
+	_, err = loadRewriteRules(path)
+	assert.ErrorContains(t, err, "duplicate")
+}
+
+func TestRewriteBeforeLookup(t *testing.T) {
+	jira := &rewriteRule{Name: "jira", Pattern: "jira/{ticket}", Target: "https://company.atlassian.net/browse/{ticket}"}
+	docs := &rewriteRule{Name: "docs", Pattern: "docs", Target: "https://docs.example.com"}
+	require.NoError(t, jira.compile())
+	require.NoError(t, docs.compile())
+	storage := newContractLocalStorage(t)
+	require.NoError(t, storage.SaveURL(context.Background(), URLObject{ShortID: "docs", URL: "https://old-docs.example.com"}))
+	api := shortieAPI{storage: storage, rewrites: []*rewriteRule{jira, docs}}
+
+	for path, expected := range map[string]string{
+		"/shortie/jira/ABC-123": "https://company.atlassian.net/browse/ABC-123",
+		"/shortie/docs":         "https://docs.example.com",
+	} {
+		w := httptest.NewRecorder()
+		api.GetRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code, path)
+		assert.Equal(t, expected, w.Header().Get("Location"), path)
+	}
+
+	w := httptest.NewRecorder()
+	api.GetRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/shortie/jira", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
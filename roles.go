@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// role is what a caller may do, each role can do everything the roles below it can
+type role int
+
+const (
+	roleNone role = iota
+	// roleViewer reads statistics and looks links up
+	roleViewer
+	// roleEditor also creates links and changes the links it created
+	roleEditor
+	// roleAdmin also changes every link and uses the admin api
+	roleAdmin
+)
+
+var roleNames = map[string]role{"viewer": roleViewer, "editor": roleEditor, "admin": roleAdmin}
+
+func (r role) String() string {
+	for name, named := range roleNames {
+		if named == r {
+			return name
+		}
+	}
+	return "none"
+}
+
+func parseRole(name string) (role, error) {
+	parsed, found := roleNames[name]
+	if !found {
+		return roleNone, fmt.Errorf("unknown role %q, expected viewer, editor or admin", name)
+	}
+	return parsed, nil
+}
+
+// principal is the authenticated caller of a request, name identifies the owner of the links it creates
+type principal struct {
+	name string
+	role role
+	// scopes and campaign further limit access tokens, nil scopes allow everything the role does
+	scopes   []string
+	campaign string
+}
+
+func (caller *principal) allows(scope string) bool {
+	if caller.scopes == nil {
+		return true
+	}
+	for _, allowed := range caller.scopes {
+		if allowed == scope {
+			return true
+		}
+	}
+	return false
+}
+
+const principalKey = "principal"
+
+type apiKey struct {
+	key       string
+	principal principal
+}
+
+// parseAPIKeys reads comma separated name=role:key entries, e.g. ci=editor:s3cret,grafana=viewer:0ther
+func parseAPIKeys(config string) ([]apiKey, error) {
+	var keys []apiKey
+	for _, entry := range strings.Split(config, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, roleAndKey, found := strings.Cut(entry, "=")
+		roleName, key, hasKey := strings.Cut(roleAndKey, ":")
+		if !found || !hasKey || name == "" || key == "" {
+			return nil, fmt.Errorf("api key %q is not name=role:key", name)
+		}
+		parsed, err := parseRole(roleName)
+		if err != nil {
+			return nil, fmt.Errorf("api key %s: %w", name, err)
+		}
+		keys = append(keys, apiKey{key: key, principal: principal{name: name, role: parsed}})
+	}
+	return keys, nil
+}
+
+// rbacEnabled is set once api keys, jwts or access tokens are configured, until then only the admin api needs a token
+func (api shortieAPI) rbacEnabled() bool {
+	return len(api.apiKeys) > 0 || len(api.jwtSecret) > 0 || api.tokens != nil
+}
+
+// authenticate finds the caller from the bearer token, nil if there is no token or it isn't valid
+func (api shortieAPI) authenticate(c *gin.Context) *principal {
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found {
+		return nil
+	}
+	return api.authenticateToken(c, token)
+}
+
+// authenticateToken finds the caller a token belongs to, which is the admin token, an api key, an access token or a jwt
+func (api shortieAPI) authenticateToken(c *gin.Context, token string) *principal {
+	if token == "" {
+		return nil
+	}
+	if api.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(api.adminToken)) == 1 {
+		return &principal{name: "admin", role: roleAdmin}
+	}
+	for _, key := range api.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key.key)) == 1 {
+			caller := key.principal
+			return &caller
+		}
+	}
+	if api.tokens != nil && strings.HasPrefix(token, accessTokenPrefix) {
+		return api.verifyAccessToken(c, token, time.Now())
+	}
+	if len(api.jwtSecret) > 0 {
+		caller, err := verifyJWT(token, api.jwtSecret, time.Now())
+		if err == nil {
+			return caller
+		}
+	}
+	return nil
+}
+
+// RequireRole only lets callers with at least the given role through, and records who they are for the handlers.
+// Without api keys, jwts or access tokens configured everyone is let through, as before roles existed.
+func (api shortieAPI) RequireRole(minimum role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if api.dev || !api.rbacEnabled() {
+			c.Next()
+			return
+		}
+		caller := api.authenticate(c)
+		if caller == nil {
+			respondError(c, http.StatusUnauthorized, codeUnauthorized, "a valid api key is required")
+			return
+		}
+		if caller.role < minimum {
+			respondError(c, http.StatusForbidden, codeForbidden, "the api key's role doesn't allow this")
+			return
+		}
+		c.Set(principalKey, caller)
+		c.Next()
+	}
+}
+
+// callerOf is the principal RequireRole let through, nil when roles aren't enforced
+func callerOf(c *gin.Context) *principal {
+	value, found := c.Get(principalKey)
+	if !found {
+		return nil
+	}
+	return value.(*principal)
+}
+
+// RequireOwner only lets editors change the links they created, admins can change every link
+func (api shortieAPI) RequireOwner(c *gin.Context) {
+	caller := callerOf(c)
+	if caller == nil || caller.role >= roleAdmin {
+		c.Next()
+		return
+	}
+	object, err := api.storage.GetURL(c, c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	// missing links are left to the handler to answer
+	if object != nil && object.Owner != caller.name {
+		respondError(c, http.StatusForbidden, codeForbidden, "only the link's owner or an admin can change it")
+		return
+	}
+	// deletes check the owner again as they write, in case the link changed since it was read
+	c.Set(ownerConditionKey, caller.name)
+	c.Next()
+}
+
+// ownerConditionKey holds the owner a link must still have for the request's delete to go through
+const ownerConditionKey = "ownerCondition"
+
+// expectedOwner returns the owner a delete is conditioned on, false for unconditioned deletes like an admin's
+func expectedOwner(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	owner, conditioned := ctx.Value(ownerConditionKey).(string)
+	return owner, conditioned
+}
+
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	Role      string `json:"role"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// verifyJWT checks an HS256 signed jwt from an identity provider and returns the caller in its sub and role claims
+func verifyJWT(token string, secret []byte, now time.Time) (*principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("a jwt has three parts")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	err = json.Unmarshal(headerJSON, &header)
+	if err != nil {
+		return nil, err
+	}
+	// the algorithm is pinned so a token can't pick a weaker one, like none
+	if header.Algorithm != "HS256" {
+		return nil, fmt.Errorf("unsupported jwt algorithm %q", header.Algorithm)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, errors.New("invalid jwt signature")
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var claims jwtClaims
+	err = json.Unmarshal(claimsJSON, &claims)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ExpiresAt != 0 && now.Unix() >= claims.ExpiresAt {
+		return nil, errors.New("the jwt has expired")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("the jwt has no sub claim")
+	}
+	parsed, err := parseRole(claims.Role)
+	if err != nil {
+		return nil, err
+	}
+	return &principal{name: claims.Subject, role: parsed}, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signJWT makes an HS256 jwt with the given header and claims json
+func signJWT(header string, claims string, secret string) string {
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := parseAPIKeys("ci=editor:s3cret, grafana=viewer:a:b")
+	require.NoError(t, err)
+	assert.Equal(t, []apiKey{
+		{key: "s3cret", principal: principal{name: "ci", role: roleEditor}},
+		{key: "a:b", principal: principal{name: "grafana", role: roleViewer}},
+	}, keys)
+
+	keys, err = parseAPIKeys("")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	_, err = parseAPIKeys("ci=editor")
+	assert.Error(t, err)
+	_, err = parseAPIKeys("ci=owner:s3cret")
+	assert.Error(t, err)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	header := `{"alg":"HS256","typ":"JWT"}`
+
+	caller, err := verifyJWT(signJWT(header, `{"sub":"alice","role":"editor","exp":1700000060}`, "secret"), []byte("secret"), now)
+	require.NoError(t, err)
+	assert.Equal(t, &principal{name: "alice", role: roleEditor}, caller)
+
+	_, err = verifyJWT(signJWT(header, `{"sub":"alice","role":"editor","exp":1700000000}`, "secret"), []byte("secret"), now)
+	assert.Error(t, err, "expired")
+	_, err = verifyJWT(signJWT(header, `{"sub":"alice","role":"admin"}`, "other"), []byte("secret"), now)
+	assert.Error(t, err, "wrong secret")
+	_, err = verifyJWT(signJWT(`{"alg":"none"}`, `{"sub":"alice","role":"admin"}`, "secret"), []byte("secret"), now)
+	assert.Error(t, err, "algorithm")
+	_, err = verifyJWT(signJWT(header, `{"role":"admin"}`, "secret"), []byte("secret"), now)
+	assert.Error(t, err, "no subject")
+	_, err = verifyJWT(signJWT(header, `{"sub":"alice","role":"owner"}`, "secret"), []byte("secret"), now)
+	assert.Error(t, err, "unknown role")
+	_, err = verifyJWT("not-a-jwt", []byte("secret"), now)
+	assert.Error(t, err)
+}
+
+func TestDeleteURLOwnerCondition(t *testing.T) {
+	ctx := context.Background()
+	local := newContractLocalStorage(t)
+	require.NoError(t, local.SaveURL(ctx, URLObject{ShortID: "abc", URL: "https://example.com", Owner: "alice"}))
+
+	// the link changed hands after the caller's owner check
+	err := local.DeleteURL(context.WithValue(ctx, ownerConditionKey, "bob"), "abc")
+	assert.ErrorIs(t, err, errNotOwner)
+	object, err := local.GetURL(ctx, "abc")
+	require.NoError(t, err)
+	assert.NotNil(t, object)
+
+	require.NoError(t, local.DeleteURL(context.WithValue(ctx, ownerConditionKey, "alice"), "abc"))
+	object, err = local.GetURL(ctx, "abc")
+	require.NoError(t, err)
+	assert.Nil(t, object)
+
+	// deleting a link that is already gone stays idempotent
+	assert.NoError(t, local.DeleteURL(context.WithValue(ctx, ownerConditionKey, "bob"), "abc"))
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule overrides a link's destination during windows of the week, evaluated in Timezone (UTC if empty)
+// outside of every window the link's own URL is used as the fallback
+type Schedule struct {
+	Timezone string         `dynamodbav:"timezone" json:"timezone,omitempty"`
+	Rules    []ScheduleRule `dynamodbav:"rules" json:"rules,omitempty"`
+}
+
+// ScheduleRule matches from Start up to End ("15:04" format) on the given Days ("mon", "tue", ...)
+// an empty Days list matches every day, a window with End before Start wraps past midnight,
+// and a window with End equal to Start covers the whole day
+type ScheduleRule struct {
+	Days  []string `dynamodbav:"days" json:"days,omitempty"`
+	Start string   `dynamodbav:"start" json:"start"`
+	End   string   `dynamodbav:"end" json:"end"`
+	URL   string   `dynamodbav:"url" json:"url"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func (schedule Schedule) Validate() error {
+	_, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", schedule.Timezone, err)
+	}
+	for _, rule := range schedule.Rules {
+		for _, day := range rule.Days {
+			_, found := weekdays[strings.ToLower(day)]
+			if !found {
+				return fmt.Errorf("invalid day %q", day)
+			}
+		}
+		_, err = parseTimeOfDay(rule.Start)
+		if err != nil {
+			return fmt.Errorf("invalid start %q: %w", rule.Start, err)
+		}
+		_, err = parseTimeOfDay(rule.End)
+		if err != nil {
+			return fmt.Errorf("invalid end %q: %w", rule.End, err)
+		}
+		if rule.URL == "" {
+			return fmt.Errorf("schedule rules require a url")
+		}
+	}
+	return nil
+}
+
+// Destination returns the url of the first rule matching now, if any
+func (schedule Schedule) Destination(now time.Time) (string, bool) {
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return "", false
+	}
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+
+	for _, rule := range schedule.Rules {
+		start, err := parseTimeOfDay(rule.Start)
+		if err != nil {
+			continue
+		}
+		end, err := parseTimeOfDay(rule.End)
+		if err != nil {
+			continue
+		}
+
+		day := local.Weekday()
+		var inWindow bool
+		if start == end {
+			inWindow = true
+		} else if start < end {
+			inWindow = minute >= start && minute < end
+		} else {
+			inWindow = minute >= start || minute < end
+			// the early morning part of an overnight window belongs to the previous day's rule
+			if minute < end {
+				day = (day + 6) % 7
+			}
+		}
+		if inWindow && ruleAppliesOn(rule.Days, day) {
+			return rule.URL, true
+		}
+	}
+	return "", false
+}
+
+func ruleAppliesOn(days []string, day time.Weekday) bool {
+	if len(days) == 0 {
+		return true
+	}
+	for _, name := range days {
+		if weekdays[strings.ToLower(name)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTimeOfDay converts "15:04" into minutes since midnight
+func parseTimeOfDay(value string) (int, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// ReferrerRule overrides a link's destination for clicks whose Referer is Host or one of its subdomains
+type ReferrerRule struct {
+	Host string `dynamodbav:"host" json:"host"`
+	URL  string `dynamodbav:"url" json:"url"`
+}
+
+func validateReferrerRules(rules []ReferrerRule) error {
+	for _, rule := range rules {
+		if rule.Host == "" || rule.URL == "" {
+			return fmt.Errorf("referrer rules require a host and a url")
+		}
+	}
+	return nil
+}
+
+// matchReferrerRule returns the first rule matching the referer header value, if any
+func matchReferrerRule(rules []ReferrerRule, referer string) (ReferrerRule, bool) {
+	if referer == "" {
+		return ReferrerRule{}, false
+	}
+	parsed, err := url.Parse(referer)
+	if err != nil {
+		return ReferrerRule{}, false
+	}
+	host := strings.ToLower(parsed.Hostname())
+
+	for _, rule := range rules {
+		ruleHost := strings.ToLower(rule.Host)
+		if host == ruleHost || strings.HasSuffix(host, "."+ruleHost) {
+			return rule, true
+		}
+	}
+	return ReferrerRule{}, false
+}
+
+func validateLanguageRules(rules map[string]string) error {
+	for language, destination := range rules {
+		if language == "" || destination == "" {
+			return fmt.Errorf("language rules require a language and a url")
+		}
+	}
+	return nil
+}
+
+// matchLanguageRule picks the destination for the most preferred language in an Accept-Language header
+// an exact tag match ("pt-br") is used before falling back to the base language ("pt")
+func matchLanguageRule(rules map[string]string, acceptLanguage string) (string, string, bool) {
+	if len(rules) == 0 {
+		return "", "", false
+	}
+	normalized := make(map[string]string, len(rules))
+	for language, destination := range rules {
+		normalized[strings.ToLower(language)] = destination
+	}
+
+	for _, language := range parseAcceptLanguage(acceptLanguage) {
+		destination, found := normalized[language]
+		if found {
+			return language, destination, true
+		}
+		base, _, hasRegion := strings.Cut(language, "-")
+		if hasRegion {
+			destination, found = normalized[base]
+			if found {
+				return base, destination, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// parseAcceptLanguage returns the lowercased language tags of the header ordered by preference
+func parseAcceptLanguage(header string) []string {
+	type weightedLanguage struct {
+		tag    string
+		weight float64
+	}
+	var languages []weightedLanguage
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		weight := 1.0
+		quality, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if found {
+			parsed, err := strconv.ParseFloat(quality, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+		if weight <= 0 {
+			continue
+		}
+		languages = append(languages, weightedLanguage{tag: tag, weight: weight})
+	}
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].weight > languages[j].weight
+	})
+
+	tags := make([]string, 0, len(languages))
+	for _, language := range languages {
+		tags = append(tags, language.tag)
+	}
+	return tags
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleDestination(t *testing.T) {
+	schedule := Schedule{
+		Timezone: "America/Denver",
+		Rules: []ScheduleRule{
+			{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "17:00", URL: "https://example.com/chat"},
+			{Days: []string{"fri"}, Start: "22:00", End: "02:00", URL: "https://example.com/late"},
+		},
+	}
+	require.NoError(t, schedule.Validate())
+
+	denver, err := time.LoadLocation("America/Denver")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		now             time.Time
+		expectedURL     string
+		expectedMatched bool
+	}{
+		{
+			name:            "weekday business hours",
+			now:             time.Date(2023, time.November, 6, 10, 30, 0, 0, denver),
+			expectedURL:     "https://example.com/chat",
+			expectedMatched: true,
+		},
+		{
+			name: "weekday after hours",
+			now:  time.Date(2023, time.November, 6, 17, 0, 0, 0, denver),
+		},
+		{
+			name: "weekend",
+			now:  time.Date(2023, time.November, 4, 10, 30, 0, 0, denver),
+		},
+		{
+			name:            "overnight window after midnight",
+			now:             time.Date(2023, time.November, 4, 1, 0, 0, 0, denver),
+			expectedURL:     "https://example.com/late",
+			expectedMatched: true,
+		},
+		{
+			name:            "evaluated in the schedule's timezone",
+			now:             time.Date(2023, time.November, 6, 17, 30, 0, 0, time.UTC),
+			expectedURL:     "https://example.com/chat",
+			expectedMatched: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			url, matched := schedule.Destination(test.now)
+			assert.Equal(t, test.expectedMatched, matched)
+			assert.Equal(t, test.expectedURL, url)
+		})
+	}
+}
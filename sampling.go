@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// usageSampler records only 1 in rate clicks of busy links in the detailed usage counters, weighted by rate
+// so the counters stay unbiased estimates. The daily usage still counts every click exactly.
+// Traffic is measured per replica, a link is busy once it gets more than threshold clicks in a window.
+type usageSampler struct {
+	rate      int64
+	threshold int
+	window    time.Duration
+
+	lock        sync.Mutex
+	windowStart time.Time
+	clicks      map[string]int
+}
+
+func newUsageSampler(rate int64, threshold int) *usageSampler {
+	return &usageSampler{
+		rate:      rate,
+		threshold: threshold,
+		window:    time.Minute,
+		clicks:    map[string]int{},
+	}
+}
+
+// Weight returns what a click on shortID adds to the detailed usage counters, 0 if it isn't sampled
+func (sampler *usageSampler) Weight(shortID string, now time.Time) int64 {
+	sampler.lock.Lock()
+	// the counts start over every window, which also keeps the map from growing with every link ever clicked
+	if now.Sub(sampler.windowStart) >= sampler.window {
+		sampler.windowStart = now
+		sampler.clicks = map[string]int{}
+	}
+	sampler.clicks[shortID]++
+	busy := sampler.clicks[shortID] > sampler.threshold
+	sampler.lock.Unlock()
+
+	if !busy {
+		return 1
+	}
+	if rand.Int63n(sampler.rate) != 0 {
+		return 0
+	}
+	return sampler.rate
+}
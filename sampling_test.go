@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageSampler(t *testing.T) {
+	sampler := newUsageSampler(10, 5)
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, int64(1), sampler.Weight("111", now))
+	}
+
+	// past the threshold clicks are either left out or stand in for rate clicks
+	total := int64(0)
+	for i := 0; i < 10000; i++ {
+		weight := sampler.Weight("111", now)
+		assert.Contains(t, []int64{0, 10}, weight)
+		total += weight
+	}
+	assert.InDelta(t, 10000, total, 2000)
+
+	// other links and later windows aren't affected
+	assert.Equal(t, int64(1), sampler.Weight("222", now))
+	assert.Equal(t, int64(1), sampler.Weight("111", now.Add(time.Minute)))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// scannerDetector denylists clients that probe for links, which shows up as many 404s from one ip
+// counts are kept per replica, so a scanner spread over replicas needs more requests to be caught
+type scannerDetector struct {
+	// threshold 404s within window gets an ip denied for banDuration
+	threshold   int
+	window      time.Duration
+	banDuration time.Duration
+	// tarpit is how long denied requests are held before being answered, to slow scanners down
+	tarpit time.Duration
+
+	lock      sync.Mutex
+	misses    map[string]*missCount
+	denied    map[string]time.Time
+	lastPrune time.Time
+}
+
+type missCount struct {
+	count       int
+	windowStart time.Time
+}
+
+type deniedScanner struct {
+	IP    string    `json:"ip"`
+	Until time.Time `json:"until"`
+}
+
+func newScannerDetector(threshold int, window time.Duration, banDuration time.Duration, tarpit time.Duration) *scannerDetector {
+	return &scannerDetector{
+		threshold:   threshold,
+		window:      window,
+		banDuration: banDuration,
+		tarpit:      tarpit,
+		misses:      map[string]*missCount{},
+		denied:      map[string]time.Time{},
+	}
+}
+
+// RecordMiss counts a 404 for ip, reporting whether it got the ip denied
+func (detector *scannerDetector) RecordMiss(ip string, now time.Time) bool {
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+
+	detector.prune(now)
+	misses, found := detector.misses[ip]
+	if !found || now.Sub(misses.windowStart) >= detector.window {
+		misses = &missCount{windowStart: now}
+		detector.misses[ip] = misses
+	}
+	misses.count++
+	if misses.count < detector.threshold {
+		return false
+	}
+	delete(detector.misses, ip)
+	detector.denied[ip] = now.Add(detector.banDuration)
+	return true
+}
+
+func (detector *scannerDetector) Denied(ip string, now time.Time) bool {
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+
+	until, found := detector.denied[ip]
+	return found && now.Before(until)
+}
+
+func (detector *scannerDetector) List(now time.Time) []deniedScanner {
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+
+	detector.prune(now)
+	list := make([]deniedScanner, 0, len(detector.denied))
+	for ip, until := range detector.denied {
+		list = append(list, deniedScanner{IP: ip, Until: until})
+	}
+	return list
+}
+
+// Remove lifts the denial of an ip, e.g. one that turns out to be a legitimate client behind a shared NAT
+func (detector *scannerDetector) Remove(ip string) bool {
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+
+	_, found := detector.denied[ip]
+	delete(detector.denied, ip)
+	delete(detector.misses, ip)
+	return found
+}
+
+// prune drops expired counts and denials at most once per window, the caller must hold the lock
+func (detector *scannerDetector) prune(now time.Time) {
+	if now.Sub(detector.lastPrune) < detector.window {
+		return
+	}
+	detector.lastPrune = now
+	for ip, misses := range detector.misses {
+		if now.Sub(misses.windowStart) >= detector.window {
+			delete(detector.misses, ip)
+		}
+	}
+	for ip, until := range detector.denied {
+		if !now.Before(until) {
+			delete(detector.denied, ip)
+		}
+	}
+}
+
+// DetectScanners tarpits denied clients and counts the 404s of everyone else
+func (api shortieAPI) DetectScanners(c *gin.Context) {
+	if api.scanners == nil {
+		c.Next()
+		return
+	}
+	ip := c.ClientIP()
+	if api.scanners.Denied(ip, time.Now()) {
+		select {
+		case <-time.After(api.scanners.tarpit):
+		case <-c.Request.Context().Done():
+		}
+		// answered like any missing link so the scanner learns nothing from it
+		c.String(http.StatusNotFound, "Not Found")
+		c.Abort()
+		return
+	}
+
+	c.Next()
+	if c.Writer.Status() == http.StatusNotFound && api.scanners.RecordMiss(ip, time.Now()) {
+		log.Printf("denying %s for probing nonexistent links\n", ip)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScannerDetector(t *testing.T) {
+	detector := newScannerDetector(3, time.Minute, time.Hour, 0)
+	now := time.Now()
+
+	assert.False(t, detector.RecordMiss("192.0.2.10", now))
+	assert.False(t, detector.RecordMiss("192.0.2.10", now.Add(time.Second)))
+	assert.False(t, detector.RecordMiss("192.0.2.10", now.Add(2*time.Minute)), "misses outside the window start a new count")
+	assert.False(t, detector.RecordMiss("192.0.2.10", now.Add(2*time.Minute)))
+	assert.False(t, detector.Denied("192.0.2.10", now.Add(2*time.Minute)))
+
+	assert.True(t, detector.RecordMiss("192.0.2.10", now.Add(2*time.Minute)))
+	assert.True(t, detector.Denied("192.0.2.10", now.Add(2*time.Minute)))
+	assert.False(t, detector.Denied("198.51.100.10", now.Add(2*time.Minute)))
+	assert.Equal(t, []deniedScanner{{IP: "192.0.2.10", Until: now.Add(time.Hour + 2*time.Minute)}}, detector.List(now.Add(2*time.Minute)))
+
+	assert.False(t, detector.Denied("192.0.2.10", now.Add(2*time.Hour)), "denials expire")
+	assert.Empty(t, detector.List(now.Add(2*time.Hour)))
+
+	detector.RecordMiss("192.0.2.10", now)
+	detector.RecordMiss("192.0.2.10", now)
+	detector.RecordMiss("192.0.2.10", now)
+	assert.True(t, detector.Remove("192.0.2.10"))
+	assert.False(t, detector.Denied("192.0.2.10", now))
+	assert.False(t, detector.Remove("192.0.2.10"))
+}
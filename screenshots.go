@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxThumbnailBytes is the largest screenshot kept, providers are asked for thumbnails, not full pages
+	maxThumbnailBytes = 1 << 20
+	// maxThumbnails bounds the cache, the oldest screenshot makes room for a new one
+	maxThumbnails = 1000
+)
+
+// screenshotProvider captures an image of the page at a url
+type screenshotProvider interface {
+	Capture(ctx context.Context, url string) (image []byte, contentType string, err error)
+}
+
+// HTTPScreenshotProvider gets screenshots with a GET of a url template whose {url} is replaced by the escaped destination,
+// e.g. a headless Chrome service at https://chrome.internal/screenshot?url={url}, or a third-party api with its access key in the template
+type HTTPScreenshotProvider struct {
+	client   *http.Client
+	template string
+}
+
+func NewHTTPScreenshotProvider(template string) (*HTTPScreenshotProvider, error) {
+	if !strings.Contains(template, "{url}") {
+		return nil, errors.New("invalid SHORTIE_SCREENSHOT_URL: it must contain {url}")
+	}
+	_, err := url.Parse(strings.ReplaceAll(template, "{url}", "x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHORTIE_SCREENSHOT_URL: %w", err)
+	}
+	// rendering a page takes a while, longer than fetching one
+	return &HTTPScreenshotProvider{client: &http.Client{Timeout: 30 * time.Second}, template: template}, nil
+}
+
+func (provider *HTTPScreenshotProvider) Capture(ctx context.Context, destination string) ([]byte, string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.ReplaceAll(provider.template, "{url}", url.QueryEscape(destination)), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	response, err := provider.client.Do(request)
+	if err != nil {
+		// the error includes the request url, which can hold the provider's access key
+		return nil, "", errors.New("the screenshot provider can't be reached")
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, "", fmt.Errorf("the screenshot provider responded with %d", response.StatusCode)
+	}
+	contentType, _, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(contentType, "image/") {
+		return nil, "", fmt.Errorf("the screenshot provider responded with %q instead of an image", response.Header.Get("Content-Type"))
+	}
+	image, err := io.ReadAll(io.LimitReader(response.Body, maxThumbnailBytes+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read the screenshot: %w", err)
+	}
+	if len(image) > maxThumbnailBytes {
+		return nil, "", fmt.Errorf("the screenshot is larger than %d bytes", maxThumbnailBytes)
+	}
+	return image, contentType, nil
+}
+
+type thumbnail struct {
+	image       []byte
+	contentType string
+	captured    time.Time
+}
+
+// thumbnails caches a screenshot per destination, links to the same page share it.
+// The cache is per replica, each replica captures a destination once per ttl.
+type thumbnails struct {
+	provider screenshotProvider
+	ttl      time.Duration
+
+	lock    sync.Mutex
+	entries map[string]thumbnail
+	// capturing holds a channel per destination being captured, closed when it is done,
+	// so concurrent requests for a new destination wait for one capture instead of starting their own
+	capturing map[string]chan struct{}
+}
+
+func newThumbnails(provider screenshotProvider, ttl time.Duration) *thumbnails {
+	return &thumbnails{provider: provider, ttl: ttl, entries: map[string]thumbnail{}, capturing: map[string]chan struct{}{}}
+}
+
+func initThumbnails(env Environment) (*thumbnails, error) {
+	provider, err := NewHTTPScreenshotProvider(env.ScreenshotURL)
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := time.ParseDuration(env.ScreenshotTTL)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("invalid SHORTIE_SCREENSHOT_TTL %q", env.ScreenshotTTL)
+	}
+	return newThumbnails(provider, ttl), nil
+}
+
+// Get returns the thumbnail of a destination, capturing it if it isn't cached or is older than the ttl
+func (cache *thumbnails) Get(ctx context.Context, destination string, now time.Time) (thumbnail, error) {
+	key := hashURL(destination)
+	for {
+		cache.lock.Lock()
+		entry, found := cache.entries[key]
+		if found && now.Sub(entry.captured) < cache.ttl {
+			cache.lock.Unlock()
+			return entry, nil
+		}
+		done, capturing := cache.capturing[key]
+		if !capturing {
+			done = make(chan struct{})
+			cache.capturing[key] = done
+			cache.lock.Unlock()
+			break
+		}
+		cache.lock.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return thumbnail{}, ctx.Err()
+		}
+		// a failed capture isn't cached, so a waiting request tries it again itself
+	}
+
+	image, contentType, err := cache.provider.Capture(ctx, destination)
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	close(cache.capturing[key])
+	delete(cache.capturing, key)
+	if err != nil {
+		return thumbnail{}, err
+	}
+	entry := thumbnail{image: image, contentType: contentType, captured: now}
+	if _, found := cache.entries[key]; !found && len(cache.entries) >= maxThumbnails {
+		cache.evictOldest()
+	}
+	cache.entries[key] = entry
+	return entry, nil
+}
+
+func (cache *thumbnails) evictOldest() {
+	oldest := ""
+	for key, entry := range cache.entries {
+		if oldest == "" || entry.captured.Before(cache.entries[oldest].captured) {
+			oldest = key
+		}
+	}
+	delete(cache.entries, oldest)
+}
+
+// GetThumbnail serves a screenshot of a link's destination, for previews in admin tools and link unfurls
+func (api shortieAPI) GetThumbnail(c *gin.Context) {
+	if api.thumbnails == nil {
+		respondError(c, http.StatusBadRequest, codeFeatureDisabled, "thumbnails are not enabled")
+		return
+	}
+	object, err := api.storage.GetURL(c, c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	now := time.Now()
+	if object == nil || (object.Expiration != 0 && now.Unix() >= object.Expiration) {
+		respondNotFound(c)
+		return
+	}
+	// the destinations of links flagged as spam aren't visited
+	if object.Quarantined {
+		respondError(c, http.StatusForbidden, codeForbidden, "the link is quarantined")
+		return
+	}
+	destination, err := url.Parse(object.URL)
+	if err != nil || (destination.Scheme != "http" && destination.Scheme != "https") {
+		respondError(c, http.StatusBadRequest, codeURLInvalid, "only http and https destinations have thumbnails")
+		return
+	}
+	entry, err := api.thumbnails.Get(c, object.URL, now)
+	if err != nil {
+		respondError(c, http.StatusBadGateway, codeURLInvalid, "the destination can't be captured: "+err.Error())
+		return
+	}
+	maxAge := int(api.thumbnails.ttl.Seconds() - now.Sub(entry.captured).Seconds())
+	c.Header("Cache-Control", "private, max-age="+strconv.Itoa(max(maxAge, 0)))
+	c.Data(http.StatusOK, entry.contentType, entry.image)
+}
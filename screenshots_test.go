@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeScreenshots captures every destination as its own url, and fails for urls in failing
+type fakeScreenshots struct {
+	lock     sync.Mutex
+	captures int
+	failing  map[string]bool
+}
+
+func (provider *fakeScreenshots) Capture(ctx context.Context, url string) ([]byte, string, error) {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	provider.captures++
+	if provider.failing[url] {
+		return nil, "", errors.New("timed out")
+	}
+	return []byte("png of " + url), "image/png", nil
+}
+
+func TestThumbnails(t *testing.T) {
+	provider := &fakeScreenshots{failing: map[string]bool{"https://example.com/down": true}}
+	api := shortieAPI{storage: newContractLocalStorage(t), thumbnails: newThumbnails(provider, time.Hour)}
+	for _, object := range []URLObject{
+		{ShortID: "a", URL: "https://example.com/page"},
+		{ShortID: "b", URL: "https://example.com/page"},
+		{ShortID: "down", URL: "https://example.com/down"},
+		{ShortID: "spam", URL: "https://example.com/spam", Quarantined: true},
+	} {
+		require.NoError(t, api.storage.ImportURL(context.Background(), object))
+	}
+
+	w := serveAs(api, "", http.MethodGet, "/shortie/a/thumbnail", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	assert.Equal(t, "png of https://example.com/page", w.Body.String())
+	assert.Equal(t, "private, max-age=3600", w.Header().Get("Cache-Control"))
+
+	// links to the same destination share its thumbnail
+	w = serveAs(api, "", http.MethodGet, "/shortie/b/thumbnail", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, provider.captures)
+
+	w = serveAs(api, "", http.MethodGet, "/shortie/down/thumbnail", "")
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	w = serveAs(api, "", http.MethodGet, "/shortie/spam/thumbnail", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serveAs(api, "", http.MethodGet, "/shortie/missing/thumbnail", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, 2, provider.captures)
+
+	// expired thumbnails are captured again
+	_, err := api.thumbnails.Get(context.Background(), "https://example.com/page", time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 3, provider.captures)
+}
+
+func TestHTTPScreenshotProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("url") == "https://example.com/text?a=1&b=2" {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<p>an error page</p>"))
+			return
+		}
+		assert.Equal(t, "secret", r.URL.Query().Get("key"))
+		w.Header().Set("Content-Type", "image/jpeg")
+		_, _ = w.Write([]byte("jpeg of " + r.URL.Query().Get("url")))
+	}))
+	defer server.Close()
+
+	_, err := NewHTTPScreenshotProvider(server.URL + "/screenshot")
+	assert.Error(t, err, "a template without {url}")
+
+	provider, err := NewHTTPScreenshotProvider(server.URL + "/screenshot?key=secret&url={url}")
+	require.NoError(t, err)
+	image, contentType, err := provider.Capture(context.Background(), "https://example.com/a?b=1&c=2")
+	require.NoError(t, err)
+	assert.Equal(t, "image/jpeg", contentType)
+	assert.Equal(t, "jpeg of https://example.com/a?b=1&c=2", string(image))
+
+	_, _, err = provider.Capture(context.Background(), "https://example.com/text?a=1&b=2")
+	assert.ErrorContains(t, err, "instead of an image")
+}
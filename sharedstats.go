@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Owners share the statistics of a link with people without a token through a signed url of its stats page,
+// which works until it expires. The signature covers the shortID and the expiration, so rotating
+// SHORTIE_STATS_SHARE_SECRET revokes every shared url at once.
+
+const (
+	// defaultShareTTL and maxShareTTL bound how long a shared stats url works
+	defaultShareTTL = 7 * 24 * time.Hour
+	maxShareTTL     = 90 * 24 * time.Hour
+	// sharedStatsDays is how many days of daily clicks the shared page lists
+	sharedStatsDays = 30
+)
+
+// shareSignature signs the stats of a link, apart from the signatures of signed links which cover the bare shortID
+func shareSignature(secret string, shortID string, expires int64) string {
+	return signShortID(secret, "stats:"+shortID, expires)
+}
+
+// ShareStats answers a signed url of the link's stats page, expiring at expiration or in a week
+func (api shortieAPI) ShareStats(c *gin.Context) {
+	if api.statsShareSecret == "" {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "sharing stats requires SHORTIE_STATS_SHARE_SECRET")
+		return
+	}
+	body := struct {
+		Expiration int64 `json:"expiration"`
+	}{}
+	if c.Request.ContentLength != 0 && !api.bindJSON(c, &body) {
+		return
+	}
+	now := time.Now()
+	if body.Expiration == 0 {
+		body.Expiration = now.Add(defaultShareTTL).Unix()
+	}
+	if body.Expiration <= now.Unix() || body.Expiration > now.Add(maxShareTTL).Unix() {
+		respondError(c, http.StatusBadRequest, codeExpirationInvalid, fmt.Sprintf("expiration must be in the future and at most %s away", maxShareTTL))
+		return
+	}
+
+	shortID := c.Param("id")
+	object, err := api.storage.GetURL(c, shortID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if object == nil {
+		respondNotFound(c)
+		return
+	}
+	query := url.Values{}
+	query.Set(expiresQueryParam, strconv.FormatInt(body.Expiration, 10))
+	query.Set(signatureQueryParam, shareSignature(api.statsShareSecret, shortID, body.Expiration))
+	c.JSON(http.StatusOK, gin.H{
+		"url":        "http://localhost:8421/shared/stats/" + url.PathEscape(shortID) + "?" + query.Encode(),
+		"expiration": body.Expiration,
+	})
+}
+
+var sharedStatsPage = template.Must(template.New("stats").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Statistics of {{.ShortID}}</title>
+<link rel="stylesheet" href="/static/style.css">
+</head>
+<body>
+<h1>Statistics of /shortie/{{.ShortID}}</h1>
+{{if .Title}}<p>{{.Title}}</p>{{end}}
+<table>
+<tr><th>Last day</th><td>{{.Summary.lastDay}}</td></tr>
+<tr><th>Last 7 days</th><td>{{.Summary.lastWeek}}</td></tr>
+<tr><th>Last 30 days</th><td>{{.Summary.last30Days}}</td></tr>
+<tr><th>This month</th><td>{{.Summary.lastMonth}}</td></tr>
+<tr><th>All time</th><td>{{.Summary.allTime}}</td></tr>
+</table>
+<h2>Daily clicks</h2>
+<table>
+{{range .Days}}<tr><th>{{.Day}}</th><td>{{.Clicks}}</td></tr>
+{{end}}</table>
+<p>Shared until {{.Until}}.</p>
+</body>
+</html>
+`))
+
+// sharedDay is a row of the daily clicks on the shared stats page
+type sharedDay struct {
+	Day    string
+	Clicks int64
+}
+
+// GetSharedStats shows the stats page of a link to anyone with a valid shared url
+func (api shortieAPI) GetSharedStats(c *gin.Context) {
+	shortID := c.Param("id")
+	now := time.Now()
+	expires, _ := strconv.ParseInt(c.Query(expiresQueryParam), 10, 64)
+	// urls without an expiration are never made, so one can't be crafted from a signature that lasts forever
+	if api.statsShareSecret == "" || expires == 0 ||
+		!verifySignature(api.statsShareSecret, "stats:"+shortID, c.Query(signatureQueryParam), expires, now) {
+		c.String(http.StatusForbidden, "Forbidden")
+		return
+	}
+	object, err := api.storage.GetURL(c, shortID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if object == nil {
+		c.String(http.StatusNotFound, "Not Found")
+		return
+	}
+	statistics, err := api.statistics(c, shortID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+
+	today := UTCTimestampOfTodayRounded()
+	days := make([]sharedDay, 0, sharedStatsDays)
+	for i := 0; i < sharedStatsDays; i++ {
+		day := today.AddDate(0, 0, -i)
+		days = append(days, sharedDay{Day: day.Format(time.DateOnly), Clicks: statistics.Usage[strconv.FormatInt(day.Unix(), 10)]})
+	}
+	var page bytes.Buffer
+	err = sharedStatsPage.Execute(&page, map[string]any{
+		"ShortID": shortID,
+		"Title":   object.Title,
+		"Summary": summarizeUsage(statistics),
+		"Days":    days,
+		"Until":   time.Unix(expires, 0).UTC().Format(time.RFC1123),
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	// the signature is in the url, so it isn't cached, indexed or sent on as a referrer
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("X-Robots-Tag", "noindex")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+const (
+	signatureQueryParam = "sig"
+	expiresQueryParam   = "exp"
+)
+
+func newSigningSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// signShortID produces the signature a signed link expects in its sig query parameter
+// expires is a unix timestamp and 0 means the signature never expires
+func signShortID(secret string, shortID string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(shortID + "." + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifySignature(secret string, shortID string, signature string, expires int64, now time.Time) bool {
+	if expires != 0 && now.Unix() >= expires {
+		return false
+	}
+	expected := signShortID(secret, shortID, expires)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxSitemapURLs is the most urls a sitemap file can list, links past it are left out
+	maxSitemapURLs = 50000
+	// sitemapMaxAge rebuilds a sitemap that saw no changes, links expire and activate without one,
+	// and replicas without a link event bus don't hear about changes made on other replicas
+	sitemapMaxAge = time.Hour
+)
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc string `xml:"loc"`
+}
+
+// SitemapStorage serves the sitemap of the indexable links, it is built on the first request after a link changed
+// changes made through this instance and the link events of other replicas mark it stale
+type SitemapStorage struct {
+	urlStorage
+
+	// changes counts the changes to links, the sitemap is stale once it goes past the count it was built at
+	changes atomic.Int64
+	lock    sync.Mutex
+	built   int64
+	builtAt time.Time
+	sitemap []byte
+	// paths are the link paths in the sitemap, robots.txt allows them past its disallow of /shortie/
+	paths []string
+}
+
+func NewSitemapStorage(storage urlStorage) *SitemapStorage {
+	return &SitemapStorage{urlStorage: storage}
+}
+
+func (sitemap *SitemapStorage) changed() {
+	sitemap.changes.Add(1)
+}
+
+// HandleLinkEvent marks the sitemap stale when other replicas change links
+func (sitemap *SitemapStorage) HandleLinkEvent(event linkEvent) {
+	sitemap.changed()
+}
+
+func (sitemap *SitemapStorage) SaveURL(ctx context.Context, object URLObject) error {
+	err := sitemap.urlStorage.SaveURL(ctx, object)
+	if err == nil {
+		sitemap.changed()
+	}
+	return err
+}
+
+func (sitemap *SitemapStorage) ImportURL(ctx context.Context, object URLObject) error {
+	err := sitemap.urlStorage.ImportURL(ctx, object)
+	if err == nil {
+		sitemap.changed()
+	}
+	return err
+}
+
+func (sitemap *SitemapStorage) SetPaused(ctx context.Context, shortID string, paused bool) error {
+	err := sitemap.urlStorage.SetPaused(ctx, shortID, paused)
+	if err == nil {
+		sitemap.changed()
+	}
+	return err
+}
+
+func (sitemap *SitemapStorage) SetQuarantined(ctx context.Context, shortID string, quarantined bool) error {
+	err := sitemap.urlStorage.SetQuarantined(ctx, shortID, quarantined)
+	if err == nil {
+		sitemap.changed()
+	}
+	return err
+}
+
+func (sitemap *SitemapStorage) SetExpiration(ctx context.Context, shortID string, expiration int64) error {
+	err := sitemap.urlStorage.SetExpiration(ctx, shortID, expiration)
+	if err == nil {
+		sitemap.changed()
+	}
+	return err
+}
+
+func (sitemap *SitemapStorage) DeleteURL(ctx context.Context, shortID string) error {
+	err := sitemap.urlStorage.DeleteURL(ctx, shortID)
+	if err == nil {
+		sitemap.changed()
+	}
+	return err
+}
+
+func (sitemap *SitemapStorage) ConsumeURL(ctx context.Context, shortID string) (bool, error) {
+	consumed, err := sitemap.urlStorage.ConsumeURL(ctx, shortID)
+	if err == nil && consumed {
+		sitemap.changed()
+	}
+	return consumed, err
+}
+
+// linkPath is the path a link redirects from, with its segments escaped
+func linkPath(shortID string) string {
+	if isTeamAlias(shortID) {
+		team, alias, _ := strings.Cut(shortID, teamAliasSeparator)
+		return "/t/" + url.PathEscape(team) + "/" + url.PathEscape(alias)
+	}
+	return "/shortie/" + url.PathEscape(shortID)
+}
+
+// Sitemap returns the sitemap and the paths it lists, rebuilding them if links changed since they were built
+func (sitemap *SitemapStorage) Sitemap(ctx context.Context) ([]byte, []string, error) {
+	sitemap.lock.Lock()
+	defer sitemap.lock.Unlock()
+	changes := sitemap.changes.Load()
+	if sitemap.sitemap != nil && sitemap.built == changes && time.Since(sitemap.builtAt) < sitemapMaxAge {
+		return sitemap.sitemap, sitemap.paths, nil
+	}
+
+	// changes made while building count past the count read above, so they rebuild it again
+	err := sitemap.build(ctx, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	sitemap.built = changes
+	return sitemap.sitemap, sitemap.paths, nil
+}
+
+// build lists the links marked indexable that redirect everyone, sorted by path
+func (sitemap *SitemapStorage) build(ctx context.Context, now time.Time) error {
+	shortIDs, err := sitemap.urlStorage.ListShortIDs(ctx)
+	if err != nil {
+		return err
+	}
+	urlSet := sitemapURLSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9", URLs: []sitemapURL{}}
+	paths := []string{}
+	for _, shortID := range shortIDs {
+		object, err := sitemap.urlStorage.GetURL(ctx, shortID)
+		if err != nil {
+			return err
+		}
+		if object == nil || !object.Indexable || object.NoIndex || !redirectsEveryone(*object, now) {
+			continue
+		}
+		paths = append(paths, linkPath(shortID))
+	}
+	sort.Strings(paths)
+	if len(paths) > maxSitemapURLs {
+		log.Printf("error: %d links are indexable, the sitemap only lists the first %d", len(paths), maxSitemapURLs)
+		paths = paths[:maxSitemapURLs]
+	}
+	for _, path := range paths {
+		urlSet.URLs = append(urlSet.URLs, sitemapURL{Loc: "http://localhost:8421" + path})
+	}
+
+	var encoded bytes.Buffer
+	encoded.WriteString(xml.Header)
+	err = xml.NewEncoder(&encoded).Encode(urlSet)
+	if err != nil {
+		return err
+	}
+	sitemap.sitemap = encoded.Bytes()
+	sitemap.paths = paths
+	sitemap.builtAt = now
+	return nil
+}
+
+// GetSitemap serves the sitemap when it is enabled
+func (api shortieAPI) GetSitemap(c *gin.Context) {
+	if api.sitemap == nil {
+		c.String(http.StatusNotFound, "Not Found")
+		return
+	}
+	sitemap, _, err := api.sitemap.Sitemap(c)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "application/xml; charset=utf-8", sitemap)
+}
+
+// sitemapRobotsTxt is the default robots.txt with the sitemap's links allowed and the sitemap announced
+func (api shortieAPI) sitemapRobotsTxt(c *gin.Context) string {
+	robots := defaultRobotsTxt
+	_, paths, err := api.sitemap.Sitemap(c)
+	if err != nil {
+		// crawlers still get the default rules, the sitemap is retried on the next request
+		log.Println("error: failed to build the sitemap: " + err.Error())
+		return robots
+	}
+	for _, path := range paths {
+		if strings.HasPrefix(path, "/shortie/") {
+			// $ ends the match, so the link's path doesn't allow every id it is a prefix of
+			robots += "Allow: " + path + "$\n"
+		}
+	}
+	return robots + "\nSitemap: http://localhost:8421/sitemap.xml\n"
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSitemap(t *testing.T) {
+	sitemap := NewSitemapStorage(newContractLocalStorage(t))
+	api := shortieAPI{storage: sitemap, sitemap: sitemap}
+	for _, object := range []URLObject{
+		{ShortID: "launch", URL: "https://example.com/launch", Indexable: true},
+		{ShortID: teamAliasID("eng", "handbook"), URL: "https://example.com/handbook", Indexable: true},
+		{ShortID: "private", URL: "https://example.com/private"},
+		{ShortID: "hidden", URL: "https://example.com/hidden", Indexable: true, NoIndex: true},
+		{ShortID: "signed", URL: "https://example.com/signed", Indexable: true, SigningSecret: "secret"},
+		{ShortID: "expired", URL: "https://example.com/expired", Indexable: true, Expiration: time.Now().Add(-time.Hour).Unix()},
+	} {
+		require.NoError(t, api.storage.ImportURL(context.Background(), object))
+	}
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		api.GetRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		return w
+	}
+
+	w := get("/sitemap.xml")
+	assert.Equal(t, "application/xml; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+		`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`+
+		`<url><loc>http://localhost:8421/shortie/launch</loc></url>`+
+		`<url><loc>http://localhost:8421/t/eng/handbook</loc></url>`+
+		`</urlset>`, w.Body.String())
+	assert.Equal(t, "User-agent: *\nDisallow: /shortie/\nAllow: /shortie/launch$\n\nSitemap: http://localhost:8421/sitemap.xml\n", get("/robots.txt").Body.String())
+
+	// pausing the link rebuilds the sitemap without it
+	require.NoError(t, api.storage.SetPaused(context.Background(), "launch", true))
+	w = get("/sitemap.xml")
+	assert.NotContains(t, w.Body.String(), "/shortie/launch")
+	assert.Contains(t, w.Body.String(), "/t/eng/handbook")
+
+	api.sitemap = nil
+	w = httptest.NewRecorder()
+	api.GetRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sitemap.xml", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, defaultRobotsTxt, get("/robots.txt").Body.String())
+}
+
+func TestCreateIndexableURL(t *testing.T) {
+	api := shortieAPI{storage: newContractLocalStorage(t)}
+	w := serveAs(api, "", http.MethodPost, "/shortie", `{"url":"https://example.com/launch","indexable":true}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var created map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	shortID := strings.TrimPrefix(created["shortUrl"], "http://localhost:8421/shortie/")
+	object, err := api.storage.GetURL(context.Background(), shortID)
+	require.NoError(t, err)
+	require.NotNil(t, object)
+	assert.True(t, object.Indexable)
+
+	w = serveAs(api, "", http.MethodPost, "/shortie", `{"url":"https://example.com/launch","indexable":true,"noIndex":true}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	slackTimestampHeader = "X-Slack-Request-Timestamp"
+	slackSignatureHeader = "X-Slack-Signature"
+	// slackMaxSkew is how old a signed request can be, so a captured one can't be replayed later
+	slackMaxSkew = 5 * time.Minute
+)
+
+const slackUsage = "Usage: `/shorten <url>`, e.g. `/shorten https://example.com/a/very/long/path`"
+
+// slackMessage is the response to a slash command, ephemeral messages are only shown to whoever ran it
+type slackMessage struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// verifySlackSignature checks the v0 signature Slack sends with every request, an HMAC-SHA256 of the timestamp and the body
+func verifySlackSignature(secret string, timestamp string, signature string, body []byte, now time.Time) bool {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	sent := time.Unix(seconds, 0)
+	if sent.Before(now.Add(-slackMaxSkew)) || sent.After(now.Add(slackMaxSkew)) {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte("v0:" + timestamp + ":"))
+	_, _ = mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
+
+// slackText reads the url out of a command's text, Slack sends links formatted as <url> or <url|label>
+func slackText(text string) string {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "<") && strings.HasSuffix(text, ">") {
+		text, _, _ = strings.Cut(text[1:len(text)-1], "|")
+	}
+	return text
+}
+
+// slackEscape escapes the characters Slack reads as markup in message text
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// HandleSlackCommand shortens the url of a /shorten slash command. Slack shows anything but a 200 as a generic failure,
+// so problems with the url are answered with a message, only requests that aren't from Slack get an error status.
+func (api shortieAPI) HandleSlackCommand(c *gin.Context) {
+	if api.slackSigningSecret == "" {
+		respondNotFound(c)
+		return
+	}
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondError(c, http.StatusRequestEntityTooLarge, codeBodyTooLarge, "the body is too large")
+			return
+		}
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	if !verifySlackSignature(api.slackSigningSecret, c.GetHeader(slackTimestampHeader), c.GetHeader(slackSignatureHeader), payload, time.Now()) {
+		respondError(c, http.StatusUnauthorized, codeUnauthorized, "invalid slack signature")
+		return
+	}
+	form, err := url.ParseQuery(string(payload))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+
+	destination := slackText(form.Get("text"))
+	if destination == "" || destination == "help" {
+		c.JSON(http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: slackUsage})
+		return
+	}
+	text, err := api.shortenFromSlack(c, destination, form.Get("team_id"), form.Get("user_id"))
+	if err != nil {
+		log.Println("error: failed to shorten a url from slack: " + err.Error())
+		text = "Something went wrong, the link wasn't created. Request " + c.GetString(requestIDKey)
+	}
+	c.JSON(http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: text})
+}
+
+// shortenFromSlack creates a plain link and returns the message for the user, errors are only returned for failures on our side.
+// Links from Slack are owned by the Slack user, so no api key can change them and only admins manage them.
+func (api shortieAPI) shortenFromSlack(c *gin.Context, destination string, team string, user string) (string, error) {
+	if api.readOnly != nil && api.readOnly.Load() {
+		return "Links can't be created right now, shortie is read-only.", nil
+	}
+	err := api.validatePlainURL(destination)
+	if err != nil {
+		return "That url can't be shortened, " + err.Error() + "\n" + slackUsage, nil
+	}
+	object, err := api.createPlainLink(c, destination, "slack:"+team+":"+user)
+	if err != nil {
+		return "", err
+	}
+	shortURL := "http://localhost:8421/shortie/" + object.ShortID
+	if object.Quarantined {
+		return shortURL + " was created for " + slackEscape(object.URL) + ", it won't redirect until an admin approves it.", nil
+	}
+	return shortURL + " now redirects to " + slackEscape(object.URL), nil
+}
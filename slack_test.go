@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSlackSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+func slackCommand(t *testing.T, api shortieAPI, secret string, sentAt time.Time, text string) (int, slackMessage) {
+	body := url.Values{"command": {"/shorten"}, "text": {text}, "team_id": {"T0001"}, "user_id": {"U2147"}}.Encode()
+	timestamp := strconv.FormatInt(sentAt.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+
+	request := httptest.NewRequest(http.MethodPost, "/integrations/slack", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set(slackTimestampHeader, timestamp)
+	request.Header.Set(slackSignatureHeader, "v0="+hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+	api.GetRouter().ServeHTTP(w, request)
+
+	var message slackMessage
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &message))
+	}
+	return w.Code, message
+}
+
+func TestSlackCommand(t *testing.T) {
+	api := shortieAPI{storage: newContractLocalStorage(t), slackSigningSecret: testSlackSecret}
+
+	status, message := slackCommand(t, api, testSlackSecret, time.Now(), "<https://example.com/a/very/long/path?a=1&b=2|example.com/a/very/long/path>")
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "ephemeral", message.ResponseType)
+	assert.Contains(t, message.Text, "https://example.com/a/very/long/path?a=1&amp;b=2")
+
+	shortID, _, found := strings.Cut(strings.TrimPrefix(message.Text, "http://localhost:8421/shortie/"), " ")
+	require.True(t, found)
+	object, err := api.storage.GetURL(context.Background(), shortID)
+	require.NoError(t, err)
+	require.NotNil(t, object)
+	assert.Equal(t, "https://example.com/a/very/long/path?a=1&b=2", object.URL)
+	assert.Equal(t, "slack:T0001:U2147", object.Owner)
+
+	status, message = slackCommand(t, api, testSlackSecret, time.Now(), "")
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, slackUsage, message.Text)
+
+	status, message = slackCommand(t, api, testSlackSecret, time.Now(), "javascript:alert(1)")
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, message.Text, "can't be shortened")
+}
+
+func TestSlackCommandSignature(t *testing.T) {
+	api := shortieAPI{storage: newContractLocalStorage(t), slackSigningSecret: testSlackSecret}
+
+	status, _ := slackCommand(t, api, "someone else's secret", time.Now(), "https://example.com")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, _ = slackCommand(t, api, testSlackSecret, time.Now().Add(-10*time.Minute), "https://example.com")
+	assert.Equal(t, http.StatusUnauthorized, status, "a replayed request")
+
+	api.slackSigningSecret = ""
+	status, _ = slackCommand(t, api, "", time.Now(), "https://example.com")
+	assert.Equal(t, http.StatusNotFound, status)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// domainAgeLookup finds when a domain was registered, spam is often sent from domains only days old
+type domainAgeLookup interface {
+	RegisteredAt(ctx context.Context, domain string) (time.Time, error)
+}
+
+// RDAPLookup reads registration dates from RDAP, the successor of whois
+type RDAPLookup struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewRDAPLookup() *RDAPLookup {
+	// rdap.org redirects to the registry responsible for each tld
+	return &RDAPLookup{baseURL: "https://rdap.org", client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (lookup *RDAPLookup) RegisteredAt(ctx context.Context, domain string) (time.Time, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, lookup.baseURL+"/domain/"+url.PathEscape(domain), nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	request.Header.Set("Accept", "application/rdap+json")
+
+	response, err := lookup.client.Do(request)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to look up %s: %w", domain, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("looking up %s responded with %d", domain, response.StatusCode)
+	}
+
+	var body struct {
+		Events []struct {
+			Action string    `json:"eventAction"`
+			Date   time.Time `json:"eventDate"`
+		} `json:"events"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read the registration of %s: %w", domain, err)
+	}
+	for _, event := range body.Events {
+		if event.Action == "registration" {
+			return event.Date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("no registration date for %s", domain)
+}
+
+// spamChecker flags new links that look like spam so they can be quarantined for review
+// any heuristic is disabled by leaving its setting at zero
+type spamChecker struct {
+	// maxEntropy is the most bits per character a label of the url's host can have, generated domains score higher
+	maxEntropy float64
+
+	// domains younger than minDomainAge are flagged, lookup failures don't flag a link
+	domainAges   domainAgeLookup
+	minDomainAge time.Duration
+
+	// maxRepeats is how many links one ip can create to the same host within repeatWindow
+	maxRepeats   int
+	repeatWindow time.Duration
+
+	lock    sync.Mutex
+	repeats map[string][]time.Time
+}
+
+func newSpamChecker() *spamChecker {
+	return &spamChecker{repeats: map[string][]time.Time{}}
+}
+
+// Check returns why a new link looks like spam, or an empty string if it doesn't
+func (checker *spamChecker) Check(ctx context.Context, rawURL string, clientIP string, now time.Time) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return ""
+	}
+	host := strings.ToLower(parsed.Hostname())
+
+	if checker.maxEntropy > 0 {
+		for _, label := range strings.Split(host, ".") {
+			if shannonEntropy(label) > checker.maxEntropy {
+				return "random looking domain"
+			}
+		}
+	}
+	if checker.maxRepeats > 0 && checker.repeated(clientIP+" "+host, now) {
+		return "repeated destination"
+	}
+	if checker.domainAges != nil && checker.minDomainAge > 0 {
+		registeredAt, err := checker.domainAges.RegisteredAt(ctx, registrableDomain(host))
+		if err == nil && now.Sub(registeredAt) < checker.minDomainAge {
+			return "newly registered domain"
+		}
+	}
+	return ""
+}
+
+// repeated counts a link for key and reports whether there have been more than maxRepeats within the window
+func (checker *spamChecker) repeated(key string, now time.Time) bool {
+	checker.lock.Lock()
+	defer checker.lock.Unlock()
+
+	var recent []time.Time
+	for _, createdAt := range checker.repeats[key] {
+		if now.Sub(createdAt) < checker.repeatWindow {
+			recent = append(recent, createdAt)
+		}
+	}
+	recent = append(recent, now)
+	checker.repeats[key] = recent
+
+	// keep the map from growing with keys that have aged out
+	if len(checker.repeats) > 10000 {
+		for other, times := range checker.repeats {
+			if now.Sub(times[len(times)-1]) >= checker.repeatWindow {
+				delete(checker.repeats, other)
+			}
+		}
+	}
+	return len(recent) > checker.maxRepeats
+}
+
+func shannonEntropy(value string) float64 {
+	if value == "" {
+		return 0
+	}
+	counts := map[rune]int{}
+	for _, character := range value {
+		counts[character]++
+	}
+	length := float64(len([]rune(value)))
+	var entropy float64
+	for _, count := range counts {
+		probability := float64(count) / length
+		entropy -= probability * math.Log2(probability)
+	}
+	return entropy
+}
+
+// registrableDomain approximates the domain that was registered as the last two labels of the host,
+// which is wrong for suffixes like co.uk but only means those lookups fail
+func registrableDomain(host string) string {
+	labels := strings.Split(host, ".")
+	if len(labels) <= 2 {
+		return host
+	}
+	return strings.Join(labels[len(labels)-2:], ".")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeDomainAges map[string]time.Time
+
+func (ages fakeDomainAges) RegisteredAt(ctx context.Context, domain string) (time.Time, error) {
+	registeredAt, found := ages[domain]
+	if !found {
+		return time.Time{}, errors.New("not found")
+	}
+	return registeredAt, nil
+}
+
+func TestSpamChecker(t *testing.T) {
+	now := time.Now()
+	checker := newSpamChecker()
+	checker.maxEntropy = 3.8
+	checker.domainAges = fakeDomainAges{"example.com": now.AddDate(-10, 0, 0), "fresh.top": now.Add(-time.Hour)}
+	checker.minDomainAge = 30 * 24 * time.Hour
+	checker.maxRepeats = 2
+	checker.repeatWindow = time.Hour
+
+	assert.Equal(t, "", checker.Check(context.Background(), "https://www.example.com/data/hi", "192.0.2.10", now))
+	assert.Equal(t, "random looking domain", checker.Check(context.Background(), "https://x7kq9zp2mw4bv8ht.top/", "192.0.2.10", now))
+	assert.Equal(t, "newly registered domain", checker.Check(context.Background(), "https://login.fresh.top/", "192.0.2.10", now))
+	assert.Equal(t, "", checker.Check(context.Background(), "https://unknown.org/", "192.0.2.10", now), "failed lookups don't flag links")
+
+	assert.Equal(t, "", checker.Check(context.Background(), "https://www.example.com/other", "192.0.2.10", now))
+	assert.Equal(t, "repeated destination", checker.Check(context.Background(), "https://www.example.com/third", "192.0.2.10", now))
+	assert.Equal(t, "", checker.Check(context.Background(), "https://www.example.com/third", "198.51.100.10", now), "repeats are counted per ip")
+	assert.Equal(t, "", checker.Check(context.Background(), "https://www.example.com/fourth", "192.0.2.10", now.Add(2*time.Hour)), "repeats age out")
+}
+
+func TestRegistrableDomain(t *testing.T) {
+	assert.Equal(t, "example.com", registrableDomain("www.login.example.com"))
+	assert.Equal(t, "example.com", registrableDomain("example.com"))
+	assert.Equal(t, "localhost", registrableDomain("localhost"))
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed static
+var embeddedStatic embed.FS
+
+// staticFiles serves the embedded static directory, e.g. the stylesheet for interstitial pages
+var staticFiles = func() http.FileSystem {
+	files, err := fs.Sub(embeddedStatic, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.FS(files)
+}()
+
+func (api shortieAPI) GetFavicon(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.FileFromFS("favicon.ico", staticFiles)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxCachedStats bounds the stats cache, past it the stats of more links are read without being kept
+const maxCachedStats = 10000
+
+// statsCacheName identifies the stats cache in Cache-Status headers
+const statsCacheName = "shortie-stats"
+
+// statsCache keeps the statistics of links for a short ttl, so dashboards polling the same links read them
+// from storage once per ttl. Clicks show up in the stats once the entry expires, and the cache is per replica.
+type statsCache struct {
+	ttl time.Duration
+
+	lock    sync.Mutex
+	entries map[string]statsEntry
+}
+
+type statsEntry struct {
+	statistics Statistics
+	fetched    time.Time
+}
+
+func newStatsCache(ttl time.Duration) *statsCache {
+	return &statsCache{ttl: ttl, entries: map[string]statsEntry{}}
+}
+
+func initStatsCache(env Environment) (*statsCache, error) {
+	ttl, err := time.ParseDuration(env.StatsCacheTTL)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("invalid SHORTIE_STATS_CACHE_TTL %q", env.StatsCacheTTL)
+	}
+	return newStatsCache(ttl), nil
+}
+
+// Get returns the statistics of a link and how old they are, reading them from storage unless a fresh copy is kept
+func (cache *statsCache) Get(ctx context.Context, storage urlStorage, shortID string, now time.Time) (Statistics, time.Duration, bool, error) {
+	cache.lock.Lock()
+	entry, found := cache.entries[shortID]
+	cache.lock.Unlock()
+	if found && now.Sub(entry.fetched) < cache.ttl {
+		return entry.statistics, now.Sub(entry.fetched), true, nil
+	}
+
+	statistics, err := storage.GetStatistics(ctx, shortID)
+	if err != nil {
+		return Statistics{}, 0, false, err
+	}
+	// a copy, the in-memory backend hands out the maps it keeps counting in
+	statistics = Statistics{Usage: maps.Clone(statistics.Usage), RuleUsage: maps.Clone(statistics.RuleUsage)}
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if _, found := cache.entries[shortID]; !found && len(cache.entries) >= maxCachedStats {
+		cache.dropExpired(now)
+	}
+	if len(cache.entries) < maxCachedStats || found {
+		cache.entries[shortID] = statsEntry{statistics: statistics, fetched: now}
+	}
+	return statistics, 0, false, nil
+}
+
+// Forget drops the statistics kept for a link
+func (cache *statsCache) Forget(shortID string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	delete(cache.entries, shortID)
+}
+
+func (cache *statsCache) dropExpired(now time.Time) {
+	for shortID, entry := range cache.entries {
+		if now.Sub(entry.fetched) >= cache.ttl {
+			delete(cache.entries, shortID)
+		}
+	}
+}
+
+// statistics reads the statistics of a link for a stats response, through the stats cache when it is enabled.
+// Clients that need the latest counts skip the cache with Cache-Control: no-cache.
+func (api shortieAPI) statistics(c *gin.Context, shortID string) (Statistics, error) {
+	if api.statsCache == nil {
+		return api.storage.GetStatistics(c, shortID)
+	}
+	if strings.Contains(strings.ToLower(c.GetHeader("Cache-Control")), "no-cache") {
+		c.Header("Cache-Status", statsCacheName+"; fwd=request")
+		return api.storage.GetStatistics(c, shortID)
+	}
+	// the stats of a link deleted within the link cache's ttl aren't served from before the delete
+	if api.cache != nil && api.cache.IsDeleted(shortID) {
+		api.statsCache.Forget(shortID)
+		c.Header("Cache-Status", statsCacheName+"; fwd=stale")
+		return api.storage.GetStatistics(c, shortID)
+	}
+	statistics, age, hit, err := api.statsCache.Get(c, api.storage, shortID, time.Now())
+	if err != nil {
+		return Statistics{}, err
+	}
+	if !hit {
+		c.Header("Cache-Status", statsCacheName+"; fwd=miss")
+		return statistics, nil
+	}
+	remaining := int(math.Ceil((api.statsCache.ttl - age).Seconds()))
+	c.Header("Cache-Status", statsCacheName+"; hit; ttl="+strconv.Itoa(remaining))
+	c.Header("Age", strconv.Itoa(int(age.Seconds())))
+	return statistics, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// statsReadCounter counts the statistics reads that reach the storage
+type statsReadCounter struct {
+	urlStorage
+	reads atomic.Int64
+}
+
+func (counter *statsReadCounter) GetStatistics(ctx context.Context, shortID string) (Statistics, error) {
+	counter.reads.Add(1)
+	return counter.urlStorage.GetStatistics(ctx, shortID)
+}
+
+func TestStatsCache(t *testing.T) {
+	ctx := context.Background()
+	storage := &statsReadCounter{urlStorage: newContractLocalStorage(t)}
+	require.NoError(t, storage.ImportURL(ctx, URLObject{ShortID: "abc", URL: "https://example.com"}))
+	require.NoError(t, storage.IncrementUsage(ctx, "abc", "", 1, 1))
+	api := shortieAPI{storage: storage, statsCache: newStatsCache(time.Minute)}
+	allTime := func(w *httptest.ResponseRecorder) int64 {
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response struct {
+			AllTime int64 `json:"allTime"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.AllTime
+	}
+
+	w := serveAs(api, "", http.MethodGet, "/shortie/abc/stats", "")
+	assert.Equal(t, int64(1), allTime(w))
+	assert.Equal(t, "shortie-stats; fwd=miss", w.Header().Get("Cache-Status"))
+
+	// clicks in the meantime show up once the entry expires
+	require.NoError(t, storage.IncrementUsage(ctx, "abc", "", 1, 1))
+	w = serveAs(api, "", http.MethodGet, "/shortie/abc/stats?detailed=true", "")
+	assert.Equal(t, int64(1), allTime(w))
+	assert.Equal(t, "shortie-stats; hit; ttl=60", w.Header().Get("Cache-Status"))
+	assert.Equal(t, "0", w.Header().Get("Age"))
+	assert.Equal(t, int64(1), storage.reads.Load())
+
+	// no-cache reads the latest counts, and leaves the cached ones as they are
+	request := httptest.NewRequest(http.MethodGet, "/shortie/abc/stats", nil)
+	request.Header.Set("Cache-Control", "no-cache")
+	w = httptest.NewRecorder()
+	api.GetRouter().ServeHTTP(w, request)
+	assert.Equal(t, int64(2), allTime(w))
+	assert.Equal(t, "shortie-stats; fwd=request", w.Header().Get("Cache-Status"))
+	assert.Equal(t, int64(2), storage.reads.Load())
+
+	_, _, hit, err := api.statsCache.Get(ctx, storage, "abc", time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.False(t, hit)
+	assert.Equal(t, int64(3), storage.reads.Load())
+
+	// without the cache every request reads the storage
+	api.statsCache = nil
+	w = serveAs(api, "", http.MethodGet, "/shortie/abc/stats", "")
+	assert.Equal(t, int64(2), allTime(w))
+	assert.Empty(t, w.Header().Get("Cache-Status"))
+	assert.Equal(t, int64(4), storage.reads.Load())
+}
+
+func TestStatsCacheIsBounded(t *testing.T) {
+	ctx := context.Background()
+	storage := newContractLocalStorage(t)
+	cache := newStatsCache(time.Minute)
+	now := time.Now()
+	for i := 0; i < maxCachedStats; i++ {
+		cache.entries[strconv.Itoa(i)] = statsEntry{fetched: now.Add(-time.Duration(i%2) * time.Hour)}
+	}
+	// expired entries make room for new ones
+	_, _, _, err := cache.Get(ctx, storage, "new", now)
+	require.NoError(t, err)
+	assert.Equal(t, maxCachedStats/2+1, len(cache.entries))
+	assert.Contains(t, cache.entries, "new")
+}
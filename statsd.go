@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsdMaxPacket keeps packets within the MTU of most networks, the size the Datadog agent recommends
+const statsdMaxPacket = 1432
+
+// statsdFlushInterval bounds how long a metric waits in a packet that isn't full yet
+const statsdFlushInterval = time.Second
+
+// StatsDSink sends every metric over udp to a StatsD server or a Datadog agent, several per packet.
+// DogStatsD carries the tags as tags, plain StatsD has none so they are folded into the metric name.
+type StatsDSink struct {
+	conn      net.Conn
+	prefix    string
+	dogstatsd bool
+
+	lock   sync.Mutex
+	packet []byte
+}
+
+func NewStatsDSink(address string, prefix string, dogstatsd bool) (*StatsDSink, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the statsd address: %w", err)
+	}
+	return &StatsDSink{conn: conn, prefix: prefix, dogstatsd: dogstatsd}, nil
+}
+
+func initStatsDSink(env Environment) (*StatsDSink, error) {
+	dogstatsd, err := strconv.ParseBool(env.StatsDTags)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHORTIE_STATSD_TAGS: %w", err)
+	}
+	return NewStatsDSink(env.StatsDAddress, env.StatsDPrefix, dogstatsd)
+}
+
+func (sink *StatsDSink) Count(name string, value int64, tags map[string]string) {
+	sink.send(sink.line(name, strconv.FormatInt(value, 10), "c", tags))
+}
+
+func (sink *StatsDSink) Timing(name string, duration time.Duration, tags map[string]string) {
+	milliseconds := float64(duration.Microseconds()) / 1000
+	sink.send(sink.line(name, strconv.FormatFloat(milliseconds, 'f', -1, 64), "ms", tags))
+}
+
+// line formats a metric, e.g. shortie.redirects:1|c|#status:302 for DogStatsD or shortie.redirects.status.302:1|c for StatsD
+func (sink *StatsDSink) line(name string, value string, kind string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var line strings.Builder
+	line.WriteString(sink.prefix + name)
+	if !sink.dogstatsd {
+		for _, key := range keys {
+			line.WriteString("." + statsdName(key) + "." + statsdName(tags[key]))
+		}
+	}
+	line.WriteString(":" + value + "|" + kind)
+	if sink.dogstatsd && len(keys) > 0 {
+		pairs := make([]string, 0, len(keys))
+		for _, key := range keys {
+			pairs = append(pairs, statsdTag(key)+":"+statsdTag(tags[key]))
+		}
+		line.WriteString("|#" + strings.Join(pairs, ","))
+	}
+	return line.String()
+}
+
+// statsdName keeps a tag usable as a segment of a dotted name, e.g. /shortie/:id becomes shortie__id
+func statsdName(value string) string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '_'
+	}, value), "_")
+}
+
+// statsdTag replaces the characters that separate the fields of a DogStatsD line
+func statsdTag(value string) string {
+	return strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_").Replace(value)
+}
+
+// send adds a line to the current packet, sending the packet first if the line doesn't fit
+func (sink *StatsDSink) send(line string) {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if len(sink.packet) > 0 && len(sink.packet)+1+len(line) > statsdMaxPacket {
+		// udp is fire and forget, Run reports the errors of the packets it sends
+		_ = sink.flushLocked()
+	}
+	if len(sink.packet) > 0 {
+		sink.packet = append(sink.packet, '\n')
+	}
+	sink.packet = append(sink.packet, line...)
+}
+
+// Flush sends the lines that haven't been sent yet
+func (sink *StatsDSink) Flush() error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	return sink.flushLocked()
+}
+
+func (sink *StatsDSink) flushLocked() error {
+	if len(sink.packet) == 0 {
+		return nil
+	}
+	_, err := sink.conn.Write(sink.packet)
+	sink.packet = sink.packet[:0]
+	return err
+}
+
+// Run sends partly filled packets on every interval until ctx is done, then closes the connection.
+// Only the first of consecutive errors is logged, a missing agent would otherwise log every interval.
+func (sink *StatsDSink) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ticker.C:
+			err := sink.Flush()
+			if err != nil && !failing {
+				log.Println("error: failed to send metrics to statsd: " + err.Error())
+			}
+			failing = err != nil
+		case <-ctx.Done():
+			_ = sink.Flush()
+			_ = sink.conn.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listenStatsD returns a udp listener standing in for the agent and a function reading the next packet's lines
+func listenStatsD(t *testing.T) (string, func() []string) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	return listener.LocalAddr().String(), func() []string {
+		buffer := make([]byte, 65536)
+		require.NoError(t, listener.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := listener.ReadFrom(buffer)
+		require.NoError(t, err)
+		return strings.Split(string(buffer[:n]), "\n")
+	}
+}
+
+func TestStatsDSink(t *testing.T) {
+	address, read := listenStatsD(t)
+	sink, err := NewStatsDSink(address, "shortie.", true)
+	require.NoError(t, err)
+
+	sink.Count(metricRequests, 1, map[string]string{"status": "302", "route": "/shortie/:id"})
+	sink.Timing(metricRequestLatency, 1500*time.Microsecond, map[string]string{"route": "/shortie/:id"})
+	sink.Count(metricRedirects, 2, nil)
+	require.NoError(t, sink.Flush())
+	assert.Equal(t, []string{
+		"shortie.requests:1|c|#route:/shortie/:id,status:302",
+		"shortie.request_latency:1.5|ms|#route:/shortie/:id",
+		"shortie.redirects:2|c",
+	}, read())
+
+	// plain statsd has no tags, they become part of the name
+	sink, err = NewStatsDSink(address, "shortie.", false)
+	require.NoError(t, err)
+	sink.Count(metricRequests, 1, map[string]string{"status": "302", "route": "/shortie/:id"})
+	require.NoError(t, sink.Flush())
+	assert.Equal(t, []string{"shortie.requests.route.shortie__id.status.302:1|c"}, read())
+}
+
+func TestStatsDSinkPackets(t *testing.T) {
+	address, read := listenStatsD(t)
+	sink, err := NewStatsDSink(address, "", true)
+	require.NoError(t, err)
+
+	// a full packet is sent as soon as the next line doesn't fit
+	line := strings.Repeat("x", 700)
+	sink.Count(line, 1, nil)
+	sink.Count(line, 1, nil)
+	sink.Count(line, 1, nil)
+	assert.Equal(t, []string{line + ":1|c", line + ":1|c"}, read())
+
+	// the rest is sent by Run, also on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sink.Run(ctx, time.Hour)
+		close(done)
+	}()
+	cancel()
+	<-done
+	assert.Equal(t, []string{line + ":1|c"}, read())
+}
+
+func TestInitMetricsStatsD(t *testing.T) {
+	address, read := listenStatsD(t)
+	env, err := loadEnvironment("")
+	require.NoError(t, err)
+	env.Metrics = "statsd"
+	env.StatsDAddress = address
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	metrics, err := initMetrics(ctx, env)
+	require.NoError(t, err)
+	metrics.Count(metricRedirects, 1, map[string]string{"status": "302"})
+	assert.Equal(t, []string{"shortie.redirects:1|c|#status:302"}, read())
+
+	env.StatsDTags = "maybe"
+	_, err = initMetrics(ctx, env)
+	assert.ErrorContains(t, err, "invalid SHORTIE_STATSD_TAGS")
+}
+
+func TestMultiMetricsSink(t *testing.T) {
+	first, second := newMetricsAggregator(), newMetricsAggregator()
+	sinks := multiMetricsSink{first, second}
+	sinks.Count(metricRedirects, 1, nil)
+	sinks.Timing(metricRequestLatency, time.Millisecond, nil)
+	assert.Len(t, first.drain(), 2)
+	assert.Len(t, second.drain(), 2)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stepUpHeader carries the current TOTP code on destructive admin requests
+const stepUpHeader = "X-Shortie-TOTP"
+
+// totpStep and totpDigits are the RFC 6238 defaults authenticator apps use
+const (
+	totpStep   = 30 * time.Second
+	totpDigits = 6
+)
+
+// totpVerifier checks the codes of an authenticator app, so a leaked admin token alone can't run destructive operations
+type totpVerifier struct {
+	secret []byte
+
+	// lastStep is the time step of the last accepted code, each code only works once on a replica
+	lock     sync.Mutex
+	lastStep int64
+}
+
+// newTOTPVerifier reads the base32 secret authenticator apps are set up with, spaces and padding are ignored
+func newTOTPVerifier(secret string) (*totpVerifier, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	decoded, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return nil, fmt.Errorf("the totp secret must be base32: %w", err)
+	}
+	if len(decoded) < 10 {
+		return nil, fmt.Errorf("the totp secret must be at least 80 bits")
+	}
+	return &totpVerifier{secret: decoded}, nil
+}
+
+// totpCode is the code of a time step, per RFC 4226 with HMAC-SHA1
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// Verify accepts the code of the current time step or the ones next to it, to allow for clock drift,
+// unless a code of the same or a later step was already accepted
+func (verifier *totpVerifier) Verify(code string, now time.Time) bool {
+	current := now.Unix() / int64(totpStep.Seconds())
+	verifier.lock.Lock()
+	defer verifier.lock.Unlock()
+
+	for step := current - 1; step <= current+1; step++ {
+		if step <= verifier.lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(totpCode(verifier.secret, step))) == 1 {
+			verifier.lastStep = step
+			return true
+		}
+	}
+	return false
+}
+
+// RequireStepUp asks for a current TOTP code on top of the admin credentials before destructive operations,
+// it lets everything through when no TOTP secret is configured
+func (api shortieAPI) RequireStepUp(c *gin.Context) {
+	if api.dev || api.stepUp == nil {
+		c.Next()
+		return
+	}
+	code := c.GetHeader(stepUpHeader)
+	if code == "" {
+		respondError(c, http.StatusForbidden, codeStepUpRequired, "this operation requires a totp code in the "+stepUpHeader+" header")
+		return
+	}
+	if !api.stepUp.Verify(code, time.Now()) {
+		respondError(c, http.StatusForbidden, codeStepUpRequired, "the totp code is invalid or was already used")
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfc6238Secret is the sha1 secret of the RFC 6238 test vectors, "12345678901234567890" in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	verifier, err := newTOTPVerifier(rfc6238Secret)
+	require.NoError(t, err)
+	// the last 6 of the 8 digit codes in the RFC
+	assert.Equal(t, "287082", totpCode(verifier.secret, 59/30))
+	assert.Equal(t, "081804", totpCode(verifier.secret, 1111111109/30))
+	assert.Equal(t, "050471", totpCode(verifier.secret, 1111111111/30))
+}
+
+func TestTOTPVerify(t *testing.T) {
+	verifier, err := newTOTPVerifier(strings.ToLower(rfc6238Secret[:16] + " " + rfc6238Secret[16:]))
+	require.NoError(t, err)
+	now := time.Unix(1111111109, 0)
+
+	assert.False(t, verifier.Verify("000000", now))
+	// a step of clock drift is allowed, but not two
+	assert.False(t, verifier.Verify(totpCode(verifier.secret, 1111111109/30-2), now))
+	assert.True(t, verifier.Verify(totpCode(verifier.secret, 1111111109/30-1), now))
+	assert.True(t, verifier.Verify("081804", now))
+	// codes can't be replayed
+	assert.False(t, verifier.Verify("081804", now))
+	assert.False(t, verifier.Verify(totpCode(verifier.secret, 1111111109/30-1), now))
+
+	_, err = newTOTPVerifier("not base32!")
+	assert.Error(t, err)
+	_, err = newTOTPVerifier("GEZDGNBV")
+	assert.Error(t, err)
+}
+
+func TestRequireStepUp(t *testing.T) {
+	verifier, err := newTOTPVerifier(rfc6238Secret)
+	require.NoError(t, err)
+	api := shortieAPI{storage: &LocalStorage{Objects: map[string]URLObject{}}, adminToken: "admin", readOnly: newSwitch(false), stepUp: verifier}
+	router := api.GetRouter()
+	send := func(code string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPut, "/admin/read-only", strings.NewReader(`{"readOnly":true}`))
+		request.Header.Set("Authorization", "Bearer admin")
+		if code != "" {
+			request.Header.Set(stepUpHeader, code)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w
+	}
+
+	w := send("")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "STEP_UP_REQUIRED")
+	assert.False(t, api.readOnly.Load())
+
+	w = send(totpCode(verifier.secret, time.Now().Unix()/30))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, api.readOnly.Load())
+
+	// reads don't need a code
+	request := httptest.NewRequest(http.MethodGet, "/admin/read-only", nil)
+	request.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -2,85 +2,436 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
+	"maps"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
 type URLObject struct {
-	ShortID    string           `dynamodbav:"shortID"`
-	URL        string           `dynamodbav:"url"`
-	Version    int64            `dynamodbav:"version"`
-	Expiration int64            `dynamodbav:"expiration"`
-	Usage      map[string]int64 `dynamodbav:"usage"`
+	ShortID       string            `dynamodbav:"shortID"`
+	URL           string            `dynamodbav:"url"`
+	URLHash       string            `dynamodbav:"urlHash"`
+	Version       int64             `dynamodbav:"version"`
+	Expiration    int64             `dynamodbav:"expiration"`
+	ActiveFrom    int64             `dynamodbav:"activeFrom"`
+	Paused        bool              `dynamodbav:"paused"`
+	BurnAfterRead bool              `dynamodbav:"burnAfterRead"`
+	SigningSecret string            `dynamodbav:"signingSecret"`
+	IPRules       IPRules           `dynamodbav:"ipRules"`
+	Schedule      Schedule          `dynamodbav:"schedule"`
+	ReferrerRules []ReferrerRule    `dynamodbav:"referrerRules"`
+	LanguageRules map[string]string `dynamodbav:"languageRules"`
+	AppLink       AppLink           `dynamodbav:"appLink"`
+	NoIndex       bool              `dynamodbav:"noIndex"`
+	// Headers are extra response headers sent with the redirects, under their canonical names
+	Headers map[string]string `dynamodbav:"headers,omitempty"`
+	// ReferrerPolicy is sent as the Referrer-Policy of the redirects, no-referrer keeps the short link private from the destination
+	ReferrerPolicy string `dynamodbav:"referrerPolicy,omitempty"`
+	// Passthrough links also redirect the paths below them, appending the path and query to the destination
+	Passthrough bool `dynamodbav:"passthrough"`
+	// Campaign groups links for aggregate statistics, left out when empty since index keys can't be empty strings
+	Campaign string `dynamodbav:"campaign,omitempty"`
+	// Notes and Annotations record why a link exists, they are internal and only shown on the admin api
+	Notes       string            `dynamodbav:"notes"`
+	Annotations map[string]string `dynamodbav:"annotations"`
+	// Owner is the api key or jwt subject that created the link, editors can only change their own links
+	Owner string `dynamodbav:"owner,omitempty"`
+	// Anonymous links were created without a token in public mode, they are never handed back to callers with one
+	Anonymous bool `dynamodbav:"anonymous,omitempty"`
+	// Created is when the link was created, 0 for links from before it was recorded
+	Created int64 `dynamodbav:"created"`
+	// Indexable links are listed in the sitemap, for branded links meant to be found by search engines
+	Indexable bool `dynamodbav:"indexable"`
+	// Title is the destination page's title, given at creation or fetched from the page, for list views and the directory
+	Title string `dynamodbav:"title"`
+	// ContentHash is a simhash of the destination's page when the link was created, for links created with verifyContent
+	ContentHash string `dynamodbav:"contentHash"`
+	// Locked links can't be changed or deleted until an admin unlocks them, for links printed where they can't be reissued
+	Locked bool `dynamodbav:"locked"`
+	// Quarantined links were flagged as likely spam and don't redirect until an admin approves them
+	Quarantined      bool             `dynamodbav:"quarantined"`
+	QuarantineReason string           `dynamodbav:"quarantineReason"`
+	Usage            map[string]int64 `dynamodbav:"usage"`
+	RuleUsage        map[string]int64 `dynamodbav:"ruleUsage"`
+}
+
+// HealthStatus describes whether the storage backend can currently serve requests
+type HealthStatus struct {
+	Healthy bool   `json:"healthy"`
+	Backend string `json:"backend"`
+	Region  string `json:"region,omitempty"`
+	// Replicas maps each replica region of a global table to its replica status
+	Replicas map[string]string `json:"replicas,omitempty"`
+	// Memory is how full the in-memory backend is, when it is capped
+	Memory *MemoryUsage `json:"memory,omitempty"`
+	// Snapshot is how fresh a read replica's copy of the links is, when it keeps one
+	Snapshot *SnapshotStatus `json:"snapshot,omitempty"`
+}
+
+type Statistics struct {
+	// Usage is a map of UTC day timestamps rounded to the nearest day, plus hourUsagePrefix keys when hourly usage is enabled
+	Usage map[string]int64
+	// RuleUsage counts redirects by the redirect rule that picked their destination
+	RuleUsage map[string]int64
+}
+
+var errNotFound = errors.New("short url not found")
+
+// errLocked rejects changes to a locked link
+var errLocked = errors.New("the link is locked")
+
+// errNotOwner rejects a delete conditioned on an owner the stored link doesn't have
+var errNotOwner = errors.New("the link belongs to another owner")
+
+// hashURL keys the url index, urls can be too long to be index keys themselves
+// the url is normalized first so that links can be found by any spelling of their destination
+func hashURL(url string) string {
+	sum := sha256.Sum256([]byte(normalizeURL(url)))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsActive reports whether now falls inside the link's activation window, zero bounds are open-ended
+func (object URLObject) IsActive(now time.Time) bool {
+	if object.ActiveFrom != 0 && now.Unix() < object.ActiveFrom {
+		return false
+	}
+	if object.Expiration != 0 && now.Unix() >= object.Expiration {
+		return false
+	}
+	return true
 }
 
 type LocalStorage struct {
 	Objects map[string]URLObject
 	lock    sync.Mutex
+	// hourlyUsage counts usage by the hour as well as by the day
+	hourlyUsage bool
+
+	// maxLinks and maxBytes cap the links kept, past them links are evicted, zero is unlimited
+	maxLinks int
+	maxBytes int64
+	// accessed orders links by their last use for eviction, clock is the last order handed out
+	accessed map[string]uint64
+	clock    uint64
+	// sizes are the estimated bytes of each link and bytes their sum
+	sizes     map[string]int64
+	bytes     int64
+	evictions int64
+	metrics   metricsSink
 }
 
-func (storage *LocalStorage) SaveURL(ctx context.Context, shortID string, url string, expiration int64) error {
+func (storage *LocalStorage) SaveURL(ctx context.Context, object URLObject) error {
 	storage.lock.Lock()
 	defer storage.lock.Unlock()
 
-	_, found := storage.Objects[shortID]
+	_, found := storage.Objects[object.ShortID]
 	if found {
 		return nil
 	}
-	storage.Objects[shortID] = URLObject{
-		ShortID:    shortID,
-		URL:        url,
-		Expiration: expiration,
-		Usage:      map[string]int64{},
-	}
+	object.URLHash = hashURL(object.URL)
+	object.Usage = map[string]int64{}
+	object.RuleUsage = map[string]int64{}
+	storage.Objects[object.ShortID] = object
+	storage.stored(object.ShortID, true)
+	storage.evict(time.Now())
 	return nil
 }
 
-func (storage *LocalStorage) GetURL(ctx context.Context, shortID string) (string, error) {
+func (storage *LocalStorage) GetURL(ctx context.Context, shortID string) (*URLObject, error) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	object, found := storage.Objects[shortID]
+	if !found {
+		return nil, nil
+	}
+	storage.touch(shortID)
+	return &object, nil
+}
+
+func (storage *LocalStorage) IncrementUsage(ctx context.Context, shortID string, rule string, clicks int64, weight int64) error {
 	storage.lock.Lock()
 	defer storage.lock.Unlock()
 
 	object, found := storage.Objects[shortID]
 	if !found {
-		return "", nil
+		return nil
 	}
 
+	counters := len(object.Usage) + len(object.RuleUsage)
 	todayTimestamp := strconv.Itoa(int(UTCTimestampOfTodayRounded().Unix()))
 	todayUsage := object.Usage[todayTimestamp]
-	object.Usage[todayTimestamp] = todayUsage + 1
+	object.Usage[todayTimestamp] = todayUsage + clicks
+	if weight > 0 && storage.hourlyUsage {
+		object.Usage[hourUsageKey(time.Now())] += weight
+	}
+	if weight > 0 && rule != "" {
+		object.RuleUsage[rule] += weight
+	}
 	storage.Objects[shortID] = object
+	// only new counters grow the link
+	storage.stored(shortID, len(object.Usage)+len(object.RuleUsage) != counters)
+
+	return nil
+}
+
+func (storage *LocalStorage) RollupUsage(ctx context.Context, shortID string, before time.Time) error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
 
-	return object.URL, nil
+	object, found := storage.Objects[shortID]
+	if !found {
+		return nil
+	}
+	for _, key := range staleHourKeys(object.Usage, before) {
+		delete(object.Usage, key)
+	}
+	return nil
+}
+
+func (storage *LocalStorage) SetPaused(ctx context.Context, shortID string, paused bool) error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	object, found := storage.Objects[shortID]
+	if !found {
+		return errNotFound
+	}
+	if object.Locked {
+		return errLocked
+	}
+	object.Paused = paused
+	storage.Objects[shortID] = object
+
+	return nil
+}
+
+func (storage *LocalStorage) SetQuarantined(ctx context.Context, shortID string, quarantined bool) error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	object, found := storage.Objects[shortID]
+	if !found {
+		return errNotFound
+	}
+	if object.Locked {
+		return errLocked
+	}
+	object.Quarantined = quarantined
+	storage.Objects[shortID] = object
+
+	return nil
+}
+
+func (storage *LocalStorage) SetLocked(ctx context.Context, shortID string, locked bool) error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	object, found := storage.Objects[shortID]
+	if !found {
+		return errNotFound
+	}
+	object.Locked = locked
+	storage.Objects[shortID] = object
+
+	return nil
+}
+
+func (storage *LocalStorage) SetContentHash(ctx context.Context, shortID string, hash string) error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	object, found := storage.Objects[shortID]
+	if !found {
+		return errNotFound
+	}
+	object.ContentHash = hash
+	storage.Objects[shortID] = object
+
+	return nil
+}
+
+func (storage *LocalStorage) SetTitle(ctx context.Context, shortID string, title string) error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	object, found := storage.Objects[shortID]
+	if !found {
+		return errNotFound
+	}
+	object.Title = title
+	storage.Objects[shortID] = object
+	storage.stored(shortID, true)
+
+	return nil
+}
+
+func (storage *LocalStorage) SetExpiration(ctx context.Context, shortID string, expiration int64) error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	object, found := storage.Objects[shortID]
+	if !found {
+		return errNotFound
+	}
+	if object.Locked {
+		return errLocked
+	}
+	object.Expiration = expiration
+	storage.Objects[shortID] = object
+
+	return nil
 }
 
 func (storage *LocalStorage) DeleteURL(ctx context.Context, shortID string) error {
 	storage.lock.Lock()
 	defer storage.lock.Unlock()
 
-	delete(storage.Objects, shortID)
+	object, found := storage.Objects[shortID]
+	if found && object.Locked {
+		return errLocked
+	}
+	if owner, conditioned := expectedOwner(ctx); conditioned && found && object.Owner != owner {
+		return errNotOwner
+	}
+	storage.forget(shortID)
 
 	return nil
 }
 
-func (storage *LocalStorage) GetStatistics(ctx context.Context, shortID string) (map[string]int64, error) {
+func (storage *LocalStorage) ConsumeURL(ctx context.Context, shortID string) (bool, error) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	_, found := storage.Objects[shortID]
+	if !found {
+		return false, nil
+	}
+	storage.forget(shortID)
+
+	return true, nil
+}
+
+func (storage *LocalStorage) GetStatistics(ctx context.Context, shortID string) (Statistics, error) {
 	storage.lock.Lock()
 	defer storage.lock.Unlock()
 	object, found := storage.Objects[shortID]
 	if !found {
-		return map[string]int64{}, nil
+		return Statistics{Usage: map[string]int64{}, RuleUsage: map[string]int64{}}, nil
+	}
+	return Statistics{Usage: object.Usage, RuleUsage: object.RuleUsage}, nil
+}
+
+func (storage *LocalStorage) GetStatisticsBatch(ctx context.Context, shortIDs []string) (map[string]Statistics, error) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	batch := make(map[string]Statistics, len(shortIDs))
+	for _, shortID := range shortIDs {
+		object, found := storage.Objects[shortID]
+		if found {
+			batch[shortID] = Statistics{Usage: object.Usage, RuleUsage: object.RuleUsage}
+		}
+	}
+	return batch, nil
+}
+
+func (storage *LocalStorage) FindByURL(ctx context.Context, url string) (map[string]string, error) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	normalized := normalizeURL(url)
+	matches := map[string]string{}
+	for shortID, object := range storage.Objects {
+		if normalizeURL(object.URL) == normalized {
+			matches[shortID] = object.URL
+		}
+	}
+	return matches, nil
+}
+
+func (storage *LocalStorage) FindByCampaign(ctx context.Context, campaign string) ([]string, error) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	var shortIDs []string
+	for shortID, object := range storage.Objects {
+		if object.Campaign == campaign {
+			shortIDs = append(shortIDs, shortID)
+		}
+	}
+	return shortIDs, nil
+}
+
+func (storage *LocalStorage) ListShortIDs(ctx context.Context) ([]string, error) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	shortIDs := make([]string, 0, len(storage.Objects))
+	for shortID := range storage.Objects {
+		shortIDs = append(shortIDs, shortID)
+	}
+	return shortIDs, nil
+}
+
+// ListPage pages through the shortIDs in order, a position is the last shortID of the previous page
+// so links created meanwhile land in their place in order without shifting the rest
+func (storage *LocalStorage) ListPage(ctx context.Context, after string, limit int) ([]string, string, error) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	shortIDs := []string{}
+	for shortID := range storage.Objects {
+		if shortID > after {
+			shortIDs = append(shortIDs, shortID)
+		}
+	}
+	sort.Strings(shortIDs)
+	if len(shortIDs) <= limit {
+		return shortIDs, "", nil
+	}
+	return shortIDs[:limit], shortIDs[limit-1], nil
+}
+
+func (storage *LocalStorage) ImportURL(ctx context.Context, object URLObject) error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	object.URLHash = hashURL(object.URL)
+	// copy the usage so the imported link doesn't share maps with wherever it came from
+	object.Usage = maps.Clone(object.Usage)
+	if object.Usage == nil {
+		object.Usage = map[string]int64{}
+	}
+	object.RuleUsage = maps.Clone(object.RuleUsage)
+	if object.RuleUsage == nil {
+		object.RuleUsage = map[string]int64{}
+	}
+	storage.Objects[object.ShortID] = object
+	storage.stored(object.ShortID, true)
+	storage.evict(time.Now())
+	return nil
+}
+
+func (storage *LocalStorage) HealthCheck(ctx context.Context) (HealthStatus, error) {
+	status := HealthStatus{Healthy: true, Backend: "memory"}
+	if storage.maxLinks > 0 || storage.maxBytes > 0 {
+		usage := storage.MemoryUsage()
+		status.Memory = &usage
 	}
-	return object.Usage, nil
+	return status, nil
 }
 
 func UTCTimestampOfTodayRounded() time.Time {
@@ -89,86 +440,364 @@ func UTCTimestampOfTodayRounded() time.Time {
 
 const tableName = "shortie-urls"
 const attributeShortID = "shortID"
+const attributeURLHash = "urlHash"
+const attributeCampaign = "campaign"
+const campaignIndexName = "campaign-index"
+const urlHashIndexName = "urlHash-index"
+
+// tableWaitTimeout is how long table changes like new indexes and replicas get to become active
+const tableWaitTimeout = 20 * time.Minute
+
+// urlHashIndex lets links be found by their destination without scanning the table
+// items saved before the index existed don't have a urlHash and won't be found through it
+var urlHashIndex = types.GlobalSecondaryIndex{
+	IndexName: aws.String(urlHashIndexName),
+	KeySchema: []types.KeySchemaElement{
+		{
+			AttributeName: aws.String(attributeURLHash),
+			KeyType:       types.KeyTypeHash,
+		},
+	},
+	Projection: &types.Projection{
+		// the url is projected so hash collisions can be ruled out without reading the items
+		ProjectionType:   types.ProjectionTypeInclude,
+		NonKeyAttributes: []string{"url"},
+	},
+}
 
 type DynamoStorage struct {
-	dynamo *dynamodb.DynamoDB
+	dynamo *dynamodb.Client
+	region string
+	// replicaRegions are the other regions of a global table, empty for a single region table
+	replicaRegions []string
+	// awsConfig is shared with the other aws clients built on top of the storage, like the stream consumer
+	awsConfig aws.Config
+	// endpoint is the DynamoDB endpoint, resolved by the sdk from the region if empty
+	endpoint string
+	// enableStream turns on the table stream even when it isn't needed for a global table
+	enableStream bool
+	// hourlyUsage counts usage by the hour as well as by the day
+	hourlyUsage bool
+	// capacity is the provisioned throughput of new tables, nil creates them pay-per-request
+	capacity *tableCapacity
+	settings tableSettings
+	// initializeTables creates and updates the tables on startup, off when they are provisioned apart from the runtime
+	initializeTables bool
+	// consistentReads makes every lookup strongly consistent, otherwise only those of requests asking for it are
+	consistentReads bool
 }
 
+// tableStream captures new and old images, which both global tables and the stream consumer need
+var tableStream = &types.StreamSpecification{
+	StreamEnabled:  aws.Bool(true),
+	StreamViewType: types.StreamViewTypeNewAndOldImages,
+}
+
+func (storage *DynamoStorage) streamEnabled() bool {
+	return storage.enableStream || storage.isGlobal()
+}
+
+// resolvedEndpoint is the AWS_CUSTOM_DYNAMO_ENDPOINT value that picks the aws endpoint for the region
+// instead of a fixed url, which also gives the stream consumer the streams endpoint it needs
+const resolvedEndpoint = "aws"
+
 func InitDynamoStorage(env Environment) (*DynamoStorage, error) {
-	awsConfig := aws.NewConfig().
-		WithRegion(env.AWSRegion).
-		WithEndpoint(env.AWSCustomDynamoEndpoint).
-		WithCredentials(credentials.NewStaticCredentials(
-			env.AWSAccessKeyID,
-			env.AWSSecretAccessKey,
-			"",
-		))
-
-	awsSession, err := session.NewSession(awsConfig)
+	awsConfig, err := loadAWSConfig(context.Background(), env)
+	if err != nil {
+		return nil, err
+	}
+	capacity, err := parseTableCapacity(env)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := parseTableSettings(env)
+	if err != nil {
+		return nil, err
+	}
+	initializeTables, err := strconv.ParseBool(env.DynamoInitializeTables)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHORTIE_DYNAMO_INITIALIZE_TABLES: %w", err)
+	}
+	consistentReads, _, err := parseConsistentReads(env.DynamoConsistentReads)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize an aws session: %w", err)
+		return nil, err
+	}
+	endpoint := env.AWSCustomDynamoEndpoint
+	if endpoint == resolvedEndpoint {
+		endpoint = ""
+	}
+	dynamoClient := dynamodb.NewFromConfig(awsConfig, func(options *dynamodb.Options) {
+		if endpoint != "" {
+			options.BaseEndpoint = aws.String(endpoint)
+		}
+		options.APIOptions = append(options.APIOptions, addTracingMiddleware)
+	})
+
+	var replicaRegions []string
+	for _, region := range strings.Split(env.DynamoReplicaRegions, ",") {
+		region = strings.TrimSpace(region)
+		if region != "" && region != env.AWSRegion {
+			replicaRegions = append(replicaRegions, region)
+		}
 	}
-	dynamoClient := dynamodb.New(awsSession)
 	return &DynamoStorage{
-		dynamo: dynamoClient,
+		dynamo:           dynamoClient,
+		region:           env.AWSRegion,
+		replicaRegions:   replicaRegions,
+		awsConfig:        awsConfig,
+		endpoint:         endpoint,
+		enableStream:     env.StreamSink != "",
+		hourlyUsage:      env.HourlyUsageDays != "",
+		capacity:         capacity,
+		settings:         settings,
+		initializeTables: initializeTables,
+		consistentReads:  consistentReads,
 	}, nil
 }
 
+// isConditionFailed reports whether a write was rejected by its condition expression
+func isConditionFailed(err error) bool {
+	var conditionFailed *types.ConditionalCheckFailedException
+	return errors.As(err, &conditionFailed)
+}
+
+const attributeLocked = "locked"
+
+// unlockedCondition holds for links that aren't locked, including links from before locking existed
+const unlockedCondition = "(attribute_not_exists(#locked) OR #locked = :false)"
+
+// isLockedItem reports whether a condition failed on a locked link, for requests returning the item on failure
+func isLockedItem(err error) bool {
+	var conditionFailed *types.ConditionalCheckFailedException
+	if !errors.As(err, &conditionFailed) {
+		return false
+	}
+	locked, _ := conditionFailed.Item[attributeLocked].(*types.AttributeValueMemberBOOL)
+	return locked != nil && locked.Value
+}
+
+// campaignIndex lists the links of a campaign, links without a campaign aren't in the index at all
+var campaignIndex = types.GlobalSecondaryIndex{
+	IndexName: aws.String(campaignIndexName),
+	KeySchema: []types.KeySchemaElement{
+		{
+			AttributeName: aws.String(attributeCampaign),
+			KeyType:       types.KeyTypeHash,
+		},
+	},
+	Projection: &types.Projection{
+		ProjectionType: types.ProjectionTypeKeysOnly,
+	},
+}
+
 func (storage *DynamoStorage) InitializeTable() error {
-	_, err := storage.dynamo.CreateTable(&dynamodb.CreateTableInput{
-		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+	ctx := context.Background()
+	var stream *types.StreamSpecification
+	if storage.streamEnabled() {
+		stream = tableStream
+	}
+	_, err := storage.dynamo.CreateTable(ctx, &dynamodb.CreateTableInput{
+		AttributeDefinitions: []types.AttributeDefinition{
 			{
 				AttributeName: aws.String(attributeShortID),
-				AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+				AttributeType: types.ScalarAttributeTypeS,
 			},
+			{
+				AttributeName: aws.String(attributeURLHash),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String(attributeCampaign),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			storage.capacity.withThroughput(urlHashIndex),
+			storage.capacity.withThroughput(campaignIndex),
 		},
-		BillingMode:               aws.String(dynamodb.BillingModePayPerRequest),
-		DeletionProtectionEnabled: aws.Bool(true),
-		KeySchema: []*dynamodb.KeySchemaElement{
+		BillingMode:               storage.capacity.billingMode(),
+		ProvisionedThroughput:     storage.capacity.throughput(),
+		DeletionProtectionEnabled: aws.Bool(storage.settings.deletionProtection),
+		TableClass:                storage.settings.tableClass,
+		Tags:                      storage.settings.tags,
+		KeySchema: []types.KeySchemaElement{
 			{
 				AttributeName: aws.String(attributeShortID),
-				KeyType:       aws.String(dynamodb.KeyTypeHash),
+				KeyType:       types.KeyTypeHash,
 			},
 		},
+		StreamSpecification: stream,
+		TableName:           aws.String(tableName),
+	})
+	if err != nil {
+		var alreadyExists *types.TableAlreadyExistsException
+		var inUse *types.ResourceInUseException
+		if !errors.As(err, &alreadyExists) && !errors.As(err, &inUse) {
+			return fmt.Errorf("failed to create the table: %w", err)
+		}
+		// tables created by older versions are missing the indexes added since
+		err = storage.ensureIndex(ctx, urlHashIndex, attributeURLHash)
+		if err != nil {
+			return err
+		}
+		err = storage.ensureIndex(ctx, campaignIndex, attributeCampaign)
+		if err != nil {
+			return err
+		}
+		if storage.streamEnabled() {
+			err = storage.ensureStream(ctx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// point in time recovery can't be enabled at creation, and existing tables may have drifted from the config
+	err = storage.applySettings(ctx)
+	if err != nil {
+		return err
+	}
+
+	if storage.capacity != nil {
+		// replicas of a provisioned table can only be added once writes autoscale
+		err = storage.waitForTable(ctx)
+		if err != nil {
+			return err
+		}
+		err = storage.ensureAutoscaling(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if storage.isGlobal() {
+		err = storage.ensureReplicas(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	// TODO: setup the auto-expiration if we want to keep that feature
+	return nil
+}
+
+// waitForTable waits until the table and its changes are active
+func (storage *DynamoStorage) waitForTable(ctx context.Context) error {
+	err := dynamodb.NewTableExistsWaiter(storage.dynamo).Wait(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	}, tableWaitTimeout)
+	if err != nil {
+		return fmt.Errorf("failed waiting for the table: %w", err)
+	}
+	return nil
+}
+
+// ensureStream enables the stream on tables created without one
+func (storage *DynamoStorage) ensureStream(ctx context.Context) error {
+	err := storage.waitForTable(ctx)
+	if err != nil {
+		return err
+	}
+	out, err := storage.dynamo.DescribeTable(ctx, &dynamodb.DescribeTableInput{
 		TableName: aws.String(tableName),
-		Tags:      nil,
 	})
 	if err != nil {
-		awsErr := err.(awserr.Error)
-		if awsErr.Code() == dynamodb.ErrCodeTableAlreadyExistsException || awsErr.Code() == dynamodb.ErrCodeResourceInUseException {
+		return fmt.Errorf("failed to describe the table: %w", err)
+	}
+	if out.Table.StreamSpecification != nil && aws.ToBool(out.Table.StreamSpecification.StreamEnabled) {
+		return nil
+	}
+
+	_, err = storage.dynamo.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+		TableName:           aws.String(tableName),
+		StreamSpecification: tableStream,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable the table stream: %w", err)
+	}
+	return storage.waitForTable(ctx)
+}
+
+// ensureIndex adds a global secondary index keyed on attribute if the table doesn't have it yet
+func (storage *DynamoStorage) ensureIndex(ctx context.Context, index types.GlobalSecondaryIndex, attribute string) error {
+	out, err := storage.dynamo.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe the table: %w", err)
+	}
+	for _, existing := range out.Table.GlobalSecondaryIndexes {
+		if aws.ToString(existing.IndexName) == aws.ToString(index.IndexName) {
 			return nil
 		}
-		return fmt.Errorf("failed to create the table: %w", err)
 	}
 
-	// TODO: setup the auto-expiration if we want to keep that feature
+	_, err = storage.dynamo.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String(attribute),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{
+			{
+				Create: &types.CreateGlobalSecondaryIndexAction{
+					IndexName:             index.IndexName,
+					KeySchema:             index.KeySchema,
+					Projection:            index.Projection,
+					ProvisionedThroughput: storage.capacity.throughput(),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add the %s index: %w", aws.ToString(index.IndexName), err)
+	}
 	return nil
 }
 
-func (storage *DynamoStorage) SaveURL(ctx context.Context, shortID string, url string, expiration int64) error {
-	object := URLObject{
-		ShortID:    shortID,
-		URL:        url,
-		Version:    0,
-		Expiration: expiration,
-		Usage:      map[string]int64{},
+// shortIDKey is the table key of a link
+func shortIDKey(shortID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		attributeShortID: &types.AttributeValueMemberS{Value: shortID},
+	}
+}
+
+// numberValue is a number attribute, which the api takes as a string
+func numberValue(number int64) *types.AttributeValueMemberN {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(number, 10)}
+}
+
+// stringAttribute reads a string attribute of an item, empty if it is missing or not a string
+func stringAttribute(item map[string]types.AttributeValue, name string) string {
+	value, _ := item[name].(*types.AttributeValueMemberS)
+	if value == nil {
+		return ""
 	}
-	dynamoItem, err := dynamodbattribute.MarshalMap(&object)
+	return value.Value
+}
+
+func (storage *DynamoStorage) SaveURL(ctx context.Context, object URLObject) error {
+	object.Version = 0
+	object.URLHash = hashURL(object.URL)
+	object.Usage = map[string]int64{}
+	object.RuleUsage = map[string]int64{}
+	dynamoItem, err := attributevalue.MarshalMap(&object)
 	if err != nil {
 		return fmt.Errorf("failed to serialize url object: %w", err)
 	}
 
-	_, err = storage.dynamo.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+	_, err = storage.dynamo.PutItem(ctx, &dynamodb.PutItemInput{
 		TableName:           aws.String(tableName),
 		Item:                dynamoItem,
 		ConditionExpression: aws.String("attribute_not_exists(#shortID)"),
-		ExpressionAttributeNames: map[string]*string{
-			"#shortID": aws.String(attributeShortID),
+		ExpressionAttributeNames: map[string]string{
+			"#shortID": attributeShortID,
 		},
 	})
 	if err != nil {
-		var awsErr awserr.Error
-		if errors.As(err, &awsErr) && (awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException) {
+		if isConditionFailed(err) {
 			return nil
 		}
 		return fmt.Errorf("failed to save a url: %w", err)
@@ -176,67 +805,490 @@ func (storage *DynamoStorage) SaveURL(ctx context.Context, shortID string, url s
 	return nil
 }
 
-func (storage *DynamoStorage) GetURL(ctx context.Context, shortID string) (string, error) {
-	object, err := storage.getObject(ctx, shortID)
+func (storage *DynamoStorage) ImportURL(ctx context.Context, object URLObject) error {
+	object.URLHash = hashURL(object.URL)
+	if object.Usage == nil {
+		object.Usage = map[string]int64{}
+	}
+	if object.RuleUsage == nil {
+		object.RuleUsage = map[string]int64{}
+	}
+	dynamoItem, err := attributevalue.MarshalMap(&object)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("failed to serialize url object: %w", err)
 	}
-	if object == nil {
-		return "", nil
+
+	_, err = storage.dynamo.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item:      dynamoItem,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import a url: %w", err)
 	}
+	return nil
+}
+
+func (storage *DynamoStorage) GetURL(ctx context.Context, shortID string) (*URLObject, error) {
+	return storage.getObject(ctx, shortID)
+}
 
-	go func() {
-		// This is an absolutely horrible way to do this for scale reasons but works for low usage
-		// With more time, I would buffer these updates in-memory (at risk of losing some occasionally)
-		// and flush say a minutes worth of usage all in one request. Very similar to how metric infrastructure works.
-		todayTimestamp := strconv.Itoa(int(UTCTimestampOfTodayRounded().Unix()))
-		todayUsage := object.Usage[todayTimestamp]
-		object.Usage[todayTimestamp] = todayUsage + 1
+func (storage *DynamoStorage) IncrementUsage(ctx context.Context, shortID string, rule string, clicks int64, weight int64) error {
+	// An atomic increment per redirect works for low usage but is a lot of write traffic at scale.
+	// With more time, I would buffer these updates in-memory (at risk of losing some occasionally)
+	// and flush say a minutes worth of usage all in one request. Very similar to how metric infrastructure works.
+	if storage.isGlobal() {
+		return storage.incrementRegionalUsage(ctx, shortID, rule, clicks, weight)
+	}
 
-		serialized, err := dynamodbattribute.MarshalMap(&object)
-		if err != nil {
-			log.Println("failed to increment usage: " + err.Error())
+	todayTimestamp := strconv.Itoa(int(UTCTimestampOfTodayRounded().Unix()))
+	updateExpression := "SET #usage.#day = if_not_exists(#usage.#day, :zero) + :clicks"
+	names := map[string]string{
+		"#shortID": attributeShortID,
+		"#usage":   "usage",
+		"#day":     todayTimestamp,
+	}
+	values := map[string]types.AttributeValue{
+		":zero":   numberValue(0),
+		":clicks": numberValue(clicks),
+	}
+	// the detailed counters are weighted when only a sample of the clicks is recorded in them
+	if weight > 0 && (storage.hourlyUsage || rule != "") {
+		values[":weight"] = numberValue(weight)
+	}
+	if weight > 0 && storage.hourlyUsage {
+		updateExpression += ", #usage.#hour = if_not_exists(#usage.#hour, :zero) + :weight"
+		names["#hour"] = hourUsageKey(time.Now())
+	}
+	if weight > 0 && rule != "" {
+		updateExpression += ", #ruleUsage.#rule = if_not_exists(#ruleUsage.#rule, :zero) + :weight"
+		names["#ruleUsage"] = "ruleUsage"
+		names["#rule"] = rule
+	}
+
+	_, err := storage.dynamo.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tableName),
+		Key:                       shortIDKey(shortID),
+		UpdateExpression:          aws.String(updateExpression),
+		ConditionExpression:       aws.String("attribute_exists(#shortID)"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		if isConditionFailed(err) {
+			return nil
 		}
+		return fmt.Errorf("failed to increment usage: %w", err)
+	}
+	return nil
+}
+
+// maxUsageRemovals bounds the buckets removed per update, to stay well under the expression size limit
+const maxUsageRemovals = 100
 
-		_, err = storage.dynamo.PutItemWithContext(ctx, &dynamodb.PutItemInput{
-			TableName: aws.String(tableName),
-			Item:      serialized,
+func (storage *DynamoStorage) RollupUsage(ctx context.Context, shortID string, before time.Time) error {
+	if storage.isGlobal() {
+		return storage.rollupRegionalUsage(ctx, shortID, before)
+	}
+
+	object, err := storage.getObject(ctx, shortID)
+	if err != nil || object == nil {
+		return err
+	}
+	stale := staleHourKeys(object.Usage, before)
+	for start := 0; start < len(stale); start += maxUsageRemovals {
+		paths := []string{}
+		names := map[string]string{
+			"#shortID": attributeShortID,
+			"#usage":   "usage",
+		}
+		for i, key := range stale[start:min(start+maxUsageRemovals, len(stale))] {
+			name := "#hour" + strconv.Itoa(i)
+			paths = append(paths, "#usage."+name)
+			names[name] = key
+		}
+
+		_, err = storage.dynamo.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                aws.String(tableName),
+			Key:                      shortIDKey(shortID),
+			UpdateExpression:         aws.String("REMOVE " + strings.Join(paths, ", ")),
+			ConditionExpression:      aws.String("attribute_exists(#shortID)"),
+			ExpressionAttributeNames: names,
 		})
 		if err != nil {
-			log.Println("failed to increment usage: " + err.Error())
+			if isConditionFailed(err) {
+				return nil
+			}
+			return fmt.Errorf("failed to roll up usage: %w", err)
 		}
-	}()
+	}
+	return nil
+}
 
-	return object.URL, nil
+func (storage *DynamoStorage) SetPaused(ctx context.Context, shortID string, paused bool) error {
+	return storage.setAttribute(ctx, shortID, "paused", &types.AttributeValueMemberBOOL{Value: paused}, true)
 }
 
-func (storage *DynamoStorage) DeleteURL(ctx context.Context, shortID string) error {
-	_, err := storage.dynamo.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+func (storage *DynamoStorage) SetQuarantined(ctx context.Context, shortID string, quarantined bool) error {
+	return storage.setAttribute(ctx, shortID, "quarantined", &types.AttributeValueMemberBOOL{Value: quarantined}, true)
+}
+
+func (storage *DynamoStorage) SetLocked(ctx context.Context, shortID string, locked bool) error {
+	return storage.setAttribute(ctx, shortID, attributeLocked, &types.AttributeValueMemberBOOL{Value: locked}, false)
+}
+
+// SetContentHash records a destination's content again, locked links included since it doesn't change where they go
+func (storage *DynamoStorage) SetContentHash(ctx context.Context, shortID string, hash string) error {
+	return storage.setAttribute(ctx, shortID, "contentHash", &types.AttributeValueMemberS{Value: hash}, false)
+}
+
+// SetTitle records a destination's title, locked links included since it doesn't change where they go
+func (storage *DynamoStorage) SetTitle(ctx context.Context, shortID string, title string) error {
+	return storage.setAttribute(ctx, shortID, "title", &types.AttributeValueMemberS{Value: title}, false)
+}
+
+func (storage *DynamoStorage) SetExpiration(ctx context.Context, shortID string, expiration int64) error {
+	return storage.setAttribute(ctx, shortID, "expiration", numberValue(expiration), true)
+}
+
+// setAttribute changes one attribute of an existing link, refusing locked links unless unlessLocked is false
+func (storage *DynamoStorage) setAttribute(ctx context.Context, shortID string, attribute string, value types.AttributeValue, unlessLocked bool) error {
+	input := &dynamodb.UpdateItemInput{
 		TableName: aws.String(tableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			attributeShortID: {S: aws.String(shortID)},
+		Key:       shortIDKey(shortID),
+		// the version is bumped on every change so replicas of a global table can tell the latest write apart
+		UpdateExpression:    aws.String("SET #attribute = :value ADD #version :one"),
+		ConditionExpression: aws.String("attribute_exists(#shortID)"),
+		ExpressionAttributeNames: map[string]string{
+			"#shortID":   attributeShortID,
+			"#attribute": attribute,
+			"#version":   "version",
 		},
-	})
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":value": value,
+			":one":   numberValue(1),
+		},
+	}
+	if unlessLocked {
+		input.ConditionExpression = aws.String("attribute_exists(#shortID) AND " + unlockedCondition)
+		input.ExpressionAttributeNames["#locked"] = attributeLocked
+		input.ExpressionAttributeValues[":false"] = &types.AttributeValueMemberBOOL{Value: false}
+		input.ReturnValuesOnConditionCheckFailure = types.ReturnValuesOnConditionCheckFailureAllOld
+	}
+	_, err := storage.dynamo.UpdateItem(ctx, input)
 	if err != nil {
+		if isConditionFailed(err) {
+			if isLockedItem(err) {
+				return errLocked
+			}
+			return errNotFound
+		}
+		return fmt.Errorf("failed to update %s: %w", attribute, err)
+	}
+	return nil
+}
+
+func (storage *DynamoStorage) DeleteURL(ctx context.Context, shortID string) error {
+	input := &dynamodb.DeleteItemInput{
+		TableName:           aws.String(tableName),
+		Key:                 shortIDKey(shortID),
+		ConditionExpression: aws.String("attribute_not_exists(#shortID) OR " + unlockedCondition),
+		ExpressionAttributeNames: map[string]string{
+			"#shortID": attributeShortID,
+			"#locked":  attributeLocked,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":false": &types.AttributeValueMemberBOOL{Value: false},
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+	// the owner is checked in the same request, so the link can't change hands between a check and the delete
+	if owner, conditioned := expectedOwner(ctx); conditioned {
+		input.ConditionExpression = aws.String("attribute_not_exists(#shortID) OR (" + unlockedCondition + " AND #owner = :owner)")
+		input.ExpressionAttributeNames["#owner"] = "owner"
+		input.ExpressionAttributeValues[":owner"] = &types.AttributeValueMemberS{Value: owner}
+	}
+	_, err := storage.dynamo.DeleteItem(ctx, input)
+	if err != nil {
+		if isConditionFailed(err) {
+			if isLockedItem(err) {
+				return errLocked
+			}
+			return errNotOwner
+		}
 		return fmt.Errorf("failed to delete a url object: %w", err)
 	}
 	return nil
 }
 
-func (storage *DynamoStorage) GetStatistics(ctx context.Context, shortID string) (map[string]int64, error) {
+func (storage *DynamoStorage) ConsumeURL(ctx context.Context, shortID string) (bool, error) {
+	// the condition makes sure only one concurrent redirect gets to consume the link
+	_, err := storage.dynamo.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(tableName),
+		Key:                 shortIDKey(shortID),
+		ConditionExpression: aws.String("attribute_exists(#shortID)"),
+		ExpressionAttributeNames: map[string]string{
+			"#shortID": attributeShortID,
+		},
+	})
+	if err != nil {
+		if isConditionFailed(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to consume a url object: %w", err)
+	}
+	return true, nil
+}
+
+func (storage *DynamoStorage) GetStatistics(ctx context.Context, shortID string) (Statistics, error) {
 	object, err := storage.getObject(ctx, shortID)
 	if err != nil {
-		return nil, err
+		return Statistics{}, err
 	}
-	return object.Usage, nil
+	if object == nil {
+		return Statistics{Usage: map[string]int64{}, RuleUsage: map[string]int64{}}, nil
+	}
+	statistics := Statistics{Usage: object.Usage, RuleUsage: object.RuleUsage}
+	if storage.isGlobal() {
+		err = storage.mergeRegionalUsage(ctx, map[string]Statistics{shortID: statistics})
+		if err != nil {
+			return Statistics{}, err
+		}
+	}
+	return statistics, nil
 }
 
-func (storage *DynamoStorage) getObject(ctx context.Context, shortID string) (*URLObject, error) {
-	out, err := storage.dynamo.GetItemWithContext(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(tableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			attributeShortID: {S: aws.String(shortID)},
+// dynamoBatchGetLimit is the most keys a single BatchGetItem request accepts
+const dynamoBatchGetLimit = 100
+
+// unprocessed keys are retried with an exponential backoff, starting at unprocessedKeysBackoff, a batch that
+// still isn't done after maxUnprocessedKeysRetries fails rather than hammering a throttled table
+const (
+	unprocessedKeysBackoff    = 50 * time.Millisecond
+	maxUnprocessedKeysRetries = 5
+)
+
+// backOffUnprocessedKeys waits before the given retry of a batch's unprocessed keys, or fails once they are used up
+func backOffUnprocessedKeys(ctx context.Context, retry int) error {
+	if retry > maxUnprocessedKeysRetries {
+		return fmt.Errorf("keys still unprocessed after %d retries", maxUnprocessedKeysRetries)
+	}
+	timer := time.NewTimer(unprocessedKeysBackoff << (retry - 1))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (storage *DynamoStorage) GetStatisticsBatch(ctx context.Context, shortIDs []string) (map[string]Statistics, error) {
+	batch := make(map[string]Statistics, len(shortIDs))
+	for start := 0; start < len(shortIDs); start += dynamoBatchGetLimit {
+		end := min(start+dynamoBatchGetLimit, len(shortIDs))
+
+		keys := make([]map[string]types.AttributeValue, 0, end-start)
+		seen := map[string]bool{}
+		for _, shortID := range shortIDs[start:end] {
+			// BatchGetItem rejects requests with duplicate keys
+			if seen[shortID] {
+				continue
+			}
+			seen[shortID] = true
+			keys = append(keys, shortIDKey(shortID))
+		}
+
+		requestItems := map[string]types.KeysAndAttributes{
+			tableName: {
+				Keys:                 keys,
+				ProjectionExpression: aws.String("#shortID, #usage, #ruleUsage"),
+				ExpressionAttributeNames: map[string]string{
+					"#shortID":   attributeShortID,
+					"#usage":     "usage",
+					"#ruleUsage": "ruleUsage",
+				},
+			},
+		}
+		// unprocessed keys are returned when the batch is throttled or too large, and have to be retried
+		for retry := 0; len(requestItems) > 0; retry++ {
+			if retry > 0 {
+				err := backOffUnprocessedKeys(ctx, retry)
+				if err != nil {
+					return nil, fmt.Errorf("failed to batch read statistics: %w", err)
+				}
+			}
+			out, err := storage.dynamo.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: requestItems,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to batch read statistics: %w", err)
+			}
+			for _, item := range out.Responses[tableName] {
+				var object URLObject
+				err = attributevalue.UnmarshalMap(item, &object)
+				if err != nil {
+					return nil, fmt.Errorf("failed to deserialize url object: %w", err)
+				}
+				statistics := Statistics{Usage: object.Usage, RuleUsage: object.RuleUsage}
+				if statistics.Usage == nil {
+					statistics.Usage = map[string]int64{}
+				}
+				if statistics.RuleUsage == nil {
+					statistics.RuleUsage = map[string]int64{}
+				}
+				batch[object.ShortID] = statistics
+			}
+			requestItems = out.UnprocessedKeys
+		}
+	}
+	if storage.isGlobal() {
+		err := storage.mergeRegionalUsage(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return batch, nil
+}
+
+func (storage *DynamoStorage) FindByCampaign(ctx context.Context, campaign string) ([]string, error) {
+	var shortIDs []string
+	pages := dynamodb.NewQueryPaginator(storage.dynamo, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(campaignIndexName),
+		KeyConditionExpression: aws.String("#campaign = :campaign"),
+		ExpressionAttributeNames: map[string]string{
+			"#campaign": attributeCampaign,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":campaign": &types.AttributeValueMemberS{Value: campaign},
+		},
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the campaign's links: %w", err)
+		}
+		for _, item := range page.Items {
+			shortIDs = append(shortIDs, stringAttribute(item, attributeShortID))
+		}
+	}
+	return shortIDs, nil
+}
+
+func (storage *DynamoStorage) FindByURL(ctx context.Context, url string) (map[string]string, error) {
+	normalized := normalizeURL(url)
+	matches := map[string]string{}
+	pages := dynamodb.NewQueryPaginator(storage.dynamo, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(urlHashIndexName),
+		KeyConditionExpression: aws.String("#urlHash = :urlHash"),
+		ExpressionAttributeNames: map[string]string{
+			"#urlHash": attributeURLHash,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":urlHash": &types.AttributeValueMemberS{Value: hashURL(url)},
+		},
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find urls: %w", err)
+		}
+		for _, item := range page.Items {
+			var object URLObject
+			err := attributevalue.UnmarshalMap(item, &object)
+			if err == nil && normalizeURL(object.URL) == normalized {
+				matches[object.ShortID] = object.URL
+			}
+		}
+	}
+	return matches, nil
+}
+
+func (storage *DynamoStorage) ListShortIDs(ctx context.Context) ([]string, error) {
+	var shortIDs []string
+	pages := dynamodb.NewScanPaginator(storage.dynamo, &dynamodb.ScanInput{
+		TableName:            aws.String(tableName),
+		ProjectionExpression: aws.String("#shortID"),
+		ExpressionAttributeNames: map[string]string{
+			"#shortID": attributeShortID,
+		},
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list shortIDs: %w", err)
+		}
+		for _, item := range page.Items {
+			shortID := stringAttribute(item, attributeShortID)
+			if shortID != "" && !isRegionalUsageKey(shortID) {
+				shortIDs = append(shortIDs, shortID)
+			}
+		}
+	}
+	return shortIDs, nil
+}
+
+// ListPage scans one page of the table, a position is the shortID of the LastEvaluatedKey.
+// Scans walk the table in hash order, which new items don't disturb.
+func (storage *DynamoStorage) ListPage(ctx context.Context, after string, limit int) ([]string, string, error) {
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(tableName),
+		ProjectionExpression: aws.String("#shortID"),
+		ExpressionAttributeNames: map[string]string{
+			"#shortID": attributeShortID,
 		},
+		Limit: aws.Int32(int32(limit)),
+	}
+	if after != "" {
+		input.ExclusiveStartKey = shortIDKey(after)
+	}
+	out, err := storage.dynamo.Scan(ctx, input)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list shortIDs: %w", err)
+	}
+
+	// regional usage items count towards the limit, so global tables can return short pages
+	shortIDs := []string{}
+	for _, item := range out.Items {
+		shortID := stringAttribute(item, attributeShortID)
+		if shortID != "" && !isRegionalUsageKey(shortID) {
+			shortIDs = append(shortIDs, shortID)
+		}
+	}
+	return shortIDs, stringAttribute(out.LastEvaluatedKey, attributeShortID), nil
+}
+
+// HealthCheck is served by this region's replica of the table, so an unhealthy replica elsewhere
+// doesn't take this region out of service, but replica statuses are reported to help spot one
+func (storage *DynamoStorage) HealthCheck(ctx context.Context) (HealthStatus, error) {
+	out, err := storage.dynamo.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return HealthStatus{Backend: "dynamodb", Region: storage.region}, fmt.Errorf("failed to describe the table: %w", err)
+	}
+
+	status := HealthStatus{
+		Healthy: out.Table.TableStatus == types.TableStatusActive,
+		Backend: "dynamodb",
+		Region:  storage.region,
+	}
+	if len(out.Table.Replicas) > 0 {
+		status.Replicas = map[string]string{}
+		for _, replica := range out.Table.Replicas {
+			status.Replicas[aws.ToString(replica.RegionName)] = string(replica.ReplicaStatus)
+		}
+	}
+	return status, nil
+}
+
+func (storage *DynamoStorage) getObject(ctx context.Context, shortID string) (*URLObject, error) {
+	// strongly consistent reads cost twice the capacity, and on a global table only see the writes of this region
+	out, err := storage.dynamo.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(tableName),
+		Key:            shortIDKey(shortID),
+		ConsistentRead: aws.Bool(storage.consistentReads || wantsConsistentRead(ctx)),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to read a shortID: %w", err)
@@ -246,12 +1298,15 @@ func (storage *DynamoStorage) getObject(ctx context.Context, shortID string) (*U
 	}
 
 	var object URLObject
-	err = dynamodbattribute.UnmarshalMap(out.Item, &object)
+	err = attributevalue.UnmarshalMap(out.Item, &object)
 	if err != nil {
 		return nil, fmt.Errorf("failed to deserialize url object: %w", err)
 	}
 	if object.Usage == nil {
 		object.Usage = map[string]int64{}
 	}
+	if object.RuleUsage == nil {
+		object.RuleUsage = map[string]int64{}
+	}
 	return &object, nil
 }
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStorageContract checks the behavior every urlStorage implementation has to share, so backends and the
+// layers wrapped around them are verified the same way. Backends can be shared between runs, so the links
+// are scoped to a prefix unique to the run and nothing assumes the backend starts out empty.
+func testStorageContract(t *testing.T, newStorage func(t *testing.T) urlStorage) {
+	ctx := context.Background()
+	prefix := "contract" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	today := strconv.Itoa(int(UTCTimestampOfTodayRounded().Unix()))
+	link := func(name string) URLObject {
+		return URLObject{ShortID: prefix + name, URL: "https://example.com/" + prefix + "/" + name}
+	}
+
+	t.Run("SaveURL", func(t *testing.T) {
+		storage := newStorage(t)
+		object := link("save")
+		object.Campaign = prefix
+		object.Notes = "notes"
+		require.NoError(t, storage.SaveURL(ctx, object))
+		saved, err := storage.GetURL(ctx, object.ShortID)
+		require.NoError(t, err)
+		require.NotNil(t, saved)
+		assert.Equal(t, object.URL, saved.URL)
+		assert.Equal(t, prefix, saved.Campaign)
+		assert.Equal(t, "notes", saved.Notes)
+
+		// saving an existing shortID keeps the first link
+		replacement := object
+		replacement.URL = "https://example.com/replacement"
+		require.NoError(t, storage.SaveURL(ctx, replacement))
+		saved, err = storage.GetURL(ctx, object.ShortID)
+		require.NoError(t, err)
+		assert.Equal(t, object.URL, saved.URL)
+
+		missing, err := storage.GetURL(ctx, prefix+"missing")
+		require.NoError(t, err)
+		assert.Nil(t, missing)
+	})
+
+	t.Run("IncrementUsage", func(t *testing.T) {
+		storage := newStorage(t)
+		object := link("usage")
+		require.NoError(t, storage.SaveURL(ctx, object))
+		require.NoError(t, storage.IncrementUsage(ctx, object.ShortID, "", 1, 1))
+		require.NoError(t, storage.IncrementUsage(ctx, object.ShortID, "mobile", 1, 1))
+		// sampled out clicks still count for the day
+		require.NoError(t, storage.IncrementUsage(ctx, object.ShortID, "mobile", 1, 0))
+		// clicks reported by edge workers are counted at once
+		require.NoError(t, storage.IncrementUsage(ctx, object.ShortID, "mobile", 5, 5))
+		statistics, err := storage.GetStatistics(ctx, object.ShortID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(8), statistics.Usage[today])
+		assert.Equal(t, map[string]int64{"mobile": 6}, statistics.RuleUsage)
+
+		// usage of missing links is dropped without creating them
+		require.NoError(t, storage.IncrementUsage(ctx, prefix+"missing", "", 1, 1))
+		missing, err := storage.GetURL(ctx, prefix+"missing")
+		require.NoError(t, err)
+		assert.Nil(t, missing)
+		statistics, err = storage.GetStatistics(ctx, prefix+"missing")
+		require.NoError(t, err)
+		assert.Empty(t, statistics.Usage)
+		assert.NotNil(t, statistics.Usage)
+		assert.NotNil(t, statistics.RuleUsage)
+	})
+
+	t.Run("SetAttributes", func(t *testing.T) {
+		storage := newStorage(t)
+		object := link("attributes")
+		require.NoError(t, storage.SaveURL(ctx, object))
+		require.NoError(t, storage.SetPaused(ctx, object.ShortID, true))
+		require.NoError(t, storage.SetQuarantined(ctx, object.ShortID, true))
+		require.NoError(t, storage.SetExpiration(ctx, object.ShortID, 4102444800))
+		saved, err := storage.GetURL(ctx, object.ShortID)
+		require.NoError(t, err)
+		assert.True(t, saved.Paused)
+		assert.True(t, saved.Quarantined)
+		assert.Equal(t, int64(4102444800), saved.Expiration)
+
+		assert.True(t, errors.Is(storage.SetPaused(ctx, prefix+"missing", true), errNotFound))
+		assert.True(t, errors.Is(storage.SetQuarantined(ctx, prefix+"missing", true), errNotFound))
+		assert.True(t, errors.Is(storage.SetExpiration(ctx, prefix+"missing", 1), errNotFound))
+	})
+
+	t.Run("DeleteURL", func(t *testing.T) {
+		storage := newStorage(t)
+		object := link("delete")
+		require.NoError(t, storage.SaveURL(ctx, object))
+		require.NoError(t, storage.DeleteURL(ctx, object.ShortID))
+		deleted, err := storage.GetURL(ctx, object.ShortID)
+		require.NoError(t, err)
+		assert.Nil(t, deleted)
+		// deleting is idempotent
+		require.NoError(t, storage.DeleteURL(ctx, object.ShortID))
+	})
+
+	t.Run("ConsumeURL", func(t *testing.T) {
+		storage := newStorage(t)
+		object := link("consume")
+		require.NoError(t, storage.SaveURL(ctx, object))
+		consumed, err := storage.ConsumeURL(ctx, object.ShortID)
+		require.NoError(t, err)
+		assert.True(t, consumed)
+		consumed, err = storage.ConsumeURL(ctx, object.ShortID)
+		require.NoError(t, err)
+		assert.False(t, consumed)
+		gone, err := storage.GetURL(ctx, object.ShortID)
+		require.NoError(t, err)
+		assert.Nil(t, gone)
+	})
+
+	t.Run("GetStatisticsBatch", func(t *testing.T) {
+		storage := newStorage(t)
+		first, second := link("batch1"), link("batch2")
+		require.NoError(t, storage.SaveURL(ctx, first))
+		require.NoError(t, storage.SaveURL(ctx, second))
+		require.NoError(t, storage.IncrementUsage(ctx, first.ShortID, "", 1, 1))
+		batch, err := storage.GetStatisticsBatch(ctx, []string{first.ShortID, second.ShortID, first.ShortID, prefix + "missing"})
+		require.NoError(t, err)
+		require.Len(t, batch, 2)
+		assert.Equal(t, int64(1), batch[first.ShortID].Usage[today])
+		assert.NotNil(t, batch[second.ShortID].Usage)
+		assert.NotNil(t, batch[second.ShortID].RuleUsage)
+	})
+
+	t.Run("FindByURL", func(t *testing.T) {
+		storage := newStorage(t)
+		object := link("find")
+		object.URL = "https://Example.com/" + prefix + "/find"
+		require.NoError(t, storage.SaveURL(ctx, object))
+		found, err := storage.FindByURL(ctx, "https://example.com/"+prefix+"/find")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{object.ShortID: object.URL}, found)
+		found, err = storage.FindByURL(ctx, "https://example.com/"+prefix+"/other")
+		require.NoError(t, err)
+		assert.Empty(t, found)
+	})
+
+	t.Run("FindByCampaign", func(t *testing.T) {
+		storage := newStorage(t)
+		first, second, other := link("campaign1"), link("campaign2"), link("campaign3")
+		first.Campaign, second.Campaign = prefix+"campaign", prefix+"campaign"
+		for _, object := range []URLObject{first, second, other} {
+			require.NoError(t, storage.SaveURL(ctx, object))
+		}
+		shortIDs, err := storage.FindByCampaign(ctx, prefix+"campaign")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{first.ShortID, second.ShortID}, shortIDs)
+	})
+
+	t.Run("ListPage", func(t *testing.T) {
+		storage := newStorage(t)
+		var saved []string
+		for i := 0; i < 5; i++ {
+			object := link("list" + strconv.Itoa(i))
+			require.NoError(t, storage.SaveURL(ctx, object))
+			saved = append(saved, object.ShortID)
+		}
+		all, err := storage.ListShortIDs(ctx)
+		require.NoError(t, err)
+		assert.Subset(t, all, saved)
+
+		// pages can come back short, but together they list every link exactly once
+		var listed []string
+		after := ""
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, len(all)+1, "paging didn't end")
+			shortIDs, next, err := storage.ListPage(ctx, after, 2)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(shortIDs), 2)
+			listed = append(listed, shortIDs...)
+			if next == "" {
+				break
+			}
+			after = next
+		}
+		assert.Subset(t, listed, saved)
+		assert.Len(t, listed, len(all))
+	})
+
+	t.Run("ImportURL", func(t *testing.T) {
+		storage := newStorage(t)
+		object := link("import")
+		require.NoError(t, storage.SaveURL(ctx, object))
+		imported := object
+		imported.URL = "https://example.com/" + prefix + "/imported"
+		imported.Usage = map[string]int64{today: 7}
+		imported.RuleUsage = map[string]int64{"mobile": 2}
+		require.NoError(t, storage.ImportURL(ctx, imported))
+		saved, err := storage.GetURL(ctx, object.ShortID)
+		require.NoError(t, err)
+		assert.Equal(t, imported.URL, saved.URL)
+		statistics, err := storage.GetStatistics(ctx, object.ShortID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), statistics.Usage[today])
+		assert.Equal(t, int64(2), statistics.RuleUsage["mobile"])
+		// imported links are found by their destination like saved ones
+		found, err := storage.FindByURL(ctx, imported.URL)
+		require.NoError(t, err)
+		assert.Contains(t, found, object.ShortID)
+	})
+
+	t.Run("HealthCheck", func(t *testing.T) {
+		storage := newStorage(t)
+		health, err := storage.HealthCheck(ctx)
+		require.NoError(t, err)
+		assert.True(t, health.Healthy)
+		assert.NotEmpty(t, health.Backend)
+	})
+}
+
+func newContractLocalStorage(t *testing.T) urlStorage {
+	return &LocalStorage{Objects: map[string]URLObject{}}
+}
+
+func TestLocalStorageContract(t *testing.T) {
+	testStorageContract(t, newContractLocalStorage)
+}
+
+func TestCachedStorageContract(t *testing.T) {
+	testStorageContract(t, func(t *testing.T) urlStorage {
+		return NewCachedStorage(newContractLocalStorage(t), time.Minute)
+	})
+}
+
+func TestFilteredStorageContract(t *testing.T) {
+	testStorageContract(t, func(t *testing.T) urlStorage {
+		filtered, err := NewFilteredStorage(context.Background(), newContractLocalStorage(t))
+		require.NoError(t, err)
+		return filtered
+	})
+}
+
+func TestMigratingStorageContract(t *testing.T) {
+	testStorageContract(t, func(t *testing.T) urlStorage {
+		return NewMigratingStorage(newContractLocalStorage(t), newContractLocalStorage(t))
+	})
+}
+
+// TestDynamoStorageContract runs against a real table, e.g. localstack's, when SHORTIE_TEST_DYNAMO_ENDPOINT is set
+func TestDynamoStorageContract(t *testing.T) {
+	endpoint := os.Getenv("SHORTIE_TEST_DYNAMO_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("SHORTIE_TEST_DYNAMO_ENDPOINT is not set")
+	}
+	env, err := loadEnvironment("")
+	require.NoError(t, err)
+	env.AWSCustomDynamoEndpoint = endpoint
+	if env.AWSRegion == "" {
+		env.AWSRegion = "us-west-2"
+	}
+	if env.AWSAccessKeyID == "" {
+		env.AWSAccessKeyID, env.AWSSecretAccessKey = "dev", "dev"
+	}
+	storage, err := InitDynamoStorage(env)
+	require.NoError(t, err)
+	require.NoError(t, storage.InitializeTable())
+
+	testStorageContract(t, func(t *testing.T) urlStorage {
+		return storage
+	})
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// linkChangeEvent is what downstream systems receive for every created, updated or deleted link
+type linkChangeEvent struct {
+	Type    string     `json:"type"`
+	ShortID string     `json:"shortID"`
+	Time    time.Time  `json:"time"`
+	Link    *URLObject `json:"link,omitempty"`
+}
+
+type linkChangeSink interface {
+	Send(ctx context.Context, event linkChangeEvent) error
+}
+
+// WebhookSink posts every event as JSON to a url
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (sink *WebhookSink) Send(ctx context.Context, event linkChangeEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := sink.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to call the webhook: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("the webhook responded with %d", response.StatusCode)
+	}
+	return nil
+}
+
+// SNSSink publishes every event to an SNS topic, which can fan out to SQS, Lambda, Kafka bridges, etc.
+type SNSSink struct {
+	sns      *sns.Client
+	topicARN string
+}
+
+func NewSNSSink(storage *DynamoStorage, topicARN string) *SNSSink {
+	return &SNSSink{sns: sns.NewFromConfig(storage.awsConfig), topicARN: topicARN}
+}
+
+func (sink *SNSSink) Send(ctx context.Context, event linkChangeEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = sink.sns.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(sink.topicARN),
+		Message:  aws.String(string(body)),
+		MessageAttributes: map[string]snstypes.MessageAttributeValue{
+			"type": {DataType: aws.String("String"), StringValue: aws.String(event.Type)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish to sns: %w", err)
+	}
+	return nil
+}
+
+// StreamConsumer republishes the table's stream to a sink
+// only the replica holding the "streams" lock reads the stream, and a replica that takes over
+// starts from the latest records, so events can be missed while leadership changes hands
+type StreamConsumer struct {
+	streams *dynamodbstreams.Client
+	dynamo  *dynamodb.Client
+	sink    linkChangeSink
+	locker  locker
+
+	// iterators holds the next shard iterator of every open shard being read
+	iterators map[string]*string
+	// seenShards are shards that have been read or finished, so closed parents aren't read again
+	seenShards map[string]bool
+}
+
+const streamPollInterval = time.Second
+const streamLockTTL = 30 * time.Second
+
+func NewStreamConsumer(storage *DynamoStorage, sink linkChangeSink, locker locker) *StreamConsumer {
+	return &StreamConsumer{
+		streams: dynamodbstreams.NewFromConfig(storage.awsConfig, func(options *dynamodbstreams.Options) {
+			// a custom endpoint like DynamoDB local serves the streams api too
+			if storage.endpoint != "" {
+				options.BaseEndpoint = aws.String(storage.endpoint)
+			}
+		}),
+		dynamo:     storage.dynamo,
+		sink:       sink,
+		locker:     locker,
+		iterators:  map[string]*string{},
+		seenShards: map[string]bool{},
+	}
+}
+
+func (consumer *StreamConsumer) Run(ctx context.Context) {
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			leader, err := consumer.locker.TryLock(ctx, "streams", streamLockTTL)
+			if err != nil {
+				log.Println("error: " + err.Error())
+				continue
+			}
+			if !leader {
+				consumer.iterators = map[string]*string{}
+				consumer.seenShards = map[string]bool{}
+				continue
+			}
+			err = consumer.poll(ctx)
+			if err != nil {
+				log.Println("error: " + err.Error())
+			}
+		}
+	}
+}
+
+func (consumer *StreamConsumer) poll(ctx context.Context) error {
+	err := consumer.discoverShards(ctx)
+	if err != nil {
+		return err
+	}
+
+	for shardID, iterator := range consumer.iterators {
+		out, err := consumer.streams.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{
+			ShardIterator: iterator,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read the stream: %w", err)
+		}
+		for _, record := range out.Records {
+			event, publish, err := toLinkChangeEvent(record)
+			if err != nil {
+				log.Println("error: " + err.Error())
+				continue
+			}
+			if !publish {
+				continue
+			}
+			err = consumer.sink.Send(ctx, event)
+			if err != nil {
+				log.Println("error: " + err.Error())
+			}
+		}
+
+		if out.NextShardIterator == nil {
+			// the shard is closed and fully read
+			delete(consumer.iterators, shardID)
+			continue
+		}
+		consumer.iterators[shardID] = out.NextShardIterator
+	}
+	return nil
+}
+
+// discoverShards starts reading shards that haven't been seen yet
+// shards that exist on the first discovery start at their latest records, later ones are read from the start
+func (consumer *StreamConsumer) discoverShards(ctx context.Context) error {
+	table, err := consumer.dynamo.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe the table: %w", err)
+	}
+	streamARN := table.Table.LatestStreamArn
+	if streamARN == nil {
+		return fmt.Errorf("the table has no stream")
+	}
+
+	firstDiscovery := len(consumer.seenShards) == 0
+	var lastShardID *string
+	for {
+		stream, err := consumer.streams.DescribeStream(ctx, &dynamodbstreams.DescribeStreamInput{
+			StreamArn:             streamARN,
+			ExclusiveStartShardId: lastShardID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to describe the stream: %w", err)
+		}
+
+		for _, shard := range stream.StreamDescription.Shards {
+			shardID := aws.ToString(shard.ShardId)
+			if consumer.seenShards[shardID] {
+				continue
+			}
+			consumer.seenShards[shardID] = true
+
+			iteratorType := streamtypes.ShardIteratorTypeTrimHorizon
+			if firstDiscovery {
+				iteratorType = streamtypes.ShardIteratorTypeLatest
+			}
+			iterator, err := consumer.streams.GetShardIterator(ctx, &dynamodbstreams.GetShardIteratorInput{
+				StreamArn:         streamARN,
+				ShardId:           shard.ShardId,
+				ShardIteratorType: iteratorType,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to get a shard iterator: %w", err)
+			}
+			consumer.iterators[shardID] = iterator.ShardIterator
+		}
+
+		lastShardID = stream.StreamDescription.LastEvaluatedShardId
+		if lastShardID == nil {
+			return nil
+		}
+	}
+}
+
+// toLinkChangeEvent converts a stream record, reporting false for records downstream systems don't care about:
+// regional usage items and modifications that only counted usage
+func toLinkChangeEvent(record streamtypes.Record) (linkChangeEvent, bool, error) {
+	key, _ := record.Dynamodb.Keys[attributeShortID].(*streamtypes.AttributeValueMemberS)
+	if key == nil || isRegionalUsageKey(key.Value) {
+		return linkChangeEvent{}, false, nil
+	}
+
+	event := linkChangeEvent{
+		ShortID: key.Value,
+		Time:    aws.ToTime(record.Dynamodb.ApproximateCreationDateTime),
+	}
+	newImage, err := streamImageToObject(record.Dynamodb.NewImage)
+	if err != nil {
+		return linkChangeEvent{}, false, err
+	}
+	oldImage, err := streamImageToObject(record.Dynamodb.OldImage)
+	if err != nil {
+		return linkChangeEvent{}, false, err
+	}
+
+	switch record.EventName {
+	case streamtypes.OperationTypeInsert:
+		event.Type = changeCreated
+	case streamtypes.OperationTypeModify:
+		event.Type = changeUpdated
+		if oldImage != nil && newImage != nil && !linkDiffers(*oldImage, *newImage) {
+			return linkChangeEvent{}, false, nil
+		}
+	case streamtypes.OperationTypeRemove:
+		event.Type = changeDeleted
+	}
+	event.Link = newImage
+	if event.Link != nil {
+		// the signing secret must never leave the service
+		event.Link.SigningSecret = ""
+	}
+	return event, true, nil
+}
+
+// linkDiffers compares two versions of a link, ignoring the usage counters and version
+func linkDiffers(before URLObject, after URLObject) bool {
+	for _, object := range []*URLObject{&before, &after} {
+		object.Usage = nil
+		object.RuleUsage = nil
+		object.Version = 0
+	}
+	return !reflect.DeepEqual(before, after)
+}
+
+func streamImageToObject(image map[string]streamtypes.AttributeValue) (*URLObject, error) {
+	if image == nil {
+		return nil, nil
+	}
+	// stream images have their own attribute value types, which the table's decoder doesn't take
+	item, err := attributevalue.FromDynamoDBStreamsMap(image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert a stream image: %w", err)
+	}
+	var object URLObject
+	err = attributevalue.UnmarshalMap(item, &object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize a stream image: %w", err)
+	}
+	return &object, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// toStreamValue converts a table attribute value to the stream's own type, which is what stream records carry
+func toStreamValue(t *testing.T, value types.AttributeValue) streamtypes.AttributeValue {
+	switch value := value.(type) {
+	case *types.AttributeValueMemberS:
+		return &streamtypes.AttributeValueMemberS{Value: value.Value}
+	case *types.AttributeValueMemberN:
+		return &streamtypes.AttributeValueMemberN{Value: value.Value}
+	case *types.AttributeValueMemberBOOL:
+		return &streamtypes.AttributeValueMemberBOOL{Value: value.Value}
+	case *types.AttributeValueMemberNULL:
+		return &streamtypes.AttributeValueMemberNULL{Value: value.Value}
+	case *types.AttributeValueMemberL:
+		list := make([]streamtypes.AttributeValue, 0, len(value.Value))
+		for _, element := range value.Value {
+			list = append(list, toStreamValue(t, element))
+		}
+		return &streamtypes.AttributeValueMemberL{Value: list}
+	case *types.AttributeValueMemberM:
+		return &streamtypes.AttributeValueMemberM{Value: toStreamImage(t, value.Value)}
+	}
+	t.Fatalf("unexpected attribute value %T", value)
+	return nil
+}
+
+func toStreamImage(t *testing.T, item map[string]types.AttributeValue) map[string]streamtypes.AttributeValue {
+	image := make(map[string]streamtypes.AttributeValue, len(item))
+	for name, value := range item {
+		image[name] = toStreamValue(t, value)
+	}
+	return image
+}
+
+func TestToLinkChangeEvent(t *testing.T) {
+	image := func(object URLObject) map[string]streamtypes.AttributeValue {
+		item, err := attributevalue.MarshalMap(&object)
+		require.NoError(t, err)
+		return toStreamImage(t, item)
+	}
+	record := func(eventName streamtypes.OperationType, key string, oldImage, newImage map[string]streamtypes.AttributeValue) streamtypes.Record {
+		return streamtypes.Record{
+			EventName: eventName,
+			Dynamodb: &streamtypes.StreamRecord{
+				Keys:     map[string]streamtypes.AttributeValue{attributeShortID: &streamtypes.AttributeValueMemberS{Value: key}},
+				OldImage: oldImage,
+				NewImage: newImage,
+			},
+		}
+	}
+	link := URLObject{ShortID: "111", URL: "http://redirection.com/portal/portal", SigningSecret: "secret", Usage: map[string]int64{}}
+	usedLink := link
+	usedLink.Usage = map[string]int64{"1699228800": 1}
+	pausedLink := link
+	pausedLink.Paused = true
+
+	tests := []struct {
+		name            string
+		record          streamtypes.Record
+		expectedPublish bool
+		expectedType    string
+	}{
+		{
+			name:            "created",
+			record:          record(streamtypes.OperationTypeInsert, "111", nil, image(link)),
+			expectedPublish: true,
+			expectedType:    "created",
+		},
+		{
+			name:            "paused",
+			record:          record(streamtypes.OperationTypeModify, "111", image(link), image(pausedLink)),
+			expectedPublish: true,
+			expectedType:    "updated",
+		},
+		{
+			name:   "usage only",
+			record: record(streamtypes.OperationTypeModify, "111", image(link), image(usedLink)),
+		},
+		{
+			name:            "deleted",
+			record:          record(streamtypes.OperationTypeRemove, "111", image(link), nil),
+			expectedPublish: true,
+			expectedType:    "deleted",
+		},
+		{
+			name:   "regional usage item",
+			record: record(streamtypes.OperationTypeInsert, regionalUsageKey("111", "us-west-2"), nil, nil),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, publish, err := toLinkChangeEvent(test.record)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedPublish, publish)
+			if !publish {
+				return
+			}
+			assert.Equal(t, test.expectedType, event.Type)
+			assert.Equal(t, "111", event.ShortID)
+			if event.Link != nil {
+				assert.Empty(t, event.Link.SigningSecret)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// tableSettings are the table options applied on every start, so the table follows the config
+// instead of whatever it was created with
+type tableSettings struct {
+	pointInTimeRecovery bool
+	deletionProtection  bool
+	tableClass          types.TableClass
+	// tags are added to the table or updated, tags missing from the config are left alone
+	tags []types.Tag
+}
+
+func parseTableSettings(env Environment) (tableSettings, error) {
+	var settings tableSettings
+	var err error
+	settings.pointInTimeRecovery, err = strconv.ParseBool(env.DynamoPointInTimeRecovery)
+	if err != nil {
+		return settings, fmt.Errorf("invalid SHORTIE_DYNAMO_POINT_IN_TIME_RECOVERY: %w", err)
+	}
+	settings.deletionProtection, err = strconv.ParseBool(env.DynamoDeletionProtection)
+	if err != nil {
+		return settings, fmt.Errorf("invalid SHORTIE_DYNAMO_DELETION_PROTECTION: %w", err)
+	}
+	switch env.DynamoTableClass {
+	case "standard":
+		settings.tableClass = types.TableClassStandard
+	case "infrequent-access":
+		settings.tableClass = types.TableClassStandardInfrequentAccess
+	default:
+		return settings, fmt.Errorf("unknown SHORTIE_DYNAMO_TABLE_CLASS %q, expected standard or infrequent-access", env.DynamoTableClass)
+	}
+
+	tags := map[string]string{}
+	for _, pair := range strings.Split(env.DynamoTags, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, found := strings.Cut(pair, "=")
+		if !found || strings.TrimSpace(key) == "" {
+			return settings, fmt.Errorf("invalid SHORTIE_DYNAMO_TAGS entry %q, expected key=value", pair)
+		}
+		tags[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	for key, value := range tags {
+		settings.tags = append(settings.tags, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	sort.Slice(settings.tags, func(i, j int) bool {
+		return aws.ToString(settings.tags[i].Key) < aws.ToString(settings.tags[j].Key)
+	})
+	return settings, nil
+}
+
+// applySettings brings an existing table in line with the table settings, changing only what differs
+func (storage *DynamoStorage) applySettings(ctx context.Context) error {
+	err := storage.waitForTable(ctx)
+	if err != nil {
+		return err
+	}
+	out, err := storage.dynamo.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe the table: %w", err)
+	}
+
+	if aws.ToBool(out.Table.DeletionProtectionEnabled) != storage.settings.deletionProtection {
+		_, err = storage.dynamo.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+			TableName:                 aws.String(tableName),
+			DeletionProtectionEnabled: aws.Bool(storage.settings.deletionProtection),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update the deletion protection: %w", err)
+		}
+		err = storage.waitForTable(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	// tables that never changed class don't report one, they are standard
+	tableClass := types.TableClassStandard
+	if out.Table.TableClassSummary != nil && out.Table.TableClassSummary.TableClass != "" {
+		tableClass = out.Table.TableClassSummary.TableClass
+	}
+	if tableClass != storage.settings.tableClass {
+		_, err = storage.dynamo.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+			TableName:  aws.String(tableName),
+			TableClass: storage.settings.tableClass,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update the table class: %w", err)
+		}
+		err = storage.waitForTable(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	backups, err := storage.dynamo.DescribeContinuousBackups(ctx, &dynamodb.DescribeContinuousBackupsInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe the continuous backups: %w", err)
+	}
+	recovery := backups.ContinuousBackupsDescription.PointInTimeRecoveryDescription
+	enabled := recovery != nil && recovery.PointInTimeRecoveryStatus == types.PointInTimeRecoveryStatusEnabled
+	if enabled != storage.settings.pointInTimeRecovery {
+		_, err = storage.dynamo.UpdateContinuousBackups(ctx, &dynamodb.UpdateContinuousBackupsInput{
+			TableName: aws.String(tableName),
+			PointInTimeRecoverySpecification: &types.PointInTimeRecoverySpecification{
+				PointInTimeRecoveryEnabled: aws.Bool(storage.settings.pointInTimeRecovery),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update point in time recovery: %w", err)
+		}
+	}
+
+	if len(storage.settings.tags) > 0 {
+		_, err = storage.dynamo.TagResource(ctx, &dynamodb.TagResourceInput{
+			ResourceArn: out.Table.TableArn,
+			Tags:        storage.settings.tags,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to tag the table: %w", err)
+		}
+	}
+	return nil
+}
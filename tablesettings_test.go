@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTableSettings(t *testing.T) {
+	env := Environment{
+		DynamoPointInTimeRecovery: "true",
+		DynamoDeletionProtection:  "false",
+		DynamoTableClass:          "infrequent-access",
+		DynamoTags:                "team=growth, cost-center = 42,team=links",
+	}
+	settings, err := parseTableSettings(env)
+	require.NoError(t, err)
+	assert.Equal(t, tableSettings{
+		pointInTimeRecovery: true,
+		deletionProtection:  false,
+		tableClass:          types.TableClassStandardInfrequentAccess,
+		// later tags win, and the tags are sorted by key
+		tags: []types.Tag{
+			{Key: aws.String("cost-center"), Value: aws.String("42")},
+			{Key: aws.String("team"), Value: aws.String("links")},
+		},
+	}, settings)
+
+	for _, invalid := range []func(env *Environment){
+		func(env *Environment) { env.DynamoPointInTimeRecovery = "sometimes" },
+		func(env *Environment) { env.DynamoTableClass = "glacier" },
+		func(env *Environment) { env.DynamoTags = "team" },
+		func(env *Environment) { env.DynamoTags = "=growth" },
+	} {
+		invalidEnv := env
+		invalid(&invalidEnv)
+		_, err = parseTableSettings(invalidEnv)
+		assert.Error(t, err)
+	}
+}
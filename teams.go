@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// team aliases are stored as links with the shortID team/alias, generated ids never contain a slash
+// so aliases can't collide with them, and two teams can use the same alias without colliding with each other
+const teamAliasSeparator = "/"
+
+const maxAliasLength = 64
+
+var aliasPattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+func teamAliasID(team string, alias string) string {
+	return team + teamAliasSeparator + alias
+}
+
+func isTeamAlias(shortID string) bool {
+	return strings.Contains(shortID, teamAliasSeparator)
+}
+
+// validateAlias checks team names and aliases, both are lowercase so /t/Eng/Guide can't shadow /t/eng/guide
+func validateAlias(name string) error {
+	if len(name) == 0 || len(name) > maxAliasLength {
+		return fmt.Errorf("names must be between 1 and %d characters", maxAliasLength)
+	}
+	if !aliasPattern.MatchString(name) {
+		return fmt.Errorf("%q can only contain lowercase letters, digits, - and _", name)
+	}
+	return nil
+}
+
+// parseTeamTokens reads comma separated team=token pairs, e.g. eng=secret1,growth=secret2
+func parseTeamTokens(config string) (map[string]string, error) {
+	tokens := map[string]string{}
+	for _, pair := range strings.Split(config, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		team, token, found := strings.Cut(pair, "=")
+		if !found || token == "" {
+			return nil, fmt.Errorf("team token for %q is not team=token", team)
+		}
+		err := validateAlias(team)
+		if err != nil {
+			return nil, fmt.Errorf("invalid team: %w", err)
+		}
+		tokens[team] = token
+	}
+	return tokens, nil
+}
+
+// RequireTeam only lets requests through with the bearer token of the team in the path, or an admin's token
+func (api shortieAPI) RequireTeam(c *gin.Context) {
+	team := c.Param("team")
+	token, found := api.teamTokens[team]
+	if !found {
+		respondError(c, http.StatusNotFound, codeNotFound, "team not found")
+		return
+	}
+	if api.dev {
+		c.Next()
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) == 1 {
+		c.Next()
+		return
+	}
+	if caller := api.authenticate(c); caller != nil && caller.role >= roleAdmin {
+		c.Next()
+		return
+	}
+	respondError(c, http.StatusUnauthorized, codeUnauthorized, "invalid team token")
+}
+
+func (api shortieAPI) HandleTeamRedirect(c *gin.Context) {
+	api.redirect(c, teamAliasID(c.Param("team"), c.Param("alias")))
+}
+
+type teamAlias struct {
+	Alias      string `json:"alias"`
+	URL        string `json:"url"`
+	ShortURL   string `json:"shortUrl"`
+	Expiration int64  `json:"expiration,omitempty"`
+	Title      string `json:"title,omitempty"`
+}
+
+func newTeamAlias(team string, alias string, object *URLObject) teamAlias {
+	return teamAlias{
+		Alias:      alias,
+		URL:        object.URL,
+		ShortURL:   "http://localhost:8421/t/" + team + "/" + alias,
+		Expiration: object.Expiration,
+		Title:      object.Title,
+	}
+}
+
+// CreateTeamAlias claims a friendly name in the team's namespace, names are first come first served within a team
+func (api shortieAPI) CreateTeamAlias(c *gin.Context) {
+	var body = struct {
+		Alias      string `json:"alias"`
+		URL        string `json:"url"`
+		Expiration int64  `json:"expiration"`
+		Notes      string `json:"notes"`
+		Indexable  bool   `json:"indexable"`
+		Title      string `json:"title"`
+	}{}
+	if !api.bindJSON(c, &body) {
+		return
+	}
+	team := c.Param("team")
+	err := validateAlias(body.Alias)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, "invalid alias: "+err.Error())
+		return
+	}
+	err = api.validateURLLengths(body.URL)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeURLInvalid, err.Error())
+		return
+	}
+	if body.URL == "" {
+		respondError(c, http.StatusBadRequest, codeURLInvalid, "url is required")
+		return
+	}
+	err = validateNotes(body.Notes, nil)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	err = validateTitle(body.Title)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	if api.canonicalizer != nil {
+		body.URL = api.canonicalizer.Canonicalize(body.URL)
+	}
+
+	shortID := teamAliasID(team, body.Alias)
+	existing, err := api.storage.GetURL(c, shortID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if existing != nil {
+		respondErrorDetails(c, http.StatusConflict, codeAliasTaken, "the alias is already taken", map[string]any{
+			"url": existing.URL,
+		})
+		return
+	}
+	object := URLObject{
+		ShortID:    shortID,
+		URL:        body.URL,
+		Expiration: body.Expiration,
+		Notes:      body.Notes,
+		Indexable:  body.Indexable,
+		Title:      body.Title,
+		Created:    time.Now().Unix(),
+	}
+	err = api.storage.SaveURL(c, object)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	// saving keeps a link that already exists, so read it back in case another request claimed the alias first
+	saved, err := api.storage.GetURL(c, shortID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if saved != nil && saved.URL != object.URL {
+		respondErrorDetails(c, http.StatusConflict, codeAliasTaken, "the alias is already taken", map[string]any{
+			"url": saved.URL,
+		})
+		return
+	}
+	if api.titles != nil && object.Title == "" {
+		api.titles.FetchLater(api.storage, shortID, object.URL)
+	}
+	c.JSON(http.StatusOK, newTeamAlias(team, body.Alias, &object))
+}
+
+// ListTeamAliases lists the aliases of a team by name, it scans every link so it is meant for occasional use
+func (api shortieAPI) ListTeamAliases(c *gin.Context) {
+	team := c.Param("team")
+	shortIDs, err := api.storage.ListShortIDs(c)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	prefix := teamAliasID(team, "")
+	aliases := []teamAlias{}
+	for _, shortID := range shortIDs {
+		alias, found := strings.CutPrefix(shortID, prefix)
+		if !found {
+			continue
+		}
+		object, err := api.storage.GetURL(c, shortID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		if object == nil {
+			continue
+		}
+		aliases = append(aliases, newTeamAlias(team, alias, object))
+	}
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].Alias < aliases[j].Alias })
+	c.JSON(http.StatusOK, map[string]any{"aliases": aliases})
+}
+
+func (api shortieAPI) DeleteTeamAlias(c *gin.Context) {
+	shortID := teamAliasID(c.Param("team"), c.Param("alias"))
+	object, err := api.storage.GetURL(c, shortID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if object == nil {
+		respondError(c, http.StatusNotFound, codeNotFound, "alias not found")
+		return
+	}
+	err = api.storage.DeleteURL(c, shortID)
+	if errors.Is(err, errLocked) {
+		respondLocked(c)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAlias(t *testing.T) {
+	assert.NoError(t, validateAlias("deploy-guide"))
+	assert.NoError(t, validateAlias("q3_okrs"))
+	assert.Error(t, validateAlias(""))
+	assert.Error(t, validateAlias("Deploy-Guide"))
+	assert.Error(t, validateAlias("deploy/guide"))
+	assert.Error(t, validateAlias(string(make([]byte, maxAliasLength+1))))
+}
+
+func TestParseTeamTokens(t *testing.T) {
+	tokens, err := parseTeamTokens("eng=s3cret, growth=0ther")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"eng": "s3cret", "growth": "0ther"}, tokens)
+
+	tokens, err = parseTeamTokens("")
+	require.NoError(t, err)
+	assert.Empty(t, tokens)
+
+	_, err = parseTeamTokens("eng")
+	assert.Error(t, err)
+	_, err = parseTeamTokens("Eng=s3cret")
+	assert.Error(t, err)
+}
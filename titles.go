@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// titleMaxBytes is how much of a page is read looking for its title, which belongs in the head at the top
+	titleMaxBytes = 256 << 10
+	// maxTitleLength is the most characters a title keeps, longer fetched titles are cut and longer given ones rejected
+	maxTitleLength    = 200
+	titleFetchTimeout = 10 * time.Second
+	// titleFetchConcurrency bounds the fetches running in the background, links created past it get no title until refreshed
+	titleFetchConcurrency = 8
+)
+
+var (
+	errNoTitle = errors.New("the destination has no title")
+	pageTitle  = regexp.MustCompile(`(?is)<title\b[^>]*>(.*?)</title\s*>`)
+)
+
+// parseTitle finds the title of a page, with its entities decoded and its whitespace collapsed
+func parseTitle(page []byte) (string, error) {
+	match := pageTitle.FindSubmatch(page)
+	if match == nil {
+		return "", errNoTitle
+	}
+	title := strings.Join(strings.Fields(html.UnescapeString(strings.ToValidUTF8(string(match[1]), ""))), " ")
+	if title == "" {
+		return "", errNoTitle
+	}
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		title = string([]rune(title)[:maxTitleLength])
+	}
+	return title, nil
+}
+
+// validateTitle checks a title given when creating a link
+func validateTitle(title string) error {
+	if !utf8.ValidString(title) {
+		return errors.New("title must be valid utf-8")
+	}
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		return fmt.Errorf("titles can be at most %d characters", maxTitleLength)
+	}
+	return nil
+}
+
+// linkTitles fetches the titles of destinations, in the background for links created without one
+type linkTitles struct {
+	fetcher contentFetcher
+	slots   chan struct{}
+}
+
+func newLinkTitles(fetcher contentFetcher) *linkTitles {
+	return &linkTitles{fetcher: fetcher, slots: make(chan struct{}, titleFetchConcurrency)}
+}
+
+// Fetch reads the title of a destination
+func (titles *linkTitles) Fetch(ctx context.Context, url string) (string, error) {
+	page, err := titles.fetcher.Fetch(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	return parseTitle(page)
+}
+
+// FetchLater stores the title of a link once it is fetched, without holding up the request that created it.
+// Failures are only logged, the link keeps no title until it is refreshed.
+func (titles *linkTitles) FetchLater(storage urlStorage, shortID string, url string) {
+	select {
+	case titles.slots <- struct{}{}:
+	default:
+		log.Println("error: too many titles are being fetched, skipped the title of " + shortID)
+		return
+	}
+	go func() {
+		defer func() { <-titles.slots }()
+		ctx, cancel := context.WithTimeout(context.Background(), titleFetchTimeout)
+		defer cancel()
+
+		title, err := titles.Fetch(ctx, url)
+		if err != nil {
+			log.Println("error: failed to fetch the title of " + shortID + ": " + err.Error())
+			return
+		}
+		// a title given in the meantime, e.g. by creating the same link again with one, is kept
+		object, err := storage.GetURL(ctx, shortID)
+		if err != nil {
+			log.Println("error: failed to fetch the title of " + shortID + ": " + err.Error())
+			return
+		}
+		if object == nil || object.Title != "" || object.URL != url {
+			return
+		}
+		err = storage.SetTitle(ctx, shortID, title)
+		if err != nil && !errors.Is(err, errNotFound) {
+			log.Println("error: failed to save the title of " + shortID + ": " + err.Error())
+		}
+	}()
+}
+
+// RefreshTitle fetches the title of a link's destination again and replaces the stored one
+func (api shortieAPI) RefreshTitle(c *gin.Context) {
+	if api.titles == nil {
+		respondError(c, http.StatusBadRequest, codeFeatureDisabled, "link titles are not enabled")
+		return
+	}
+	shortID := c.Param("id")
+	object, err := api.storage.GetURL(c, shortID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if object == nil {
+		respondNotFound(c)
+		return
+	}
+	// the destinations of links flagged as spam aren't visited
+	if object.Quarantined {
+		respondError(c, http.StatusForbidden, codeForbidden, "the link is quarantined")
+		return
+	}
+	ctx, cancel := context.WithTimeout(c, titleFetchTimeout)
+	defer cancel()
+	title, err := api.titles.Fetch(ctx, object.URL)
+	if err != nil {
+		respondError(c, http.StatusBadGateway, codeURLInvalid, "the destination's title can't be fetched: "+err.Error())
+		return
+	}
+	err = api.storage.SetTitle(c, shortID, title)
+	if errors.Is(err, errNotFound) {
+		respondNotFound(c)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, map[string]string{"title": title})
+}
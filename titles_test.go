@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTitle(t *testing.T) {
+	title, err := parseTitle([]byte("<html><head><TITLE lang=\"en\">\n  Tom &amp; Jerry\n\t| Cartoons </TITLE></head></html>"))
+	require.NoError(t, err)
+	assert.Equal(t, "Tom & Jerry | Cartoons", title)
+
+	title, err = parseTitle([]byte("<title>" + strings.Repeat("é", maxTitleLength+10) + "</title>"))
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("é", maxTitleLength), title)
+
+	for _, page := range []string{"<html><body>no title</body></html>", "<title>  </title>"} {
+		_, err = parseTitle([]byte(page))
+		assert.ErrorIs(t, err, errNoTitle, page)
+	}
+}
+
+func TestLinkTitles(t *testing.T) {
+	fetcher := fakeContentFetcher{"https://example.com/anvil": "<html><head><title>Acme Anvil 3000</title></head></html>"}
+	api := shortieAPI{storage: newContractLocalStorage(t), titles: newLinkTitles(fetcher)}
+	create := func(body string) string {
+		w := serveAs(api, "", http.MethodPost, "/shortie", body)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var created map[string]string
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+		return strings.TrimPrefix(created["shortUrl"], "http://localhost:8421/shortie/")
+	}
+	title := func(shortID string) string {
+		object, err := api.storage.GetURL(context.Background(), shortID)
+		require.NoError(t, err)
+		require.NotNil(t, object)
+		return object.Title
+	}
+
+	// fetched in the background
+	shortID := create(`{"url":"https://example.com/anvil"}`)
+	assert.Eventually(t, func() bool { return title(shortID) == "Acme Anvil 3000" }, time.Second, 10*time.Millisecond)
+
+	given := create(`{"url":"https://example.com/hammer","title":"The hammer"}`)
+	assert.Equal(t, "The hammer", title(given))
+
+	w := serveAs(api, "", http.MethodPost, "/shortie", `{"url":"https://example.com/long","title":"`+strings.Repeat("a", maxTitleLength+1)+`"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// refreshing replaces the title with the page's current one
+	fetcher["https://example.com/anvil"] = "<title>Acme Anvil 4000</title>"
+	w = serveAs(api, "", http.MethodPost, "/shortie/"+shortID+"/title", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"title":"Acme Anvil 4000"}`, w.Body.String())
+	assert.Equal(t, "Acme Anvil 4000", title(shortID))
+
+	w = serveAs(api, "", http.MethodPost, "/shortie/"+given+"/title", "")
+	assert.Equal(t, http.StatusBadGateway, w.Code, "an unreachable destination")
+	assert.Equal(t, "The hammer", title(given))
+
+	w = serveAs(api, "", http.MethodPost, "/shortie/missing/title", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	api.titles = nil
+	w = serveAs(api, "", http.MethodPost, "/shortie/"+shortID+"/title", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+)
+
+// the scopes an access token can be limited to
+const (
+	// scopeCreate creates links
+	scopeCreate = "create"
+	// scopeManage pauses, resumes, extends and deletes the links the token created
+	scopeManage = "manage"
+	// scopeStats reads statistics and looks links up
+	scopeStats = "stats"
+	// scopeEdge resolves links and reports their clicks for edge workers, and follows the change feed
+	scopeEdge = "edge"
+)
+
+var tokenScopes = map[string]bool{scopeCreate: true, scopeManage: true, scopeStats: true, scopeEdge: true}
+
+// maxTokenTTL is the furthest in the future an access token can expire, so forgotten tokens run out
+const maxTokenTTL = 365 * 24 * time.Hour
+
+// accessTokenPrefix marks the tokens minted by the service, which are accessTokenPrefix + id + "_" + secret
+const accessTokenPrefix = "shortie_"
+
+// accessToken is a scoped, expiring token for automation minted from the admin api.
+// Only a hash of its secret is stored, revoked tokens are kept so the revocation list shows them.
+type accessToken struct {
+	ID         string   `dynamodbav:"tokenID" json:"id"`
+	Name       string   `dynamodbav:"name" json:"name"`
+	SecretHash string   `dynamodbav:"secretHash" json:"-"`
+	Scopes     []string `dynamodbav:"scopes" json:"scopes"`
+	// Campaign limits the token to the links of one campaign, any link if empty
+	Campaign   string `dynamodbav:"campaign,omitempty" json:"campaign,omitempty"`
+	CreatedAt  int64  `dynamodbav:"createdAt" json:"createdAt"`
+	Expiration int64  `dynamodbav:"expiration" json:"expiration"`
+	RevokedAt  int64  `dynamodbav:"revokedAt" json:"revokedAt,omitempty"`
+}
+
+// principal is who a valid token acts as, tokens that create or manage links are editors and the rest viewers
+func (token accessToken) principal() *principal {
+	caller := &principal{name: token.Name, role: roleViewer, scopes: token.Scopes, campaign: token.Campaign}
+	for _, scope := range token.Scopes {
+		if scope == scopeCreate || scope == scopeManage {
+			caller.role = roleEditor
+		}
+	}
+	return caller
+}
+
+func hashTokenSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// tokenStore keeps the access tokens minted by the service, shared by every replica
+type tokenStore interface {
+	SaveToken(ctx context.Context, token accessToken) error
+	// GetToken returns nil if the token doesn't exist
+	GetToken(ctx context.Context, id string) (*accessToken, error)
+	ListTokens(ctx context.Context) ([]accessToken, error)
+	// RevokeToken returns errNotFound for missing tokens
+	RevokeToken(ctx context.Context, id string, revokedAt int64) error
+}
+
+// LocalTokenStore keeps tokens in memory, they are lost when the process exits
+type LocalTokenStore struct {
+	lock   sync.Mutex
+	tokens map[string]accessToken
+}
+
+func NewLocalTokenStore() *LocalTokenStore {
+	return &LocalTokenStore{tokens: map[string]accessToken{}}
+}
+
+func (store *LocalTokenStore) SaveToken(ctx context.Context, token accessToken) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.tokens[token.ID] = token
+	return nil
+}
+
+func (store *LocalTokenStore) GetToken(ctx context.Context, id string) (*accessToken, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	token, found := store.tokens[id]
+	if !found {
+		return nil, nil
+	}
+	return &token, nil
+}
+
+func (store *LocalTokenStore) ListTokens(ctx context.Context) ([]accessToken, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	tokens := make([]accessToken, 0, len(store.tokens))
+	for _, token := range store.tokens {
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+func (store *LocalTokenStore) RevokeToken(ctx context.Context, id string, revokedAt int64) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	token, found := store.tokens[id]
+	if !found {
+		return errNotFound
+	}
+	if token.RevokedAt == 0 {
+		token.RevokedAt = revokedAt
+		store.tokens[id] = token
+	}
+	return nil
+}
+
+const tokensTableName = "shortie-tokens"
+const attributeTokenID = "tokenID"
+
+// DynamoTokenStore keeps tokens in their own table next to the links table
+type DynamoTokenStore struct {
+	dynamo *dynamodb.Client
+}
+
+func NewDynamoTokenStore(storage *DynamoStorage) *DynamoTokenStore {
+	return &DynamoTokenStore{dynamo: storage.dynamo}
+}
+
+func (store *DynamoTokenStore) InitializeTable() error {
+	_, err := store.dynamo.CreateTable(context.Background(), &dynamodb.CreateTableInput{
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String(attributeTokenID),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String(attributeTokenID),
+				KeyType:       types.KeyTypeHash,
+			},
+		},
+		TableName: aws.String(tokensTableName),
+	})
+	if err != nil {
+		var alreadyExists *types.TableAlreadyExistsException
+		var inUse *types.ResourceInUseException
+		if errors.As(err, &alreadyExists) || errors.As(err, &inUse) {
+			return nil
+		}
+		return fmt.Errorf("failed to create the tokens table: %w", err)
+	}
+	return nil
+}
+
+func tokenIDKey(id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		attributeTokenID: &types.AttributeValueMemberS{Value: id},
+	}
+}
+
+func (store *DynamoTokenStore) SaveToken(ctx context.Context, token accessToken) error {
+	item, err := attributevalue.MarshalMap(&token)
+	if err != nil {
+		return err
+	}
+	_, err = store.dynamo.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tokensTableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save the token: %w", err)
+	}
+	return nil
+}
+
+func (store *DynamoTokenStore) GetToken(ctx context.Context, id string) (*accessToken, error) {
+	// a strongly consistent read so a revocation applies everywhere right away
+	out, err := store.dynamo.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(tokensTableName),
+		Key:            tokenIDKey(id),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the token: %w", err)
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	var token accessToken
+	err = attributevalue.UnmarshalMap(out.Item, &token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (store *DynamoTokenStore) ListTokens(ctx context.Context) ([]accessToken, error) {
+	tokens := []accessToken{}
+	paginator := dynamodb.NewScanPaginator(store.dynamo, &dynamodb.ScanInput{
+		TableName: aws.String(tokensTableName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the tokens: %w", err)
+		}
+		var pageTokens []accessToken
+		err = attributevalue.UnmarshalListOfMaps(page.Items, &pageTokens)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, pageTokens...)
+	}
+	return tokens, nil
+}
+
+func (store *DynamoTokenStore) RevokeToken(ctx context.Context, id string, revokedAt int64) error {
+	_, err := store.dynamo.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(tokensTableName),
+		Key:                 tokenIDKey(id),
+		UpdateExpression:    aws.String("SET #revokedAt = :revokedAt"),
+		ConditionExpression: aws.String("attribute_exists(#id) AND #revokedAt = :never"),
+		ExpressionAttributeNames: map[string]string{
+			"#id":        attributeTokenID,
+			"#revokedAt": "revokedAt",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":revokedAt": numberValue(revokedAt),
+			":never":     numberValue(0),
+		},
+	})
+	if err != nil {
+		if isConditionFailed(err) {
+			// either the token doesn't exist or it was already revoked, which keeps its first revocation time
+			existing, getErr := store.GetToken(ctx, id)
+			if getErr != nil {
+				return getErr
+			}
+			if existing == nil {
+				return errNotFound
+			}
+			return nil
+		}
+		return fmt.Errorf("failed to revoke the token: %w", err)
+	}
+	return nil
+}
+
+// initTokenStore keeps tokens with the storage backend, nil if access tokens aren't enabled
+func initTokenStore(env Environment, dynamoStorage *DynamoStorage) (tokenStore, error) {
+	enabled, err := strconv.ParseBool(env.AccessTokens)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHORTIE_ACCESS_TOKENS: %w", err)
+	}
+	if !enabled {
+		return nil, nil
+	}
+	if dynamoStorage == nil {
+		return NewLocalTokenStore(), nil
+	}
+	store := NewDynamoTokenStore(dynamoStorage)
+	if !dynamoStorage.initializeTables {
+		return store, nil
+	}
+	return store, store.InitializeTable()
+}
+
+// verifyAccessToken returns who a minted token acts as, nil if it doesn't exist, doesn't match, expired or was revoked
+func (api shortieAPI) verifyAccessToken(ctx context.Context, value string, now time.Time) *principal {
+	id, secret, found := strings.Cut(strings.TrimPrefix(value, accessTokenPrefix), "_")
+	if !found {
+		return nil
+	}
+	token, err := api.tokens.GetToken(ctx, id)
+	if err != nil {
+		log.Println("error: " + err.Error())
+		return nil
+	}
+	if token == nil || token.RevokedAt != 0 || now.Unix() >= token.Expiration {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashTokenSecret(secret)), []byte(token.SecretHash)) != 1 {
+		return nil
+	}
+	return token.principal()
+}
+
+// CreateToken mints a scoped, expiring token, its value is only ever shown in this response
+func (api shortieAPI) CreateToken(c *gin.Context) {
+	if api.tokens == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "access tokens are not enabled")
+		return
+	}
+	var body struct {
+		Name       string   `json:"name"`
+		Scopes     []string `json:"scopes"`
+		Campaign   string   `json:"campaign"`
+		Expiration int64    `json:"expiration"`
+	}
+	if !api.bindJSON(c, &body) {
+		return
+	}
+	if body.Name == "" {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, "name is required")
+		return
+	}
+	if len(body.Scopes) == 0 {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, "at least one scope is required")
+		return
+	}
+	for _, scope := range body.Scopes {
+		if !tokenScopes[scope] {
+			respondError(c, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("unknown scope %q, expected create, manage, stats or edge", scope))
+			return
+		}
+	}
+	if len(body.Campaign) > maxCampaignLength {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("campaign names can be at most %d characters", maxCampaignLength))
+		return
+	}
+	now := time.Now()
+	if body.Expiration <= now.Unix() || body.Expiration > now.Add(maxTokenTTL).Unix() {
+		respondError(c, http.StatusBadRequest, codeExpirationInvalid, fmt.Sprintf("expiration is required and can be at most %s away", maxTokenTTL))
+		return
+	}
+
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	secret, err := newSigningSecret()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	token := accessToken{
+		ID:         hex.EncodeToString(id),
+		Name:       body.Name,
+		SecretHash: hashTokenSecret(secret),
+		Scopes:     body.Scopes,
+		Campaign:   body.Campaign,
+		CreatedAt:  now.Unix(),
+		Expiration: body.Expiration,
+	}
+	err = api.tokens.SaveToken(c, token)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, map[string]any{
+		"id":         token.ID,
+		"token":      accessTokenPrefix + token.ID + "_" + secret,
+		"name":       token.Name,
+		"scopes":     token.Scopes,
+		"campaign":   token.Campaign,
+		"expiration": token.Expiration,
+	})
+}
+
+// ListTokens lists the minted tokens without their secrets, ?revoked=true lists the revocation list alone
+func (api shortieAPI) ListTokens(c *gin.Context) {
+	if api.tokens == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "access tokens are not enabled")
+		return
+	}
+	tokens, err := api.tokens.ListTokens(c)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if c.Query("revoked") == "true" {
+		revoked := []accessToken{}
+		for _, token := range tokens {
+			if token.RevokedAt != 0 {
+				revoked = append(revoked, token)
+			}
+		}
+		tokens = revoked
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt > tokens[j].CreatedAt })
+	c.JSON(http.StatusOK, tokens)
+}
+
+// RevokeToken stops a token from working on every replica right away
+func (api shortieAPI) RevokeToken(c *gin.Context) {
+	if api.tokens == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "access tokens are not enabled")
+		return
+	}
+	err := api.tokens.RevokeToken(c, c.Param("id"), time.Now().Unix())
+	if errors.Is(err, errNotFound) {
+		respondNotFound(c)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// RequireScope rejects access tokens that weren't given the scope, api keys and jwts are only limited by their role
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller := callerOf(c)
+		if caller != nil && !caller.allows(scope) {
+			respondError(c, http.StatusForbidden, codeForbidden, "the token's scopes don't allow this")
+			return
+		}
+		c.Next()
+	}
+}
+
+// RestrictCampaign keeps tokens limited to a campaign to the links of that campaign:
+// routes on a link check its campaign, routes on a campaign check its name, and routes across links are refused
+func (api shortieAPI) RestrictCampaign(c *gin.Context) {
+	caller := callerOf(c)
+	if caller == nil || caller.campaign == "" {
+		c.Next()
+		return
+	}
+	switch {
+	case c.Param("id") != "":
+		object, err := api.storage.GetURL(c, c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		// missing links are left to the handler to answer
+		if object != nil && object.Campaign != caller.campaign {
+			respondError(c, http.StatusForbidden, codeForbidden, "the token is limited to the links of campaign "+caller.campaign)
+			return
+		}
+	case c.Param("name") != "":
+		if c.Param("name") != caller.campaign {
+			respondError(c, http.StatusForbidden, codeForbidden, "the token is limited to the links of campaign "+caller.campaign)
+			return
+		}
+	default:
+		respondError(c, http.StatusForbidden, codeForbidden, "the token is limited to the links of campaign "+caller.campaign)
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessTokenPrincipal(t *testing.T) {
+	assert.Equal(t, roleEditor, accessToken{Name: "bot", Scopes: []string{scopeStats, scopeCreate}}.principal().role)
+	assert.Equal(t, roleViewer, accessToken{Name: "bot", Scopes: []string{scopeStats}}.principal().role)
+
+	caller := accessToken{Name: "bot", Scopes: []string{scopeCreate}, Campaign: "launch"}.principal()
+	assert.True(t, caller.allows(scopeCreate))
+	assert.False(t, caller.allows(scopeStats))
+	assert.Equal(t, "launch", caller.campaign)
+}
+
+func TestLocalTokenStoreRevoke(t *testing.T) {
+	store := NewLocalTokenStore()
+	err := store.SaveToken(context.Background(), accessToken{ID: "abc", Name: "bot"})
+	require.NoError(t, err)
+
+	require.NoError(t, store.RevokeToken(context.Background(), "abc", 100))
+	// revoking again keeps the first revocation time
+	require.NoError(t, store.RevokeToken(context.Background(), "abc", 200))
+	token, err := store.GetToken(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), token.RevokedAt)
+
+	assert.ErrorIs(t, store.RevokeToken(context.Background(), "missing", 100), errNotFound)
+}
+
+func TestAccessTokens(t *testing.T) {
+	storage := &LocalStorage{Objects: map[string]URLObject{}, lock: sync.Mutex{}}
+	api := shortieAPI{storage: storage, adminToken: "admin", tokens: NewLocalTokenStore()}
+	router := api.GetRouter()
+	send := func(method string, path string, body string, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w
+	}
+
+	expiration := time.Now().Add(time.Hour).Unix()
+	w := send(http.MethodPost, "/auth/tokens", fmt.Sprintf(`{"name":"release-bot","scopes":["create"],"campaign":"launch","expiration":%d}`, expiration), "admin")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var minted struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(w.Body.String()), &minted))
+	assert.True(t, strings.HasPrefix(minted.Token, accessTokenPrefix))
+
+	// the token creates links in its campaign, owned by its name
+	w = send(http.MethodPost, "/shortie", `{"url":"https://example.com/data/hi"}`, minted.Token)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	object, err := storage.GetURL(context.Background(), "4e24c46962")
+	require.NoError(t, err)
+	assert.Equal(t, "release-bot", object.Owner)
+	assert.Equal(t, "launch", object.Campaign)
+
+	w = send(http.MethodPost, "/shortie", `{"url":"https://example.com/data/other","campaign":"other"}`, minted.Token)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	// it wasn't given the stats scope
+	w = send(http.MethodGet, "/shortie/4e24c46962/stats", "", minted.Token)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	// a tampered secret doesn't match the stored hash
+	tampered := minted.Token[:len(minted.Token)-1] + "0"
+	if tampered == minted.Token {
+		tampered = minted.Token[:len(minted.Token)-1] + "1"
+	}
+	w = send(http.MethodPost, "/shortie", `{"url":"https://example.com/data/hi"}`, tampered)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = send(http.MethodDelete, "/auth/tokens/"+minted.ID, "", "admin")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = send(http.MethodPost, "/shortie", `{"url":"https://example.com/data/hi"}`, minted.Token)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = send(http.MethodGet, "/auth/tokens?revoked=true", "", "admin")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), minted.ID)
+	assert.NotContains(t, w.Body.String(), "secretHash")
+}
+
+func TestCreateTokenValidation(t *testing.T) {
+	api := shortieAPI{storage: &LocalStorage{Objects: map[string]URLObject{}}, adminToken: "admin", tokens: NewLocalTokenStore()}
+	router := api.GetRouter()
+	for _, body := range []string{
+		`{"scopes":["create"],"expiration":%d}`,
+		`{"name":"bot","scopes":[],"expiration":%d}`,
+		`{"name":"bot","scopes":["delete"],"expiration":%d}`,
+		`{"name":"bot","scopes":["create"],"expiration":%d0}`,
+	} {
+		request := httptest.NewRequest(http.MethodPost, "/auth/tokens", strings.NewReader(fmt.Sprintf(body, time.Now().Add(time.Hour).Unix())))
+		request.Header.Set("Authorization", "Bearer admin")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// traceHeader is the X-Ray trace header load balancers add to requests, it is passed on to DynamoDB
+// so a slow or failed request can be followed into AWS
+const traceHeader = "X-Amzn-Trace-Id"
+
+const traceIDKey = "traceID"
+
+// contextString reads a value the RequestID middleware stored, the gin context handlers pass to storage
+// resolves string keys from its own keys. Background jobs have neither a request nor a trace id.
+func contextString(ctx context.Context, key string) string {
+	if ctx == nil {
+		return ""
+	}
+	value, _ := ctx.Value(key).(string)
+	return value
+}
+
+// addTracingMiddleware passes the trace id of the request being served on to aws calls,
+// and logs failed calls with both the aws request id and ours, which is what AWS support asks for
+func addTracingMiddleware(stack *middleware.Stack) error {
+	err := stack.Build.Add(middleware.BuildMiddlewareFunc("shortie.TraceHeader", func(
+		ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler,
+	) (middleware.BuildOutput, middleware.Metadata, error) {
+		// the signer leaves this header out of the signature, so setting it doesn't invalidate the request
+		request, isHTTP := in.Request.(*smithyhttp.Request)
+		traceID := contextString(ctx, traceIDKey)
+		if isHTTP && traceID != "" {
+			request.Header.Set(traceHeader, traceID)
+		}
+		return next.HandleBuild(ctx, in)
+	}), middleware.After)
+	if err != nil {
+		return err
+	}
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("shortie.LogErrors", func(
+		ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+	) (middleware.InitializeOutput, middleware.Metadata, error) {
+		out, metadata, err := next.HandleInitialize(ctx, in)
+		if err != nil && !isExpectedError(ctx, err) {
+			awsRequestID, _ := awsmiddleware.GetRequestIDMetadata(metadata)
+			var responseErr *awshttp.ResponseError
+			if awsRequestID == "" && errors.As(err, &responseErr) {
+				awsRequestID = responseErr.ServiceRequestID()
+			}
+			log.Printf("error: %s failed (aws request id %q, request id %q): %s",
+				awsmiddleware.GetOperationName(ctx), awsRequestID, contextString(ctx, requestIDKey), err)
+		}
+		return out, metadata, err
+	}), middleware.After)
+}
+
+// isExpectedError reports errors that are part of normal operation, like a condition rejecting a write
+// to a missing link, or a request given up on because the client went away
+func isExpectedError(ctx context.Context, err error) bool {
+	return isConditionFailed(err) || errors.Is(err, context.Canceled) || ctx.Err() != nil
+}
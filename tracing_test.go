@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	var traceID string
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID = r.Header.Get(traceHeader)
+		w.Header().Set("x-amzn-RequestId", "AWS123")
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"__type":"com.amazonaws.dynamodb.v20120810#ResourceNotFoundException","message":"table not found"}`))
+	}))
+	defer endpoint.Close()
+
+	client := dynamodb.New(dynamodb.Options{
+		Region:           "us-west-2",
+		BaseEndpoint:     aws.String(endpoint.URL),
+		Credentials:      credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		RetryMaxAttempts: 1,
+		APIOptions:       []func(*middleware.Stack) error{addTracingMiddleware},
+	})
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(requestIDKey, "req-1")
+	c.Set(traceIDKey, "Root=1-5759e988-bd862e3fe1be46a994272793")
+	_, err := client.GetItem(c, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key:       shortIDKey("111"),
+	})
+	require.Error(t, err)
+
+	assert.Equal(t, "Root=1-5759e988-bd862e3fe1be46a994272793", traceID)
+	assert.Contains(t, logs.String(), `GetItem failed (aws request id "AWS123", request id "req-1")`)
+
+	// conditions rejecting writes are part of normal operation and aren't logged
+	assert.True(t, isExpectedError(context.Background(), &types.ConditionalCheckFailedException{}))
+}
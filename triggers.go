@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Triggers are polled by no-code automation tools like Zapier and IFTTT. Items come in a stable order and each has an id
+// the tools dedupe on: a poll passes since from the first item of the last response and gets the items after it,
+// at most limit of the oldest ones, newest first, so catching up after a quiet spell takes a few polls but skips nothing.
+
+const (
+	defaultTriggerLimit = 50
+	maxTriggerLimit     = 500
+	// triggerSettle holds back links created and periods ended within it, a link saved a moment late
+	// would otherwise sort before a cursor that was already handed out and never be seen
+	triggerSettle = 5 * time.Second
+)
+
+// linkTrigger is a new link, ordered by when its creation was recorded in the change log
+type linkTrigger struct {
+	ID       string `json:"id"`
+	Cursor   string `json:"cursor"`
+	ShortID  string `json:"shortID"`
+	ShortURL string `json:"shortUrl"`
+	URL      string `json:"url"`
+	Created  int64  `json:"created"`
+	Campaign string `json:"campaign,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+}
+
+// clickTrigger is the clicks of one link in a day or hour that ended, ordered by the period's start and then shortID
+type clickTrigger struct {
+	ID       string `json:"id"`
+	ShortID  string `json:"shortID"`
+	ShortURL string `json:"shortUrl"`
+	Start    int64  `json:"start"`
+	End      int64  `json:"end"`
+	Clicks   int64  `json:"clicks"`
+}
+
+// linkCursor is the position of a link's creation in the change log, links have no secrets to guard so it isn't signed
+func linkCursor(seq string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(seq))
+}
+
+func parseLinkCursor(cursor string) (string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", errInvalidCursor
+	}
+	nanos, _, _ := strings.Cut(string(decoded), "-")
+	if _, err := strconv.ParseInt(nanos, 10, 64); err != nil {
+		return "", errInvalidCursor
+	}
+	return string(decoded), nil
+}
+
+// triggerVisible is whether the triggers list a link to the caller: tokens limited to a campaign only see its links,
+// and callers below admin only see the links they own, as RequireOwner only lets them change those
+func triggerVisible(caller *principal, owner string, campaign string) bool {
+	if caller == nil {
+		return true
+	}
+	if caller.campaign != "" && campaign != caller.campaign {
+		return false
+	}
+	return caller.role >= roleAdmin || owner == caller.name
+}
+
+// RequireTriggers keeps the triggers, which list other people's links to admins, from being public when roles aren't enforced
+func (api shortieAPI) RequireTriggers(c *gin.Context) {
+	if !api.dev && !api.rbacEnabled() {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "triggers require api keys, jwts or access tokens")
+		return
+	}
+	c.Next()
+}
+
+func triggerLimit(c *gin.Context) (int, bool) {
+	value := c.Query("limit")
+	if value == "" {
+		return defaultTriggerLimit, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxTriggerLimit {
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, "limit must be between 1 and "+strconv.Itoa(maxTriggerLimit))
+		return 0, false
+	}
+	return limit, true
+}
+
+// GetLinkTriggers lists the links created after the since cursor. It reads the change log rather than every link,
+// so it requires the change feed and only lists links created within its retention.
+func (api shortieAPI) GetLinkTriggers(c *gin.Context) {
+	if api.changeFeed == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "the links trigger requires SHORTIE_CHANGE_FEED_RETENTION")
+		return
+	}
+	limit, ok := triggerLimit(c)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	settled := changeSeq(now.Add(-triggerSettle))
+	// the changes before the retention are no longer kept
+	after := changeSeq(now.Add(-api.changeFeed.retention))
+	if since := c.Query("since"); since != "" {
+		position, err := parseLinkCursor(since)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, codeInvalidParameter, err.Error())
+			return
+		}
+		after = max(after, position)
+	}
+
+	caller := callerOf(c)
+	links := []linkTrigger{}
+	// the log is read a page at a time and only until limit links are found
+	for after < settled && len(links) < limit {
+		changes, err := api.changeFeed.log.ListChanges(c, after, settled, maxChangesLimit)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		for _, change := range changes {
+			if len(links) == limit {
+				break
+			}
+			after = change.Seq
+			if change.Type != changeCreated || !triggerVisible(caller, change.Owner, change.Campaign) {
+				continue
+			}
+			object, err := api.storage.GetURL(c, change.ShortID)
+			if err != nil {
+				respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+				return
+			}
+			// deleted since it was created
+			if object == nil {
+				continue
+			}
+			links = append(links, linkTrigger{
+				ID:       change.ShortID,
+				Cursor:   linkCursor(change.Seq),
+				ShortID:  change.ShortID,
+				ShortURL: "http://localhost:8421/shortie/" + change.ShortID,
+				URL:      object.URL,
+				Created:  object.Created,
+				Campaign: object.Campaign,
+				Owner:    object.Owner,
+			})
+		}
+		if len(changes) < maxChangesLimit {
+			break
+		}
+	}
+	slices.Reverse(links)
+	c.JSON(http.StatusOK, links)
+}
+
+// GetClickTriggers lists the clicks per day, or per hour with period=hour when hourly usage is enabled, of one link
+// with id or the links of a campaign, for the periods that started at or after since and have ended.
+// The next poll passes the end of the first item as since.
+// A period is never split between responses, so a response can go over the limit by the links clicked in one period.
+func (api shortieAPI) GetClickTriggers(c *gin.Context) {
+	limit, ok := triggerLimit(c)
+	if !ok {
+		return
+	}
+	var since int64
+	if value := c.Query("since"); value != "" {
+		var err error
+		since, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, codeInvalidParameter, "since must be a unix timestamp")
+			return
+		}
+	}
+	period := c.DefaultQuery("period", "day")
+	hourly := false
+	switch period {
+	case "day":
+	case "hour":
+		hourly = true
+	default:
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, "period must be day or hour")
+		return
+	}
+
+	caller := callerOf(c)
+	campaign := c.Query("campaign")
+	if campaign == "" && caller != nil {
+		campaign = caller.campaign
+	}
+	var shortIDs []string
+	switch id := c.Query("id"); {
+	case id != "":
+		shortIDs = []string{id}
+	case campaign != "":
+		var err error
+		shortIDs, err = api.storage.FindByCampaign(c, campaign)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+	default:
+		// polling every link would read the whole table
+		respondError(c, http.StatusBadRequest, codeInvalidParameter, "id or campaign is required")
+		return
+	}
+	settled := time.Now().Add(-triggerSettle)
+	clicks := []clickTrigger{}
+	for _, shortID := range shortIDs {
+		object, err := api.storage.GetURL(c, shortID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		if object == nil || !triggerVisible(caller, object.Owner, object.Campaign) {
+			continue
+		}
+		for key, count := range object.Usage {
+			start, isHour := parseHourUsageKey(key)
+			length := time.Hour
+			if !isHour {
+				day, err := strconv.ParseInt(key, 10, 64)
+				if err != nil {
+					continue
+				}
+				start, length = time.Unix(day, 0).UTC(), 24*time.Hour
+			}
+			end := start.Add(length)
+			if isHour != hourly || count == 0 || start.Unix() < since || end.After(settled) {
+				continue
+			}
+			clicks = append(clicks, clickTrigger{
+				ID:       shortID + "@" + period + ":" + strconv.FormatInt(start.Unix(), 10),
+				ShortID:  shortID,
+				ShortURL: "http://localhost:8421/shortie/" + shortID,
+				Start:    start.Unix(),
+				End:      end.Unix(),
+				Clicks:   count,
+			})
+		}
+	}
+	sort.Slice(clicks, func(i, j int) bool {
+		if clicks[i].Start != clicks[j].Start {
+			return clicks[i].Start < clicks[j].Start
+		}
+		return clicks[i].ShortID < clicks[j].ShortID
+	})
+	if len(clicks) > limit {
+		// cut before the period the limit falls in, unless it is the first one
+		cut := limit
+		for cut > 0 && clicks[cut].Start == clicks[cut-1].Start {
+			cut--
+		}
+		if cut == 0 {
+			cut = limit
+			for cut < len(clicks) && clicks[cut].Start == clicks[0].Start {
+				cut++
+			}
+		}
+		clicks = clicks[:cut]
+	}
+	slices.Reverse(clicks)
+	c.JSON(http.StatusOK, clicks)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// normalizeURL reduces trivially different spellings of a url to one form,
+// lowercasing the scheme and host, dropping default ports and fragments, and using "/" for an empty path
+// urls that don't parse are returned as is
+func normalizeURL(rawURL string) string {
+	parsed, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || parsed.Host == "" {
+		return rawURL
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	host := strings.ToLower(parsed.Hostname())
+	port := parsed.Port()
+	if (parsed.Scheme == "http" && port == "80") || (parsed.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	// JoinHostPort and the brackets keep IPv6 hosts like [::1] apart from their port
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	parsed.Host = host
+	parsed.Fragment = ""
+	parsed.RawFragment = ""
+	if parsed.Path == "" {
+		parsed.Path = "/"
+	}
+	return parsed.String()
+}
+
+// urlCanonicalizer rewrites the urls of new links to a canonical form before their shortID is derived,
+// so urls that only differ by tracking parameters, parameter order or fragment share one link
+type urlCanonicalizer struct {
+	// stripParams are the query parameters removed, entries ending in * remove every parameter with that prefix
+	stripParams   []string
+	sortParams    bool
+	stripFragment bool
+}
+
+// parseCanonicalizer reads comma separated parameter names, nil if no rule is enabled
+func parseCanonicalizer(stripParams string, sortParams bool, stripFragment bool) *urlCanonicalizer {
+	canonicalizer := &urlCanonicalizer{sortParams: sortParams, stripFragment: stripFragment}
+	for _, param := range strings.Split(stripParams, ",") {
+		param = strings.ToLower(strings.TrimSpace(param))
+		if param != "" {
+			canonicalizer.stripParams = append(canonicalizer.stripParams, param)
+		}
+	}
+	if len(canonicalizer.stripParams) == 0 && !sortParams && !stripFragment {
+		return nil
+	}
+	return canonicalizer
+}
+
+func (canonicalizer *urlCanonicalizer) stripped(param string) bool {
+	param = strings.ToLower(param)
+	for _, pattern := range canonicalizer.stripParams {
+		prefix, wildcard := strings.CutSuffix(pattern, "*")
+		if param == pattern || (wildcard && strings.HasPrefix(param, prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Canonicalize normalizes the url like normalizeURL, which keeps IPv6 hosts in brackets, and applies the
+// canonicalization rules. Parameters are kept as they were encoded, urls that don't parse are returned as is
+func (canonicalizer *urlCanonicalizer) Canonicalize(rawURL string) string {
+	parsed, err := url.Parse(normalizeURL(rawURL))
+	if err != nil || parsed.Host == "" {
+		return rawURL
+	}
+	if !canonicalizer.stripFragment {
+		// normalizeURL drops the fragment, which is only wanted with the rule
+		original, err := url.Parse(strings.TrimSpace(rawURL))
+		if err == nil {
+			parsed.Fragment = original.Fragment
+			parsed.RawFragment = original.RawFragment
+		}
+	}
+
+	params := []string{}
+	for _, param := range strings.Split(parsed.RawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(param, "=")
+		unescaped, err := url.QueryUnescape(name)
+		if err != nil {
+			unescaped = name
+		}
+		if !canonicalizer.stripped(unescaped) {
+			params = append(params, param)
+		}
+	}
+	if canonicalizer.sortParams {
+		// stable, so repeated parameters keep their order
+		sort.SliceStable(params, func(i, j int) bool {
+			nameI, _, _ := strings.Cut(params[i], "=")
+			nameJ, _, _ := strings.Cut(params[j], "=")
+			return nameI < nameJ
+		})
+	}
+	parsed.RawQuery = strings.Join(params, "&")
+	parsed.ForceQuery = false
+	return parsed.String()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	tests := map[string]string{
+		"HTTP://Example.COM:80":         "http://example.com/",
+		"https://example.com:8443/a#b":  "https://example.com:8443/a",
+		"http://[::1]:8080/a":           "http://[::1]:8080/a",
+		"https://[2001:DB8::1]:443/a":   "https://[2001:db8::1]/a",
+		"  https://example.com/a?b=1  ": "https://example.com/a?b=1",
+		"not a url":                     "not a url",
+	}
+	for url, expected := range tests {
+		assert.Equal(t, expected, normalizeURL(url), url)
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	assert.Nil(t, parseCanonicalizer(" , ", false, false))
+
+	tests := []struct {
+		name          string
+		canonicalizer *urlCanonicalizer
+		url           string
+		expected      string
+	}{
+		{
+			name:          "strip tracking parameters",
+			canonicalizer: parseCanonicalizer("fbclid, gclid,utm_*", false, false),
+			url:           "https://Example.com:443/a?utm_source=mail&b=2&FBCLID=x&a=1&gclid=y#top",
+			expected:      "https://example.com/a?b=2&a=1#top",
+		},
+		{
+			name:          "sort parameters keeping repeated ones in order",
+			canonicalizer: parseCanonicalizer("", true, false),
+			url:           "https://example.com/a?b=2&a=3&a=1",
+			expected:      "https://example.com/a?a=3&a=1&b=2",
+		},
+		{
+			name:          "strip the fragment",
+			canonicalizer: parseCanonicalizer("", false, true),
+			url:           "https://example.com#top",
+			expected:      "https://example.com/",
+		},
+		{
+			name:          "keep parameter encoding",
+			canonicalizer: parseCanonicalizer("gclid", true, false),
+			url:           "https://example.com/?q=a%20b&gclid=1",
+			expected:      "https://example.com/?q=a%20b",
+		},
+		{
+			name:          "remove every parameter",
+			canonicalizer: parseCanonicalizer("utm_*", false, false),
+			url:           "https://example.com/?utm_source=x",
+			expected:      "https://example.com/",
+		},
+		{
+			name:          "keep the brackets of IPv6 hosts",
+			canonicalizer: parseCanonicalizer("utm_*", true, false),
+			url:           "http://[2001:DB8::1]:8080/a?utm_source=x&b=2&a=1#top",
+			expected:      "http://[2001:db8::1]:8080/a?a=1&b=2#top",
+		},
+		{
+			name:          "keep the brackets of IPv6 hosts without a port",
+			canonicalizer: parseCanonicalizer("", false, true),
+			url:           "https://[::1]:443/a#top",
+			expected:      "https://[::1]/a",
+		},
+		{
+			name:          "unparseable urls are kept",
+			canonicalizer: parseCanonicalizer("utm_*", true, true),
+			url:           "not a url",
+			expected:      "not a url",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.canonicalizer.Canonicalize(test.url))
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hourly usage is counted in the usage map next to the daily usage, under keys with hourUsagePrefix,
+// so every redirect counts towards both its day and its hour. Rolling up hours into days is then
+// only a matter of dropping the old hourly buckets, the daily usage already has their clicks.
+
+const hourUsagePrefix = "hour:"
+
+// hourUsageKey is the usage map key of the UTC hour t falls in
+func hourUsageKey(t time.Time) string {
+	return hourUsagePrefix + strconv.FormatInt(t.UTC().Truncate(time.Hour).Unix(), 10)
+}
+
+// parseHourUsageKey returns the start of the hour of an hourly usage key, false for daily usage keys
+func parseHourUsageKey(key string) (time.Time, bool) {
+	hour, isHour := strings.CutPrefix(key, hourUsagePrefix)
+	if !isHour {
+		return time.Time{}, false
+	}
+	timestamp, err := strconv.ParseInt(hour, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(timestamp, 0).UTC(), true
+}
+
+// staleHourKeys lists the hourly usage buckets that started before the given time
+func staleHourKeys(usage map[string]int64, before time.Time) []string {
+	var stale []string
+	for key := range usage {
+		hour, isHour := parseHourUsageKey(key)
+		if isHour && hour.Before(before) {
+			stale = append(stale, key)
+		}
+	}
+	return stale
+}
+
+// rollupHourlyUsage drops the hourly usage of every link that is older than keep
+func rollupHourlyUsage(ctx context.Context, storage urlStorage, keep time.Duration) error {
+	shortIDs, err := storage.ListShortIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	before := time.Now().Add(-keep)
+	for _, shortID := range shortIDs {
+		err = storage.RollupUsage(ctx, shortID, before)
+		if err != nil {
+			return fmt.Errorf("failed to roll up the usage of %s: %w", shortID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHourUsageKey(t *testing.T) {
+	hour := time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)
+	key := hourUsageKey(hour.Add(42 * time.Minute))
+	assert.Equal(t, "hour:"+strconv.FormatInt(hour.Unix(), 10), key)
+
+	parsed, isHour := parseHourUsageKey(key)
+	assert.True(t, isHour)
+	assert.Equal(t, hour, parsed)
+
+	_, isHour = parseHourUsageKey(strconv.FormatInt(hour.Truncate(24*time.Hour).Unix(), 10))
+	assert.False(t, isHour)
+}
+
+func TestRollupHourlyUsage(t *testing.T) {
+	storage := &LocalStorage{Objects: map[string]URLObject{}, hourlyUsage: true}
+	err := storage.SaveURL(context.Background(), URLObject{ShortID: "111", URL: "http://redirection.com"})
+	require.NoError(t, err)
+	err = storage.IncrementUsage(context.Background(), "111", "", 1, 1)
+	require.NoError(t, err)
+
+	today := strconv.FormatInt(UTCTimestampOfTodayRounded().Unix(), 10)
+	thisHour := hourUsageKey(time.Now())
+	staleHour := hourUsageKey(time.Now().Add(-72 * time.Hour))
+	storage.Objects["111"].Usage[staleHour] = 5
+
+	err = rollupHourlyUsage(context.Background(), storage, 48*time.Hour)
+	require.NoError(t, err)
+
+	statistics, err := storage.GetStatistics(context.Background(), "111")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{today: 1, thisHour: 1}, statistics.Usage)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiVersionHeader lets clients ask for a version of the json api, responses say which version served them
+const apiVersionHeader = "X-Shortie-API-Version"
+
+// currentAPIVersion is what the unprefixed routes serve, they move to the newest version
+// only for changes old clients can't break on
+const currentAPIVersion = "1"
+
+var supportedAPIVersions = []string{"1"}
+
+// NegotiateVersion serves the json api routes of a version, rejecting clients that asked for a different one
+func NegotiateVersion(version string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requested := c.GetHeader(apiVersionHeader)
+		if requested != "" && !slices.Contains(supportedAPIVersions, requested) {
+			respondErrorDetails(c, http.StatusBadRequest, codeUnsupportedVersion, "unsupported api version "+requested, map[string]any{
+				"supported": supportedAPIVersions,
+			})
+			return
+		}
+		if requested != "" && requested != version {
+			respondError(c, http.StatusBadRequest, codeUnsupportedVersion, "this route serves version "+version+", use /v"+requested)
+			return
+		}
+		c.Header(apiVersionHeader, version)
+		c.Next()
+	}
+}